@@ -1,4 +1,5 @@
 #!/bin/bash
 
 go build ../../server
-go build ../../client
\ No newline at end of file
+go build ../../client
+go build ../../keygen
\ No newline at end of file
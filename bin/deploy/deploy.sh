@@ -18,14 +18,19 @@ distribute(){
     for (( j=1; j<=$1; j++))
     do 
        ssh-keyscan ${SERVER_ADDR[j-1]} >> ~/.ssh/known_hosts
-       ssh -i ${SSH_KEY} -t $2@${SERVER_ADDR[j-1]} mkdir bamboo
+       ssh -i ${SSH_KEY} -t $2@${SERVER_ADDR[j-1]} mkdir -p bamboo/keys
        echo -e "---- upload replica ${j}: $2@${SERVER_ADDR[j-1]} \n ----"
        scp -i ${SSH_KEY} server ips.txt $2@${SERVER_ADDR[j-1]}:~/bamboo
+       # every replica gets its own private key only
+       scp -i ${SSH_KEY} keys/registry.json keys/${j}.key $2@${SERVER_ADDR[j-1]}:~/bamboo/keys
     done
 }
 
 USERNAME='ubuntu'
 MAXPEERNUM=(`wc -l public_ips.txt | awk '{ print $1 }'`)
 
+# a key pair per replica, kept if it was already generated
+[ -f keys/registry.json ] || ./keygen -dir keys
+
 # distribute files
 distribute $MAXPEERNUM $USERNAME
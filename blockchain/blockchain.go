@@ -6,7 +6,10 @@ import (
 )
 
 type BlockChain struct {
-	forrest *LevelledForest
+	forrest       *LevelledForest
+	notarizations map[crypto.Identifier]*Notarization // certificates of the blocks that are not pruned yet
+	finalizations map[crypto.Identifier]*Finalization
+	fastFinals    map[crypto.Identifier]*FastFinalization
 	// measurement
 	highestComitted  int
 	committedBlockNo int
@@ -16,9 +19,19 @@ type BlockChain struct {
 func NewBlockchain(n int) *BlockChain {
 	bc := new(BlockChain)
 	bc.forrest = NewLevelledForest()
+	bc.notarizations = make(map[crypto.Identifier]*Notarization)
+	bc.finalizations = make(map[crypto.Identifier]*Finalization)
+	bc.fastFinals = make(map[crypto.Identifier]*FastFinalization)
 	return bc
 }
 
+// Restore makes a block recovered from the store the root of the chain
+func (bc *BlockChain) Restore(block *Block) {
+	bc.forrest.LowestLevel = uint64(block.Height)
+	bc.AddBlock(block)
+	bc.highestComitted = block.Height
+}
+
 func (bc *BlockChain) Exists(id crypto.Identifier) bool {
 	return bc.forrest.HasVertex(id)
 }
@@ -28,6 +41,54 @@ func (bc *BlockChain) AddBlock(block *Block) {
 	bc.forrest.AddVertex(blockContainer)
 }
 
+// AddNotarization keeps the notarization as the evidence of its block
+func (bc *BlockChain) AddNotarization(notarization *Notarization) {
+	if uint64(notarization.Height) < bc.forrest.LowestLevel {
+		return
+	}
+	_, exists := bc.notarizations[notarization.BlockID]
+	if !exists {
+		bc.notarizations[notarization.BlockID] = notarization
+	}
+}
+
+// GetNotarization returns the notarization of the block, nil if it is unknown
+func (bc *BlockChain) GetNotarization(id crypto.Identifier) *Notarization {
+	return bc.notarizations[id]
+}
+
+// AddFinalization keeps the finalization as the evidence of its block
+func (bc *BlockChain) AddFinalization(finalization *Finalization) {
+	if uint64(finalization.Height) < bc.forrest.LowestLevel {
+		return
+	}
+	_, exists := bc.finalizations[finalization.BlockID]
+	if !exists {
+		bc.finalizations[finalization.BlockID] = finalization
+	}
+}
+
+// GetFinalization returns the finalization of the block, nil if it is unknown
+func (bc *BlockChain) GetFinalization(id crypto.Identifier) *Finalization {
+	return bc.finalizations[id]
+}
+
+// AddFastFinalization keeps the fast finalization as the evidence of its block
+func (bc *BlockChain) AddFastFinalization(fastFinalization *FastFinalization) {
+	if uint64(fastFinalization.Height) < bc.forrest.LowestLevel {
+		return
+	}
+	_, exists := bc.fastFinals[fastFinalization.BlockID]
+	if !exists {
+		bc.fastFinals[fastFinalization.BlockID] = fastFinalization
+	}
+}
+
+// GetFastFinalization returns the fast finalization of the block, nil if it is unknown
+func (bc *BlockChain) GetFastFinalization(id crypto.Identifier) *FastFinalization {
+	return bc.fastFinals[id]
+}
+
 func (bc *BlockChain) GetBlockByID(id crypto.Identifier) (*Block, error) {
 	vertex, exists := bc.forrest.GetVertex(id)
 	if !exists {
@@ -57,17 +118,35 @@ func (bc *BlockChain) GetGrandParentBlock(id crypto.Identifier) (*Block, error)
 	return bc.GetParentBlock(parentBlock.ID)
 }
 
-// CommitBlock prunes blocks and returns committed blocks up to the last committed one and prunedBlocks
+// CommitBlock prunes blocks and returns committed blocks up to the last committed one and prunedBlocks.
+// Committed blocks are returned in commit order, the ancestors first.
 func (bc *BlockChain) CommitBlock(id crypto.Identifier, height int) ([]*Block, []*Block, error) {
 	vertex, ok := bc.forrest.GetVertex(id)
 	if !ok {
 		return nil, nil, fmt.Errorf("cannot find the block, id: %x", id)
 	}
+	// certificates of blocks committed previously are not needed anymore,
+	// the ones of the blocks committed now are kept until the next commit
+	for id, notarization := range bc.notarizations {
+		if uint64(notarization.Height) < bc.forrest.LowestLevel {
+			delete(bc.notarizations, id)
+		}
+	}
+	for id, finalization := range bc.finalizations {
+		if uint64(finalization.Height) < bc.forrest.LowestLevel {
+			delete(bc.finalizations, id)
+		}
+	}
+	for id, fastFinalization := range bc.fastFinals {
+		if uint64(fastFinalization.Height) < bc.forrest.LowestLevel {
+			delete(bc.fastFinals, id)
+		}
+	}
 	committedHeight := vertex.GetBlock().Height
 	bc.highestComitted = int(vertex.GetBlock().Height)
 	var committedBlocks []*Block
 	for block := vertex.GetBlock(); uint64(block.Height) > bc.forrest.LowestLevel; {
-		committedBlocks = append(committedBlocks, block)
+		committedBlocks = append([]*Block{block}, committedBlocks...)
 		bc.committedBlockNo++
 		vertex, exists := bc.forrest.GetVertex(block.PrevID)
 		if !exists {
@@ -0,0 +1,55 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"banyan/config"
+	"banyan/crypto"
+)
+
+// SuperMajority is the number of shares that is more than 2n/3
+func SuperMajority(n int) int {
+	return n*2/3 + 1
+}
+
+// BanyanQuorum is the number of notarization shares that is more than (n+f)/2
+func BanyanQuorum(n int, f int) int {
+	return (n+f)/2 + 1
+}
+
+// VerifyNotarization checks that the notarization carries valid shares of at least quorum distinct replicas
+func VerifyNotarization(notarization *Notarization, quorum int) error {
+	if notarization == nil {
+		return errors.New("no notarization")
+	}
+	return verifyCertificate(notarization.BlockID, notarization.AggSig, notarization.Signers, quorum)
+}
+
+// VerifyFinalization checks that the finalization carries valid shares of at least quorum distinct replicas, signed
+// over FinalizeID
+func VerifyFinalization(finalization *Finalization, quorum int) error {
+	if finalization == nil {
+		return errors.New("no finalization")
+	}
+	return verifyCertificate(FinalizeID(finalization.BlockID), finalization.AggSig, finalization.Signers, quorum)
+}
+
+func verifyCertificate(id crypto.Identifier, aggSig crypto.AggSig, signers crypto.Signers, quorum int) error {
+	for _, signer := range signers.IDs() {
+		if _, known := config.GetConfig().Addrs[signer]; !known {
+			return fmt.Errorf("unknown signer %v", signer)
+		}
+	}
+	if count := signers.Count(); count < quorum {
+		return fmt.Errorf("%v distinct signers, %v needed", count, quorum)
+	}
+	ok, err := crypto.VerifyQuorumSignature(aggSig, id, signers)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("invalid signature in the certificate of block %x", id)
+	}
+	return nil
+}
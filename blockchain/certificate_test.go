@@ -0,0 +1,205 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"testing"
+
+	"banyan/config"
+	"banyan/crypto"
+	"banyan/identity"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setNodes(t testing.TB, n int) {
+	saved := config.Configuration
+	t.Cleanup(func() { config.Configuration = saved })
+	config.Configuration.Addrs = make(map[identity.NodeID]string)
+	for i := 1; i <= n; i++ {
+		config.Configuration.Addrs[identity.NewNodeID(i)] = ""
+	}
+	config.Configuration.N = n
+}
+
+// useKeys generates keys of the scheme for the n replicas, all local
+func useKeys(t testing.TB, scheme string, n int) {
+	var ids []identity.NodeID
+	for i := 1; i <= n; i++ {
+		ids = append(ids, identity.NewNodeID(i))
+	}
+	require.NoError(t, crypto.GenerateKeys(scheme, ids))
+	t.Cleanup(func() { crypto.UseKeys(nil) })
+}
+
+func nShare(voter int, rank int, id crypto.Identifier) *NotarizationShare {
+	return &NotarizationShare{Height: 1, Rank: rank, Voter: identity.NewNodeID(voter), BlockID: id, Signature: crypto.Signature{byte(voter)}}
+}
+
+// the bags build the certificate once more than 2n/3 shares are collected
+func TestBagsBuildCertificates(t *testing.T) {
+	id := crypto.MakeID("block")
+	nBag := NewNSharesBag(4)
+	fBag := NewFSharesBag(4)
+	for voter := 1; voter <= 3; voter++ {
+		isN, notarization := nBag.Add(nShare(voter, 0, id))
+		isF, finalization := fBag.Add(&FinalizationShare{Height: 1, Voter: identity.NewNodeID(voter), BlockID: id, Signature: crypto.Signature{byte(voter)}})
+		if voter < 3 {
+			require.False(t, isN)
+			require.Nil(t, notarization)
+			require.False(t, isF)
+			require.Nil(t, finalization)
+			continue
+		}
+		require.True(t, isN)
+		require.Equal(t, id, notarization.BlockID)
+		require.Equal(t, 3, notarization.Signers.Count())
+		require.Len(t, notarization.AggSig, 3)
+		require.True(t, isF)
+		require.Equal(t, id, finalization.BlockID)
+		require.Equal(t, notarization.Signers, finalization.Signers)
+	}
+
+	// n = 4, f = 1: more than (n+f)/2 shares notarize a block in Banyan
+	banyanBag := NewNSharesBagBanyan(4, 1, 1)
+	notarization, _ := banyanBag.Add(nShare(1, 0, id))
+	require.Nil(t, notarization)
+	notarization, _ = banyanBag.Add(nShare(2, 0, id))
+	require.Nil(t, notarization)
+	notarization, _ = banyanBag.Add(nShare(3, 0, id))
+	require.NotNil(t, notarization)
+	require.Equal(t, 3, notarization.Signers.Count())
+}
+
+// certificates that cannot be valid are rejected before any signature is checked
+func TestVerifyCertificateRejects(t *testing.T) {
+	setNodes(t, 4)
+	id := crypto.MakeID("block")
+	signers := func(ids ...int) crypto.Signers {
+		var nodes []identity.NodeID
+		for _, i := range ids {
+			nodes = append(nodes, identity.NewNodeID(i))
+		}
+		return crypto.NewSigners(nodes)
+	}
+	sigs := func(n int) crypto.AggSig {
+		return make(crypto.AggSig, n)
+	}
+
+	require.Error(t, VerifyNotarization(nil, 3))
+	require.Error(t, VerifyFinalization(nil, 3))
+	// too few signers
+	require.Error(t, VerifyNotarization(&Notarization{BlockID: id, Signers: signers(1, 2), AggSig: sigs(2)}, SuperMajority(4)))
+	// the same signer counts once
+	require.Error(t, VerifyFinalization(&Finalization{BlockID: id, Signers: signers(1, 1, 2), AggSig: sigs(3)}, SuperMajority(4)))
+	// a signer that is not a replica
+	require.Error(t, VerifyFinalization(&Finalization{BlockID: id, Signers: signers(1, 2, 7), AggSig: sigs(3)}, SuperMajority(4)))
+	// a signature missing
+	require.Error(t, VerifyNotarization(&Notarization{BlockID: id, Signers: signers(1, 2, 3), AggSig: sigs(2)}, SuperMajority(4)))
+}
+
+func TestQuorums(t *testing.T) {
+	require.Equal(t, 3, SuperMajority(4))
+	require.Equal(t, 5, SuperMajority(7))
+	require.Equal(t, 3, BanyanQuorum(4, 1))
+	require.Equal(t, 4, BanyanQuorum(5, 1))
+}
+
+// rank -1 shares are counted per block, shares for another block on the same height do not add up
+func TestFastFinalizationPerBlock(t *testing.T) {
+	id := crypto.MakeID("block")
+	other := crypto.MakeID("other block")
+	bag := NewNSharesBagBanyan(4, 1, 1)
+	_, fast := bag.Add(nShare(1, -1, id))
+	require.Nil(t, fast)
+	_, fast = bag.Add(nShare(2, -1, other))
+	require.Nil(t, fast)
+	_, fast = bag.Add(nShare(3, 0, id))
+	require.Nil(t, fast)
+	_, fast = bag.Add(nShare(4, -1, id))
+	require.Nil(t, fast)
+	_, fast = bag.Add(nShare(2, -1, id))
+	require.NotNil(t, fast)
+	require.Equal(t, id, fast.BlockID)
+	require.Equal(t, []identity.NodeID{identity.NewNodeID(1), identity.NewNodeID(2), identity.NewNodeID(4)}, fast.Signers.IDs())
+	require.Len(t, fast.AggSig, 3)
+}
+
+// a share conflicts with the first share of the voter for another block on the same height and rank
+func TestBagsConflict(t *testing.T) {
+	a, b := crypto.MakeID("a"), crypto.MakeID("b")
+	nBag := NewNSharesBag(4)
+	first := nShare(1, 0, a)
+	nBag.Add(first)
+	require.Nil(t, nBag.Conflict(nShare(1, 0, a)))
+	require.Nil(t, nBag.Conflict(nShare(1, 1, b)))
+	require.Nil(t, nBag.Conflict(nShare(2, 0, b)))
+	require.Equal(t, first, nBag.Conflict(nShare(1, 0, b)))
+	// the first share is kept
+	nBag.Add(nShare(1, 0, b))
+	require.Equal(t, first, nBag.Conflict(nShare(1, 0, crypto.MakeID("c"))))
+
+	banyanBag := NewNSharesBagBanyan(4, 1, 0)
+	fast := nShare(1, -1, a)
+	banyanBag.Add(fast)
+	require.Nil(t, banyanBag.Conflict(nShare(1, 0, b)))
+	require.Equal(t, fast, banyanBag.Conflict(nShare(1, -1, b)))
+
+	fBag := NewFSharesBag(4)
+	fShare := &FinalizationShare{Height: 1, Voter: "1", BlockID: a}
+	fBag.Add(fShare)
+	require.Nil(t, fBag.Conflict(&FinalizationShare{Height: 2, Voter: "1", BlockID: b}))
+	require.Equal(t, fShare, fBag.Conflict(&FinalizationShare{Height: 1, Voter: "1", BlockID: b}))
+}
+
+// with BLS keys a certificate carries a single signature, whatever the number of signers
+func TestBLSCertificates(t *testing.T) {
+	setNodes(t, 4)
+	useKeys(t, crypto.BLS_BLS12381, 4)
+	id := crypto.MakeID("block")
+	bag := NewNSharesBagBanyan(4, 1, 1)
+	var notarization *Notarization
+	var fast *FastFinalization
+	for voter := 1; voter <= 3; voter++ {
+		notarization, fast = bag.Add(MakeNShare(1, -1, identity.NewNodeID(voter), id))
+	}
+	require.NotNil(t, notarization)
+	require.Len(t, notarization.AggSig, 1)
+	require.NoError(t, VerifyNotarization(notarization, BanyanQuorum(4, 1)))
+	require.NotNil(t, fast)
+	require.Len(t, fast.AggSig, 1)
+	require.NoError(t, VerifyFastFinalization(fast, 3))
+
+	// the aggregate does not stand for other signers
+	notarization.Signers = crypto.NewSigners([]identity.NodeID{"1", "2", "4"})
+	require.Error(t, VerifyNotarization(notarization, BanyanQuorum(4, 1)))
+	notarization.Signers = crypto.NewSigners([]identity.NodeID{"1", "2", "3", "4"})
+	require.Error(t, VerifyNotarization(notarization, BanyanQuorum(4, 1)))
+}
+
+// BenchmarkVerifyCertificate compares the size of a certificate of 2n/3 shares and the cost of checking it, per
+// signature scheme
+func BenchmarkVerifyCertificate(b *testing.B) {
+	for _, scheme := range []string{crypto.ECDSA_P256, crypto.ECDSA_SECp256k1, crypto.ED25519, crypto.BLS_BLS12381} {
+		for _, n := range []int{4, 100, 200} {
+			b.Run(fmt.Sprintf("%v/n=%v", scheme, n), func(b *testing.B) {
+				setNodes(b, n)
+				useKeys(b, scheme, n)
+				id := crypto.MakeID("block")
+				bag := NewFSharesBag(n)
+				var finalization *Finalization
+				for voter := 1; finalization == nil; voter++ {
+					_, finalization = bag.Add(MakeFShare(1, 0, identity.NewNodeID(voter), id))
+				}
+				var encoded bytes.Buffer
+				require.NoError(b, gob.NewEncoder(&encoded).Encode(finalization))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					require.NoError(b, VerifyFinalization(finalization, SuperMajority(n)))
+				}
+				b.ReportMetric(float64(encoded.Len()), "bytes/cert")
+			})
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package blockchain
+
+import (
+	"errors"
+
+	"banyan/crypto"
+)
+
+// FastFinalization is the evidence that a block was finalized on the fast path of Banyan:
+// n-p replicas voted for it with rank -1, i.e., as the first and only block they voted for on the height.
+// The signatures are the FastSignatures of their shares.
+type FastFinalization struct {
+	Height  int
+	BlockID crypto.Identifier
+	Signers crypto.Signers
+	crypto.AggSig
+}
+
+type fastVote struct {
+	BlockID crypto.Identifier
+}
+
+// FastID is what a rank -1 notarization share signs on top of the block id,
+// the rank is not covered by the share signature
+func FastID(id crypto.Identifier) crypto.Identifier {
+	return crypto.MakeID(fastVote{BlockID: id})
+}
+
+// VerifyFastFinalization checks that the fast finalization carries valid rank -1 signatures
+// of at least quorum distinct replicas
+func VerifyFastFinalization(fastFinalization *FastFinalization, quorum int) error {
+	if fastFinalization == nil {
+		return errors.New("no fast finalization")
+	}
+	if quorum <= 0 {
+		return errors.New("the fast path is disabled")
+	}
+	return verifyCertificate(FastID(fastFinalization.BlockID), fastFinalization.AggSig, fastFinalization.Signers, quorum)
+}
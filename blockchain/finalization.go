@@ -14,6 +14,11 @@ type FinalizationShare struct {
 	Voter   identity.NodeID
 	BlockID crypto.Identifier
 	crypto.Signature
+	verified bool // the signature was checked when the share arrived, it is not sent
+}
+
+func (fs FinalizationShare) Sender() identity.NodeID {
+	return fs.Voter
 }
 
 type Finalization struct {
@@ -21,7 +26,7 @@ type Finalization struct {
 	Height  int
 	Rank    int
 	BlockID crypto.Identifier
-	Signers []identity.NodeID
+	Signers crypto.Signers
 	crypto.AggSig
 	crypto.Signature
 }
@@ -29,10 +34,22 @@ type Finalization struct {
 type FSharesBag struct {
 	total int
 	votes map[crypto.Identifier]map[identity.NodeID]*FinalizationShare
+	first map[slot]*FinalizationShare // the first share of each voter on a height and rank
+}
+
+type finalVote struct {
+	Domain  string
+	BlockID crypto.Identifier
+}
+
+// FinalizeID is what a finalization share signs instead of the block id, which the notarization shares sign,
+// so that a notarization does not pass for a finalization
+func FinalizeID(id crypto.Identifier) crypto.Identifier {
+	return crypto.MakeID(finalVote{Domain: "finalization", BlockID: id})
 }
 
 func MakeFShare(height int, rank int, voter identity.NodeID, id crypto.Identifier) *FinalizationShare {
-	sig, err := crypto.PrivSign(crypto.IDToByte(id), voter, nil)
+	sig, err := crypto.PrivSign(crypto.IDToByte(FinalizeID(id)), voter, nil)
 	if err != nil {
 		log.Fatalf("[%v] has an error when signing a vote", voter)
 		return nil
@@ -46,15 +63,35 @@ func MakeFShare(height int, rank int, voter identity.NodeID, id crypto.Identifie
 	}
 }
 
+// Claims returns the signature of the share
+func (fs *FinalizationShare) Claims() []crypto.Claim {
+	return []crypto.Claim{{Signer: fs.Voter, Data: crypto.IDToByte(FinalizeID(fs.BlockID)), Sig: fs.Signature}}
+}
+
+// MarkVerified records that the signature of the share holds, the protocol does not check it again
+func (fs *FinalizationShare) MarkVerified() {
+	fs.verified = true
+}
+
+func (fs *FinalizationShare) IsVerified() bool {
+	return fs.verified
+}
+
 func NewFSharesBag(total int) *FSharesBag {
 	return &FSharesBag{
 		total: total,
 		votes: make(map[crypto.Identifier]map[identity.NodeID]*FinalizationShare),
+		first: make(map[slot]*FinalizationShare),
 	}
 }
 
 // Add adds id to quorum ack records
-func (q *FSharesBag) Add(vote *FinalizationShare) bool {
+// return (is finalized, the finalization built from the shares collected so far)
+func (q *FSharesBag) Add(vote *FinalizationShare) (bool, *Finalization) {
+	key := slot{Height: vote.Height, Rank: vote.Rank, Signer: vote.Voter}
+	if _, exists := q.first[key]; !exists {
+		q.first[key] = vote
+	}
 	_, exist := q.votes[vote.BlockID]
 	if !exist {
 		//	first time of receiving the vote for this block
@@ -62,28 +99,26 @@ func (q *FSharesBag) Add(vote *FinalizationShare) bool {
 	}
 	q.votes[vote.BlockID][vote.Voter] = vote
 	if q.superMajority(vote.BlockID) {
-		//aggSig, signers, err := q.getSigs(vote.BlockID)
-		_, _, err := q.getSigs(vote.BlockID)
+		aggSig, signers, err := q.getSigs(vote.BlockID)
 		if err != nil {
 			log.Warningf("cannot generate a valid qc, height: %v, block id: %x: %w", vote.Height, vote.BlockID, err)
+			return true, nil
 		}
-		/*
-			qc := &Finalization{
-				Height:  vote.Height,
-				Rank:    vote.Rank,
-				BlockID: vote.BlockID,
-				AggSig:  aggSig,
-				Signers: signers,
-			}
-		*/
-		return true
+		finalization := &Finalization{
+			Height:  vote.Height,
+			Rank:    vote.Rank,
+			BlockID: vote.BlockID,
+			AggSig:  aggSig,
+			Signers: signers,
+		}
+		return true, finalization
 	}
-	return false
+	return false, nil
 }
 
 // Super majority quorum satisfied
 func (q *FSharesBag) superMajority(blockID crypto.Identifier) bool {
-	return q.size(blockID) > q.total*2/3
+	return q.size(blockID) >= SuperMajority(q.total)
 }
 
 // Size returns ack size for the block
@@ -91,17 +126,32 @@ func (q *FSharesBag) size(blockID crypto.Identifier) int {
 	return len(q.votes[blockID])
 }
 
-func (q *FSharesBag) getSigs(blockID crypto.Identifier) (crypto.AggSig, []identity.NodeID, error) {
-	var sigs crypto.AggSig
-	var signers []identity.NodeID
+func (q *FSharesBag) getSigs(blockID crypto.Identifier) (crypto.AggSig, crypto.Signers, error) {
 	_, exists := q.votes[blockID]
 	if !exists {
 		return nil, nil, fmt.Errorf("sigs does not exist, id: %x", blockID)
 	}
+	sigs := make(map[identity.NodeID]crypto.Signature)
 	for _, vote := range q.votes[blockID] {
-		sigs = append(sigs, vote.Signature)
-		signers = append(signers, vote.Voter)
+		sigs[vote.Voter] = vote.Signature
 	}
+	return crypto.Aggregate(sigs)
+}
 
-	return sigs, signers, nil
+// Conflict returns the share the voter sent for another block on the same height and rank, nil if there is none
+func (q *FSharesBag) Conflict(vote *FinalizationShare) *FinalizationShare {
+	share, exists := q.first[slot{Height: vote.Height, Rank: vote.Rank, Signer: vote.Voter}]
+	if !exists || share.BlockID == vote.BlockID {
+		return nil
+	}
+	return share
+}
+
+// Shares returns the shares collected for the block
+func (q *FSharesBag) Shares(blockID crypto.Identifier) []*FinalizationShare {
+	var shares []*FinalizationShare
+	for _, vote := range q.votes[blockID] {
+		shares = append(shares, vote)
+	}
+	return shares
 }
@@ -9,11 +9,18 @@ import (
 )
 
 type NotarizationShare struct {
-	Height  int
-	Rank    int
-	Voter   identity.NodeID
-	BlockID crypto.Identifier
+	Height        int
+	Rank          int
+	Voter         identity.NodeID
+	BlockID       crypto.Identifier
+	FastSignature crypto.Signature // signs FastID(BlockID), only in rank -1 shares
 	crypto.Signature
+	verified bool // the signatures were checked when the share arrived, it is not sent
+}
+
+// Sender returns the voter, the replicas never relay the shares of others
+func (ns NotarizationShare) Sender() identity.NodeID {
+	return ns.Voter
 }
 
 type Notarization struct {
@@ -21,7 +28,7 @@ type Notarization struct {
 	Height  int
 	Rank    int
 	BlockID crypto.Identifier
-	Signers []identity.NodeID
+	Signers crypto.Signers
 	crypto.AggSig
 	crypto.Signature
 }
@@ -29,6 +36,14 @@ type Notarization struct {
 type NSharesBag struct {
 	total int
 	votes map[crypto.Identifier]map[identity.NodeID]*NotarizationShare
+	first map[slot]*NotarizationShare // the first share of each voter on a height and rank
+}
+
+// slot is a height and rank on which an honest replica signs a single block
+type slot struct {
+	Height int
+	Rank   int
+	Signer identity.NodeID
 }
 
 func MakeNShare(height int, rank int, voter identity.NodeID, id crypto.Identifier) *NotarizationShare {
@@ -37,24 +52,54 @@ func MakeNShare(height int, rank int, voter identity.NodeID, id crypto.Identifie
 		log.Fatalf("[%v] has an error when signing a vote", voter)
 		return nil
 	}
+	var fastSig crypto.Signature
+	if rank == -1 {
+		fastSig, err = crypto.PrivSign(crypto.IDToByte(FastID(id)), voter, nil)
+		if err != nil {
+			log.Fatalf("[%v] has an error when signing a vote", voter)
+			return nil
+		}
+	}
 	return &NotarizationShare{
-		Height:    height,
-		Rank:      rank,
-		Voter:     voter,
-		BlockID:   id,
-		Signature: sig,
+		Height:        height,
+		Rank:          rank,
+		Voter:         voter,
+		BlockID:       id,
+		FastSignature: fastSig,
+		Signature:     sig,
 	}
 }
 
+// Claims returns the signatures of the share, with the fast one of a rank -1 share
+func (ns *NotarizationShare) Claims() []crypto.Claim {
+	claims := []crypto.Claim{{Signer: ns.Voter, Data: crypto.IDToByte(ns.BlockID), Sig: ns.Signature}}
+	if ns.Rank == -1 {
+		claims = append(claims, crypto.Claim{Signer: ns.Voter, Data: crypto.IDToByte(FastID(ns.BlockID)), Sig: ns.FastSignature})
+	}
+	return claims
+}
+
+// MarkVerified records that the signatures of the share hold, the protocol does not check them again
+func (ns *NotarizationShare) MarkVerified() {
+	ns.verified = true
+}
+
+func (ns *NotarizationShare) IsVerified() bool {
+	return ns.verified
+}
+
 func NewNSharesBag(total int) *NSharesBag {
 	return &NSharesBag{
 		total: total,
 		votes: make(map[crypto.Identifier]map[identity.NodeID]*NotarizationShare),
+		first: make(map[slot]*NotarizationShare),
 	}
 }
 
 // Add adds id to quorum ack records
-func (q *NSharesBag) Add(vote *NotarizationShare) bool {
+// return (is notarized, the notarization built from the shares collected so far)
+func (q *NSharesBag) Add(vote *NotarizationShare) (bool, *Notarization) {
+	firstShare(q.first, vote)
 	_, exist := q.votes[vote.BlockID]
 	if !exist {
 		//	first time of receiving the vote for this block
@@ -62,68 +107,96 @@ func (q *NSharesBag) Add(vote *NotarizationShare) bool {
 	}
 	q.votes[vote.BlockID][vote.Voter] = vote
 	if q.superMajority(vote.BlockID) {
-		//aggSig, signers, err := q.getSigs(vote.BlockID)
-		_, _, err := q.getSigs(vote.BlockID)
+		aggSig, signers, err := getNSigs(q.votes, vote.BlockID)
 		if err != nil {
 			log.Warningf("cannot generate a valid qc, height: %v, block id: %x: %w", vote.Height, vote.BlockID, err)
+			return true, nil
 		}
-		/*
-			qc := &Notarization{
-				Height:  vote.Height,
-				Rank:    vote.Rank,
-				BlockID: vote.BlockID,
-				AggSig:  aggSig,
-				Signers: signers,
-			}
-		*/
-		return true
+		notarization := &Notarization{
+			Height:  vote.Height,
+			Rank:    vote.Rank,
+			BlockID: vote.BlockID,
+			AggSig:  aggSig,
+			Signers: signers,
+		}
+		return true, notarization
 	}
-	return false
+	return false, nil
 }
 
 // Super majority quorum satisfied
 func (q *NSharesBag) superMajority(blockID crypto.Identifier) bool {
-	return (len(q.votes[blockID])) > q.total*2/3
+	return len(q.votes[blockID]) >= SuperMajority(q.total)
 }
 
-func (q *NSharesBag) getSigs(blockID crypto.Identifier) (crypto.AggSig, []identity.NodeID, error) {
-	var sigs crypto.AggSig
-	var signers []identity.NodeID
-	_, exists := q.votes[blockID]
+func getNSigs(votes map[crypto.Identifier]map[identity.NodeID]*NotarizationShare, blockID crypto.Identifier) (crypto.AggSig, crypto.Signers, error) {
+	_, exists := votes[blockID]
 	if !exists {
 		return nil, nil, fmt.Errorf("sigs does not exist, id: %x", blockID)
 	}
-	for _, vote := range q.votes[blockID] {
-		sigs = append(sigs, vote.Signature)
-		signers = append(signers, vote.Voter)
+	sigs := make(map[identity.NodeID]crypto.Signature)
+	for _, vote := range votes[blockID] {
+		sigs[vote.Voter] = vote.Signature
 	}
+	return crypto.Aggregate(sigs)
+}
 
-	return sigs, signers, nil
+// Conflict returns the share the voter sent for another block on the same height and rank, nil if there is none
+func (q *NSharesBag) Conflict(vote *NotarizationShare) *NotarizationShare {
+	return conflictingShare(q.first, vote)
+}
+
+func firstShare(first map[slot]*NotarizationShare, vote *NotarizationShare) {
+	key := slot{Height: vote.Height, Rank: vote.Rank, Signer: vote.Voter}
+	if _, exists := first[key]; !exists {
+		first[key] = vote
+	}
+}
+
+func conflictingShare(first map[slot]*NotarizationShare, vote *NotarizationShare) *NotarizationShare {
+	share, exists := first[slot{Height: vote.Height, Rank: vote.Rank, Signer: vote.Voter}]
+	if !exists || share.BlockID == vote.BlockID {
+		return nil
+	}
+	return share
+}
+
+// Shares returns the shares collected for the block
+func (q *NSharesBag) Shares(blockID crypto.Identifier) []*NotarizationShare {
+	var shares []*NotarizationShare
+	for _, vote := range q.votes[blockID] {
+		shares = append(shares, vote)
+	}
+	return shares
 }
 
 // TODO: add crypto/aggregation of different types for Banyan
 // TODO: handle multiple blocks of the same rank
 type NSharesBagBanyan struct {
-	n                 int
-	f                 int
-	p                 int
-	votes             map[crypto.Identifier]map[identity.NodeID]*NotarizationShare
-	fastVotesRankZero map[int]int
+	n         int
+	f         int
+	p         int
+	votes     map[crypto.Identifier]map[identity.NodeID]*NotarizationShare
+	fastVotes map[crypto.Identifier]map[identity.NodeID]*NotarizationShare // rank -1 shares
+	first     map[slot]*NotarizationShare                                  // the first share of each voter on a height and rank
 }
 
 func NewNSharesBagBanyan(n int, f int, p int) *NSharesBagBanyan {
 	return &NSharesBagBanyan{
-		n:                 n,
-		f:                 f,
-		p:                 p,
-		votes:             make(map[crypto.Identifier]map[identity.NodeID]*NotarizationShare),
-		fastVotesRankZero: make(map[int]int),
+		n:         n,
+		f:         f,
+		p:         p,
+		votes:     make(map[crypto.Identifier]map[identity.NodeID]*NotarizationShare),
+		fastVotes: make(map[crypto.Identifier]map[identity.NodeID]*NotarizationShare),
+		first:     make(map[slot]*NotarizationShare),
 	}
 }
 
 // Add adds id to quorum ack records
-// return (is notarized, is fast path finalized)
-func (q *NSharesBagBanyan) Add(vote *NotarizationShare) (bool, bool) {
+// return (the notarization, nil if the block is not notarized yet,
+// the fast finalization, nil if the block is not fast path finalized yet)
+func (q *NSharesBagBanyan) Add(vote *NotarizationShare) (*Notarization, *FastFinalization) {
+	firstShare(q.first, vote)
 	_, exist := q.votes[vote.BlockID]
 	if !exist {
 		//	first time of receiving the vote for this block
@@ -133,12 +206,57 @@ func (q *NSharesBagBanyan) Add(vote *NotarizationShare) (bool, bool) {
 	bagForThisBlock := q.votes[vote.BlockID]
 	bagForThisBlock[vote.Voter] = vote
 
+	var fastFinalization *FastFinalization
 	if vote.Rank == -1 {
-		q.fastVotesRankZero[vote.Height] += 1
+		if _, exist := q.fastVotes[vote.BlockID]; !exist {
+			q.fastVotes[vote.BlockID] = make(map[identity.NodeID]*NotarizationShare)
+		}
+		fastVotes := q.fastVotes[vote.BlockID]
+		fastVotes[vote.Voter] = vote
+		if len(fastVotes) >= q.n-q.p {
+			sigs := make(map[identity.NodeID]crypto.Signature)
+			for _, fastVote := range fastVotes {
+				sigs[fastVote.Voter] = fastVote.FastSignature
+			}
+			aggSig, signers, err := crypto.Aggregate(sigs)
+			if err != nil {
+				log.Warningf("cannot generate a valid fast finalization, height: %v, block id: %x: %v", vote.Height, vote.BlockID, err)
+			} else {
+				fastFinalization = &FastFinalization{Height: vote.Height, BlockID: vote.BlockID, Signers: signers, AggSig: aggSig}
+			}
+		}
 	}
 
-	isNotarized := (len(bagForThisBlock))*2 > q.n+q.f
-	isFinalized := ((vote.Rank == -1) && (q.fastVotesRankZero[vote.Height] >= q.n-q.p))
+	isNotarized := len(bagForThisBlock) >= BanyanQuorum(q.n, q.f)
+	if !isNotarized {
+		return nil, fastFinalization
+	}
+	aggSig, signers, err := getNSigs(q.votes, vote.BlockID)
+	if err != nil {
+		log.Warningf("cannot generate a valid qc, height: %v, block id: %x: %v", vote.Height, vote.BlockID, err)
+		return nil, fastFinalization
+	}
+	notarization := &Notarization{
+		Height:  vote.Height,
+		Rank:    vote.Rank,
+		BlockID: vote.BlockID,
+		AggSig:  aggSig,
+		Signers: signers,
+	}
+	return notarization, fastFinalization
+}
+
+// Conflict returns the share the voter sent for another block on the same height and rank, nil if there is none,
+// the rank of a share is -1 on the fast path
+func (q *NSharesBagBanyan) Conflict(vote *NotarizationShare) *NotarizationShare {
+	return conflictingShare(q.first, vote)
+}
 
-	return isNotarized, isFinalized
+// Shares returns the shares collected for the block
+func (q *NSharesBagBanyan) Shares(blockID crypto.Identifier) []*NotarizationShare {
+	var shares []*NotarizationShare
+	for _, vote := range q.votes[blockID] {
+		shares = append(shares, vote)
+	}
+	return shares
 }
@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"banyan/crypto"
+)
+
+// CommittedRecord is what the store keeps for every committed block and what the protocols
+// pass on to the replica, the certificates and the shares are the evidence that the block was notarized and finalized.
+// A block finalized on the fast path has a FastFinalization, one finalized on the slow path a Finalization,
+// an ancestor committed together with a finalized block has neither.
+type CommittedRecord struct {
+	Block              *Block
+	Path               CommitPath // how I committed the block, it is not part of the evidence
+	Notarization       *Notarization
+	Finalization       *Finalization
+	FastFinalization   *FastFinalization
+	NotarizationShares []*NotarizationShare
+	FinalizationShares []*FinalizationShare
+}
+
+// CommitPath tells how a committed block was finalized
+type CommitPath int
+
+const (
+	SlowPath     CommitPath = iota // more than 2n/3 finalization shares
+	FastPath                       // n-p rank -1 notarization shares
+	ImplicitPath                   // committed as an ancestor of a finalized block
+)
+
+func (p CommitPath) String() string {
+	switch p {
+	case SlowPath:
+		return "slow"
+	case FastPath:
+		return "fast"
+	case ImplicitPath:
+		return "implicit"
+	}
+	return "unknown"
+}
+
+// VotingState is the part of the protocol state that has to survive a restart,
+// otherwise a restarted replica could vote for two different blocks on the same height
+type VotingState struct {
+	SentNRank     map[int]int
+	SentNSharesNo map[int]int
+	SentNShareId  map[int]crypto.Identifier
+	SentFShare    map[int]bool
+	SharedRanks   map[int][]int // the ranks of the blocks I sent a notarization share for, per height
+}
+
+func NewVotingState() *VotingState {
+	return &VotingState{
+		SentNRank:     make(map[int]int),
+		SentNSharesNo: make(map[int]int),
+		SentNShareId:  make(map[int]crypto.Identifier),
+		SentFShare:    make(map[int]bool),
+		SharedRanks:   make(map[int][]int),
+	}
+}
+
+// SharedRank tells if I sent a notarization share for a block of the rank on the height
+func (s *VotingState) SharedRank(height int, rank int) bool {
+	for _, shared := range s.SharedRanks[height] {
+		if shared == rank {
+			return true
+		}
+	}
+	return false
+}
+
+// ShareRank records that I sent a notarization share for a block of the rank on the height
+func (s *VotingState) ShareRank(height int, rank int) {
+	if s.SharedRanks == nil {
+		// the state was saved before the ranks were recorded
+		s.SharedRanks = make(map[int][]int)
+	}
+	s.SharedRanks[height] = append(s.SharedRanks[height], rank)
+}
+
+// Prune drops the heights up to the committed one, a replica does not vote on them anymore
+func (s *VotingState) Prune(committedHeight int) {
+	for height := range s.SentNSharesNo {
+		if height <= committedHeight {
+			delete(s.SentNRank, height)
+			delete(s.SentNSharesNo, height)
+			delete(s.SentNShareId, height)
+			delete(s.SharedRanks, height)
+		}
+	}
+	for height := range s.SentFShare {
+		if height <= committedHeight {
+			delete(s.SentFShare, height)
+		}
+	}
+}
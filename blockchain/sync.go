@@ -0,0 +1,122 @@
+package blockchain
+
+import (
+	"sort"
+
+	"banyan/config"
+	"banyan/crypto"
+	"banyan/identity"
+)
+
+// MaxSyncCount caps the number of blocks sent in one response
+const MaxSyncCount = 100
+
+// BlockRequest asks a peer for the block with the ID or, in the range mode (Count > 0),
+// for up to Count committed blocks starting from the Height
+type BlockRequest struct {
+	From   identity.NodeID
+	ID     crypto.Identifier
+	Height int
+	Count  int
+}
+
+// Sender returns the node asking for the blocks, the one the response goes to
+func (r BlockRequest) Sender() identity.NodeID {
+	return r.From
+}
+
+// BlockResponse carries the requested blocks ordered by height,
+// committed blocks come with the shares that notarized and finalized them
+type BlockResponse struct {
+	From    identity.NodeID
+	ID      crypto.Identifier // the requested block, empty in the range mode
+	Records []*CommittedRecord
+}
+
+func (r BlockResponse) Sender() identity.NodeID {
+	return r.From
+}
+
+// Finalized checks the evidence that the block of the record was finalized,
+// i.e., a valid finalization, more than 2n/3 valid finalization shares or, if fastQuorum is positive,
+// a valid fast finalization of that many replicas
+func (r *CommittedRecord) Finalized(n int, fastQuorum int) bool {
+	id := r.Block.ID
+	if r.Finalization != nil && r.Finalization.BlockID == id && VerifyFinalization(r.Finalization, SuperMajority(n)) == nil {
+		return true
+	}
+	if r.FastFinalization != nil && r.FastFinalization.BlockID == id && VerifyFastFinalization(r.FastFinalization, fastQuorum) == nil {
+		return true
+	}
+	voters := make(map[identity.NodeID]struct{})
+	for _, share := range r.FinalizationShares {
+		if share.BlockID == id && validShare(share.Signature, id, share.Voter) {
+			voters[share.Voter] = struct{}{}
+		}
+	}
+	return len(voters) >= SuperMajority(n)
+}
+
+// validShare checks the signature of a finalization share of the block
+func validShare(sig crypto.Signature, id crypto.Identifier, voter identity.NodeID) bool {
+	if _, known := config.GetConfig().Addrs[voter]; !known {
+		return false
+	}
+	ok, err := crypto.PubVerify(sig, crypto.IDToByte(FinalizeID(id)), voter)
+	return err == nil && ok
+}
+
+// CommittedCache keeps the records of the recently committed blocks to serve peers that are catching up
+type CommittedCache struct {
+	size    int
+	records map[int]*CommittedRecord
+	ids     map[crypto.Identifier]int
+	heights []int // ascending
+}
+
+func NewCommittedCache(size int) *CommittedCache {
+	return &CommittedCache{
+		size:    size,
+		records: make(map[int]*CommittedRecord),
+		ids:     make(map[crypto.Identifier]int),
+	}
+}
+
+// Add keeps the record, the oldest one is dropped once the cache is full
+func (c *CommittedCache) Add(record *CommittedRecord) {
+	height := record.Block.Height
+	if _, exists := c.records[height]; exists {
+		return
+	}
+	if len(c.heights) == c.size {
+		oldest := c.heights[0]
+		delete(c.ids, c.records[oldest].Block.ID)
+		delete(c.records, oldest)
+		c.heights = c.heights[1:]
+	}
+	c.records[height] = record
+	c.ids[record.Block.ID] = height
+	c.heights = append(c.heights, height)
+}
+
+// GetByID returns the record of the committed block with the id
+func (c *CommittedCache) GetByID(id crypto.Identifier) (*CommittedRecord, bool) {
+	height, exists := c.ids[id]
+	if !exists {
+		return nil, false
+	}
+	return c.records[height], true
+}
+
+// Range returns up to count records starting from the height,
+// false if the cache does not reach down to it
+func (c *CommittedCache) Range(from int, count int) ([]*CommittedRecord, bool) {
+	if len(c.heights) == 0 || c.heights[0] > from {
+		return nil, false
+	}
+	var records []*CommittedRecord
+	for i := sort.SearchInts(c.heights, from); i < len(c.heights) && len(records) < count; i++ {
+		records = append(records, c.records[c.heights[i]])
+	}
+	return records, true
+}
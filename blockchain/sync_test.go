@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/message"
+
+	"github.com/stretchr/testify/require"
+)
+
+func makeRecord(height int) *CommittedRecord {
+	block := &Block{Height: height, PrevID: crypto.MakeID(height - 1)}
+	block.ID = block.computeID()
+	return &CommittedRecord{Block: block}
+}
+
+// the id of a block survives the encoding, an empty payload decodes as nil
+func TestVerifyID(t *testing.T) {
+	block := &Block{Height: 1, Payload: make([]*message.Transaction, 0)}
+	block.ID = block.computeID()
+	require.True(t, block.VerifyID())
+
+	var buf bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buf).Encode(block))
+	var decoded Block
+	require.NoError(t, gob.NewDecoder(&buf).Decode(&decoded))
+	require.Nil(t, decoded.Payload)
+	require.True(t, decoded.VerifyID())
+
+	decoded.Height = 2
+	require.False(t, decoded.VerifyID())
+}
+
+// the oldest records are dropped once the cache is full
+func TestCommittedCache(t *testing.T) {
+	cache := NewCommittedCache(3)
+	for height := 1; height <= 5; height++ {
+		cache.Add(makeRecord(height))
+	}
+	_, ok := cache.Range(2, 10)
+	require.False(t, ok)
+	records, ok := cache.Range(3, 2)
+	require.True(t, ok)
+	require.Len(t, records, 2)
+	require.Equal(t, 3, records[0].Block.Height)
+	require.Equal(t, 4, records[1].Block.Height)
+
+	record := makeRecord(5)
+	found, ok := cache.GetByID(record.Block.ID)
+	require.True(t, ok)
+	require.Equal(t, 5, found.Block.Height)
+	_, ok = cache.GetByID(makeRecord(1).Block.ID)
+	require.False(t, ok)
+}
+
+// a notarization, or its shares, relabelled as a finalization does not prove that the block was finalized
+func TestRelabelledNotarization(t *testing.T) {
+	setNodes(t, 4)
+	useKeys(t, crypto.ECDSA_P256, 4)
+	record := makeRecord(1)
+	id := record.Block.ID
+	nBag := NewNSharesBag(4)
+	fBag := NewFSharesBag(4)
+	var notarization *Notarization
+	var finalization *Finalization
+	for voter := 1; voter <= 3; voter++ {
+		nShare := MakeNShare(1, 0, identity.NewNodeID(voter), id)
+		_, notarization = nBag.Add(nShare)
+		_, finalization = fBag.Add(MakeFShare(1, 0, identity.NewNodeID(voter), id))
+		record.FinalizationShares = append(record.FinalizationShares, &FinalizationShare{Height: 1, Voter: nShare.Voter, BlockID: id, Signature: nShare.Signature})
+	}
+	require.NoError(t, VerifyNotarization(notarization, SuperMajority(4)))
+	relabelled := &Finalization{Height: 1, BlockID: id, Signers: notarization.Signers, AggSig: notarization.AggSig}
+	require.Error(t, VerifyFinalization(relabelled, SuperMajority(4)))
+	record.Finalization = relabelled
+	require.False(t, record.Finalized(4, 0))
+
+	record.Finalization = finalization
+	require.NoError(t, VerifyFinalization(finalization, SuperMajority(4)))
+	require.True(t, record.Finalized(4, 0))
+	record.Finalization = nil
+	record.FinalizationShares = fBag.Shares(id)
+	require.True(t, record.Finalized(4, 0))
+}
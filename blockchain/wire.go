@@ -0,0 +1,69 @@
+package blockchain
+
+import (
+	"banyan/identity"
+	"banyan/message"
+	"banyan/types/encoding/binary"
+	"time"
+)
+
+// The blocks and shares have a binary encoding on the wire, the other messages are encoded with gob unless the
+// codec of the replicas handles them.
+
+func (b *Block) MarshalWire(w *binary.Writer) {
+	w.Int(b.Height)
+	w.Int(b.Rank)
+	w.String(string(b.Proposer))
+	w.Time(b.Timestamp)
+	message.WriteTransactions(w, b.Payload)
+	w.Fixed(b.PrevID[:])
+	w.Bytes(b.Sig)
+	w.Fixed(b.ID[:])
+	w.Int(int(b.Ts))
+}
+
+func (b *Block) UnmarshalWire(r *binary.Reader) {
+	b.Height = r.Int()
+	b.Rank = r.Int()
+	b.Proposer = identity.NodeID(r.String())
+	b.Timestamp = r.Time()
+	b.Payload = message.ReadTransactions(r)
+	r.Fixed(b.PrevID[:])
+	b.Sig = r.Bytes()
+	r.Fixed(b.ID[:])
+	b.Ts = time.Duration(r.Int())
+}
+
+func (ns *NotarizationShare) MarshalWire(w *binary.Writer) {
+	w.Int(ns.Height)
+	w.Int(ns.Rank)
+	w.String(string(ns.Voter))
+	w.Fixed(ns.BlockID[:])
+	w.Bytes(ns.FastSignature)
+	w.Bytes(ns.Signature)
+}
+
+func (ns *NotarizationShare) UnmarshalWire(r *binary.Reader) {
+	ns.Height = r.Int()
+	ns.Rank = r.Int()
+	ns.Voter = identity.NodeID(r.String())
+	r.Fixed(ns.BlockID[:])
+	ns.FastSignature = r.Bytes()
+	ns.Signature = r.Bytes()
+}
+
+func (fs *FinalizationShare) MarshalWire(w *binary.Writer) {
+	w.Int(fs.Height)
+	w.Int(fs.Rank)
+	w.String(string(fs.Voter))
+	w.Fixed(fs.BlockID[:])
+	w.Bytes(fs.Signature)
+}
+
+func (fs *FinalizationShare) UnmarshalWire(r *binary.Reader) {
+	fs.Height = r.Int()
+	fs.Rank = r.Int()
+	fs.Voter = identity.NodeID(r.String())
+	r.Fixed(fs.BlockID[:])
+	fs.Signature = r.Bytes()
+}
@@ -0,0 +1,110 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"banyan/crypto"
+	"banyan/message"
+	"banyan/types/encoding"
+	"banyan/types/encoding/binary"
+	"banyan/types/encoding/gob"
+	"banyan/types/encoding/json"
+
+	"github.com/stretchr/testify/require"
+)
+
+var wireCodecs = map[string]func() encoding.Encoder{
+	"gob":    func() encoding.Encoder { return gob.NewEncoder() },
+	"json":   func() encoding.Encoder { return json.NewEncoder() },
+	"binary": func() encoding.Encoder { return binary.NewEncoder() },
+}
+
+// makePayloadBlock returns a block of transactions of size bytes in all
+func makePayloadBlock(txs int, size int) *Block {
+	payload := make([]*message.Transaction, txs)
+	for i := range payload {
+		payload[i] = message.NewTransaction(make([]byte, size/txs), "client", uint64(i))
+	}
+	block := &Block{Height: 7, Rank: -1, Proposer: "2", Timestamp: time.Now(), Payload: payload, PrevID: crypto.MakeID(6), Ts: time.Second}
+	block.ID = block.computeID()
+	block.Sig = crypto.Signature("signature")
+	return block
+}
+
+// the codecs decode what they encode, and the id of the block still holds
+func TestWireCodecs(t *testing.T) {
+	empty := &Block{Height: 1, Payload: []*message.Transaction{}}
+	empty.ID = empty.computeID()
+	messages := []interface{}{
+		makePayloadBlock(3, 30),
+		empty,
+		&NotarizationShare{Height: 1, Rank: -1, Voter: "3", BlockID: crypto.MakeID(1), FastSignature: crypto.Signature("fast"), Signature: crypto.Signature("sig")},
+		&NotarizationShare{Height: 1, Rank: 2, Voter: "3", BlockID: crypto.MakeID(1), Signature: crypto.Signature("sig")},
+		&FinalizationShare{Height: 1, Rank: 0, Voter: "4", BlockID: crypto.MakeID(1), Signature: crypto.Signature("sig")},
+	}
+	for name, codec := range wireCodecs {
+		for _, m := range messages {
+			encoded, err := codec().Encode(m)
+			require.NoError(t, err, name)
+			decoded := newOf(m)
+			require.NoError(t, codec().Decode(encoded, decoded), name)
+			if block, ok := m.(*Block); ok {
+				require.True(t, decoded.(*Block).VerifyID(), name)
+				require.True(t, block.Timestamp.Equal(decoded.(*Block).Timestamp), name)
+				require.Equal(t, len(block.Payload), len(decoded.(*Block).Payload), name)
+				continue
+			}
+			require.Equal(t, m, decoded, name)
+		}
+	}
+}
+
+func newOf(m interface{}) interface{} {
+	switch m.(type) {
+	case *Block:
+		return new(Block)
+	case *NotarizationShare:
+		return new(NotarizationShare)
+	case *FinalizationShare:
+		return new(FinalizationShare)
+	}
+	panic(fmt.Sprintf("no message %T", m))
+}
+
+// a truncated binary block fails to decode rather than panics
+func TestWireTruncated(t *testing.T) {
+	encoded, err := binary.NewEncoder().Encode(makePayloadBlock(3, 30))
+	require.NoError(t, err)
+	for n := 0; n < len(encoded); n++ {
+		require.Error(t, binary.NewEncoder().Decode(encoded[:n], new(Block)))
+	}
+}
+
+// BenchmarkWire encodes and decodes a block of 1 MB in 1000 transactions, and a share
+func BenchmarkWire(b *testing.B) {
+	for _, name := range []string{"gob", "json", "binary"} {
+		for _, m := range []interface{}{
+			makePayloadBlock(1000, 1000000),
+			&NotarizationShare{Height: 1, Voter: "3", BlockID: crypto.MakeID(1), Signature: make(crypto.Signature, 64)},
+		} {
+			b.Run(fmt.Sprintf("%v/%T", name, m), func(b *testing.B) {
+				// a stream of messages, as over a connection
+				encoder, decoder := wireCodecs[name](), wireCodecs[name]()
+				var size int
+				for i := 0; i < b.N; i++ {
+					encoded, err := encoder.Encode(m)
+					if err != nil {
+						b.Fatal(err)
+					}
+					size = len(encoded)
+					if err := decoder.Decode(encoded, newOf(m)); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(size), "bytes")
+			})
+		}
+	}
+}
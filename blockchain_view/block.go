@@ -1,12 +1,11 @@
 package blockchain
 
 import (
-	"io"
-	"math/rand"
 	"time"
 
 	"banyan/crypto"
 	"banyan/identity"
+	"banyan/message"
 	"banyan/types"
 )
 
@@ -15,7 +14,7 @@ type Block struct {
 	QC        *QC
 	Proposer  identity.NodeID
 	Timestamp time.Time
-	Payload   []byte
+	Payload   []*message.Transaction
 	PrevID    crypto.Identifier
 	Sig       crypto.Signature
 	ID        crypto.Identifier
@@ -33,34 +32,65 @@ type rawBlock struct {
 }
 
 // MakeBlock creates an unsigned block
-func MakeBlock(view types.View, qc *QC, prevID crypto.Identifier, proposer identity.NodeID, blockByteSize int, r *rand.Rand) *Block {
+func MakeBlock(view types.View, qc *QC, prevID crypto.Identifier, proposer identity.NodeID, payload []*message.Transaction) *Block {
 	b := new(Block)
 	b.View = view
 	b.Proposer = proposer
 	b.QC = qc
-	b.Payload = generateRandomPayload(blockByteSize, r)
+	b.Payload = payload
 	b.PrevID = prevID
 	b.makeID(proposer)
 	return b
 }
 
 func (b *Block) makeID(nodeID identity.NodeID) {
-	raw := &rawBlock{
-		View:     b.View,
-		QC:       b.QC,
-		Proposer: b.Proposer,
-		PrevID:   b.PrevID,
-	}
-	raw.PayloadHash = crypto.MakeID(b.Payload)
-	b.ID = crypto.MakeID(raw)
+	b.ID = b.computeID()
 	// TODO: uncomment the following
 	b.Sig, _ = crypto.PrivSign(crypto.IDToByte(b.ID), nodeID, nil)
 }
 
-func generateRandomPayload(size int, r *rand.Rand) []byte {
-	payload := make([]byte, size)
-	if _, err := io.ReadFull(r, payload); err != nil {
-		panic(err)
+func (b *Block) computeID() crypto.Identifier {
+	return b.Header().ID()
+}
+
+// Header is what the id of a block is computed from, it binds a signature over the id
+// to the position of the block without carrying the payload
+type Header struct {
+	types.View
+	QC          *QC
+	Proposer    identity.NodeID
+	PayloadHash crypto.Identifier
+	PrevID      crypto.Identifier
+}
+
+// Header returns the header of the block
+func (b *Block) Header() *Header {
+	// an empty payload decodes as nil, both have to hash the same
+	payload := b.Payload
+	if payload == nil {
+		payload = []*message.Transaction{}
 	}
-	return payload
+	return &Header{
+		View:        b.View,
+		QC:          b.QC,
+		Proposer:    b.Proposer,
+		PayloadHash: crypto.MakeID(payload),
+		PrevID:      b.PrevID,
+	}
+}
+
+// ID computes the id of the block with this header
+func (h *Header) ID() crypto.Identifier {
+	return crypto.MakeID(&rawBlock{
+		View:        h.View,
+		QC:          h.QC,
+		Proposer:    h.Proposer,
+		PayloadHash: h.PayloadHash,
+		PrevID:      h.PrevID,
+	})
+}
+
+// VerifyID checks that the id matches the content of a block received from a peer
+func (b *Block) VerifyID() bool {
+	return b.computeID() == b.ID
 }
@@ -9,6 +9,8 @@ import (
 type BlockChain struct {
 	forrest          *LevelledForest
 	quorum           *Quorum
+	certificates     map[crypto.Identifier]*QC // QCs of the blocks that are not pruned yet
+	root             crypto.Identifier         // the last committed block, the genesis before the first commit
 	longestTailBlock *Block
 	// measurement
 	highestComitted     int
@@ -21,9 +23,18 @@ func NewBlockchain(n int) *BlockChain {
 	bc := new(BlockChain)
 	bc.forrest = NewLevelledForest()
 	bc.quorum = NewQuorum(n)
+	bc.certificates = make(map[crypto.Identifier]*QC)
 	return bc
 }
 
+// Restore makes a block recovered from the store the root of the chain
+func (bc *BlockChain) Restore(block *Block) {
+	bc.forrest.LowestLevel = uint64(block.View)
+	bc.root = block.ID
+	bc.AddBlock(block)
+	bc.highestComitted = int(block.View)
+}
+
 func (bc *BlockChain) Exists(id crypto.Identifier) bool {
 	return bc.forrest.HasVertex(id)
 }
@@ -34,7 +45,32 @@ func (bc *BlockChain) AddBlock(block *Block) {
 }
 
 func (bc *BlockChain) AddVote(vote *Vote) (bool, *QC) {
-	return bc.quorum.Add(vote)
+	isBuilt, qc := bc.quorum.Add(vote)
+	if isBuilt {
+		bc.AddQC(qc)
+	}
+	return isBuilt, qc
+}
+
+// VoteConflict returns the vote the voter sent for another block in the same view, nil if there is none
+func (bc *BlockChain) VoteConflict(vote *Vote) *Vote {
+	return bc.quorum.Conflict(vote)
+}
+
+// AddQC keeps the QC as the evidence of its block
+func (bc *BlockChain) AddQC(qc *QC) {
+	if uint64(qc.View) < bc.forrest.LowestLevel {
+		return
+	}
+	_, exists := bc.certificates[qc.BlockID]
+	if !exists {
+		bc.certificates[qc.BlockID] = qc
+	}
+}
+
+// GetQC returns the QC of the block, nil if it is unknown
+func (bc *BlockChain) GetQC(id crypto.Identifier) *QC {
+	return bc.certificates[id]
 }
 
 func (bc *BlockChain) GetBlockByID(id crypto.Identifier) (*Block, error) {
@@ -66,17 +102,51 @@ func (bc *BlockChain) GetGrandParentBlock(id crypto.Identifier) (*Block, error)
 	return bc.GetParentBlock(parentBlock.ID)
 }
 
-// CommitBlock prunes blocks and returns committed blocks up to the last committed one and prunedBlocks
+// SetGenesis sets the id the first block extends, the zero id by default
+func (bc *BlockChain) SetGenesis(id crypto.Identifier) {
+	if bc.highestComitted == 0 {
+		bc.root = id
+	}
+}
+
+// MissingAncestor walks back from the block to the last committed one and returns the first ancestor
+// that is missing
+func (bc *BlockChain) MissingAncestor(id crypto.Identifier) (crypto.Identifier, bool) {
+	vertex, exists := bc.forrest.GetVertex(id)
+	if !exists {
+		return id, true
+	}
+	for block := vertex.GetBlock(); block.PrevID != bc.root && uint64(block.View) > bc.forrest.LowestLevel; {
+		vertex, exists = bc.forrest.GetVertex(block.PrevID)
+		if !exists {
+			return block.PrevID, true
+		}
+		block = vertex.GetBlock()
+	}
+	return crypto.Identifier{}, false
+}
+
+// CommitBlock prunes blocks and returns committed blocks up to the last committed one and prunedBlocks.
+// Committed blocks are returned in commit order, the ancestors first.
 func (bc *BlockChain) CommitBlock(id crypto.Identifier, view types.View) ([]*Block, []*Block, error) {
 	vertex, ok := bc.forrest.GetVertex(id)
 	if !ok {
 		return nil, nil, fmt.Errorf("cannot find the block, id: %x", id)
 	}
+	// QCs of blocks committed previously are not needed anymore,
+	// the ones of the blocks committed now are kept until the next commit
+	for id, qc := range bc.certificates {
+		if uint64(qc.View) < bc.forrest.LowestLevel {
+			delete(bc.certificates, id)
+		}
+	}
+	bc.quorum.prune(types.View(bc.forrest.LowestLevel))
 	committedView := vertex.GetBlock().View
+	bc.root = id
 	bc.highestComitted = int(vertex.GetBlock().View)
 	var committedBlocks []*Block
 	for block := vertex.GetBlock(); uint64(block.View) > bc.forrest.LowestLevel; {
-		committedBlocks = append(committedBlocks, block)
+		committedBlocks = append([]*Block{block}, committedBlocks...)
 		_, ok := bc.quorum.votes[block.ID]
 		if ok {
 			delete(bc.quorum.votes, block.ID)
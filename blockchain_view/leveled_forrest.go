@@ -158,6 +158,10 @@ func (f *LevelledForest) AddVertex(vertex Vertex) {
 		return
 	}
 	// container is empty, i.e. full vertex is new and should be stored in container
+	if container.level != vertex.Level() {
+		// the container was created by a child that did not know the level of its parent
+		f.moveToLevel(container, vertex.Level())
+	}
 	container.vertex = vertex // add vertex to container
 	f.registerWithParent(container)
 	return
@@ -181,6 +185,19 @@ func (f *LevelledForest) registerWithParent(vertexContainer *vertexContainer) {
 	return
 }
 
+// moveToLevel moves an empty container to the level of its vertex
+func (f *LevelledForest) moveToLevel(container *vertexContainer, level uint64) {
+	containers := f.verticesAtLevel[container.level]
+	for i, c := range containers {
+		if c == container {
+			f.verticesAtLevel[container.level] = append(containers[:i:i], containers[i+1:]...)
+			break
+		}
+	}
+	container.level = level
+	f.verticesAtLevel[level] = append(f.verticesAtLevel[level], container)
+}
+
 // getOrCreateVertexContainer returns the vertexContainer if there exists one
 // or creates a new vertexContainer and adds it to the internal data structures.
 // It errors if a vertex with same id but different Level is already known
@@ -14,13 +14,14 @@ type Vote struct {
 	Voter   identity.NodeID
 	BlockID crypto.Identifier
 	crypto.Signature
+	verified bool // the signature was checked when the vote arrived, it is not sent
 }
 
 type QC struct {
 	Leader  identity.NodeID
 	View    types.View
 	BlockID crypto.Identifier
-	Signers []identity.NodeID
+	Signers crypto.Signers
 	crypto.AggSig
 	crypto.Signature
 }
@@ -28,6 +29,12 @@ type QC struct {
 type Quorum struct {
 	total int
 	votes map[crypto.Identifier]map[identity.NodeID]*Vote
+	first map[voterView]*Vote // the first vote of each voter in a view
+}
+
+type voterView struct {
+	types.View
+	Voter identity.NodeID
 }
 
 func MakeVote(view types.View, voter identity.NodeID, id crypto.Identifier) *Vote {
@@ -45,15 +52,34 @@ func MakeVote(view types.View, voter identity.NodeID, id crypto.Identifier) *Vot
 	}
 }
 
+// Claims returns the signature of the vote
+func (v *Vote) Claims() []crypto.Claim {
+	return []crypto.Claim{{Signer: v.Voter, Data: crypto.IDToByte(v.BlockID), Sig: v.Signature}}
+}
+
+// MarkVerified records that the signature of the vote holds, the protocol does not check it again
+func (v *Vote) MarkVerified() {
+	v.verified = true
+}
+
+func (v *Vote) IsVerified() bool {
+	return v.verified
+}
+
 func NewQuorum(total int) *Quorum {
 	return &Quorum{
 		total: total,
 		votes: make(map[crypto.Identifier]map[identity.NodeID]*Vote),
+		first: make(map[voterView]*Vote),
 	}
 }
 
 // Add adds id to quorum ack records
 func (q *Quorum) Add(vote *Vote) (bool, *QC) {
+	key := voterView{View: vote.View, Voter: vote.Voter}
+	if _, exists := q.first[key]; !exists {
+		q.first[key] = vote
+	}
 	if q.superMajority(vote.BlockID) {
 		return false, nil
 	}
@@ -79,6 +105,24 @@ func (q *Quorum) Add(vote *Vote) (bool, *QC) {
 	return false, nil
 }
 
+// Conflict returns the vote the voter sent for another block in the same view, nil if there is none
+func (q *Quorum) Conflict(vote *Vote) *Vote {
+	first, exists := q.first[voterView{View: vote.View, Voter: vote.Voter}]
+	if !exists || first.BlockID == vote.BlockID {
+		return nil
+	}
+	return first
+}
+
+// prune forgets the first votes of the views below view
+func (q *Quorum) prune(view types.View) {
+	for key := range q.first {
+		if key.View < view {
+			delete(q.first, key)
+		}
+	}
+}
+
 // Super majority quorum satisfied
 func (q *Quorum) superMajority(blockID crypto.Identifier) bool {
 	return q.size(blockID) > q.total*2/3
@@ -89,17 +133,16 @@ func (q *Quorum) size(blockID crypto.Identifier) int {
 	return len(q.votes[blockID])
 }
 
-func (q *Quorum) getSigs(blockID crypto.Identifier) (crypto.AggSig, []identity.NodeID, error) {
-	var sigs crypto.AggSig
-	var signers []identity.NodeID
+// getSigs aggregates the votes for the block, the QC, and the id of the block that carries it, do not depend on the
+// order the votes arrived in
+func (q *Quorum) getSigs(blockID crypto.Identifier) (crypto.AggSig, crypto.Signers, error) {
 	_, exists := q.votes[blockID]
 	if !exists {
 		return nil, nil, fmt.Errorf("sigs does not exist, id: %x", blockID)
 	}
+	sigs := make(map[identity.NodeID]crypto.Signature)
 	for _, vote := range q.votes[blockID] {
-		sigs = append(sigs, vote.Signature)
-		signers = append(signers, vote.Voter)
+		sigs[vote.Voter] = vote.Signature
 	}
-
-	return sigs, signers, nil
+	return crypto.Aggregate(sigs)
 }
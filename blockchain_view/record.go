@@ -0,0 +1,28 @@
+package blockchain
+
+import (
+	"banyan/crypto"
+	"banyan/types"
+)
+
+// CommittedRecord is what the store keeps for every committed block,
+// the QC is the evidence that the block was certified
+type CommittedRecord struct {
+	Block *Block
+	QC    *QC
+}
+
+// VotingState is the part of the protocol state that has to survive a restart,
+// otherwise a restarted replica could vote twice in the same view
+type VotingState struct {
+	LastVotedView types.View
+	PreferredView types.View
+	HighQC        *QC
+	Voted         []VotedBlock // blocks voted for above the last committed one, fetched again after a restart
+}
+
+// VotedBlock is a block voted for, the state keeps its id only since the block may carry a large payload
+type VotedBlock struct {
+	View types.View
+	ID   crypto.Identifier
+}
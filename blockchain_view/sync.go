@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"sort"
+
+	"banyan/config"
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/types"
+)
+
+// MaxSyncCount caps the number of blocks sent in one response
+const MaxSyncCount = 100
+
+// BlockRequest asks a peer for the block with the ID or, in the range mode (Count > 0),
+// for up to Count committed blocks starting from the View
+type BlockRequest struct {
+	From  identity.NodeID
+	ID    crypto.Identifier
+	View  types.View
+	Count int
+}
+
+// Sender returns the node asking for the blocks, the one the response goes to
+func (r BlockRequest) Sender() identity.NodeID {
+	return r.From
+}
+
+// BlockResponse carries the requested blocks ordered by view,
+// committed blocks come with the QC that certified them
+type BlockResponse struct {
+	From    identity.NodeID
+	ID      crypto.Identifier // the requested block, empty in the range mode
+	Records []*CommittedRecord
+}
+
+func (r BlockResponse) Sender() identity.NodeID {
+	return r.From
+}
+
+// Certified checks that the QC of the record is a valid quorum certificate of its block
+func (r *CommittedRecord) Certified(n int) bool {
+	qc := r.QC
+	if qc == nil || qc.BlockID != r.Block.ID {
+		return false
+	}
+	for _, signer := range qc.Signers.IDs() {
+		if _, known := config.GetConfig().Addrs[signer]; !known {
+			return false
+		}
+	}
+	if qc.Signers.Count() <= n*2/3 {
+		return false
+	}
+	ok, err := crypto.VerifyQuorumSignature(qc.AggSig, qc.BlockID, qc.Signers)
+	return err == nil && ok
+}
+
+// CommittedCache keeps the records of the recently committed blocks to serve peers that are catching up
+type CommittedCache struct {
+	size    int
+	records map[types.View]*CommittedRecord
+	ids     map[crypto.Identifier]types.View
+	views   []int // ascending
+}
+
+func NewCommittedCache(size int) *CommittedCache {
+	return &CommittedCache{
+		size:    size,
+		records: make(map[types.View]*CommittedRecord),
+		ids:     make(map[crypto.Identifier]types.View),
+	}
+}
+
+// Add keeps the record, the oldest one is dropped once the cache is full
+func (c *CommittedCache) Add(record *CommittedRecord) {
+	view := record.Block.View
+	if _, exists := c.records[view]; exists {
+		return
+	}
+	if len(c.views) == c.size {
+		oldest := types.View(c.views[0])
+		delete(c.ids, c.records[oldest].Block.ID)
+		delete(c.records, oldest)
+		c.views = c.views[1:]
+	}
+	c.records[view] = record
+	c.ids[record.Block.ID] = view
+	c.views = append(c.views, int(view))
+}
+
+// GetByID returns the record of the committed block with the id
+func (c *CommittedCache) GetByID(id crypto.Identifier) (*CommittedRecord, bool) {
+	view, exists := c.ids[id]
+	if !exists {
+		return nil, false
+	}
+	return c.records[view], true
+}
+
+// Range returns up to count records starting from the view,
+// false if the cache does not reach down to it
+func (c *CommittedCache) Range(from types.View, count int) ([]*CommittedRecord, bool) {
+	if len(c.views) == 0 || types.View(c.views[0]) > from {
+		return nil, false
+	}
+	var records []*CommittedRecord
+	for i := sort.SearchInts(c.views, int(from)); i < len(c.views) && len(records) < count; i++ {
+		records = append(records, c.records[types.View(c.views[i])])
+	}
+	return records, true
+}
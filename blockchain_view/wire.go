@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"time"
+
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/message"
+	"banyan/types"
+	"banyan/types/encoding/binary"
+)
+
+// The blocks and votes have a binary encoding on the wire, the other messages are encoded with gob unless the
+// codec of the replicas handles them.
+
+func (b *Block) MarshalWire(w *binary.Writer) {
+	w.Int(int(b.View))
+	w.Bool(b.QC != nil)
+	if b.QC != nil {
+		b.QC.MarshalWire(w)
+	}
+	w.String(string(b.Proposer))
+	w.Time(b.Timestamp)
+	message.WriteTransactions(w, b.Payload)
+	w.Fixed(b.PrevID[:])
+	w.Bytes(b.Sig)
+	w.Fixed(b.ID[:])
+	w.Int(int(b.Ts))
+}
+
+func (b *Block) UnmarshalWire(r *binary.Reader) {
+	b.View = types.View(r.Int())
+	if r.Bool() {
+		b.QC = new(QC)
+		b.QC.UnmarshalWire(r)
+	}
+	b.Proposer = identity.NodeID(r.String())
+	b.Timestamp = r.Time()
+	b.Payload = message.ReadTransactions(r)
+	r.Fixed(b.PrevID[:])
+	b.Sig = r.Bytes()
+	r.Fixed(b.ID[:])
+	b.Ts = time.Duration(r.Int())
+}
+
+func (qc *QC) MarshalWire(w *binary.Writer) {
+	w.String(string(qc.Leader))
+	w.Int(int(qc.View))
+	w.Fixed(qc.BlockID[:])
+	w.Bytes(qc.Signers)
+	w.Uint64(uint64(len(qc.AggSig)))
+	for _, sig := range qc.AggSig {
+		w.Bytes(sig)
+	}
+	w.Bytes(qc.Signature)
+}
+
+func (qc *QC) UnmarshalWire(r *binary.Reader) {
+	qc.Leader = identity.NodeID(r.String())
+	qc.View = types.View(r.Int())
+	r.Fixed(qc.BlockID[:])
+	qc.Signers = r.Bytes()
+	if n := r.Len(1); n > 0 {
+		qc.AggSig = make(crypto.AggSig, n)
+		for i := range qc.AggSig {
+			qc.AggSig[i] = r.Bytes()
+		}
+	}
+	qc.Signature = r.Bytes()
+}
+
+func (v *Vote) MarshalWire(w *binary.Writer) {
+	w.Int(int(v.View))
+	w.String(string(v.Voter))
+	w.Fixed(v.BlockID[:])
+	w.Bytes(v.Signature)
+}
+
+func (v *Vote) UnmarshalWire(r *binary.Reader) {
+	v.View = types.View(r.Int())
+	v.Voter = identity.NodeID(r.String())
+	r.Fixed(v.BlockID[:])
+	v.Signature = r.Bytes()
+}
@@ -0,0 +1,205 @@
+// Package byzantine implements the behaviors of Byzantine nodes. A strategy is plugged into the socket
+// of a node, which otherwise follows the protocol, and decides what the node sends instead.
+package byzantine
+
+import (
+	"fmt"
+	"sort"
+
+	"banyan/blockchain"
+	view "banyan/blockchain_view"
+	"banyan/config"
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/socket"
+)
+
+// names of the strategies, config.Strategy selects one of them
+const (
+	Silence              = "silence"
+	Equivocate           = "equivocate"
+	DoubleVote           = "double_vote"
+	WithholdFinalization = "withhold_finalization"
+	ForkAndDelay         = "fork_and_delay"
+	Selective            = "selective"
+)
+
+// Constructor creates the strategy of a node
+type Constructor func(id identity.NodeID) socket.Behavior
+
+var strategies = map[string]Constructor{
+	Silence:              func(identity.NodeID) socket.Behavior { return silence{} },
+	Equivocate:           newEquivocate,
+	DoubleVote:           newDoubleVote,
+	WithholdFinalization: func(identity.NodeID) socket.Behavior { return withholdFinalization{} },
+	ForkAndDelay:         newForkAndDelay,
+	Selective:            newSelective,
+}
+
+// Register adds a strategy, it has to be called before the nodes are created
+func Register(name string, constructor Constructor) {
+	strategies[name] = constructor
+}
+
+// New creates the strategy registered under the given name, an empty name is silence
+func New(name string, id identity.NodeID) (socket.Behavior, error) {
+	if name == "" {
+		name = Silence
+	}
+	constructor, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %s", name)
+	}
+	return constructor(id), nil
+}
+
+// Silent tells whether the strategy sends nothing at all, the node does not need to run the protocol then
+func Silent(name string) bool {
+	return name == "" || name == Silence
+}
+
+// silence sends nothing, the node looks crashed to its peers
+type silence struct{}
+
+func (silence) Outgoing(identity.NodeID, interface{}, func(identity.NodeID, interface{})) {}
+
+func (silence) Incoming(interface{}, func(identity.NodeID, interface{})) {}
+
+// withholdFinalization follows the protocol but never sends a finalization share,
+// the view-based protocols have none so the node is honest there
+type withholdFinalization struct{}
+
+func (withholdFinalization) Outgoing(to identity.NodeID, m interface{}, send func(identity.NodeID, interface{})) {
+	switch m.(type) {
+	case *blockchain.FinalizationShare, blockchain.FinalizationShare:
+		return
+	}
+	send(to, m)
+}
+
+func (withholdFinalization) Incoming(interface{}, func(identity.NodeID, interface{})) {}
+
+// selective follows the protocol but only sends to a subset of its peers
+type selective struct {
+	peers map[identity.NodeID]struct{}
+}
+
+func newSelective(id identity.NodeID) socket.Behavior {
+	subset := config.GetConfig().StrategyPeers
+	if len(subset) == 0 {
+		subset, _ = halves(id)
+	}
+	s := selective{peers: make(map[identity.NodeID]struct{})}
+	for _, peer := range subset {
+		s.peers[peer] = struct{}{}
+	}
+	return s
+}
+
+func (s selective) Outgoing(to identity.NodeID, m interface{}, send func(identity.NodeID, interface{})) {
+	if _, ok := s.peers[to]; ok {
+		send(to, m)
+	}
+}
+
+func (selective) Incoming(interface{}, func(identity.NodeID, interface{})) {}
+
+// halves splits the peers of the node, ordered by id, in a lower and an upper half
+func halves(id identity.NodeID) ([]identity.NodeID, []identity.NodeID) {
+	var peers []identity.NodeID
+	for peer := range config.GetConfig().Addrs {
+		if peer != id {
+			peers = append(peers, peer)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Node() < peers[j].Node() })
+	return peers[:len(peers)/2], peers[len(peers)/2:]
+}
+
+// broadcast sends the message to all peers of the node
+func broadcast(id identity.NodeID, m interface{}, send func(identity.NodeID, interface{})) {
+	for peer := range config.GetConfig().Addrs {
+		if peer != id {
+			send(peer, m)
+		}
+	}
+}
+
+// the messages are pointers when the node sends them and values when they are received
+
+func asBlock(m interface{}) (*blockchain.Block, bool) {
+	switch b := m.(type) {
+	case *blockchain.Block:
+		return b, true
+	case blockchain.Block:
+		return &b, true
+	}
+	return nil, false
+}
+
+func asViewBlock(m interface{}) (*view.Block, bool) {
+	switch b := m.(type) {
+	case *view.Block:
+		return b, true
+	case view.Block:
+		return &b, true
+	}
+	return nil, false
+}
+
+// historyDepth is the number of heights (views) below the highest one a strategy remembers blocks for
+const historyDepth = 100
+
+// history keeps the blocks a strategy has seen on the recent heights (views)
+type history struct {
+	blocks  map[crypto.Identifier]interface{}
+	heights map[int][]crypto.Identifier
+	top     int
+}
+
+func newHistory() *history {
+	return &history{
+		blocks:  make(map[crypto.Identifier]interface{}),
+		heights: make(map[int][]crypto.Identifier),
+	}
+}
+
+// add keeps a block of either blockchain package, it returns false if the block is known or too old
+func (h *history) add(m interface{}) (int, crypto.Identifier, bool) {
+	var height int
+	var id crypto.Identifier
+	var block interface{}
+	if b, ok := asBlock(m); ok {
+		height, id, block = b.Height, b.ID, b
+	} else if b, ok := asViewBlock(m); ok {
+		height, id, block = int(b.View), b.ID, b
+	} else {
+		return 0, id, false
+	}
+	if _, known := h.blocks[id]; known || height < h.top-historyDepth {
+		return height, id, false
+	}
+	h.blocks[id] = block
+	h.heights[height] = append(h.heights[height], id)
+	if height > h.top {
+		h.top = height
+		for old, ids := range h.heights {
+			if old >= h.top-historyDepth {
+				continue
+			}
+			for _, id := range ids {
+				delete(h.blocks, id)
+			}
+			delete(h.heights, old)
+		}
+	}
+	return height, id, true
+}
+
+func (h *history) get(id crypto.Identifier) interface{} {
+	return h.blocks[id]
+}
+
+func (h *history) at(height int) []crypto.Identifier {
+	return h.heights[height]
+}
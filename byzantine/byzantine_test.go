@@ -0,0 +1,151 @@
+package byzantine
+
+import (
+	"testing"
+
+	"banyan/blockchain"
+	"banyan/config"
+	"banyan/crypto"
+	"banyan/identity"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setNodes(t *testing.T, n int) {
+	saved := config.Configuration
+	t.Cleanup(func() { config.Configuration = saved })
+	config.Configuration.Addrs = make(map[identity.NodeID]string)
+	for i := 1; i <= n; i++ {
+		config.Configuration.Addrs[identity.NewNodeID(i)] = ""
+	}
+	config.Configuration.N = n
+}
+
+// sent records what a strategy sends to each peer
+type sent map[identity.NodeID][]interface{}
+
+func (s sent) send(to identity.NodeID, m interface{}) {
+	s[to] = append(s[to], m)
+}
+
+func TestNew(t *testing.T) {
+	setNodes(t, 4)
+	for _, name := range []string{"", Silence, Equivocate, DoubleVote, WithholdFinalization, ForkAndDelay, Selective} {
+		strategy, err := New(name, identity.NewNodeID(4))
+		require.NoError(t, err, name)
+		require.NotNil(t, strategy, name)
+	}
+	_, err := New("crash", identity.NewNodeID(4))
+	require.Error(t, err)
+	require.True(t, Silent(""))
+	require.False(t, Silent(Equivocate))
+}
+
+func TestWithholdAndSelective(t *testing.T) {
+	setNodes(t, 5)
+	me := identity.NewNodeID(5)
+	nShare := &blockchain.NotarizationShare{Height: 1}
+	fShare := &blockchain.FinalizationShare{Height: 1}
+
+	withhold, _ := New(WithholdFinalization, me)
+	out := sent{}
+	withhold.Outgoing("1", nShare, out.send)
+	withhold.Outgoing("1", fShare, out.send)
+	withhold.Outgoing("1", *fShare, out.send)
+	require.Equal(t, []interface{}{nShare}, out["1"])
+
+	// the lower half of the peers by default
+	selective, _ := New(Selective, me)
+	out = sent{}
+	for _, peer := range []identity.NodeID{"1", "2", "3", "4"} {
+		selective.Outgoing(peer, nShare, out.send)
+	}
+	require.Len(t, out, 2)
+	require.Contains(t, out, identity.NodeID("1"))
+	require.Contains(t, out, identity.NodeID("2"))
+
+	config.Configuration.StrategyPeers = []identity.NodeID{"3"}
+	selective, _ = New(Selective, me)
+	out = sent{}
+	for _, peer := range []identity.NodeID{"1", "2", "3", "4"} {
+		selective.Outgoing(peer, fShare, out.send)
+	}
+	require.Equal(t, sent{"3": {fShare}}, out)
+
+	silent, _ := New(Silence, me)
+	out = sent{}
+	silent.Outgoing("1", nShare, out.send)
+	require.Empty(t, out)
+}
+
+// the fork of a proposal is a valid proposal of the same height on a block of the height before
+func TestForkAndDelay(t *testing.T) {
+	setNodes(t, 4)
+	require.NoError(t, crypto.GenerateKeys(crypto.ECDSA_P256, []identity.NodeID{"1", "2", "3", "4"}))
+	defer crypto.UseKeys(nil)
+	genesis := blockchain.MakeBlock(1, 0, crypto.Identifier{}, "1", nil)
+	parent := blockchain.MakeBlock(2, 0, genesis.ID, "2", nil)
+	sibling := blockchain.MakeBlock(2, 1, genesis.ID, "3", nil)
+	valid := func(b *blockchain.Block) {
+		require.True(t, b.VerifyID())
+		ok, _ := crypto.PubVerify(b.Sig, crypto.IDToByte(b.ID), b.Proposer)
+		require.True(t, ok)
+	}
+
+	// with one block on the height before, the transactions are replaced
+	fork := newForkAndDelay("4").(*forkAndDelay)
+	fork.Incoming(genesis, nil)
+	fork.Incoming(parent, nil)
+	proposal := blockchain.MakeBlock(3, 0, parent.ID, "4", nil)
+	forked := fork.fork(proposal).(*blockchain.Block)
+	valid(forked)
+	require.NotEqual(t, proposal.ID, forked.ID)
+	require.Equal(t, 3, forked.Height)
+	require.Equal(t, parent.ID, forked.PrevID)
+	require.Len(t, forked.Payload, 1)
+	require.Equal(t, forked, fork.fork(proposal), "a proposal is forked once")
+
+	// with another one, the fork is built on it
+	fork.Incoming(sibling, nil)
+	proposal = blockchain.MakeBlock(3, 1, parent.ID, "4", nil)
+	forked = fork.fork(proposal).(*blockchain.Block)
+	valid(forked)
+	require.Equal(t, 3, forked.Height)
+	require.Equal(t, sibling.ID, forked.PrevID)
+	require.Nil(t, fork.fork(parent), "not my proposal")
+}
+
+func TestHistory(t *testing.T) {
+	h := newHistory()
+	block := func(height int, name string) blockchain.Block {
+		return blockchain.Block{Height: height, ID: crypto.MakeID(name)}
+	}
+	_, _, added := h.add(block(1, "a"))
+	require.True(t, added)
+	_, _, added = h.add(&blockchain.Block{Height: 1, ID: crypto.MakeID("a")})
+	require.False(t, added)
+	h.add(block(1, "b"))
+	require.Len(t, h.at(1), 2)
+	require.NotNil(t, h.get(crypto.MakeID("b")))
+
+	// the blocks far below the highest one are forgotten
+	h.add(block(historyDepth+2, "c"))
+	require.Empty(t, h.at(1))
+	require.Nil(t, h.get(crypto.MakeID("a")))
+	_, _, added = h.add(block(1, "d"))
+	require.False(t, added)
+	_, _, added = h.add(&blockchain.NotarizationShare{})
+	require.False(t, added)
+}
+
+func TestIsByzantine(t *testing.T) {
+	setNodes(t, 4)
+	config.Configuration.ByzNo = 1
+	config.Configuration.Strategy = Equivocate
+	config.Configuration.Strategies = map[identity.NodeID]string{"2": DoubleVote}
+	require.False(t, config.GetConfig().IsByzantine("1"))
+	require.True(t, config.GetConfig().IsByzantine("2"))
+	require.True(t, config.GetConfig().IsByzantine("4"))
+	require.Equal(t, DoubleVote, config.GetConfig().StrategyOf("2"))
+	require.Equal(t, Equivocate, config.GetConfig().StrategyOf("4"))
+}
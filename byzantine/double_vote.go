@@ -0,0 +1,113 @@
+package byzantine
+
+import (
+	"sync"
+
+	"banyan/blockchain"
+	view "banyan/blockchain_view"
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/socket"
+)
+
+// doubleVote signs the notarization share (vote) it sends on a height (view) for every other block
+// of that height as well, e.g., rank -1 shares for all the blocks an equivocating leader proposed
+type doubleVote struct {
+	id identity.NodeID
+
+	mu     sync.Mutex
+	blocks *history
+	shares map[int]func(crypto.Identifier) interface{} // makes a share like the first one I sent on the height
+	voted  map[crypto.Identifier]struct{}
+}
+
+func newDoubleVote(id identity.NodeID) socket.Behavior {
+	return &doubleVote{
+		id:     id,
+		blocks: newHistory(),
+		shares: make(map[int]func(crypto.Identifier) interface{}),
+		voted:  make(map[crypto.Identifier]struct{}),
+	}
+}
+
+func (d *doubleVote) Outgoing(to identity.NodeID, m interface{}, send func(identity.NodeID, interface{})) {
+	send(to, m)
+	for _, share := range d.observe(m) {
+		broadcast(d.id, share, send)
+	}
+}
+
+func (d *doubleVote) Incoming(m interface{}, send func(identity.NodeID, interface{})) {
+	for _, share := range d.observe(m) {
+		broadcast(d.id, share, send)
+	}
+}
+
+// observe returns the extra shares to send after m
+func (d *doubleVote) observe(m interface{}) []interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var extra []interface{}
+	if height, id, added := d.blocks.add(m); added {
+		if makeShare, ok := d.shares[height]; ok {
+			extra = append(extra, d.vote(id, makeShare)...)
+		}
+		return extra
+	}
+
+	var height int
+	var id crypto.Identifier
+	var makeShare func(crypto.Identifier) interface{}
+	switch s := m.(type) {
+	case *blockchain.NotarizationShare:
+		if s.Voter != d.id {
+			return nil
+		}
+		height, id = s.Height, s.BlockID
+		makeShare = func(other crypto.Identifier) interface{} {
+			return blockchain.MakeNShare(s.Height, s.Rank, d.id, other)
+		}
+	case *view.Vote:
+		if s.Voter != d.id {
+			return nil
+		}
+		height, id = int(s.View), s.BlockID
+		makeShare = func(other crypto.Identifier) interface{} {
+			return view.MakeVote(s.View, d.id, other)
+		}
+	default:
+		return nil
+	}
+	d.voted[id] = struct{}{}
+	if _, ok := d.shares[height]; ok {
+		return nil
+	}
+	d.shares[height] = makeShare
+	for _, other := range d.blocks.at(height) {
+		extra = append(extra, d.vote(other, makeShare)...)
+	}
+	d.prune()
+	return extra
+}
+
+func (d *doubleVote) vote(id crypto.Identifier, makeShare func(crypto.Identifier) interface{}) []interface{} {
+	if _, ok := d.voted[id]; ok {
+		return nil
+	}
+	d.voted[id] = struct{}{}
+	return []interface{}{makeShare(id)}
+}
+
+// prune forgets the shares of the heights the history does not keep anymore
+func (d *doubleVote) prune() {
+	for height := range d.shares {
+		if height < d.blocks.top-historyDepth {
+			delete(d.shares, height)
+		}
+	}
+	for id := range d.voted {
+		if d.blocks.get(id) == nil {
+			delete(d.voted, id)
+		}
+	}
+}
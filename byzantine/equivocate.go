@@ -0,0 +1,75 @@
+package byzantine
+
+import (
+	"sync"
+
+	"banyan/blockchain"
+	view "banyan/blockchain_view"
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/message"
+	"banyan/socket"
+)
+
+// equivocate sends its proposals to the lower half of its peers and a conflicting block
+// for the same height and rank (view) to the upper half
+type equivocate struct {
+	id    identity.NodeID
+	upper map[identity.NodeID]struct{}
+
+	mu       sync.Mutex
+	original crypto.Identifier // the last proposal that got a conflicting block
+	twin     interface{}
+}
+
+func newEquivocate(id identity.NodeID) socket.Behavior {
+	e := &equivocate{id: id, upper: make(map[identity.NodeID]struct{})}
+	_, upper := halves(id)
+	for _, peer := range upper {
+		e.upper[peer] = struct{}{}
+	}
+	return e
+}
+
+func (e *equivocate) Outgoing(to identity.NodeID, m interface{}, send func(identity.NodeID, interface{})) {
+	if _, ok := e.upper[to]; ok {
+		if twin := e.conflicting(m); twin != nil {
+			send(to, twin)
+			return
+		}
+	}
+	send(to, m)
+}
+
+func (e *equivocate) Incoming(interface{}, func(identity.NodeID, interface{})) {}
+
+// conflicting returns the block that conflicts with my proposal, nil if m is not one of my proposals
+func (e *equivocate) conflicting(m interface{}) interface{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if b, ok := asBlock(m); ok && b.Proposer == e.id {
+		if e.twin == nil || e.original != b.ID {
+			twin := blockchain.MakeBlock(b.Height, b.Rank, b.PrevID, e.id, otherPayload(e.id, b.Payload, b.Height))
+			twin.Timestamp = b.Timestamp
+			e.original, e.twin = b.ID, twin
+		}
+		return e.twin
+	}
+	if b, ok := asViewBlock(m); ok && b.Proposer == e.id {
+		if e.twin == nil || e.original != b.ID {
+			twin := view.MakeBlock(b.View, b.QC, b.PrevID, e.id, otherPayload(e.id, b.Payload, int(b.View)))
+			twin.Timestamp = b.Timestamp
+			e.original, e.twin = b.ID, twin
+		}
+		return e.twin
+	}
+	return nil
+}
+
+// otherPayload leaves the last transaction out, an empty payload gets a transaction of its own
+func otherPayload(id identity.NodeID, payload []*message.Transaction, height int) []*message.Transaction {
+	if len(payload) > 0 {
+		return payload[:len(payload)-1]
+	}
+	return []*message.Transaction{message.NewTransaction([]byte("equivocation"), string(id), uint64(height))}
+}
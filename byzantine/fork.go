@@ -0,0 +1,87 @@
+package byzantine
+
+import (
+	"sync"
+	"time"
+
+	"banyan/blockchain"
+	view "banyan/blockchain_view"
+	"banyan/config"
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/socket"
+)
+
+// forkAndDelay proposes on the parent of the block it should extend, which leaves that block out of the chain,
+// and holds the proposal back to let it arrive as late as possible; with heights, where a block extends one of the
+// height before, it proposes on another block of that height, or replaces the transactions of its proposal
+type forkAndDelay struct {
+	id    identity.NodeID
+	delay time.Duration
+
+	mu       sync.Mutex
+	blocks   *history
+	original crypto.Identifier // the last proposal that was forked
+	forked   interface{}
+}
+
+func newForkAndDelay(id identity.NodeID) socket.Behavior {
+	delay := time.Duration(config.GetConfig().StrategyDelay) * time.Millisecond
+	if delay == 0 {
+		delay = time.Duration(config.GetConfig().Timeout) * time.Millisecond / 2
+	}
+	return &forkAndDelay{id: id, delay: delay, blocks: newHistory()}
+}
+
+func (f *forkAndDelay) Outgoing(to identity.NodeID, m interface{}, send func(identity.NodeID, interface{})) {
+	forked := f.fork(m)
+	if forked == nil {
+		send(to, m)
+		return
+	}
+	time.AfterFunc(f.delay, func() { send(to, forked) })
+}
+
+func (f *forkAndDelay) Incoming(m interface{}, _ func(identity.NodeID, interface{})) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocks.add(m)
+}
+
+// fork returns the block that replaces my proposal, it is the proposal itself if I do not have the parent of a
+// view block, and nil if m is not one of my proposals
+func (f *forkAndDelay) fork(m interface{}) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocks.add(m)
+	if b, ok := asBlock(m); ok && b.Proposer == f.id {
+		if f.forked == nil || f.original != b.ID {
+			f.original, f.forked = b.ID, b
+			// a block has to extend one of the height before, the fork is built on another block of that height,
+			// or on the same parent with other transactions if I know no other
+			prevID, payload := b.PrevID, otherPayload(f.id, b.Payload, b.Height)
+			for _, id := range f.blocks.at(b.Height - 1) {
+				if sibling, ok := f.blocks.get(id).(*blockchain.Block); ok && id != b.PrevID {
+					prevID, payload = sibling.ID, b.Payload
+					break
+				}
+			}
+			forked := blockchain.MakeBlock(b.Height, b.Rank, prevID, f.id, payload)
+			forked.Timestamp = b.Timestamp
+			f.forked = forked
+		}
+		return f.forked
+	}
+	if b, ok := asViewBlock(m); ok && b.Proposer == f.id {
+		if f.forked == nil || f.original != b.ID {
+			f.original, f.forked = b.ID, b
+			if parent, ok := f.blocks.get(b.PrevID).(*view.Block); ok {
+				forked := view.MakeBlock(b.View, parent.QC, parent.PrevID, f.id, b.Payload)
+				forked.Timestamp = b.Timestamp
+				f.forked = forked
+			}
+		}
+		return f.forked
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"banyan/checker"
+	"banyan/identity"
+	"banyan/log"
+	"banyan/simulator"
+)
+
+var stall = flag.Duration("stall", 0, "longest time a replica may go without committing, no bound if zero")
+var byz = flag.String("byz", "", "comma separated ids of Byzantine replicas whose commits are ignored, besides the ones the logs name")
+var report = flag.Bool("report", false, "the files are json reports written by simulate -out instead of logs")
+
+func main() {
+	_ = flag.Set("log_level", "error")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %v [flags] server.*.log\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.Setup()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var executions []*checker.Execution
+	if *report {
+		for _, name := range flag.Args() {
+			e, err := readReport(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			executions = append(executions, e)
+		}
+	} else {
+		e, err := readLogs(flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+		executions = append(executions, e)
+	}
+
+	failed := false
+	for i, e := range executions {
+		if *report {
+			fmt.Printf("%v:\n", flag.Arg(i))
+		}
+		for _, id := range strings.Split(*byz, ",") {
+			if id != "" {
+				e.Byzantine = append(e.Byzantine, identity.NodeID(strings.TrimSpace(id)))
+			}
+		}
+		violations := e.Check(*stall)
+		blocks := 0
+		for _, commits := range e.Commits {
+			if len(commits) > blocks {
+				blocks = len(commits)
+			}
+		}
+		fmt.Printf("replicas: %v, Byzantine: %v, committed blocks: %v, time: %v, violations: %v\n",
+			len(e.Commits), len(e.Byzantine), blocks, e.End.Sub(e.Start).Round(time.Millisecond), len(violations))
+		for _, v := range violations {
+			fmt.Println(v)
+		}
+		failed = failed || len(violations) > 0
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func readLogs(names []string) (*checker.Execution, error) {
+	var logs []io.Reader
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		logs = append(logs, file)
+	}
+	return checker.ReadLogs(logs...)
+}
+
+func readReport(name string) (*checker.Execution, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var r simulator.Report
+	err = json.NewDecoder(file).Decode(&r)
+	if err != nil {
+		return nil, err
+	}
+	return checker.FromReport(&r), nil
+}
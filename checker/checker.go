@@ -0,0 +1,226 @@
+// Package checker checks an execution from the blocks every replica committed, in a simulation or in the logs of a
+// run: no two honest replicas commit conflicting blocks, each of them commits a prefix of the same chain, and none of
+// them goes longer than a bound without committing.
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"banyan/crypto"
+	"banyan/identity"
+)
+
+// Commit is a block committed by a replica
+type Commit struct {
+	Height int // the view in HotStuff and Streamlet
+	ID     crypto.Identifier
+	PrevID crypto.Identifier // zero if unknown, the block is then not checked against its parent
+	Time   time.Time
+}
+
+// Kind is the kind of a violation
+type Kind string
+
+const (
+	// Conflict is two replicas committing different blocks at the same height
+	Conflict Kind = "conflict"
+	// Fork is two committed blocks extending the same block, a fork of a finalized block
+	Fork Kind = "fork"
+	// Prefix is a replica whose chain is not a prefix of the chain committed by another
+	Prefix Kind = "prefix"
+	// Gap is a replica committing a block that does not extend the block it committed before
+	Gap Kind = "gap"
+	// Stall is a replica not committing for longer than the bound
+	Stall Kind = "stall"
+)
+
+// Violation is a breach of safety or liveness
+type Violation struct {
+	Kind     Kind
+	Replicas []identity.NodeID
+	Height   int                 // the height of the first block involved
+	Blocks   []crypto.Identifier // the blocks in conflict
+	From, To time.Time           // the stall, or when the violation was committed
+}
+
+func (v Violation) String() string {
+	switch v.Kind {
+	case Stall:
+		if v.Height == 0 {
+			return fmt.Sprintf("%v: replica %v committed nothing for %v from the start (%v - %v)",
+				v.Kind, v.Replicas[0], v.To.Sub(v.From), v.From.Format(timeFormat), v.To.Format(timeFormat))
+		}
+		return fmt.Sprintf("%v: replica %v committed nothing for %v after height %v (%v - %v)",
+			v.Kind, v.Replicas[0], v.To.Sub(v.From), v.Height, v.From.Format(timeFormat), v.To.Format(timeFormat))
+	case Gap:
+		return fmt.Sprintf("%v: replica %v committed %x at height %v on top of %x instead of %x",
+			v.Kind, v.Replicas[0], v.Blocks[0][:4], v.Height, v.Blocks[1][:4], v.Blocks[2][:4])
+	case Fork:
+		return fmt.Sprintf("%v: replicas %v committed %x and %x on top of the same block at heights %v",
+			v.Kind, join(v.Replicas), v.Blocks[0][:4], v.Blocks[1][:4], v.Height)
+	}
+	return fmt.Sprintf("%v: replicas %v committed %x and %x at height %v", v.Kind, join(v.Replicas), v.Blocks[0][:4], v.Blocks[1][:4], v.Height)
+}
+
+const timeFormat = "15:04:05.000000"
+
+func join(ids []identity.NodeID) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = string(id)
+	}
+	return strings.Join(s, ", ")
+}
+
+// Config describes what is checked
+type Config struct {
+	StallBound time.Duration     // longest time a replica may go without committing, no bound if zero
+	Start      time.Time         // when the replicas started, the first stall runs from it if set
+	Replicas   []identity.NodeID // the replicas that have to commit, besides the ones that do
+	Byzantine  []identity.NodeID // replicas whose commits are ignored
+}
+
+// Checker consumes the blocks committed by the replicas and finds the violations as it goes
+type Checker struct {
+	config    Config
+	byzantine map[identity.NodeID]bool
+	replicas  map[identity.NodeID]*replica
+
+	heights  map[int]committed               // the first block committed at each height
+	children map[crypto.Identifier]committed // the first block committed on top of each block
+	chain    []committed                     // the longest chain committed so far
+
+	violations []Violation
+}
+
+type replica struct {
+	commits  int
+	last     Commit
+	diverged bool // the replica left the chain, it is only reported once
+}
+
+type committed struct {
+	replica identity.NodeID
+	Commit
+}
+
+// New creates a checker
+func New(c Config) *Checker {
+	ch := &Checker{
+		config:    c,
+		byzantine: make(map[identity.NodeID]bool),
+		replicas:  make(map[identity.NodeID]*replica),
+		heights:   make(map[int]committed),
+		children:  make(map[crypto.Identifier]committed),
+	}
+	for _, id := range c.Byzantine {
+		ch.byzantine[id] = true
+	}
+	for _, id := range c.Replicas {
+		ch.replica(id)
+	}
+	return ch
+}
+
+func (ch *Checker) replica(id identity.NodeID) *replica {
+	r, exists := ch.replicas[id]
+	if !exists && !ch.byzantine[id] {
+		r = &replica{last: Commit{Time: ch.config.Start}}
+		ch.replicas[id] = r
+	}
+	return r
+}
+
+// Commit checks a block committed by the replica, the blocks of a replica have to come in the order it committed them
+func (ch *Checker) Commit(id identity.NodeID, c Commit) {
+	r := ch.replica(id)
+	if r == nil {
+		return
+	}
+	ch.checkStall(id, r, c.Time)
+
+	if first, exists := ch.heights[c.Height]; !exists {
+		ch.heights[c.Height] = committed{id, c}
+	} else if first.ID != c.ID {
+		ch.report(Violation{Kind: Conflict, Replicas: []identity.NodeID{first.replica, id}, Height: c.Height,
+			Blocks: []crypto.Identifier{first.ID, c.ID}, From: first.Time, To: c.Time})
+	}
+
+	if c.PrevID != (crypto.Identifier{}) {
+		if r.commits > 0 && c.PrevID != r.last.ID && c.ID != r.last.ID {
+			ch.report(Violation{Kind: Gap, Replicas: []identity.NodeID{id}, Height: c.Height,
+				Blocks: []crypto.Identifier{c.ID, c.PrevID, r.last.ID}, From: r.last.Time, To: c.Time})
+		}
+		if sibling, exists := ch.children[c.PrevID]; !exists {
+			ch.children[c.PrevID] = committed{id, c}
+		} else if sibling.ID != c.ID && sibling.Height != c.Height {
+			// siblings at the same height are already a conflict
+			ch.report(Violation{Kind: Fork, Replicas: []identity.NodeID{sibling.replica, id}, Height: sibling.Height,
+				Blocks: []crypto.Identifier{sibling.ID, c.ID}, From: sibling.Time, To: c.Time})
+		}
+	}
+
+	if r.commits == 0 || c.ID != r.last.ID {
+		ch.extend(id, r, c)
+	}
+	r.last = c
+}
+
+// extend checks that the block is the next one in the chain committed so far
+func (ch *Checker) extend(id identity.NodeID, r *replica, c Commit) {
+	position := r.commits
+	r.commits++
+	if r.diverged {
+		return
+	}
+	if position == len(ch.chain) {
+		ch.chain = append(ch.chain, committed{id, c})
+		return
+	}
+	first := ch.chain[position]
+	if first.ID == c.ID {
+		return
+	}
+	r.diverged = true
+	ch.report(Violation{Kind: Prefix, Replicas: []identity.NodeID{first.replica, id}, Height: c.Height,
+		Blocks: []crypto.Identifier{first.ID, c.ID}, From: first.Time, To: c.Time})
+}
+
+func (ch *Checker) checkStall(id identity.NodeID, r *replica, now time.Time) {
+	if ch.config.StallBound <= 0 || r.last.Time.IsZero() || now.Sub(r.last.Time) <= ch.config.StallBound {
+		return
+	}
+	ch.report(Violation{Kind: Stall, Replicas: []identity.NodeID{id}, Height: r.last.Height, From: r.last.Time, To: now})
+}
+
+func (ch *Checker) report(v Violation) {
+	ch.violations = append(ch.violations, v)
+}
+
+// Finish checks that no replica stalls between its last commit and the end, and returns all the violations
+func (ch *Checker) Finish(end time.Time) []Violation {
+	ids := make([]identity.NodeID, 0, len(ch.replicas))
+	for id := range ch.replicas {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Node() < ids[j].Node() })
+	for _, id := range ids {
+		r := ch.replicas[id]
+		ch.checkStall(id, r, end)
+		r.last.Time = end
+	}
+	return ch.Violations()
+}
+
+// Violations returns the violations found so far
+func (ch *Checker) Violations() []Violation {
+	return append([]Violation(nil), ch.violations...)
+}
+
+// Committed returns the length of the longest chain committed so far
+func (ch *Checker) Committed() int {
+	return len(ch.chain)
+}
@@ -0,0 +1,177 @@
+package checker
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/simulator"
+
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.Local)
+
+// chain builds blocks on top of each other, fork names a block to tell it from the one at the same height
+func chain(from crypto.Identifier, height, count int, fork string) []Commit {
+	var commits []Commit
+	for i := 0; i < count; i++ {
+		c := Commit{Height: height + i, ID: crypto.MakeID(fmt.Sprintf("%v%v", fork, height+i)), PrevID: from}
+		c.Time = start.Add(time.Duration(c.Height) * 100 * time.Millisecond)
+		commits = append(commits, c)
+		from = c.ID
+	}
+	return commits
+}
+
+func kinds(violations []Violation) []Kind {
+	var kinds []Kind
+	for _, v := range violations {
+		kinds = append(kinds, v.Kind)
+	}
+	return kinds
+}
+
+func TestConsistent(t *testing.T) {
+	commits := chain(crypto.Identifier{}, 1, 10, "")
+	e := &Execution{Start: start, End: start.Add(time.Second), Commits: map[identity.NodeID][]Commit{
+		"1": commits,
+		"2": commits[:7],
+		"3": commits[:9],
+	}}
+	require.Empty(t, e.Check(time.Second))
+	// replica 2 commits nothing for the last 300ms
+	require.Equal(t, []Kind{Stall}, kinds(e.Check(250*time.Millisecond)))
+}
+
+func TestConflict(t *testing.T) {
+	commits := chain(crypto.Identifier{}, 1, 5, "")
+	fork := append(commits[:2:2], chain(commits[1].ID, 3, 3, "fork")...)
+	ch := New(Config{})
+	for i := range commits {
+		ch.Commit("1", commits[i])
+		ch.Commit("2", fork[i])
+	}
+	violations := ch.Finish(start.Add(time.Second))
+	require.Equal(t, []Kind{Conflict, Prefix, Conflict, Conflict}, kinds(violations))
+	require.Equal(t, []identity.NodeID{"1", "2"}, violations[0].Replicas)
+	require.Equal(t, 3, violations[0].Height)
+	require.Equal(t, []crypto.Identifier{commits[2].ID, fork[2].ID}, violations[0].Blocks)
+}
+
+func TestFork(t *testing.T) {
+	// views are not contiguous, two blocks on top of the same one do not have to be at the same height
+	commits := chain(crypto.Identifier{}, 1, 3, "")
+	other := commits[2]
+	other.ID, other.Height = crypto.MakeID("other"), 4
+	ch := New(Config{Byzantine: []identity.NodeID{"4"}})
+	for _, c := range commits {
+		ch.Commit("1", c)
+		ch.Commit("4", Commit{Height: c.Height, ID: crypto.MakeID(c.Height)})
+	}
+	ch.Commit("2", commits[0])
+	ch.Commit("2", commits[1])
+	ch.Commit("2", other)
+	require.Equal(t, []Kind{Fork, Prefix}, kinds(ch.Finish(start.Add(time.Second))))
+}
+
+func TestGap(t *testing.T) {
+	commits := chain(crypto.Identifier{}, 1, 4, "")
+	ch := New(Config{})
+	ch.Commit("1", commits[0])
+	ch.Commit("1", commits[0])
+	ch.Commit("1", commits[2])
+	ch.Commit("2", commits[0])
+	ch.Commit("2", commits[1])
+	ch.Commit("2", commits[2])
+	violations := ch.Finish(start)
+	require.Equal(t, []Kind{Gap, Prefix}, kinds(violations))
+	require.Equal(t, []crypto.Identifier{commits[2].ID, commits[1].ID, commits[0].ID}, violations[0].Blocks)
+	require.Equal(t, 2, ch.Committed(), "replica 1 skipped a block")
+}
+
+func TestStall(t *testing.T) {
+	ch := New(Config{StallBound: time.Second, Start: start, Replicas: []identity.NodeID{"1", "2", "3"}, Byzantine: []identity.NodeID{"3"}})
+	commits := chain(crypto.Identifier{}, 1, 3, "")
+	commits[2].Time = commits[1].Time.Add(1500 * time.Millisecond)
+	for _, c := range commits {
+		ch.Commit("1", c)
+	}
+	violations := ch.Finish(commits[2].Time.Add(500 * time.Millisecond))
+	require.Equal(t, []Kind{Stall, Stall}, kinds(violations))
+	require.Equal(t, []identity.NodeID{"1"}, violations[0].Replicas)
+	require.Equal(t, 2, violations[0].Height)
+	require.Equal(t, 1500*time.Millisecond, violations[0].To.Sub(violations[0].From))
+	// replica 2 never commits
+	require.Equal(t, []identity.NodeID{"2"}, violations[1].Replicas)
+	require.Equal(t, start, violations[1].From)
+}
+
+func TestReadLogs(t *testing.T) {
+	commits := chain(crypto.Identifier{}, 1, 3, "")
+	line := func(at time.Time, s string, args ...interface{}) string {
+		return fmt.Sprintf("[INFO] %v replica.go:219: %v\n", at.Format(logTimeFormat), fmt.Sprintf(s, args...))
+	}
+	first := line(start, "node 1 starting...") +
+		line(commits[0].Time, "[1] the block is committed, height: 1, path: fast, id: %x, prev: %x", commits[0].ID, commits[0].PrevID) +
+		line(commits[1].Time, "[1] the block is committed, height: 2, path: slow, id: %x, prev: %x", commits[1].ID, commits[1].PrevID) +
+		line(commits[2].Time, "[1] the block is forked, No. of transactions: 0, height: 3, id: %x", commits[2].ID)
+	// restarted
+	second := line(commits[2].Time, "[1] the block is committed, view: 3, id: %x, prev: %x", commits[2].ID, commits[2].PrevID) +
+		line(start.Add(time.Second), "[2] is Byzantine, strategy: silence") +
+		"[INFO] garbage\n"
+
+	e, err := ReadLogs(strings.NewReader(second), strings.NewReader(first))
+	require.NoError(t, err)
+	require.Equal(t, start, e.Start)
+	require.Equal(t, start.Add(time.Second), e.End)
+	require.Equal(t, []identity.NodeID{"2"}, e.Byzantine)
+	require.Equal(t, map[identity.NodeID][]Commit{"1": commits}, e.Commits)
+	require.Empty(t, e.Check(time.Second))
+	require.Equal(t, []Kind{Stall}, kinds(e.Check(500*time.Millisecond)))
+
+	// the logs before the prev ids are checked without them
+	e, err = ReadLogs(strings.NewReader(line(start, "[3] the block is committed, height: 1, path: fast, id: %x", commits[0].ID)))
+	require.NoError(t, err)
+	require.Equal(t, crypto.Identifier{}, e.Commits["3"][0].PrevID)
+}
+
+func TestSimulation(t *testing.T) {
+	_ = flag.Set("log_level", "error")
+	for _, alg := range []string{"banyan", "icc", "hotstuff", "streamlet"} {
+		ch := New(Config{StallBound: time.Second, Start: simulator.Epoch})
+		s, err := simulator.New(simulator.Config{Algorithm: alg, N: 4, F: 1, P: 1, Seed: 1, Duration: 2 * time.Second,
+			Timeout: 300 * time.Millisecond, Latency: simulator.Uniform{Min: 5 * time.Millisecond, Max: 30 * time.Millisecond},
+			ByzNo: 1, Strategy: "double_vote",
+			OnCommit: func(id identity.NodeID, c simulator.Commit) {
+				if id != "4" {
+					ch.Commit(id, FromSimulation(c))
+				}
+			}})
+		require.NoError(t, err)
+		r := s.Run()
+		require.Empty(t, ch.Finish(simulator.Epoch.Add(r.Duration)), alg)
+		require.Greater(t, ch.Committed(), 10, alg)
+		require.Equal(t, []identity.NodeID{"4"}, r.Byzantine)
+		require.Empty(t, FromReport(r).Check(time.Second), alg)
+	}
+
+	s, err := simulator.New(simulator.Config{Algorithm: "hotstuff", N: 4, F: 1, Seed: 1, Duration: 3 * time.Second,
+		Timeout: 200 * time.Millisecond, Latency: simulator.Fixed(10 * time.Millisecond)})
+	require.NoError(t, err)
+	s.At(time.Second, func() {
+		require.NoError(t, s.Faults().Partition([][]identity.NodeID{{"1", "2"}, {"3", "4"}}))
+	})
+	s.At(2*time.Second, s.Faults().Heal)
+	violations := FromReport(s.Run()).Check(500 * time.Millisecond)
+	require.Len(t, violations, 4, "every replica stalls in the partition")
+	for _, v := range violations {
+		require.Equal(t, Stall, v.Kind)
+		require.True(t, v.From.Before(simulator.Epoch.Add(1100*time.Millisecond)), v.String())
+		require.True(t, v.To.After(simulator.Epoch.Add(2*time.Second)), v.String())
+	}
+}
@@ -0,0 +1,144 @@
+package checker
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/simulator"
+)
+
+// Execution is what the replicas committed during a run
+type Execution struct {
+	Start, End time.Time
+	Commits    map[identity.NodeID][]Commit // the blocks each replica committed, in order
+	Byzantine  []identity.NodeID
+}
+
+// Check feeds the commits of the replicas to a checker in the order they happened and returns the violations
+func (e *Execution) Check(stallBound time.Duration) []Violation {
+	c := Config{StallBound: stallBound, Start: e.Start, Byzantine: e.Byzantine}
+	type entry struct {
+		id identity.NodeID
+		Commit
+	}
+	var entries []entry
+	for id, commits := range e.Commits {
+		c.Replicas = append(c.Replicas, id)
+		for _, commit := range commits {
+			entries = append(entries, entry{id, commit})
+		}
+	}
+	sort.Slice(c.Replicas, func(i, j int) bool { return c.Replicas[i].Node() < c.Replicas[j].Node() })
+	// the commits of a replica keep their order, the ties between replicas go to the lower id
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].Time.Before(entries[j].Time)
+		}
+		return entries[i].id.Node() < entries[j].id.Node()
+	})
+	checker := New(c)
+	for _, entry := range entries {
+		checker.Commit(entry.id, entry.Commit)
+	}
+	return checker.Finish(e.End)
+}
+
+// FromSimulation converts a block committed in a simulation
+func FromSimulation(c simulator.Commit) Commit {
+	return Commit{Height: c.Height, ID: c.ID, PrevID: c.PrevID, Time: simulator.Epoch.Add(c.Committed)}
+}
+
+// FromReport reads the execution of a simulation from its report
+func FromReport(r *simulator.Report) *Execution {
+	e := &Execution{
+		Start:     simulator.Epoch,
+		End:       simulator.Epoch.Add(r.Duration),
+		Commits:   make(map[identity.NodeID][]Commit),
+		Byzantine: r.Byzantine,
+	}
+	for id, commits := range r.Commits {
+		for _, c := range commits {
+			e.Commits[id] = append(e.Commits[id], FromSimulation(c))
+		}
+	}
+	return e
+}
+
+const logTimeFormat = "2006/01/02 15:04:05.000000"
+
+var (
+	// [INFO] 2020/01/01 00:00:00.000000 replica.go:219: [1] ...
+	logLine = regexp.MustCompile(`^\[[A-Z]+\] (\d{4}/\d\d/\d\d \d\d:\d\d:\d\d\.\d{6}) \S+ (.*)$`)
+	// [1] the block is committed, height: 3, path: fast, id: ..., prev: ...
+	// [1] the block is committed, view: 3, id: ..., prev: ...
+	commitLine = regexp.MustCompile(`^\[([^\]]+)\] the block is committed, (?:height|view): (\d+), (?:path: \w+, )?id: ([0-9a-f]{64})(?:, prev: ([0-9a-f]{64}))?`)
+	// [4] is Byzantine, strategy: silence
+	byzantineLine = regexp.MustCompile(`^\[([^\]]+)\] is Byzantine`)
+)
+
+// ReadLogs reads the execution from the logs of the replicas, one log per process or a single one for -sim. The
+// execution starts at the first line logged and ends at the last one.
+func ReadLogs(logs ...io.Reader) (*Execution, error) {
+	e := &Execution{Commits: make(map[identity.NodeID][]Commit)}
+	byzantine := make(map[identity.NodeID]bool)
+	for _, l := range logs {
+		scanner := bufio.NewScanner(l)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			line := logLine.FindStringSubmatch(scanner.Text())
+			if line == nil {
+				continue
+			}
+			at, err := time.ParseInLocation(logTimeFormat, line[1], time.Local)
+			if err != nil {
+				return nil, err
+			}
+			if e.Start.IsZero() || at.Before(e.Start) {
+				e.Start = at
+			}
+			if at.After(e.End) {
+				e.End = at
+			}
+			if byz := byzantineLine.FindStringSubmatch(line[2]); byz != nil && !byzantine[identity.NodeID(byz[1])] {
+				byzantine[identity.NodeID(byz[1])] = true
+				e.Byzantine = append(e.Byzantine, identity.NodeID(byz[1]))
+				continue
+			}
+			commit := commitLine.FindStringSubmatch(line[2])
+			if commit == nil {
+				continue
+			}
+			c := Commit{Time: at}
+			c.Height, err = strconv.Atoi(commit[2])
+			if err != nil {
+				return nil, err
+			}
+			c.ID, err = crypto.ParseID(commit[3])
+			if err != nil {
+				return nil, err
+			}
+			if commit[4] != "" {
+				c.PrevID, err = crypto.ParseID(commit[4])
+				if err != nil {
+					return nil, err
+				}
+			}
+			id := identity.NodeID(commit[1])
+			e.Commits[id] = append(e.Commits[id], c)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	// a replica that restarted logs into another file
+	for _, commits := range e.Commits {
+		sort.SliceStable(commits, func(i, j int) bool { return commits[i].Time.Before(commits[j].Time) })
+	}
+	return e, nil
+}
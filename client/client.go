@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"banyan"
+	"banyan/config"
+	"banyan/identity"
+	"banyan/log"
+)
+
+var mode = flag.String("mode", "closed", "load mode: open (fixed rate) or closed (fixed number of outstanding transactions)")
+var rate = flag.Int("rate", 1000, "transactions per second submitted in open loop mode")
+var concurrency = flag.Int("concurrency", 10, "outstanding transactions in closed loop mode")
+var duration = flag.Int("duration", 0, "benchmark duration in seconds, defaults to experiment_duration of the config")
+var size = flag.Int("size", 100, "size of the value written by each transaction in bytes")
+var nodes = flag.String("nodes", "", "comma separated ids of the nodes to submit to, defaults to all nodes")
+var poll = flag.Int("poll", 5, "interval in milliseconds between transaction status queries")
+var commitTimeout = flag.Int("commit_timeout", 10, "seconds to wait for a transaction to be committed")
+var out = flag.String("out", "summary.json", "summary file, written as csv if the name ends with .csv and as json otherwise")
+var records = flag.String("records", "", "optional csv file receiving the latency of every transaction")
+var boost = flag.Bool("boost", true, "query every node before the run so that replicas start proposing")
+var clientID = flag.String("id", "", "client id attached to every transaction, defaults to host and pid")
+
+// record is the outcome of a single transaction
+type record struct {
+	ID      string
+	Node    identity.NodeID
+	Submit  time.Time
+	Latency time.Duration
+	Height  int
+	Err     error
+}
+
+type benchmark struct {
+	targets  []identity.NodeID
+	client   *http.Client
+	clientID string
+	nonce    uint64
+	value    string
+	records  chan *record
+}
+
+func newBenchmark() *benchmark {
+	b := new(benchmark)
+	b.client = &http.Client{Timeout: time.Duration(*commitTimeout) * time.Second}
+	b.clientID = *clientID
+	if b.clientID == "" {
+		host, _ := os.Hostname()
+		b.clientID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if *nodes == "" {
+		for id := range config.GetConfig().HTTPAddrs {
+			b.targets = append(b.targets, id)
+		}
+	} else {
+		for _, id := range strings.Split(*nodes, ",") {
+			b.targets = append(b.targets, identity.NodeID(strings.TrimSpace(id)))
+		}
+	}
+	sort.Slice(b.targets, func(i, j int) bool { return b.targets[i].Node() < b.targets[j].Node() })
+	value := make([]byte, *size)
+	for i := range value {
+		value[i] = byte('a' + rand.Intn(26))
+	}
+	b.value = string(value)
+	b.records = make(chan *record, 10240)
+	return b
+}
+
+// boostNodes sends a query to every node, replicas start proposing on their first request
+func (b *benchmark) boostNodes() {
+	for _, id := range b.targets {
+		res, err := b.client.Get(config.GetConfig().HTTPAddrs[id] + "/query")
+		if err != nil {
+			log.Warningf("cannot query node %v: %v", id, err)
+			continue
+		}
+		res.Body.Close()
+	}
+}
+
+// submit sends a new transaction to the node and waits until it is committed
+func (b *benchmark) submit(id identity.NodeID) *record {
+	nonce := atomic.AddUint64(&b.nonce, 1)
+	addr := config.GetConfig().HTTPAddrs[id]
+	rec := &record{Node: id, Submit: time.Now()}
+	body, _ := json.Marshal(map[string]interface{}{
+		"command":   fmt.Sprintf("PUT %s-%d %s", b.clientID, nonce, b.value),
+		"client_id": b.clientID,
+		"nonce":     nonce,
+	})
+	res, err := b.client.Post(addr+"/tx", "application/json", bytes.NewReader(body))
+	if err != nil {
+		rec.Err = err
+		return rec
+	}
+	reply, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		rec.Err = err
+		return rec
+	}
+	if res.StatusCode != http.StatusOK {
+		rec.Err = fmt.Errorf("submission rejected: %s", strings.TrimSpace(string(reply)))
+		return rec
+	}
+	var submitted struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(reply, &submitted); err != nil {
+		rec.Err = err
+		return rec
+	}
+	rec.ID = submitted.ID
+	rec.Height, rec.Err = b.waitCommitted(addr, rec.ID)
+	rec.Latency = time.Since(rec.Submit)
+	return rec
+}
+
+// waitCommitted polls the status of the transaction until it is committed
+func (b *benchmark) waitCommitted(addr string, txID string) (int, error) {
+	deadline := time.Now().Add(time.Duration(*commitTimeout) * time.Second)
+	for time.Now().Before(deadline) {
+		res, err := b.client.Get(addr + "/tx/" + txID)
+		if err != nil {
+			return 0, err
+		}
+		var status struct {
+			Status string `json:"status"`
+			Height int    `json:"height"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&status)
+		res.Body.Close()
+		if err != nil {
+			return 0, err
+		}
+		if status.Status == "committed" {
+			return status.Height, nil
+		}
+		time.Sleep(time.Duration(*poll) * time.Millisecond)
+	}
+	return 0, errors.New("commit timeout")
+}
+
+// openLoop submits transactions at a fixed rate regardless of how many are outstanding
+func (b *benchmark) openLoop(d time.Duration) {
+	var wg sync.WaitGroup
+	start := time.Now()
+	sent := 0
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		elapsed := now.Sub(start)
+		if elapsed >= d {
+			break
+		}
+		due := int(elapsed.Seconds() * float64(*rate))
+		for ; sent < due; sent++ {
+			wg.Add(1)
+			go func(target identity.NodeID) {
+				defer wg.Done()
+				b.records <- b.submit(target)
+			}(b.targets[sent%len(b.targets)])
+		}
+	}
+	wg.Wait()
+}
+
+// closedLoop keeps a fixed number of transactions outstanding
+func (b *benchmark) closedLoop(d time.Duration) {
+	var wg sync.WaitGroup
+	end := time.Now().Add(d)
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func(target identity.NodeID) {
+			defer wg.Done()
+			for time.Now().Before(end) {
+				b.records <- b.submit(target)
+			}
+		}(b.targets[i%len(b.targets)])
+	}
+	wg.Wait()
+}
+
+func main() {
+	banyan.Init()
+
+	b := newBenchmark()
+	if len(b.targets) == 0 {
+		log.Fatal("no node to submit transactions to")
+	}
+	d := time.Duration(*duration) * time.Second
+	if d == 0 {
+		d = time.Duration(config.GetConfig().ExperimentDuration) * time.Second
+	}
+	if *boost {
+		b.boostNodes()
+	}
+
+	collected := make(chan []*record)
+	go func() {
+		var all []*record
+		for rec := range b.records {
+			all = append(all, rec)
+		}
+		collected <- all
+	}()
+
+	log.Infof("client %v starts a %v loop benchmark for %v against nodes %v", b.clientID, *mode, d, b.targets)
+	switch *mode {
+	case "open":
+		b.openLoop(d)
+	case "closed":
+		b.closedLoop(d)
+	default:
+		log.Fatalf("unknown mode %s", *mode)
+	}
+	close(b.records)
+	all := <-collected
+
+	s := summarize(all, d)
+	if err := s.write(*out); err != nil {
+		log.Fatal(err)
+	}
+	if *records != "" {
+		if err := writeRecords(*records, all); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Printf("committed %v/%v transactions, throughput %.1f tx/s, latency p50 %.1fms p95 %.1fms p99 %.1fms\n",
+		s.Committed, s.Submitted, s.Throughput, s.P50, s.P95, s.P99)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// summary aggregates the records of a benchmark run, latencies are in milliseconds
+type summary struct {
+	Mode       string  `json:"mode"`
+	Duration   float64 `json:"duration_s"`
+	Submitted  int     `json:"submitted"`
+	Committed  int     `json:"committed"`
+	Failed     int     `json:"failed"`
+	Throughput float64 `json:"throughput_tps"`
+	Mean       float64 `json:"latency_mean_ms"`
+	P50        float64 `json:"latency_p50_ms"`
+	P95        float64 `json:"latency_p95_ms"`
+	P99        float64 `json:"latency_p99_ms"`
+	Max        float64 `json:"latency_max_ms"`
+}
+
+func summarize(all []*record, d time.Duration) *summary {
+	s := &summary{
+		Mode:      *mode,
+		Duration:  d.Seconds(),
+		Submitted: len(all),
+	}
+	latencies := make([]time.Duration, 0, len(all))
+	var total time.Duration
+	for _, rec := range all {
+		if rec.Err != nil {
+			s.Failed++
+			continue
+		}
+		latencies = append(latencies, rec.Latency)
+		total += rec.Latency
+	}
+	s.Committed = len(latencies)
+	if s.Committed == 0 {
+		return s
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	s.Throughput = float64(s.Committed) / d.Seconds()
+	s.Mean = toMillis(total / time.Duration(s.Committed))
+	s.P50 = toMillis(percentile(latencies, 0.50))
+	s.P95 = toMillis(percentile(latencies, 0.95))
+	s.P99 = toMillis(percentile(latencies, 0.99))
+	s.Max = toMillis(latencies[len(latencies)-1])
+	return s
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies, the smallest latency that at least a
+// fraction p of them do not exceed, and 0 for no latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	// the epsilon keeps p*n from rounding up past an exact rank, as 0.95*20
+	rank := int(math.Ceil(p*float64(len(sorted))-1e-9)) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func toMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// write stores the summary as csv or json depending on the file extension
+func (s *summary) write(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if !strings.HasSuffix(path, ".csv") {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(s)
+	}
+	w := csv.NewWriter(file)
+	w.Write([]string{"mode", "duration_s", "submitted", "committed", "failed", "throughput_tps",
+		"latency_mean_ms", "latency_p50_ms", "latency_p95_ms", "latency_p99_ms", "latency_max_ms"})
+	w.Write([]string{s.Mode, formatFloat(s.Duration), strconv.Itoa(s.Submitted), strconv.Itoa(s.Committed),
+		strconv.Itoa(s.Failed), formatFloat(s.Throughput), formatFloat(s.Mean), formatFloat(s.P50),
+		formatFloat(s.P95), formatFloat(s.P99), formatFloat(s.Max)})
+	w.Flush()
+	return w.Error()
+}
+
+// writeRecords stores the outcome of every transaction as csv
+func writeRecords(path string, all []*record) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+	w.Write([]string{"id", "node", "submit_unix_ns", "latency_ms", "height", "error"})
+	for _, rec := range all {
+		errString := ""
+		if rec.Err != nil {
+			errString = rec.Err.Error()
+		}
+		w.Write([]string{rec.ID, string(rec.Node), strconv.FormatInt(rec.Submit.UnixNano(), 10),
+			formatFloat(toMillis(rec.Latency)), strconv.Itoa(rec.Height), errString})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func ms(values ...int) []time.Duration {
+	latencies := make([]time.Duration, len(values))
+	for i, v := range values {
+		latencies[i] = time.Duration(v) * time.Millisecond
+	}
+	return latencies
+}
+
+func TestPercentile(t *testing.T) {
+	one20 := make([]int, 20)
+	for i := range one20 {
+		one20[i] = i + 1
+	}
+	for _, c := range []struct {
+		name      string
+		latencies []time.Duration
+		p         float64
+		expected  time.Duration
+	}{
+		{"empty", nil, 0.5, 0},
+		{"single", ms(7), 0.5, 7 * time.Millisecond},
+		{"single p99", ms(7), 0.99, 7 * time.Millisecond},
+		{"p0 is the smallest", ms(1, 2, 3), 0, time.Millisecond},
+		{"p100 is the largest", ms(1, 2, 3), 1, 3 * time.Millisecond},
+		{"median of four", ms(1, 2, 3, 4), 0.5, 2 * time.Millisecond},
+		{"median of five", ms(1, 2, 3, 4, 5), 0.5, 3 * time.Millisecond},
+		{"rank rounds up", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 0.91, 10 * time.Millisecond},
+		{"exact rank", ms(one20...), 0.95, 19 * time.Millisecond},
+		{"p99 of twenty", ms(one20...), 0.99, 20 * time.Millisecond},
+	} {
+		require.Equal(t, c.expected, percentile(c.latencies, c.p), c.name)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	failed := errors.New("timeout")
+	for _, c := range []struct {
+		name     string
+		records  []*record
+		expected summary
+	}{
+		{"empty run", nil, summary{Mode: "closed", Duration: 2}},
+		{"all failed", []*record{{Err: failed}, {Err: failed}}, summary{Mode: "closed", Duration: 2, Submitted: 2, Failed: 2}},
+		{
+			"some failed",
+			[]*record{{Latency: 30 * time.Millisecond}, {Err: failed}, {Latency: 10 * time.Millisecond}, {Latency: 20 * time.Millisecond}},
+			summary{Mode: "closed", Duration: 2, Submitted: 4, Committed: 3, Failed: 1, Throughput: 1.5, Mean: 20, P50: 20, P95: 30, P99: 30, Max: 30},
+		},
+	} {
+		require.Equal(t, c.expected, *summarize(c.records, 2*time.Second), c.name)
+	}
+}
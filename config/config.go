@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"runtime"
 	"strconv"
 
 	"banyan/identity"
@@ -23,14 +24,51 @@ type Config struct {
 	ExperimentDuration int    `json:"experiment_duration"`
 	Timeout            int    `json:"timeout"`
 	ByzNo              int    `json:"byzNo"`
-	Strategy           string `json:"strategy"`
-	PayloadSize        int    `json:"payload_size"`
+	Strategy           string `json:"strategy"`      // behavior of the Byzantine nodes, silence if empty
+	PayloadSize        int    `json:"payload_size"`  // maximum bytes of transactions in a block
+	MemSize            int    `json:"memsize"`       // maximum number of pending transactions in the mempool
+	MemBytes           int    `json:"membytes"`      // maximum bytes of pending transactions in the mempool
+	StateMachine       string `json:"state_machine"` // application executing committed blocks, defaults to kv
+	StoreDir           string `json:"store_dir"`     // directory of the persistent block store, empty keeps everything in memory
+	StoreSync          bool   `json:"store_sync"`    // fsync every committed block, not needed to survive process crashes; the voting state always is
+	KeyDir             string `json:"key_dir"`       // directory of the keys written by keygen, the private key of the node and the registry
 	F                  int    `json:"f"`
 	P                  int    `json:"p"`
 	N                  int    // total number of nodes
 
+	Strategies    map[identity.NodeID]string `json:"strategies"`     // behavior of individual nodes, the nodes listed are Byzantine
+	StrategyDelay int                        `json:"strategy_delay"` // milliseconds a fork_and_delay proposal is held back, half the timeout if zero
+	StrategyPeers []identity.NodeID          `json:"strategy_peers"` // peers a selective node sends to, the lower half of its peers if empty
+
+	Faults FaultConfig `json:"faults"` // network faults injected from the start, they can be changed over HTTP
+
+	Signer string `json:"signer"` // signature scheme of the keys generated in memory or by keygen: ECDSA_P256, ECDSA_SECp256k1, ED25519 or BLS_BLS12381
+
+	VerifyWorkers int `json:"verify_workers"` // workers checking the signatures of the shares and votes received, the event loop checks them if zero
+	VerifyBatch   int `json:"verify_batch"`   // most shares or votes a worker checks at once
+
+	Codec string `json:"codec"` // encoding of the messages on the network: gob, json, rlp or binary
+
+	ErasureCoding bool `json:"erasure_coding"` // the proposers send every replica a chunk of their blocks rather than the whole blocks
+
 	hasher string
-	signer string
+}
+
+// LinkFault is the fault injected on the messages sent over a link
+type LinkFault struct {
+	Drop      float64 `json:"drop,omitempty"`      // probability that a message is lost
+	Delay     int     `json:"delay,omitempty"`     // milliseconds every message is held back
+	Jitter    int     `json:"jitter,omitempty"`    // up to this many milliseconds are added to the delay at random
+	Bandwidth int     `json:"bandwidth,omitempty"` // bytes per second the link carries, unlimited if zero
+	Duplicate float64 `json:"duplicate,omitempty"` // probability that a message is delivered twice
+	Reorder   float64 `json:"reorder,omitempty"`   // probability that the messages sent after a message overtake it
+}
+
+// FaultConfig describes the faults of the network. The links are named "from-to", where either side can be "*",
+// and the nodes in different groups of the partition cannot reach each other, the nodes not listed form a group.
+type FaultConfig struct {
+	Links     map[string]LinkFault `json:"links,omitempty"`
+	Partition [][]identity.NodeID  `json:"partition,omitempty"`
 }
 
 //var keys []crypto.PrivateKey
@@ -57,8 +95,15 @@ func Simulation() {
 // only used by init() and master
 func MakeDefaultConfig() Config {
 	return Config{
-		hasher: "sha3_256",
-		signer: "ECDSA_P256",
+		MemSize:       100000,
+		MemBytes:      256 * 1024 * 1024,
+		StateMachine:  "kv",
+		KeyDir:        "keys",
+		hasher:        "sha3_256",
+		Signer:        "ECDSA_P256",
+		VerifyWorkers: runtime.NumCPU() - 1, // the event loop keeps a core
+		VerifyBatch:   16,
+		Codec:         "gob",
 	}
 }
 
@@ -95,7 +140,7 @@ func (c Config) GetHashScheme() string {
 }
 
 func (c Config) GetSignatureScheme() string {
-	return c.signer
+	return c.Signer
 }
 
 // GetSignatureScheme returns the signing scheme of the configuration
@@ -139,7 +184,7 @@ func (c *Config) Load() {
 	for scanner.Scan() {
 		id := identity.NewNodeID(i)
 		port := strconv.Itoa(3734 + i)
-		addr := "tcp://" + scanner.Text() + ":" + port
+		addr := *transport.Scheme + "://" + scanner.Text() + ":" + port
 		portHttp := strconv.Itoa(8069 + i)
 		addrHttp := "http://" + scanner.Text() + ":" + portHttp
 		c.Addrs[id] = addr
@@ -164,6 +209,16 @@ func (c Config) Save() error {
 	return encoder.Encode(c)
 }
 
+// IsByzantine tells whether the node is one of the byzNo last nodes or has a strategy of its own
 func (c Config) IsByzantine(id identity.NodeID) bool {
-	return c.N-c.ByzNo < id.Node()
+	_, listed := c.Strategies[id]
+	return listed || c.N-c.ByzNo < id.Node()
+}
+
+// StrategyOf returns the strategy the node follows if it is Byzantine
+func (c Config) StrategyOf(id identity.NodeID) string {
+	if strategy, ok := c.Strategies[id]; ok {
+		return strategy
+	}
+	return c.Strategy
 }
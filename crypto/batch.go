@@ -0,0 +1,89 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"math/big"
+	"time"
+
+	"banyan/identity"
+
+	"github.com/ethereum/go-ethereum/crypto/bls12381"
+)
+
+// Claim is the signature of a node on some data
+type Claim struct {
+	Signer identity.NodeID
+	Data   []byte
+	Sig    Signature
+}
+
+// VerifyBatch tells which claims hold. The BLS claims are checked together, with one pairing per distinct data
+// instead of two per claim, and one by one only if the batch fails; the claims of the other schemes are checked one
+// by one.
+func VerifyBatch(claims []Claim) []bool {
+	valid := make([]bool, len(claims))
+	var batch []int
+	for i, claim := range claims {
+		if _, isBLS := pubKeys[claim.Signer].(*bls_bls12381_PublicKey); isBLS && len(claims) > 1 {
+			batch = append(batch, i)
+			continue
+		}
+		valid[i], _ = PubVerify(claim.Sig, claim.Data, claim.Signer)
+	}
+	if len(batch) == 0 {
+		return valid
+	}
+	if verifyBatchBLS(claims, batch) {
+		for _, i := range batch {
+			valid[i] = true
+		}
+		return valid
+	}
+	for _, i := range batch {
+		valid[i], _ = PubVerify(claims[i].Sig, claims[i].Data, claims[i].Signer)
+	}
+	return valid
+}
+
+// verifyBatchBLS checks that e(sum r_i sig_i, g2) = prod e(H(data), sum r_i key_i), where the sum on the right is
+// over the claims on the same data. The random factors r_i keep invalid signatures from canceling each other out,
+// a forged batch passes with probability 2^-64.
+func verifyBatchBLS(claims []Claim, batch []int) bool {
+	defer observeSince(verifyTime, BLS_BLS12381, time.Now())
+	g1, g2 := bls12381.NewG1(), bls12381.NewG2()
+	sigSum := g1.Zero()
+	keySums := make(map[string]*bls12381.PointG2)
+	var order []string // the data in the order they come, for the same pairings on every run
+	factorBound := new(big.Int).Lsh(big.NewInt(1), 64)
+	for _, i := range batch {
+		point, err := decodeG1(claims[i].Sig)
+		if err != nil {
+			return false
+		}
+		r, err := rand.Int(rand.Reader, factorBound)
+		if err != nil {
+			return false
+		}
+		r.Add(r, big.NewInt(1))
+		g1.Add(sigSum, sigSum, g1.MulScalar(g1.New(), point, r))
+		data := string(claims[i].Data)
+		sum, exists := keySums[data]
+		if !exists {
+			sum = g2.Zero()
+			keySums[data] = sum
+			order = append(order, data)
+		}
+		key := pubKeys[claims[i].Signer].(*bls_bls12381_PublicKey)
+		g2.Add(sum, sum, g2.MulScalar(g2.New(), key.point, r))
+	}
+	engine := bls12381.NewPairingEngine()
+	engine.AddPairInv(sigSum, engine.G2.One())
+	for _, data := range order {
+		h, err := hashToG1([]byte(data), blsSigDST)
+		if err != nil {
+			return false
+		}
+		engine.AddPair(h, keySums[data])
+	}
+	return engine.Check()
+}
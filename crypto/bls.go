@@ -0,0 +1,250 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/crypto/bls12381"
+)
+
+// BLS signatures on BLS12-381 are points of G1 and public keys points of G2, which keeps the signatures, and the
+// certificates that aggregate them, small. The signatures of several nodes on the same data add up to a single
+// signature that the sum of their public keys verifies. A public key comes with a proof of possession, a signature
+// of the key with its private key, so that no node can pick a key that cancels the keys of others in a sum.
+var (
+	blsSigDST = []byte("BLS_SIG_BLS12381G1_XMD:SHA-256_SSWU_RO_POP_")
+	blsPopDST = []byte("BLS_POP_BLS12381G1_XMD:SHA-256_SSWU_RO_POP_")
+
+	// blsP is the modulus of the base field
+	blsP, _ = new(big.Int).SetString("1a0111ea397fe69a4b1ba7b6434bacd764774b84f38512bf6730d2a0f6b0f6241eabfffeb153ffffb9feffffffffaaab", 16)
+)
+
+const (
+	blsPrivateKeySize = 32
+	blsPublicKeySize  = 192 // uncompressed G2 point
+	blsSignatureSize  = 96  // uncompressed G1 point
+)
+
+type bls_bls12381_PrivateKey struct {
+	SignAlg string
+	secret  *big.Int
+	public  *bls_bls12381_PublicKey
+}
+
+type bls_bls12381_PublicKey struct {
+	SignAlg string
+	point   *bls12381.PointG2
+	proof   []byte // signature of the encoded point with blsPopDST
+}
+
+func generateBLSKey() (*bls_bls12381_PrivateKey, error) {
+	order := bls12381.NewG1().Q()
+	for {
+		secret, err := rand.Int(rand.Reader, order)
+		if err != nil {
+			return nil, err
+		}
+		if secret.Sign() > 0 {
+			return newBLSKey(secret)
+		}
+	}
+}
+
+func newBLSKey(secret *big.Int) (*bls_bls12381_PrivateKey, error) {
+	g2 := bls12381.NewG2()
+	point := g2.MulScalar(g2.New(), g2.One(), secret)
+	priv := &bls_bls12381_PrivateKey{SignAlg: BLS_BLS12381, secret: secret}
+	proof, err := priv.sign(g2.ToBytes(point), blsPopDST)
+	if err != nil {
+		return nil, err
+	}
+	priv.public = &bls_bls12381_PublicKey{SignAlg: BLS_BLS12381, point: point, proof: proof}
+	return priv, nil
+}
+
+func (priv *bls_bls12381_PrivateKey) Algorithm() string {
+	return priv.SignAlg
+}
+
+func (priv *bls_bls12381_PrivateKey) PublicKey() PublicKey {
+	return priv.public
+}
+
+// Encode returns the secret scalar in 32 bytes, big endian
+func (priv *bls_bls12381_PrivateKey) Encode() ([]byte, error) {
+	out := make([]byte, blsPrivateKeySize)
+	secret := priv.secret.Bytes()
+	copy(out[blsPrivateKeySize-len(secret):], secret)
+	return out, nil
+}
+
+// Sign returns a signature made of a single G1 point
+func (priv *bls_bls12381_PrivateKey) Sign(msg []byte, hasher Hasher) (Signature, error) {
+	if hasher != nil {
+		msg = hasher.ComputeHash(msg)
+	}
+	sig, err := priv.sign(msg, blsSigDST)
+	if err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+func (priv *bls_bls12381_PrivateKey) sign(msg []byte, dst []byte) ([]byte, error) {
+	g1 := bls12381.NewG1()
+	h, err := hashToG1(msg, dst)
+	if err != nil {
+		return nil, err
+	}
+	return g1.ToBytes(g1.MulScalar(g1.New(), h, priv.secret)), nil
+}
+
+func (pub *bls_bls12381_PublicKey) Algorithm() string {
+	return pub.SignAlg
+}
+
+// Encode returns the G2 point followed by the proof of possession
+func (pub *bls_bls12381_PublicKey) Encode() ([]byte, error) {
+	return append(bls12381.NewG2().ToBytes(pub.point), pub.proof...), nil
+}
+
+func (pub *bls_bls12381_PublicKey) Verify(sig Signature, hash Hash) (bool, error) {
+	return verifyBLS(pub.point, sig, hash, blsSigDST)
+}
+
+// verifyBLS checks that e(sig, g2) = e(H(msg), key)
+func verifyBLS(key *bls12381.PointG2, sig []byte, msg []byte, dst []byte) (bool, error) {
+	point, err := decodeG1(sig)
+	if err != nil {
+		return false, err
+	}
+	h, err := hashToG1(msg, dst)
+	if err != nil {
+		return false, err
+	}
+	engine := bls12381.NewPairingEngine()
+	engine.AddPairInv(point, engine.G2.One())
+	engine.AddPair(h, key)
+	return engine.Check(), nil
+}
+
+// aggregateBLS adds up signatures of the same data
+func aggregateBLS(sigs []Signature) (Signature, error) {
+	g1 := bls12381.NewG1()
+	sum := g1.Zero()
+	for _, sig := range sigs {
+		point, err := decodeG1(sig)
+		if err != nil {
+			return nil, err
+		}
+		g1.Add(sum, sum, point)
+	}
+	return g1.ToBytes(sum), nil
+}
+
+// verifyAggregateBLS checks a signature aggregated from the signatures of the keys on the same data
+func verifyAggregateBLS(keys []*bls_bls12381_PublicKey, sig Signature, data []byte) (bool, error) {
+	g2 := bls12381.NewG2()
+	sum := g2.Zero()
+	for _, key := range keys {
+		g2.Add(sum, sum, key.point)
+	}
+	return (&bls_bls12381_PublicKey{SignAlg: BLS_BLS12381, point: sum}).Verify(sig, data)
+}
+
+func decodeBLSPrivateKey(data []byte) (PrivateKey, error) {
+	if len(data) != blsPrivateKeySize {
+		return nil, errors.New("a BLS private key has 32 bytes")
+	}
+	secret := new(big.Int).SetBytes(data)
+	if secret.Sign() == 0 || secret.Cmp(bls12381.NewG1().Q()) >= 0 {
+		return nil, errors.New("the BLS private key is out of range")
+	}
+	return newBLSKey(secret)
+}
+
+// decodeBLSPublicKey parses a public key and checks its proof of possession
+func decodeBLSPublicKey(data []byte) (PublicKey, error) {
+	if len(data) != blsPublicKeySize+blsSignatureSize {
+		return nil, errors.New("a BLS public key has 288 bytes with its proof of possession")
+	}
+	g2 := bls12381.NewG2()
+	point, err := g2.FromBytes(data[:blsPublicKeySize])
+	if err != nil {
+		return nil, err
+	}
+	if g2.IsZero(point) || !g2.InCorrectSubgroup(point) {
+		return nil, errors.New("the BLS public key is not in G2")
+	}
+	proof := data[blsPublicKeySize:]
+	ok, err := verifyBLS(point, proof, data[:blsPublicKeySize], blsPopDST)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("invalid proof of possession of the BLS public key")
+	}
+	return &bls_bls12381_PublicKey{SignAlg: BLS_BLS12381, point: point, proof: append([]byte(nil), proof...)}, nil
+}
+
+func decodeG1(data []byte) (*bls12381.PointG1, error) {
+	if len(data) != blsSignatureSize {
+		return nil, errors.New("a BLS signature has 96 bytes")
+	}
+	g1 := bls12381.NewG1()
+	point, err := g1.FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if !g1.InCorrectSubgroup(point) {
+		return nil, errors.New("the BLS signature is not in G1")
+	}
+	return point, nil
+}
+
+// hashToG1 is hash_to_curve of RFC 9380 with suite BLS12381G1_XMD:SHA-256_SSWU_RO_
+func hashToG1(msg []byte, dst []byte) (*bls12381.PointG1, error) {
+	uniform := expandMessageXMD(msg, dst, 128)
+	g1 := bls12381.NewG1()
+	sum := g1.Zero()
+	// MapToCurve clears the cofactor of each point, which is the same as clearing it from their sum
+	for i := 0; i < 2; i++ {
+		u := new(big.Int).Mod(new(big.Int).SetBytes(uniform[64*i:64*(i+1)]), blsP)
+		field := make([]byte, 48)
+		copy(field[48-len(u.Bytes()):], u.Bytes())
+		point, err := g1.MapToCurve(field)
+		if err != nil {
+			return nil, err
+		}
+		g1.Add(sum, sum, point)
+	}
+	return g1.Affine(sum), nil
+}
+
+// expandMessageXMD is expand_message_xmd of RFC 9380 with SHA-256, the output is at most 255 hashes long
+func expandMessageXMD(msg []byte, dst []byte, length int) []byte {
+	dstPrime := append(append([]byte(nil), dst...), byte(len(dst)))
+	h := sha256.New()
+	h.Write(make([]byte, h.BlockSize()))
+	h.Write(msg)
+	h.Write([]byte{byte(length >> 8), byte(length), 0})
+	h.Write(dstPrime)
+	b0 := h.Sum(nil)
+
+	var out []byte
+	b := make([]byte, len(b0))
+	for i := 1; len(out) < length; i++ {
+		for j := range b {
+			b[j] ^= b0[j]
+		}
+		h.Reset()
+		h.Write(b)
+		h.Write([]byte{byte(i)})
+		h.Write(dstPrime)
+		b = h.Sum(nil)
+		out = append(out, b...)
+	}
+	return out[:length]
+}
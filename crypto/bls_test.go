@@ -0,0 +1,143 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"banyan/identity"
+
+	"github.com/ethereum/go-ethereum/crypto/bls12381"
+	"github.com/stretchr/testify/require"
+)
+
+// the test vectors of RFC 9380, appendices J.9.1 and K.1
+func TestHashToG1(t *testing.T) {
+	expanded := expandMessageXMD(nil, []byte("QUUX-V01-CS02-with-expander-SHA256-128"), 32)
+	require.Equal(t, "68a985b87eb6b46952128911f2a4412bbc302a9d759667f87f7a21d803f07235", hex.EncodeToString(expanded))
+
+	dst := []byte("QUUX-V01-CS02-with-BLS12381G1_XMD:SHA-256_SSWU_RO_")
+	for msg, point := range map[string]string{
+		"": "052926add2207b76ca4fa57a8734416c8dc95e24501772c814278700eed6d1e4e8cf62d9c09db0fac349612b759e79a1" +
+			"08ba738453bfed09cb546dbb0783dbb3a5f1f566ed67bb6be0e8c67e2e81a4cc68ee29813bb7994998f3eae0c9c6a265",
+		"abc": "03567bc5ef9c690c2ab2ecdf6a96ef1c139cc0b2f284dca0a9a7943388a49a3aee664ba5379a7655d3c68900be2f6903" +
+			"0b9c15f3fe6e5cf4211f346271d7b01c8f3b28be689c8429c85b67af215533311f0b8dfaaa154fa6b88176c229f2885d",
+	} {
+		p, err := hashToG1([]byte(msg), dst)
+		require.NoError(t, err)
+		require.Equal(t, point, hex.EncodeToString(bls12381.NewG1().ToBytes(p)), msg)
+	}
+}
+
+func TestBLS(t *testing.T) {
+	key, err := GenerateKey(BLS_BLS12381)
+	require.NoError(t, err)
+	data := IDToByte(MakeID("block"))
+	sig, err := key.Sign(data, nil)
+	require.NoError(t, err)
+	require.Len(t, sig, 96)
+	ok, err := key.PublicKey().Verify(sig, data)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = key.PublicKey().Verify(sig, IDToByte(MakeID("other block")))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	private, err := key.Encode()
+	require.NoError(t, err)
+	decoded, err := DecodePrivateKey(BLS_BLS12381, private)
+	require.NoError(t, err)
+	public, err := key.PublicKey().Encode()
+	require.NoError(t, err)
+	decodedPublic, err := decoded.PublicKey().Encode()
+	require.NoError(t, err)
+	require.Equal(t, public, decodedPublic)
+	pub, err := DecodePublicKey(BLS_BLS12381, public)
+	require.NoError(t, err)
+	ok, err = pub.Verify(sig, data)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// a key whose proof of possession was made by another key is rejected
+	other, err := GenerateKey(BLS_BLS12381)
+	require.NoError(t, err)
+	otherPublic, err := other.PublicKey().Encode()
+	require.NoError(t, err)
+	_, err = DecodePublicKey(BLS_BLS12381, append(public[:blsPublicKeySize:blsPublicKeySize], otherPublic[blsPublicKeySize:]...))
+	require.Error(t, err)
+	_, err = pub.Verify(sig[:95], data)
+	require.Error(t, err)
+}
+
+func TestSigners(t *testing.T) {
+	s := NewSigners([]identity.NodeID{"10", "1", "3", "1"})
+	require.Equal(t, Signers{0x05, 0x02}, s)
+	require.Equal(t, 3, s.Count())
+	require.Equal(t, []identity.NodeID{"1", "3", "10"}, s.IDs())
+	require.True(t, s.Has("10"))
+	require.False(t, s.Has("2"))
+	require.False(t, s.Has("17"))
+	require.Equal(t, 0, Signers(nil).Count())
+}
+
+func TestAggregate(t *testing.T) {
+	data := MakeID("block")
+	for _, scheme := range []string{ECDSA_P256, ECDSA_SECp256k1, ED25519, BLS_BLS12381} {
+		require.NoError(t, GenerateKeys(scheme, []identity.NodeID{"1", "2", "3", "4"}))
+		sigs := make(map[identity.NodeID]Signature)
+		for _, id := range []identity.NodeID{"4", "1", "2"} {
+			sig, err := PrivSign(IDToByte(data), id, nil)
+			require.NoError(t, err)
+			sigs[id] = sig
+		}
+		aggSig, signers, err := Aggregate(sigs)
+		require.NoError(t, err)
+		require.Equal(t, []identity.NodeID{"1", "2", "4"}, signers.IDs())
+		if scheme == BLS_BLS12381 {
+			require.Len(t, aggSig, 1, "a BLS certificate has a single signature")
+		} else {
+			require.Equal(t, AggSig{sigs["1"], sigs["2"], sigs["4"]}, aggSig)
+		}
+		ok, err := VerifyQuorumSignature(aggSig, data, signers)
+		require.NoError(t, err)
+		require.True(t, ok, scheme)
+
+		// the signature does not stand for another set of signers, nor for another block
+		ok, _ = VerifyQuorumSignature(aggSig, data, NewSigners([]identity.NodeID{"1", "2", "3"}))
+		require.False(t, ok, scheme)
+		ok, _ = VerifyQuorumSignature(aggSig, MakeID("other block"), signers)
+		require.False(t, ok, scheme)
+		_, err = VerifyQuorumSignature(aggSig, data, nil)
+		require.Error(t, err)
+	}
+}
+
+func TestVerifyBatch(t *testing.T) {
+	ids := []identity.NodeID{"1", "2", "3", "4"}
+	require.NoError(t, GenerateKeys(BLS_BLS12381, ids))
+	defer UseKeys(nil)
+	var claims []Claim
+	for i, id := range ids {
+		data := IDToByte(MakeID(i % 2)) // two blocks
+		sig, err := PrivSign(data, id, nil)
+		require.NoError(t, err)
+		claims = append(claims, Claim{Signer: id, Data: data, Sig: sig})
+	}
+	require.Equal(t, []bool{true, true, true, true}, VerifyBatch(claims))
+
+	// the signatures of two nodes on the same block shifted by opposite amounts add up to a valid aggregate, but
+	// neither holds on its own
+	g1 := bls12381.NewG1()
+	shift := g1.MulScalar(g1.New(), g1.One(), big.NewInt(7))
+	first, err := decodeG1(claims[0].Sig)
+	require.NoError(t, err)
+	third, err := decodeG1(claims[2].Sig)
+	require.NoError(t, err)
+	forged := append([]Claim(nil), claims...)
+	forged[0].Sig = g1.ToBytes(g1.Add(g1.New(), first, shift))
+	forged[2].Sig = g1.ToBytes(g1.Sub(g1.New(), third, shift))
+	require.Equal(t, []bool{false, true, false, true}, VerifyBatch(forged))
+
+	forged[3].Signer = "5"
+	require.Equal(t, []bool{false, true, false, false}, VerifyBatch(forged))
+}
@@ -2,10 +2,16 @@ package crypto
 
 import (
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/x509"
+	"errors"
 	"math/big"
 )
 
+// p256ScalarSize is the size of r and s in a signature
+const p256ScalarSize = 32
+
 type ecdsa_p256_PrivateKey struct {
 	SignAlg    string
 	PrivateKey *ecdsa.PrivateKey
@@ -25,15 +31,17 @@ func (priv *ecdsa_p256_PrivateKey) Algorithm() string {
 	return priv.SignAlg
 }
 
+// Encode returns the private key in the SEC 1 form of x509
+func (priv *ecdsa_p256_PrivateKey) Encode() ([]byte, error) {
+	return x509.MarshalECPrivateKey(priv.PrivateKey)
+}
+
 // This function is commented for now.
 // func (priv *ecdsa_p256_PrivateKey) KeySize() int {
 //	return len([]byte(*priv))
 // }
 
-// ecdsa.Sign returns two *big.Int variables. In order to save it in the Signature type,
-// I first turn them into strings, and then I turn the strings to byte arrays.
-// I have implemented a Signature to ecdsa signature parser (toECDSA in signature.go) in oder to
-// cast the byte array Signature into the original signature of the ECDSA signing method.
+// ecdsa.Sign returns two *big.Int variables, the Signature is r followed by s, 32 bytes each.
 func (priv *ecdsa_p256_PrivateKey) Sign(msg []byte, hasher Hasher) (Signature, error) {
 	var r, s *big.Int
 	var err error
@@ -48,9 +56,9 @@ func (priv *ecdsa_p256_PrivateKey) Sign(msg []byte, hasher Hasher) (Signature, e
 			return nil, err
 		}
 	}
-	sig := make([][]byte, 2)
-	sig[0] = []byte(r.String())
-	sig[1] = []byte(s.String())
+	sig := make(Signature, 2*p256ScalarSize)
+	r.FillBytes(sig[:p256ScalarSize])
+	s.FillBytes(sig[p256ScalarSize:])
 	return sig, err
 }
 
@@ -58,8 +66,40 @@ func (pub *ecdsa_p256_PublicKey) Algorithm() string {
 	return pub.SignAlg
 }
 
+// Encode returns the public key in the PKIX form of x509
+func (pub *ecdsa_p256_PublicKey) Encode() ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(&pub.PublicKey)
+}
+
 func (pub *ecdsa_p256_PublicKey) Verify(sig Signature, hash Hash) (bool, error) {
-	ecdsaSig := sig.ToECDSA()
-	isVerified := ecdsa.Verify(&pub.PublicKey, hash, ecdsaSig.r, ecdsaSig.s)
+	if len(sig) != 2*p256ScalarSize {
+		return false, errors.New("an ECDSA P-256 signature has 64 bytes")
+	}
+	r := new(big.Int).SetBytes(sig[:p256ScalarSize])
+	s := new(big.Int).SetBytes(sig[p256ScalarSize:])
+	isVerified := ecdsa.Verify(&pub.PublicKey, hash, r, s)
 	return isVerified, nil
 }
+
+func decodeECDSAP256PrivateKey(data []byte) (PrivateKey, error) {
+	priv, err := x509.ParseECPrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	if priv.Curve != elliptic.P256() {
+		return nil, errors.New("the private key is not on curve P-256")
+	}
+	return &ecdsa_p256_PrivateKey{SignAlg: ECDSA_P256, PrivateKey: priv}, nil
+}
+
+func decodeECDSAP256PublicKey(data []byte) (PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return nil, errors.New("the public key is not an ECDSA key on curve P-256")
+	}
+	return &ecdsa_p256_PublicKey{SignAlg: ECDSA_P256, PublicKey: *pub}, nil
+}
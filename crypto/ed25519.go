@@ -0,0 +1,74 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"errors"
+)
+
+type ed25519_PrivateKey struct {
+	SignAlg    string
+	PrivateKey ed25519.PrivateKey
+}
+
+type ed25519_PublicKey struct {
+	SignAlg   string
+	PublicKey ed25519.PublicKey
+}
+
+func generateEd25519Key() (PrivateKey, error) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ed25519_PrivateKey{SignAlg: ED25519, PrivateKey: priv}, nil
+}
+
+func (priv *ed25519_PrivateKey) Algorithm() string {
+	return priv.SignAlg
+}
+
+func (priv *ed25519_PrivateKey) PublicKey() PublicKey {
+	return &ed25519_PublicKey{SignAlg: ED25519, PublicKey: priv.PrivateKey.Public().(ed25519.PublicKey)}
+}
+
+// Encode returns the 32 bytes seed the key derives from
+func (priv *ed25519_PrivateKey) Encode() ([]byte, error) {
+	return priv.PrivateKey.Seed(), nil
+}
+
+// Sign signs the message itself, Ed25519 hashes it with SHA-512, or the hash of the hasher if there is one
+func (priv *ed25519_PrivateKey) Sign(msg []byte, hasher Hasher) (Signature, error) {
+	if hasher != nil {
+		msg = hasher.ComputeHash(msg)
+	}
+	return ed25519.Sign(priv.PrivateKey, msg), nil
+}
+
+func (pub *ed25519_PublicKey) Algorithm() string {
+	return pub.SignAlg
+}
+
+func (pub *ed25519_PublicKey) Encode() ([]byte, error) {
+	return append([]byte(nil), pub.PublicKey...), nil
+}
+
+func (pub *ed25519_PublicKey) Verify(sig Signature, hash Hash) (bool, error) {
+	if len(sig) != ed25519.SignatureSize {
+		return false, errors.New("an Ed25519 signature has 64 bytes")
+	}
+	return ed25519.Verify(pub.PublicKey, hash, sig), nil
+}
+
+func decodeEd25519PrivateKey(data []byte) (PrivateKey, error) {
+	if len(data) != ed25519.SeedSize {
+		return nil, errors.New("an Ed25519 private key has 32 bytes")
+	}
+	return &ed25519_PrivateKey{SignAlg: ED25519, PrivateKey: ed25519.NewKeyFromSeed(data)}, nil
+}
+
+func decodeEd25519PublicKey(data []byte) (PublicKey, error) {
+	if len(data) != ed25519.PublicKeySize {
+		return nil, errors.New("an Ed25519 public key has 32 bytes")
+	}
+	return &ed25519_PublicKey{SignAlg: ED25519, PublicKey: append(ed25519.PublicKey(nil), data...)}, nil
+}
@@ -1,6 +1,9 @@
 package crypto
 
 import (
+	"encoding/hex"
+	"fmt"
+
 	"banyan/types/encoding"
 )
 
@@ -23,3 +26,17 @@ func HashToID(hash []byte) Identifier {
 func IDToByte(id Identifier) []byte {
 	return id[:]
 }
+
+// ParseID reads an ID written in hex
+func ParseID(s string) (Identifier, error) {
+	var id Identifier
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return id, err
+	}
+	if len(b) != len(id) {
+		return id, fmt.Errorf("an id has %v bytes, not %v", len(id), len(b))
+	}
+	copy(id[:], b)
+	return id, nil
+}
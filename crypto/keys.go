@@ -0,0 +1,185 @@
+package crypto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"banyan/identity"
+)
+
+// RegistryFile is the file of the key directory listing the public keys of all the nodes, every node has a copy
+const RegistryFile = "registry.json"
+
+// Registry holds the public keys of the nodes
+type Registry struct {
+	Algorithm string                     `json:"algorithm"`
+	Keys      map[identity.NodeID][]byte `json:"keys"` // encoded public keys
+}
+
+// keyFile holds the private key of a node, only the node should get it
+type keyFile struct {
+	ID         identity.NodeID `json:"id"`
+	Algorithm  string          `json:"algorithm"`
+	PrivateKey []byte          `json:"private_key"`
+}
+
+// KeyFile returns the file of the key directory holding the private key of the node
+func KeyFile(dir string, id identity.NodeID) string {
+	return filepath.Join(dir, string(id)+".key")
+}
+
+// DecodePrivateKey parses a private key of the signature scheme returned by Encode
+func DecodePrivateKey(signer string, data []byte) (PrivateKey, error) {
+	switch signer {
+	case ECDSA_P256:
+		return decodeECDSAP256PrivateKey(data)
+	case BLS_BLS12381:
+		return decodeBLSPrivateKey(data)
+	case ECDSA_SECp256k1:
+		return decodeSecp256k1PrivateKey(data)
+	case ED25519:
+		return decodeEd25519PrivateKey(data)
+	default:
+		return nil, fmt.Errorf("cannot decode the keys of signature scheme %v", signer)
+	}
+}
+
+// DecodePublicKey parses a public key of the signature scheme returned by Encode
+func DecodePublicKey(signer string, data []byte) (PublicKey, error) {
+	switch signer {
+	case ECDSA_P256:
+		return decodeECDSAP256PublicKey(data)
+	case BLS_BLS12381:
+		return decodeBLSPublicKey(data)
+	case ECDSA_SECp256k1:
+		return decodeSecp256k1PublicKey(data)
+	case ED25519:
+		return decodeEd25519PublicKey(data)
+	default:
+		return nil, fmt.Errorf("cannot decode the keys of signature scheme %v", signer)
+	}
+}
+
+// WriteKeys generates a key pair for each node, writes the private keys to a file per node and the public keys to
+// the registry. It does not overwrite existing keys unless told to.
+func WriteKeys(dir string, signer string, ids []identity.NodeID, overwrite bool) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if overwrite {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	registry := Registry{Algorithm: signer, Keys: make(map[identity.NodeID][]byte, len(ids))}
+	for _, id := range ids {
+		key, err := GenerateKey(signer)
+		if err != nil {
+			return err
+		}
+		private, err := key.Encode()
+		if err != nil {
+			return err
+		}
+		registry.Keys[id], err = key.PublicKey().Encode()
+		if err != nil {
+			return err
+		}
+		err = writeJSON(KeyFile(dir, id), flags, 0600, keyFile{ID: id, Algorithm: signer, PrivateKey: private})
+		if err != nil {
+			return err
+		}
+	}
+	return writeJSON(filepath.Join(dir, RegistryFile), flags, 0644, registry)
+}
+
+func writeJSON(name string, flags int, perm os.FileMode, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(name, flags, perm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// LoadKeys reads the public keys of all the nodes from the registry of the key directory, and the private keys of
+// the local nodes only. The private key of a node has to match its public key in the registry.
+func LoadKeys(dir string, local ...identity.NodeID) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, RegistryFile))
+	if err != nil {
+		return err
+	}
+	var registry Registry
+	if err = json.Unmarshal(data, &registry); err != nil {
+		return fmt.Errorf("%v: %v", RegistryFile, err)
+	}
+	public := make(map[identity.NodeID]PublicKey, len(registry.Keys))
+	for id, encoded := range registry.Keys {
+		public[id], err = DecodePublicKey(registry.Algorithm, encoded)
+		if err != nil {
+			return fmt.Errorf("public key of node %v: %v", id, err)
+		}
+	}
+
+	private := make(map[identity.NodeID]PrivateKey, len(local))
+	for _, id := range local {
+		key, err := loadPrivateKey(dir, id, registry.Algorithm)
+		if err != nil {
+			return err
+		}
+		if err = matches(key, registry.Keys[id]); err != nil {
+			return fmt.Errorf("private key of node %v: %v", id, err)
+		}
+		private[id] = key
+	}
+	keys, pubKeys = private, public
+	return nil
+}
+
+func loadPrivateKey(dir string, id identity.NodeID, signer string) (PrivateKey, error) {
+	name := KeyFile(dir, id)
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var file keyFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	if file.ID != id {
+		return nil, fmt.Errorf("%v holds the key of node %v, not %v", name, file.ID, id)
+	}
+	if file.Algorithm != signer {
+		return nil, fmt.Errorf("%v holds a %v key, the registry %v keys", name, file.Algorithm, signer)
+	}
+	key, err := DecodePrivateKey(file.Algorithm, file.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	return key, nil
+}
+
+// matches checks that the public key of the private key is the encoded one
+func matches(key PrivateKey, encoded []byte) error {
+	if encoded == nil {
+		return errors.New("the node is not in the registry")
+	}
+	public, err := key.PublicKey().Encode()
+	if err != nil {
+		return err
+	}
+	if string(public) != string(encoded) {
+		return errors.New("it does not match the public key in the registry")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"banyan/identity"
+
+	"github.com/stretchr/testify/require"
+)
+
+var ids = []identity.NodeID{"1", "2", "3", "4"}
+
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, WriteKeys(dir, ECDSA_P256, ids, false))
+	info, err := os.Stat(KeyFile(dir, "2"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	require.NoError(t, LoadKeys(dir, "2"))
+	data := IDToByte(MakeID("block"))
+	sig, err := PrivSign(data, "2", nil)
+	require.NoError(t, err)
+	ok, err := PubVerify(sig, data, "2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = PubVerify(sig, data, "3")
+	require.NoError(t, err)
+	require.False(t, ok, "the signature is not the one of node 3")
+
+	_, err = PrivSign(data, "1", nil)
+	require.Error(t, err, "the key of node 1 is not local")
+	_, err = PubVerify(sig, data, "5")
+	require.Error(t, err, "node 5 is not in the registry")
+
+	// every node loads its own key and verifies the others
+	require.NoError(t, LoadKeys(dir, "3"))
+	ok, err = PubVerify(sig, data, "2")
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestWriteKeys(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, WriteKeys(dir, ECDSA_P256, ids, false))
+	require.Error(t, WriteKeys(dir, ECDSA_P256, ids, false), "the keys are not overwritten")
+
+	// a private key from another set of keys does not match the registry
+	other := t.TempDir()
+	require.NoError(t, WriteKeys(other, ECDSA_P256, ids, false))
+	data, err := ioutil.ReadFile(KeyFile(other, "1"))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(KeyFile(dir, "1"), data, 0600))
+	require.Error(t, LoadKeys(dir, "1"))
+	require.NoError(t, LoadKeys(dir, "2"))
+
+	// nor does the key of another node
+	data, err = ioutil.ReadFile(KeyFile(dir, "3"))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(KeyFile(dir, "2"), data, 0600))
+	require.Error(t, LoadKeys(dir, "2"))
+
+	require.NoError(t, WriteKeys(dir, ECDSA_P256, ids, true))
+	require.NoError(t, LoadKeys(dir, ids...))
+	_, err = GenerateKey("RSA")
+	require.Error(t, err)
+}
@@ -0,0 +1,85 @@
+package crypto
+
+import (
+	"golang.org/x/crypto/sha3"
+)
+
+// MerkleTree commits to a list of leaves, a proof shows that a leaf is at its index without the other leaves. The
+// leaves and the inner nodes are hashed with different prefixes, and the last node of a level with an odd number
+// of nodes moves up as it is.
+type MerkleTree struct {
+	levels [][]Identifier // from the hashes of the leaves to the root
+}
+
+func leafHash(leaf []byte) Identifier {
+	return sha3.Sum256(append([]byte{0}, leaf...))
+}
+
+func nodeHash(left, right Identifier) Identifier {
+	var b [1 + 2*len(Identifier{})]byte
+	b[0] = 1
+	copy(b[1:], left[:])
+	copy(b[1+len(left):], right[:])
+	return sha3.Sum256(b[:])
+}
+
+// NewMerkleTree builds the tree of at least one leaf
+func NewMerkleTree(leaves [][]byte) *MerkleTree {
+	level := make([]Identifier, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = leafHash(leaf)
+	}
+	t := &MerkleTree{levels: [][]Identifier{level}}
+	for len(level) > 1 {
+		next := make([]Identifier, (len(level)+1)/2)
+		for i := range next {
+			if 2*i+1 < len(level) {
+				next[i] = nodeHash(level[2*i], level[2*i+1])
+			} else {
+				next[i] = level[2*i]
+			}
+		}
+		t.levels = append(t.levels, next)
+		level = next
+	}
+	return t
+}
+
+func (t *MerkleTree) Root() Identifier {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// Proof returns the siblings of the path from the leaf to the root
+func (t *MerkleTree) Proof(index int) []Identifier {
+	var proof []Identifier
+	for _, level := range t.levels[:len(t.levels)-1] {
+		if sibling := index ^ 1; sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		index /= 2
+	}
+	return proof
+}
+
+// VerifyMerkleProof tells whether the leaf is at the index of a tree of n leaves with the root
+func VerifyMerkleProof(root Identifier, leaf []byte, index, n int, proof []Identifier) bool {
+	if index < 0 || index >= n {
+		return false
+	}
+	hash := leafHash(leaf)
+	for width := n; width > 1; width = (width + 1) / 2 {
+		if index^1 < width {
+			if len(proof) == 0 {
+				return false
+			}
+			if index%2 == 0 {
+				hash = nodeHash(hash, proof[0])
+			} else {
+				hash = nodeHash(proof[0], hash)
+			}
+			proof = proof[1:]
+		}
+		index /= 2
+	}
+	return len(proof) == 0 && hash == root
+}
@@ -0,0 +1,31 @@
+package crypto
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerkleTree(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		leaves := make([][]byte, n)
+		for i := range leaves {
+			leaves[i] = []byte(fmt.Sprintf("leaf %d", i))
+		}
+		tree := NewMerkleTree(leaves)
+		for i, leaf := range leaves {
+			proof := tree.Proof(i)
+			require.True(t, VerifyMerkleProof(tree.Root(), leaf, i, n, proof), "leaf %d of %d", i, n)
+			require.False(t, VerifyMerkleProof(tree.Root(), []byte("other"), i, n, proof))
+			if n > 1 {
+				require.False(t, VerifyMerkleProof(tree.Root(), leaf, (i+1)%n, n, proof))
+			}
+		}
+	}
+
+	// a leaf is not an inner node
+	tree := NewMerkleTree([][]byte{[]byte("a"), []byte("b")})
+	inner := tree.levels[0]
+	require.False(t, VerifyMerkleProof(tree.Root(), append(inner[0][:], inner[1][:]...), 0, 1, nil))
+}
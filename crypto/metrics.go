@@ -0,0 +1,23 @@
+package crypto
+
+import (
+	"time"
+
+	"banyan/metrics"
+)
+
+// Metrics times the signatures and their verifications of the process per scheme, the keys are shared by all the
+// nodes of the process and so are these metrics
+var Metrics = metrics.NewRegistry()
+
+// SignatureBuckets are the upper bounds of the signature histograms, in seconds, much finer than the latency ones
+var SignatureBuckets = []float64{0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025}
+
+var (
+	signTime   = Metrics.HistogramVec("banyan_sign_seconds", "Time to sign a message with the key of a node.", "scheme", SignatureBuckets)
+	verifyTime = Metrics.HistogramVec("banyan_verify_seconds", "Time to verify a signature, or the aggregated signature of a certificate.", "scheme", SignatureBuckets)
+)
+
+func observeSince(vec *metrics.HistogramVec, scheme string, start time.Time) {
+	vec.With(scheme).ObserveDuration(time.Since(start))
+}
@@ -0,0 +1,128 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"banyan/identity"
+)
+
+// Signers is the bitmap of the nodes that signed a certificate, bit i-1 of the bitmap stands for node i
+type Signers []byte
+
+// NewSigners returns the bitmap of the nodes
+func NewSigners(ids []identity.NodeID) Signers {
+	var s Signers
+	for _, id := range ids {
+		s = s.With(id)
+	}
+	return s
+}
+
+// With returns the bitmap with the node added
+func (s Signers) With(id identity.NodeID) Signers {
+	i := id.Node() - 1
+	if i < 0 {
+		return s
+	}
+	for len(s) <= i/8 {
+		s = append(s, 0)
+	}
+	s[i/8] |= 1 << uint(i%8)
+	return s
+}
+
+// Has tells whether the node signed
+func (s Signers) Has(id identity.NodeID) bool {
+	i := id.Node() - 1
+	return i >= 0 && i/8 < len(s) && s[i/8]&(1<<uint(i%8)) != 0
+}
+
+// IDs returns the signers in order
+func (s Signers) IDs() []identity.NodeID {
+	var ids []identity.NodeID
+	for i := 0; i < len(s)*8; i++ {
+		if s[i/8]&(1<<uint(i%8)) != 0 {
+			ids = append(ids, identity.NewNodeID(i+1))
+		}
+	}
+	return ids
+}
+
+// Count returns the number of signers
+func (s Signers) Count() int {
+	count := 0
+	for _, b := range s {
+		for ; b != 0; b &= b - 1 {
+			count++
+		}
+	}
+	return count
+}
+
+// Aggregate combines the signatures of the nodes on the same data into the signature of a certificate. With BLS
+// keys it is a single signature whatever the number of signers, with the other schemes the signatures are listed in
+// the order of the signers.
+func Aggregate(sigs map[identity.NodeID]Signature) (AggSig, Signers, error) {
+	ids := make([]identity.NodeID, 0, len(sigs))
+	for id := range sigs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Node() < ids[j].Node() })
+	signers := NewSigners(ids)
+	if len(signers.IDs()) != len(ids) {
+		return nil, nil, errors.New("the signers are not numbered")
+	}
+	ordered := make([]Signature, len(ids))
+	for i, id := range ids {
+		ordered[i] = sigs[id]
+	}
+	if _, err := blsKeys(ids); err != nil {
+		return ordered, signers, nil
+	}
+	sig, err := aggregateBLS(ordered)
+	if err != nil {
+		return nil, nil, err
+	}
+	return AggSig{sig}, signers, nil
+}
+
+// blsKeys returns the BLS public keys of the nodes, and an error if one of them has a key of another scheme
+func blsKeys(ids []identity.NodeID) ([]*bls_bls12381_PublicKey, error) {
+	keys := make([]*bls_bls12381_PublicKey, len(ids))
+	for i, id := range ids {
+		key, ok := pubKeys[id].(*bls_bls12381_PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("node %v has no BLS public key", id)
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// VerifyQuorumSignature checks the signature of a certificate made by Aggregate
+func VerifyQuorumSignature(aggregatedSigs AggSig, blockID Identifier, aggSigners Signers) (bool, error) {
+	ids := aggSigners.IDs()
+	if len(ids) == 0 {
+		return false, errors.New("no signers")
+	}
+	if keys, err := blsKeys(ids); err == nil {
+		if len(aggregatedSigs) != 1 {
+			return false, fmt.Errorf("%v signatures for a BLS certificate", len(aggregatedSigs))
+		}
+		defer observeSince(verifyTime, BLS_BLS12381, time.Now())
+		return verifyAggregateBLS(keys, aggregatedSigs[0], IDToByte(blockID))
+	}
+	if len(aggregatedSigs) != len(ids) {
+		return false, fmt.Errorf("%v signatures for %v signers", len(aggregatedSigs), len(ids))
+	}
+	for i, signer := range ids {
+		ok, err := PubVerify(aggregatedSigs[i], IDToByte(blockID), signer)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
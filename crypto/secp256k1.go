@@ -0,0 +1,109 @@
+//go:build cgo
+// +build cgo
+
+package crypto
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/crypto/secp256k1"
+)
+
+// ECDSA on secp256k1 signs with libsecp256k1, which needs cgo. The signatures are the 64 bytes of r and s, the
+// public keys are compressed to 33 bytes.
+
+const secp256k1KeySize = 32
+
+type ecdsa_secp256k1_PrivateKey struct {
+	SignAlg string
+	secret  []byte
+	public  *ecdsa_secp256k1_PublicKey
+}
+
+type ecdsa_secp256k1_PublicKey struct {
+	SignAlg string
+	point   []byte // compressed
+}
+
+func generateSecp256k1Key() (PrivateKey, error) {
+	order := secp256k1.S256().Params().N
+	for {
+		secret, err := rand.Int(rand.Reader, order)
+		if err != nil {
+			return nil, err
+		}
+		if secret.Sign() > 0 {
+			return newSecp256k1Key(secret.FillBytes(make([]byte, secp256k1KeySize))), nil
+		}
+	}
+}
+
+func newSecp256k1Key(secret []byte) *ecdsa_secp256k1_PrivateKey {
+	x, y := secp256k1.S256().ScalarBaseMult(secret)
+	return &ecdsa_secp256k1_PrivateKey{
+		SignAlg: ECDSA_SECp256k1,
+		secret:  secret,
+		public:  &ecdsa_secp256k1_PublicKey{SignAlg: ECDSA_SECp256k1, point: secp256k1.CompressPubkey(x, y)},
+	}
+}
+
+func (priv *ecdsa_secp256k1_PrivateKey) Algorithm() string {
+	return priv.SignAlg
+}
+
+func (priv *ecdsa_secp256k1_PrivateKey) PublicKey() PublicKey {
+	return priv.public
+}
+
+// Encode returns the secret scalar in 32 bytes, big endian
+func (priv *ecdsa_secp256k1_PrivateKey) Encode() ([]byte, error) {
+	return append([]byte(nil), priv.secret...), nil
+}
+
+// Sign signs a 32 bytes digest, the one of the hasher if there is one
+func (priv *ecdsa_secp256k1_PrivateKey) Sign(msg []byte, hasher Hasher) (Signature, error) {
+	if hasher != nil {
+		msg = hasher.ComputeHash(msg)
+	}
+	sig, err := secp256k1.Sign(msg, priv.secret)
+	if err != nil {
+		return nil, err
+	}
+	// the last byte recovers the public key, which the registry already gives
+	return sig[:64], nil
+}
+
+func (pub *ecdsa_secp256k1_PublicKey) Algorithm() string {
+	return pub.SignAlg
+}
+
+func (pub *ecdsa_secp256k1_PublicKey) Encode() ([]byte, error) {
+	return append([]byte(nil), pub.point...), nil
+}
+
+func (pub *ecdsa_secp256k1_PublicKey) Verify(sig Signature, hash Hash) (bool, error) {
+	if len(sig) != 64 {
+		return false, errors.New("an ECDSA secp256k1 signature has 64 bytes")
+	}
+	if len(hash) != 32 {
+		return false, errors.New("ECDSA secp256k1 verifies 32 bytes digests")
+	}
+	return secp256k1.VerifySignature(pub.point, hash, sig), nil
+}
+
+func decodeSecp256k1PrivateKey(data []byte) (PrivateKey, error) {
+	secret := new(big.Int).SetBytes(data)
+	if len(data) != secp256k1KeySize || secret.Sign() == 0 || secret.Cmp(secp256k1.S256().Params().N) >= 0 {
+		return nil, errors.New("invalid ECDSA secp256k1 private key")
+	}
+	return newSecp256k1Key(append([]byte(nil), data...)), nil
+}
+
+func decodeSecp256k1PublicKey(data []byte) (PublicKey, error) {
+	if x, _ := secp256k1.DecompressPubkey(data); x == nil {
+		return nil, errors.New("invalid ECDSA secp256k1 public key")
+	}
+	return &ecdsa_secp256k1_PublicKey{SignAlg: ECDSA_SECp256k1, point: append([]byte(nil), data...)}, nil
+}
@@ -0,0 +1,20 @@
+//go:build !cgo
+// +build !cgo
+
+package crypto
+
+import "errors"
+
+var errNoSecp256k1 = errors.New("ECDSA secp256k1 needs a build with cgo")
+
+func generateSecp256k1Key() (PrivateKey, error) {
+	return nil, errNoSecp256k1
+}
+
+func decodeSecp256k1PrivateKey([]byte) (PrivateKey, error) {
+	return nil, errNoSecp256k1
+}
+
+func decodeSecp256k1PublicKey([]byte) (PublicKey, error) {
+	return nil, errNoSecp256k1
+}
@@ -1,11 +1,13 @@
 package crypto
 
 import (
-	"banyan/config"
 	"banyan/identity"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/rand"
 	"errors"
+	"fmt"
+	"time"
 )
 
 // SigningAlgorithm is an identifier for a signing algorithm and curve.
@@ -23,10 +25,12 @@ const (
 	BLS_BLS12381    = "BLS_BLS12381"
 	ECDSA_P256      = "ECDSA_P256"
 	ECDSA_SECp256k1 = "ECDSA_SECp256k1"
+	ED25519         = "ED25519"
 )
 
-var keys []PrivateKey
-var pubKeys []PublicKey
+// keys holds the private keys of the local nodes only, pubKeys the public keys of all the nodes
+var keys map[identity.NodeID]PrivateKey
+var pubKeys map[identity.NodeID]PublicKey
 
 // PrivateKey is an unspecified signature scheme private key
 type PrivateKey interface {
@@ -39,7 +43,7 @@ type PrivateKey interface {
 	// PublicKey returns the public key.
 	PublicKey() PublicKey
 	// Encode returns a bytes representation of the private key
-	//Encode() ([]byte, error)
+	Encode() ([]byte, error)
 }
 
 // PublicKey is an unspecified signature scheme public key.
@@ -51,71 +55,81 @@ type PublicKey interface {
 	// Verify verifies a signature of an input message using the provided hasher.
 	Verify(Signature, Hash) (bool, error)
 	// Encode returns a bytes representation of the public key.
-	//Encode() ([]byte, error)
+	Encode() ([]byte, error)
 }
 
-type StaticRand struct {
-	identity.NodeID
-}
-
-func (sr *StaticRand) Read(x []byte) (int, error) {
-	return sr.Node(), nil
+// UseKeys replaces the keys of the nodes, the key of node i is at i-1, e.g. to simulate with cheaper signatures.
+// All the keys are local.
+func UseKeys(private []PrivateKey) {
+	local := make(map[identity.NodeID]PrivateKey, len(private))
+	for i, key := range private {
+		local[identity.NewNodeID(i+1)] = key
+	}
+	setKeys(local)
 }
 
-func SetKeys() error {
-	keys = make([]PrivateKey, config.GetConfig().N)
-	pubKeys = make([]PublicKey, config.GetConfig().N)
-	var err error
-	for i := 0; i < config.GetConfig().N; i++ {
-		keys[i], err = GenerateKey(config.GetConfig().GetSignatureScheme(), identity.NewNodeID(i+1))
+// GenerateKeys generates fresh keys for the nodes and keeps them in memory, it only fits nodes that run in the same
+// process since no other process can verify their signatures
+func GenerateKeys(signer string, ids []identity.NodeID) error {
+	local := make(map[identity.NodeID]PrivateKey, len(ids))
+	for _, id := range ids {
+		key, err := GenerateKey(signer)
 		if err != nil {
 			return err
 		}
-		pubKeys[i] = keys[i].PublicKey()
+		local[id] = key
 	}
+	setKeys(local)
 	return nil
 }
 
-func GenerateKey(signer string, id identity.NodeID) (PrivateKey, error) {
-	if signer == ECDSA_P256 {
-		pubkeyCurve := elliptic.P256()
-		// use static id
-		priv, err := ecdsa.GenerateKey(pubkeyCurve, &StaticRand{id})
+// setKeys makes the keys local and takes their public keys as the public keys of the nodes
+func setKeys(local map[identity.NodeID]PrivateKey) {
+	public := make(map[identity.NodeID]PublicKey, len(local))
+	for id, key := range local {
+		public[id] = key.PublicKey()
+	}
+	keys, pubKeys = local, public
+}
+
+// GenerateKey generates a private key of the signature scheme from the system randomness
+func GenerateKey(signer string) (PrivateKey, error) {
+	switch signer {
+	case ECDSA_P256:
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 		if err != nil {
 			return nil, err
 		}
-		privKey := &ecdsa_p256_PrivateKey{SignAlg: signer, PrivateKey: priv}
-		return privKey, nil
-	} else if signer == ECDSA_SECp256k1 {
-		return nil, nil
-	} else if signer == BLS_BLS12381 {
-		return nil, nil
-	} else {
+		return &ecdsa_p256_PrivateKey{SignAlg: signer, PrivateKey: priv}, nil
+	case BLS_BLS12381:
+		return generateBLSKey()
+	case ECDSA_SECp256k1:
+		return generateSecp256k1Key()
+	case ED25519:
+		return generateEd25519Key()
+	default:
 		return nil, errors.New("Invalid signature scheme!")
 	}
 }
 
 // Use the following functions for signing and verification.
 
+// PrivSign signs with the private key of the node, which has to be local: a process cannot sign for another node
 func PrivSign(data []byte, nodeID identity.NodeID, hasher Hasher) (Signature, error) {
-	return keys[nodeID.Node()-1].Sign(data, hasher)
+	key, ok := keys[nodeID]
+	if !ok {
+		return nil, fmt.Errorf("no private key of node %v in this process", nodeID)
+	}
+	defer observeSince(signTime, key.Algorithm(), time.Now())
+	return key.Sign(data, hasher)
 }
 
+// PubVerify verifies the signature of the node with its public key in the registry
 func PubVerify(sig Signature, data []byte, nodeID identity.NodeID) (bool, error) {
-	return pubKeys[nodeID.Node()-1].Verify(sig, data)
-}
-
-func VerifyQuorumSignature(aggregatedSigs AggSig, blockID Identifier, aggSigners []identity.NodeID) (bool, error) {
-	var sigIsCorrect bool
-	var errAgg error
-	for i, signer := range aggSigners {
-		sigIsCorrect, errAgg = PubVerify(aggregatedSigs[i], IDToByte(blockID), signer)
-		if errAgg != nil {
-			return false, errAgg
-		}
-		if sigIsCorrect == false {
-			return false, nil
-		}
+	key, ok := pubKeys[nodeID]
+	if !ok {
+		return false, fmt.Errorf("no public key of node %v", nodeID)
 	}
-	return true, nil
+	defer observeSince(verifyTime, key.Algorithm(), time.Now())
+	return key.Verify(sig, data)
 }
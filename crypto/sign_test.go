@@ -0,0 +1,131 @@
+package crypto
+
+import (
+	"testing"
+
+	"banyan/identity"
+
+	"github.com/stretchr/testify/require"
+)
+
+var schemes = []string{ECDSA_P256, ECDSA_SECp256k1, ED25519, BLS_BLS12381}
+
+func TestSchemes(t *testing.T) {
+	sizes := map[string]int{ECDSA_P256: 64, ECDSA_SECp256k1: 64, ED25519: 64, BLS_BLS12381: blsSignatureSize}
+	data := IDToByte(MakeID("block"))
+	for _, scheme := range schemes {
+		key, err := GenerateKey(scheme)
+		require.NoError(t, err, scheme)
+		require.Equal(t, scheme, key.Algorithm())
+		sig, err := key.Sign(data, nil)
+		require.NoError(t, err, scheme)
+		require.Len(t, sig, sizes[scheme], scheme)
+		ok, err := key.PublicKey().Verify(sig, data)
+		require.NoError(t, err, scheme)
+		require.True(t, ok, scheme)
+
+		// the keys survive their encoding
+		private, err := key.Encode()
+		require.NoError(t, err)
+		decoded, err := DecodePrivateKey(scheme, private)
+		require.NoError(t, err, scheme)
+		require.NoError(t, matches(decoded, mustEncode(t, key.PublicKey())), scheme)
+		public, err := DecodePublicKey(scheme, mustEncode(t, key.PublicKey()))
+		require.NoError(t, err, scheme)
+		ok, err = public.Verify(sig, data)
+		require.NoError(t, err, scheme)
+		require.True(t, ok, scheme)
+
+		// nor another key, nor other data, nor a damaged signature pass
+		other, err := GenerateKey(scheme)
+		require.NoError(t, err)
+		ok, _ = other.PublicKey().Verify(sig, data)
+		require.False(t, ok, scheme)
+		ok, _ = public.Verify(sig, IDToByte(MakeID("other block")))
+		require.False(t, ok, scheme)
+		damaged := append(Signature(nil), sig...)
+		damaged[len(damaged)/4] ^= 1
+		ok, _ = public.Verify(damaged, data)
+		require.False(t, ok, scheme)
+		_, err = public.Verify(sig[1:], data)
+		require.Error(t, err, scheme)
+
+		_, err = DecodePrivateKey(scheme, private[1:])
+		require.Error(t, err, scheme)
+	}
+}
+
+func TestSignatureMetrics(t *testing.T) {
+	require.NoError(t, GenerateKeys(ED25519, ids))
+	before := verifyTime.With(ED25519).Snapshot().Count
+	data := IDToByte(MakeID("block"))
+	sig, err := PrivSign(data, "1", nil)
+	require.NoError(t, err)
+	_, err = PubVerify(sig, data, "1")
+	require.NoError(t, err)
+	require.Equal(t, before+1, verifyTime.With(ED25519).Snapshot().Count)
+	require.NotZero(t, Metrics.Snapshot().Histograms["banyan_sign_seconds"][ED25519].Count)
+}
+
+func mustEncode(t *testing.T, key PublicKey) []byte {
+	data, err := key.Encode()
+	require.NoError(t, err)
+	return data
+}
+
+// BenchmarkSign and BenchmarkVerify compare the cost of a single signature of a block id with each scheme
+func BenchmarkSign(b *testing.B) {
+	data := IDToByte(MakeID("block"))
+	for _, scheme := range schemes {
+		key, err := GenerateKey(scheme)
+		require.NoError(b, err)
+		b.Run(scheme, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err = key.Sign(data, nil)
+			}
+			require.NoError(b, err)
+		})
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	data := IDToByte(MakeID("block"))
+	for _, scheme := range schemes {
+		key, err := GenerateKey(scheme)
+		require.NoError(b, err)
+		sig, err := key.Sign(data, nil)
+		require.NoError(b, err)
+		b.Run(scheme, func(b *testing.B) {
+			ok := false
+			for i := 0; i < b.N; i++ {
+				ok, _ = key.PublicKey().Verify(sig, data)
+			}
+			require.True(b, ok)
+		})
+	}
+}
+
+// BenchmarkVerifyBatch checks the votes of 16 nodes on a block at once, as a worker of the verifier does
+func BenchmarkVerifyBatch(b *testing.B) {
+	const n = 16
+	voters := make([]identity.NodeID, n)
+	for i := range voters {
+		voters[i] = identity.NewNodeID(i + 1)
+	}
+	data := IDToByte(MakeID("block"))
+	for _, scheme := range schemes {
+		require.NoError(b, GenerateKeys(scheme, voters))
+		claims := make([]Claim, n)
+		for i, id := range voters {
+			sig, err := PrivSign(data, id, nil)
+			require.NoError(b, err)
+			claims[i] = Claim{Signer: id, Data: data, Sig: sig}
+		}
+		b.Run(scheme, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				VerifyBatch(claims)
+			}
+		})
+	}
+	UseKeys(nil)
+}
@@ -0,0 +1,315 @@
+// Package dissemination spreads the blocks with an erasure code. The proposer cuts the encoding of a block in one
+// chunk per replica, any n-2f of which give the block back, and sends every replica its own chunk with a Merkle
+// proof of it and its signature over the root and the size of the block. Every replica sends its chunk on to the others, so each one sends
+// about a (n-2f)th of the block to every peer, where the leader used to send the whole block to every peer and
+// every replica to send it again. The votes carry the id of the block only, a replica votes once it rebuilt it.
+package dissemination
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"banyan/blockchain"
+	view "banyan/blockchain_view"
+	"banyan/config"
+	"banyan/crypto"
+	"banyan/erasure"
+	"banyan/identity"
+	"banyan/log"
+	"banyan/node"
+	"banyan/types/encoding/binary"
+)
+
+// the kinds of blocks in a chunk
+const (
+	kindBlock     byte = 1 // Banyan and ICC
+	kindViewBlock byte = 2 // HotStuff and Streamlet
+)
+
+// maxRoots is how many blocks a replica remembers, the chunks of older ones are rebuilt again at worst
+const maxRoots = 1024
+
+// Chunk is the chunk of a block a replica gets from the proposer, or from the replica it is for
+type Chunk struct {
+	From     identity.NodeID
+	Proposer identity.NodeID
+	Root     crypto.Identifier // root of the Merkle tree of the chunks
+	RootSig  crypto.Signature  // signature of the proposer over the root and the size
+	Size     int               // bytes of the encoded block
+	Index    int               // the chunk of replica Index+1
+	Shard    []byte
+	Proof    []crypto.Identifier
+}
+
+func (c Chunk) Sender() identity.NodeID {
+	return c.From
+}
+
+// Node sends the blocks it proposes in chunks, and gives the blocks it rebuilds from the chunks it receives
+type Node struct {
+	node.Node
+	code  *erasure.Code
+	index int               // of the chunks of this replica
+	peers []identity.NodeID // by the index of their chunk
+
+	mu      sync.Mutex
+	blocks  map[blockKey]*block
+	pending []blockKey                 // the keys of blocks, oldest first
+	known   map[crypto.Identifier]bool // blocks dispersed or rebuilt, by id
+	order   []crypto.Identifier        // ids, oldest first
+}
+
+// blockKey is what the proposer signs of a block, the chunks of a block are kept once the signature checks
+type blockKey struct {
+	proposer identity.NodeID
+	root     crypto.Identifier
+	size     int
+}
+
+// block is what a replica knows of the chunks of a block
+type block struct {
+	shards [][]byte
+	count  int
+	echoed bool // sent the chunk of this replica on
+	done   bool
+}
+
+// NewNode disperses the blocks of n among the replicas of the configuration, f of which may fail
+func NewNode(n node.Node, f int) *Node {
+	var peers []identity.NodeID
+	for id := range config.GetConfig().Addrs {
+		peers = append(peers, id)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Node() < peers[j].Node() })
+	data := len(peers) - 2*f
+	if data < 1 {
+		data = 1
+	}
+	code, err := erasure.New(data, len(peers))
+	if err != nil {
+		log.Fatal(err)
+	}
+	d := &Node{
+		Node:   n,
+		code:   code,
+		index:  -1,
+		peers:  peers,
+		blocks: make(map[blockKey]*block),
+		known:  make(map[crypto.Identifier]bool),
+	}
+	for i, id := range peers {
+		if id == n.ID() {
+			d.index = i
+		}
+	}
+	return d
+}
+
+// Broadcast sends the blocks this replica proposes in chunks, and not at all the blocks it already dispersed or
+// rebuilt since the other replicas rebuild them too. The other blocks and messages go out as they are.
+func (d *Node) Broadcast(m interface{}) {
+	var id crypto.Identifier
+	var proposer identity.NodeID
+	switch b := m.(type) {
+	case *blockchain.Block:
+		id, proposer = b.ID, b.Proposer
+	case *view.Block:
+		id, proposer = b.ID, b.Proposer
+	default:
+		d.Node.Broadcast(m)
+		return
+	}
+	d.mu.Lock()
+	known := d.known[id]
+	d.mu.Unlock()
+	if known {
+		return
+	}
+	if proposer != d.ID() || d.index < 0 {
+		d.Node.Broadcast(m)
+		return
+	}
+	if err := d.disperse(id, m); err != nil {
+		log.Errorf("[%v] sends the whole block %x: %v", d.ID(), id, err)
+		d.Node.Broadcast(m)
+	}
+}
+
+func (d *Node) disperse(id crypto.Identifier, m interface{}) error {
+	kind := kindBlock
+	if _, ok := m.(*view.Block); ok {
+		kind = kindViewBlock
+	}
+	body, err := binary.NewEncoder().Encode(m)
+	if err != nil {
+		return err
+	}
+	encoded := append([]byte{kind}, body...)
+	shards := d.code.Encode(encoded)
+	tree := crypto.NewMerkleTree(shards)
+	root := tree.Root()
+	sig, err := crypto.PrivSign(signed(root, len(encoded)), d.ID(), nil)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.remember(id)
+	d.keep(blockKey{d.ID(), root, len(encoded)}, &block{done: true})
+	d.mu.Unlock()
+	for i, peer := range d.peers {
+		if peer == d.ID() {
+			continue
+		}
+		d.Send(peer, Chunk{
+			From:     d.ID(),
+			Proposer: d.ID(),
+			Root:     root,
+			RootSig:  sig,
+			Size:     len(encoded),
+			Index:    i,
+			Shard:    shards[i],
+			Proof:    tree.Proof(i),
+		})
+	}
+	return nil
+}
+
+type signedRoot struct {
+	Root crypto.Identifier
+	Size int
+}
+
+// signed returns what the proposer signs, a digest of the root and the size of the block: the schemes sign 32 bytes
+func signed(root crypto.Identifier, size int) []byte {
+	return crypto.IDToByte(crypto.MakeID(signedRoot{Root: root, Size: size}))
+}
+
+// remember keeps the id of a block, and forgets the oldest ones, with d.mu held
+func (d *Node) remember(id crypto.Identifier) {
+	d.order = append(d.order, id)
+	d.known[id] = true
+	if len(d.order) > 2*maxRoots {
+		for _, old := range d.order[:len(d.order)-maxRoots] {
+			delete(d.known, old)
+		}
+		d.order = append([]crypto.Identifier(nil), d.order[len(d.order)-maxRoots:]...)
+	}
+}
+
+// keep keeps the chunks of a block, and forgets the oldest blocks, with d.mu held
+func (d *Node) keep(key blockKey, b *block) {
+	d.pending = append(d.pending, key)
+	d.blocks[key] = b
+	if len(d.pending) > 2*maxRoots {
+		for _, old := range d.pending[:len(d.pending)-maxRoots] {
+			delete(d.blocks, old)
+		}
+		d.pending = append([]blockKey(nil), d.pending[len(d.pending)-maxRoots:]...)
+	}
+}
+
+// Add keeps the chunk, sends on the chunk of this replica, and returns the block once enough chunks rebuild it: a
+// blockchain.Block or a view.Block, nil until then
+func (d *Node) Add(c Chunk) (interface{}, error) {
+	if c.Size < 0 || c.Index < 0 || c.Index >= d.code.Total() || len(c.Shard) != d.code.ShardSize(c.Size) {
+		return nil, fmt.Errorf("chunk %d of %d bytes of a block of %d bytes", c.Index, len(c.Shard), c.Size)
+	}
+	if !crypto.VerifyMerkleProof(c.Root, c.Shard, c.Index, d.code.Total(), c.Proof) {
+		return nil, fmt.Errorf("chunk %d is not under the root %x", c.Index, c.Root)
+	}
+
+	// any replica sends chunks on, the chunks of a block are the ones whose signature checks, whichever came first
+	if ok, err := crypto.PubVerify(c.RootSig, signed(c.Root, c.Size), c.Proposer); !ok || err != nil {
+		return nil, fmt.Errorf("the root %x of a block of %d bytes is not signed by %v", c.Root, c.Size, c.Proposer)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := blockKey{c.Proposer, c.Root, c.Size}
+	b, exists := d.blocks[key]
+	if !exists {
+		b = &block{shards: make([][]byte, d.code.Total())}
+		d.keep(key, b)
+	}
+	if b.done || b.shards[c.Index] != nil {
+		return nil, nil
+	}
+	b.shards[c.Index] = c.Shard
+	b.count++
+	if c.Index == d.index && !b.echoed {
+		b.echoed = true
+		d.echo(c)
+	}
+	if b.count < d.code.Data() {
+		return nil, nil
+	}
+
+	b.done = true
+	encoded, err := d.code.Decode(b.shards, c.Size)
+	b.shards = nil
+	if err != nil {
+		return nil, err
+	}
+	// a proposer that encoded some chunks wrong would have the replicas rebuild different blocks from different
+	// chunks, the chunks of the block rebuilt have to be the ones under the root
+	shards := d.code.Encode(encoded)
+	tree := crypto.NewMerkleTree(shards)
+	if tree.Root() != c.Root {
+		return nil, fmt.Errorf("the chunks of %v under the root %x do not encode a block", c.Proposer, c.Root)
+	}
+	if !b.echoed && d.index >= 0 {
+		// the proposer did not send this replica its chunk, the others may need it
+		b.echoed = true
+		mine := c
+		mine.Index, mine.Shard, mine.Proof = d.index, shards[d.index], tree.Proof(d.index)
+		d.echo(mine)
+	}
+
+	m, proposer, err := decode(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if proposer != c.Proposer {
+		return nil, fmt.Errorf("%v sent the chunks of a block of %v", c.Proposer, proposer)
+	}
+	d.remember(idOf(m))
+	return m, nil
+}
+
+// echo sends the chunk of this replica to the replicas other than the proposer, with d.mu held
+func (d *Node) echo(c Chunk) {
+	c.From = d.ID()
+	for _, peer := range d.peers {
+		if peer != d.ID() && peer != c.Proposer {
+			d.Send(peer, c)
+		}
+	}
+}
+
+// decode returns the block and its proposer
+func decode(encoded []byte) (interface{}, identity.NodeID, error) {
+	if len(encoded) == 0 {
+		return nil, "", errors.New("an empty block")
+	}
+	switch encoded[0] {
+	case kindBlock:
+		var b blockchain.Block
+		err := binary.NewEncoder().Decode(encoded[1:], &b)
+		return b, b.Proposer, err
+	case kindViewBlock:
+		var b view.Block
+		err := binary.NewEncoder().Decode(encoded[1:], &b)
+		return b, b.Proposer, err
+	}
+	return nil, "", fmt.Errorf("a block of kind %d", encoded[0])
+}
+
+func idOf(m interface{}) crypto.Identifier {
+	if b, ok := m.(blockchain.Block); ok {
+		return b.ID
+	}
+	return m.(view.Block).ID
+}
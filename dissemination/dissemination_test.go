@@ -0,0 +1,260 @@
+package dissemination
+
+import (
+	"testing"
+	"time"
+
+	"banyan/blockchain"
+	view "banyan/blockchain_view"
+	"banyan/config"
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/message"
+	"banyan/node"
+	"banyan/types/encoding/binary"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sent is a chunk on its way
+type sent struct {
+	to identity.NodeID
+	m  interface{}
+}
+
+// testNode queues what the replica sends
+type testNode struct {
+	node.Node // nil, the test uses the methods below only
+	id        identity.NodeID
+	queue     *[]sent
+	broadcast []interface{}
+}
+
+func (n *testNode) ID() identity.NodeID {
+	return n.id
+}
+
+func (n *testNode) Send(to identity.NodeID, m interface{}) {
+	*n.queue = append(*n.queue, sent{to, m})
+}
+
+func (n *testNode) Broadcast(m interface{}) {
+	n.broadcast = append(n.broadcast, m)
+}
+
+func setNodes(t *testing.T, n int) ([]*Node, *[]sent) {
+	saved := config.Configuration
+	t.Cleanup(func() { config.Configuration = saved })
+	config.Configuration.Addrs = make(map[identity.NodeID]string)
+	var ids []identity.NodeID
+	for i := 1; i <= n; i++ {
+		ids = append(ids, identity.NewNodeID(i))
+		config.Configuration.Addrs[ids[i-1]] = ""
+	}
+	require.NoError(t, crypto.GenerateKeys(crypto.ED25519, ids))
+	queue := new([]sent)
+	nodes := make([]*Node, n)
+	for i, id := range ids {
+		nodes[i] = NewNode(&testNode{id: id, queue: queue}, (n-1)/3)
+	}
+	return nodes, queue
+}
+
+func testBlock(proposer identity.NodeID, size int) *blockchain.Block {
+	b := &blockchain.Block{Height: 3, Rank: 1, Proposer: proposer, Timestamp: time.Unix(5, 0), PrevID: crypto.MakeID("parent"), Sig: crypto.Signature{2}}
+	for len(b.Payload)*100 < size {
+		b.Payload = append(b.Payload, &message.Transaction{Command: make([]byte, 100), ClientID: "client"})
+	}
+	b.ID = b.Header().ID()
+	return b
+}
+
+// deliver hands the queued chunks to the replicas, and returns the blocks they rebuild
+func deliver(t *testing.T, nodes []*Node, queue *[]sent, drop func(sent) bool) map[identity.NodeID][]interface{} {
+	rebuilt := make(map[identity.NodeID][]interface{})
+	for len(*queue) > 0 {
+		s := (*queue)[0]
+		*queue = (*queue)[1:]
+		if drop != nil && drop(s) {
+			continue
+		}
+		d := nodes[s.to.Node()-1]
+		m, err := d.Add(s.m.(Chunk))
+		require.NoError(t, err)
+		if m != nil {
+			rebuilt[d.ID()] = append(rebuilt[d.ID()], m)
+		}
+	}
+	return rebuilt
+}
+
+func TestDisseminate(t *testing.T) {
+	nodes, queue := setNodes(t, 7)
+	block := testBlock("1", 10000)
+	nodes[0].Broadcast(block)
+	require.Empty(t, nodes[0].Node.(*testNode).broadcast, "the block goes in chunks")
+	require.Len(t, *queue, 6)
+	for _, s := range *queue {
+		// any 7-2*2 chunks rebuild the block, a chunk is a third of it
+		c := s.m.(Chunk)
+		require.Equal(t, (c.Size+2)/3, len(c.Shard))
+	}
+
+	rebuilt := deliver(t, nodes, queue, nil)
+	require.Len(t, rebuilt, 6)
+	for _, blocks := range rebuilt {
+		require.Equal(t, []interface{}{*block}, blocks, "every replica rebuilds the block once")
+	}
+
+	// the replicas echo the block, it is already everywhere
+	for _, d := range nodes {
+		d.Broadcast(block)
+		require.Empty(t, d.Node.(*testNode).broadcast)
+	}
+	require.Empty(t, *queue)
+
+	// the block of another replica, from a block response, goes as it is
+	other := testBlock("2", 10)
+	nodes[0].Broadcast(other)
+	require.Equal(t, []interface{}{other}, nodes[0].Node.(*testNode).broadcast)
+}
+
+func TestDisseminateViewBlock(t *testing.T) {
+	nodes, queue := setNodes(t, 4)
+	block := &view.Block{View: 2, Proposer: "3", Timestamp: time.Unix(5, 0), Sig: crypto.Signature{2}}
+	block.ID = block.Header().ID()
+	nodes[2].Broadcast(block)
+	rebuilt := deliver(t, nodes, queue, nil)
+	require.Len(t, rebuilt, 3)
+	require.Equal(t, []interface{}{*block}, rebuilt["1"])
+}
+
+func TestLostChunks(t *testing.T) {
+	// the proposer does not send node 2 its chunk, node 2 rebuilds the block from the chunks of the others and
+	// sends its own on
+	nodes, queue := setNodes(t, 4)
+	block := testBlock("1", 5000)
+	nodes[0].Broadcast(block)
+	rebuilt := deliver(t, nodes, queue, func(s sent) bool {
+		c := s.m.(Chunk)
+		return c.From == "1" && s.to == "2" || c.From == "3" && s.to == "4"
+	})
+	require.Len(t, rebuilt, 3)
+}
+
+func TestForgedChunks(t *testing.T) {
+	nodes, queue := setNodes(t, 4)
+	nodes[0].Broadcast(testBlock("1", 5000))
+	chunk := (*queue)[0].m.(Chunk)
+
+	tampered := chunk
+	tampered.Shard = append([]byte(nil), chunk.Shard...)
+	tampered.Shard[0]++
+	_, err := nodes[1].Add(tampered)
+	require.Error(t, err, "the shard is not under the root")
+
+	forged := chunk
+	forged.Proposer = "3"
+	_, err = nodes[1].Add(forged)
+	require.Error(t, err, "node 3 did not sign the root")
+
+	short := chunk
+	short.Size++
+	_, err = nodes[1].Add(short)
+	require.Error(t, err)
+
+	// a proposer whose chunks do not encode one block
+	shards := [][]byte{{1}, {2}, {3}, {4}}
+	tree := crypto.NewMerkleTree(shards)
+	root := tree.Root()
+	sig, err := crypto.PrivSign(signed(root, 2), "1", nil)
+	require.NoError(t, err)
+	for i := 2; i < 4; i++ {
+		m, err := nodes[1].Add(Chunk{From: "1", Proposer: "1", Root: root, RootSig: sig, Size: 2, Index: i, Shard: shards[i], Proof: tree.Proof(i)})
+		require.Nil(t, m)
+		if i == 3 {
+			require.Error(t, err)
+		}
+	}
+}
+
+// the forged echoes of a Byzantine replica that arrive before the chunks of the proposer do not keep the replicas
+// from rebuilding the block
+func TestForgedEchoes(t *testing.T) {
+	nodes, queue := setNodes(t, 4)
+	block := testBlock("1", 5000)
+	nodes[0].Broadcast(block)
+	chunks := append([]sent(nil), *queue...)
+	*queue = nil
+
+	for _, s := range chunks {
+		c := s.m.(Chunk)
+		forged := c
+		forged.From = "4"
+		forged.RootSig = crypto.Signature{1, 2, 3}
+		_, err := nodes[1].Add(forged)
+		require.Error(t, err, "a forged signature")
+
+		// another size of block with chunks of the same size
+		resized := c
+		resized.From = "4"
+		resized.Size--
+		if nodes[1].code.ShardSize(resized.Size) != len(c.Shard) {
+			resized.Size += 2
+		}
+		require.Equal(t, len(c.Shard), nodes[1].code.ShardSize(resized.Size))
+		_, err = nodes[1].Add(resized)
+		require.Error(t, err, "the proposer did not sign that size")
+
+		if c.Index == 1 {
+			// node 4 signs the root of node 1 with another size, its chunks are kept apart
+			sig, err := crypto.PrivSign(signed(c.Root, resized.Size), "4", nil)
+			require.NoError(t, err)
+			resized.Proposer, resized.RootSig = "4", sig
+			m, err := nodes[1].Add(resized)
+			require.NoError(t, err)
+			require.Nil(t, m)
+		}
+	}
+
+	*queue = chunks
+	rebuilt := deliver(t, nodes, queue, nil)
+	require.Len(t, rebuilt, 3)
+	require.Equal(t, []interface{}{*block}, rebuilt["2"])
+}
+
+// the proposer signs the size of the block whatever the signature scheme
+func TestSignedSize(t *testing.T) {
+	for _, scheme := range []string{crypto.ECDSA_P256, crypto.ECDSA_SECp256k1, crypto.ED25519, crypto.BLS_BLS12381} {
+		t.Run(scheme, func(t *testing.T) {
+			nodes, queue := setNodes(t, 4)
+			require.NoError(t, crypto.GenerateKeys(scheme, []identity.NodeID{"1", "2", "3", "4"}))
+			root := crypto.MakeID("root")
+			sig, err := crypto.PrivSign(signed(root, 0), "1", nil)
+			require.NoError(t, err)
+			ok, _ := crypto.PubVerify(sig, signed(root, 7), "1")
+			require.False(t, ok)
+
+			block := testBlock("1", 5000)
+			nodes[0].Broadcast(block)
+			require.Empty(t, nodes[0].Node.(*testNode).broadcast, "the block goes in chunks")
+			resized := (*queue)[0].m.(Chunk)
+			resized.Size++
+			_, err = nodes[resized.Index].Add(resized)
+			require.Error(t, err)
+			rebuilt := deliver(t, nodes, queue, nil)
+			require.Len(t, rebuilt, 3)
+		})
+	}
+}
+
+func TestChunkWire(t *testing.T) {
+	nodes, queue := setNodes(t, 4)
+	nodes[0].Broadcast(testBlock("1", 1000))
+	chunk := (*queue)[0].m.(Chunk)
+	encoded, err := binary.NewEncoder().Encode(&chunk)
+	require.NoError(t, err)
+	var decoded Chunk
+	require.NoError(t, binary.NewEncoder().Decode(encoded, &decoded))
+	require.Equal(t, chunk, decoded)
+}
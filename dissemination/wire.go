@@ -0,0 +1,37 @@
+package dissemination
+
+import (
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/types/encoding/binary"
+)
+
+func (c *Chunk) MarshalWire(w *binary.Writer) {
+	w.String(string(c.From))
+	w.String(string(c.Proposer))
+	w.Fixed(c.Root[:])
+	w.Bytes(c.RootSig)
+	w.Int(c.Size)
+	w.Int(c.Index)
+	w.Bytes(c.Shard)
+	w.Uint64(uint64(len(c.Proof)))
+	for _, hash := range c.Proof {
+		w.Fixed(hash[:])
+	}
+}
+
+func (c *Chunk) UnmarshalWire(r *binary.Reader) {
+	c.From = identity.NodeID(r.String())
+	c.Proposer = identity.NodeID(r.String())
+	r.Fixed(c.Root[:])
+	c.RootSig = r.Bytes()
+	c.Size = r.Int()
+	c.Index = r.Int()
+	c.Shard = r.Bytes()
+	if n := r.Len(len(crypto.Identifier{})); n > 0 {
+		c.Proof = make([]crypto.Identifier, n)
+		for i := range c.Proof {
+			r.Fixed(c.Proof[i][:])
+		}
+	}
+}
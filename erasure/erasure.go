@@ -0,0 +1,209 @@
+// Package erasure cuts data in shards with a systematic Reed-Solomon code over GF(2^8): the first shards are the
+// data itself, the others are parity, and any data many of the shards give the data back.
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// the field GF(2^8) with the polynomial x^8+x^4+x^3+x^2+1
+const polynomial = 0x11d
+
+var (
+	expTable [510]byte
+	logTable [256]byte
+	mul      [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= polynomial
+		}
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			mul[a][b] = expTable[int(logTable[a])+int(logTable[b])]
+		}
+	}
+}
+
+func inv(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
+
+// Code encodes data in total shards, data many of which are enough to decode it
+type Code struct {
+	data, total int
+	parity      [][]byte // total-data rows of data coefficients
+}
+
+// New returns the code of data shards out of total, at most 256 shards
+func New(data, total int) (*Code, error) {
+	if data < 1 || total < data || total > 256 {
+		return nil, fmt.Errorf("erasure: cannot code %d data shards out of %d", data, total)
+	}
+	// a Cauchy matrix, any square submatrix of [I; parity] is invertible
+	c := &Code{data: data, total: total, parity: make([][]byte, total-data)}
+	for i := range c.parity {
+		c.parity[i] = make([]byte, data)
+		for j := range c.parity[i] {
+			c.parity[i][j] = inv(byte(data+i) ^ byte(j))
+		}
+	}
+	return c, nil
+}
+
+func (c *Code) Data() int {
+	return c.data
+}
+
+func (c *Code) Total() int {
+	return c.total
+}
+
+// ShardSize returns the bytes of each shard of data of that size
+func (c *Code) ShardSize(size int) int {
+	if size == 0 {
+		return 1
+	}
+	return (size + c.data - 1) / c.data
+}
+
+// Encode returns the total shards of the data, of ShardSize bytes each
+func (c *Code) Encode(data []byte) [][]byte {
+	size := c.ShardSize(len(data))
+	buf := make([]byte, size*c.total)
+	copy(buf, data)
+	shards := make([][]byte, c.total)
+	for i := range shards {
+		shards[i] = buf[i*size : (i+1)*size : (i+1)*size]
+	}
+	for i, row := range c.parity {
+		mulAdd(shards[c.data+i], row, shards[:c.data])
+	}
+	return shards
+}
+
+// mulAdd adds to out the shards times their coefficients
+func mulAdd(out []byte, coefficients []byte, shards [][]byte) {
+	for j, coefficient := range coefficients {
+		if coefficient == 0 {
+			continue
+		}
+		table := &mul[coefficient]
+		for k, b := range shards[j] {
+			out[k] ^= table[b]
+		}
+	}
+}
+
+// Decode returns the data of the size from the shards, nil for the missing ones. It needs Data shards of the same
+// length.
+func (c *Code) Decode(shards [][]byte, size int) ([]byte, error) {
+	if len(shards) != c.total {
+		return nil, fmt.Errorf("erasure: %d shards, expected %d", len(shards), c.total)
+	}
+	length := c.ShardSize(size)
+	var present []int
+	for i, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		if len(shard) != length {
+			return nil, fmt.Errorf("erasure: shard %d of %d bytes, expected %d", i, len(shard), length)
+		}
+		if len(present) < c.data {
+			present = append(present, i)
+		}
+	}
+	if len(present) < c.data {
+		return nil, errors.New("erasure: not enough shards")
+	}
+
+	data := make([]byte, length*c.data)
+	missing := false
+	for i := 0; i < c.data; i++ {
+		if shards[i] == nil {
+			missing = true
+		} else {
+			copy(data[i*length:], shards[i])
+		}
+	}
+	if missing {
+		// the rows of the present shards, inverted, give the data shards
+		rows := make([][]byte, c.data)
+		for r, i := range present {
+			rows[r] = c.row(i)
+		}
+		inverse, err := invert(rows)
+		if err != nil {
+			return nil, err
+		}
+		sources := make([][]byte, c.data)
+		for r, i := range present {
+			sources[r] = shards[i]
+		}
+		for i := 0; i < c.data; i++ {
+			if shards[i] == nil {
+				mulAdd(data[i*length:(i+1)*length], inverse[i], sources)
+			}
+		}
+	}
+	return data[:size], nil
+}
+
+// row returns the coefficients of the shard
+func (c *Code) row(i int) []byte {
+	if i >= c.data {
+		return c.parity[i-c.data]
+	}
+	row := make([]byte, c.data)
+	row[i] = 1
+	return row
+}
+
+// invert returns the inverse of the square matrix by Gauss-Jordan elimination
+func invert(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	a := make([][]byte, n)
+	for i := range a {
+		a[i] = make([]byte, 2*n)
+		copy(a[i], m[i])
+		a[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && a[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errors.New("erasure: singular matrix")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		scale := &mul[inv(a[col][col])]
+		for k := range a[col] {
+			a[col][k] = scale[a[col][k]]
+		}
+		for r := 0; r < n; r++ {
+			if r == col || a[r][col] == 0 {
+				continue
+			}
+			factor := &mul[a[r][col]]
+			for k := range a[r] {
+				a[r][k] ^= factor[a[col][k]]
+			}
+		}
+	}
+	inverse := make([][]byte, n)
+	for i := range inverse {
+		inverse[i] = a[i][n:]
+	}
+	return inverse, nil
+}
@@ -0,0 +1,66 @@
+package erasure
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []struct{ data, total, size int }{{1, 1, 10}, {2, 4, 1001}, {3, 7, 0}, {34, 100, 100000}} {
+		code, err := New(c.data, c.total)
+		require.NoError(t, err)
+		data := make([]byte, c.size)
+		rand.Read(data)
+		shards := code.Encode(data)
+		require.Len(t, shards, c.total)
+
+		// every choice of data shards gives the data back, the parity ones first
+		for trial := 0; trial < 20; trial++ {
+			received := make([][]byte, c.total)
+			for _, i := range rand.Perm(c.total)[:c.data] {
+				received[i] = shards[i]
+			}
+			if trial == 0 {
+				received = make([][]byte, c.total)
+				copy(received[c.total-c.data:], shards[c.total-c.data:])
+			}
+			decoded, err := code.Decode(received, c.size)
+			require.NoError(t, err)
+			require.Equal(t, data, decoded)
+		}
+
+		if c.data > 1 {
+			received := make([][]byte, c.total)
+			copy(received, shards[:c.data-1])
+			_, err := code.Decode(received, c.size)
+			require.Error(t, err, "one shard short")
+		}
+	}
+
+	_, err := New(3, 2)
+	require.Error(t, err)
+	_, err = New(10, 257)
+	require.Error(t, err)
+}
+
+func BenchmarkEncode(b *testing.B) {
+	code, _ := New(34, 100)
+	data := make([]byte, 1<<20)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		code.Encode(data)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	code, _ := New(34, 100)
+	data := make([]byte, 1<<20)
+	shards := code.Encode(data)
+	copy(shards, make([][]byte, 34)) // only parity shards
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		_, _ = code.Decode(shards, len(data))
+	}
+}
@@ -0,0 +1,291 @@
+package evidence
+
+import (
+	"fmt"
+	"sync"
+
+	"banyan/blockchain"
+	view "banyan/blockchain_view"
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/log"
+	"banyan/message"
+	"banyan/metrics"
+)
+
+// depth is the number of heights (views) below the highest one whose blocks are kept to complete the proofs
+const depth = 100
+
+// Node is the part of the node the collector gossips the proofs with
+type Node interface {
+	ID() identity.NodeID
+	Broadcast(m interface{})
+	Metrics() *metrics.Registry
+}
+
+// position is a height and rank (a view) on which an honest replica signs a single block
+type position struct {
+	Signer identity.NodeID
+	Height int
+	Rank   int
+}
+
+// conflict is an equivocation whose proof waits for the header of a block
+type conflict struct {
+	kind     Kind
+	offender identity.NodeID
+	height   int // only to forget the conflicts that are not completed
+	ids      [2]crypto.Identifier
+	sigs     [2]crypto.Signature
+}
+
+// Collector turns the conflicting proposals and shares (votes) the protocols notice into proofs,
+// gossips them and keeps the ones it builds or receives
+type Collector struct {
+	Node
+	sign  func(crypto.Identifier) (crypto.Signature, error)
+	found *metrics.CounterVec
+
+	mu        sync.Mutex
+	headers   map[crypto.Identifier]Signed      // the blocks I have seen, without signature
+	proposals map[position]Signed               // the first proposal of each leader on a position
+	pending   map[crypto.Identifier][]*conflict // conflicts waiting for the header of the block
+	proofs    map[position]*EquivocationProof   // a single proof for each offender and position
+	order     []position                        // the positions of the proofs, in the order they were collected
+	top       int                               // the highest height (view) I have seen a block on
+}
+
+func NewCollector(n Node) *Collector {
+	return &Collector{
+		Node: n,
+		sign: func(id crypto.Identifier) (crypto.Signature, error) {
+			return crypto.PrivSign(crypto.IDToByte(id), n.ID(), nil)
+		},
+		found:     n.Metrics().CounterVec("banyan_equivocations_total", "Equivocation proofs built or received.", "kind"),
+		headers:   make(map[crypto.Identifier]Signed),
+		proposals: make(map[position]Signed),
+		pending:   make(map[crypto.Identifier][]*conflict),
+		proofs:    make(map[position]*EquivocationProof),
+	}
+}
+
+// Block records a proposal of Banyan or ICC whose signature is valid
+func (c *Collector) Block(block *blockchain.Block) {
+	header := block.Header()
+	c.gossip(c.proposal(block.ID, block.Proposer, signedBlock(header, block.Sig), header.Height))
+}
+
+// ViewBlock records a proposal of HotStuff or Streamlet whose signature is valid
+func (c *Collector) ViewBlock(block *view.Block) {
+	header := block.Header()
+	c.gossip(c.proposal(block.ID, block.Proposer, signedViewBlock(header, block.Sig), int(header.View)))
+}
+
+// Notarization reports two notarization shares of a voter for different blocks on the same height and rank
+func (c *Collector) Notarization(first *blockchain.NotarizationShare, second *blockchain.NotarizationShare) {
+	c.gossip(c.conflict(&conflict{
+		kind:     DoubleNotarization,
+		offender: second.Voter,
+		height:   second.Height,
+		ids:      [2]crypto.Identifier{first.BlockID, second.BlockID},
+		sigs:     [2]crypto.Signature{first.Signature, second.Signature},
+	}))
+}
+
+// Finalization reports two finalization shares of a voter for different blocks on the same height and rank
+func (c *Collector) Finalization(first *blockchain.FinalizationShare, second *blockchain.FinalizationShare) {
+	c.gossip(c.conflict(&conflict{
+		kind:     DoubleFinalization,
+		offender: second.Voter,
+		height:   second.Height,
+		ids:      [2]crypto.Identifier{first.BlockID, second.BlockID},
+		sigs:     [2]crypto.Signature{first.Signature, second.Signature},
+	}))
+}
+
+// Vote reports two votes of a voter for different blocks in the same view
+func (c *Collector) Vote(first *view.Vote, second *view.Vote) {
+	c.gossip(c.conflict(&conflict{
+		kind:     DoubleVote,
+		offender: second.Voter,
+		height:   int(second.View),
+		ids:      [2]crypto.Identifier{first.BlockID, second.BlockID},
+		sigs:     [2]crypto.Signature{first.Signature, second.Signature},
+	}))
+}
+
+// Receive keeps a proof gossiped by a peer and passes it on if it is new to me
+func (c *Collector) Receive(proof *EquivocationProof) error {
+	err := proof.Verify()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	added := c.add(proof)
+	c.mu.Unlock()
+	if added {
+		c.Broadcast(proof)
+	}
+	return nil
+}
+
+// Proofs returns the proofs in the order they were collected
+func (c *Collector) Proofs() []*EquivocationProof {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	proofs := make([]*EquivocationProof, 0, len(c.order))
+	for _, pos := range c.order {
+		proofs = append(proofs, c.proofs[pos])
+	}
+	return proofs
+}
+
+// Evidence describes the proofs in the order they were collected
+func (c *Collector) Evidence() []message.Evidence {
+	evidence := make([]message.Evidence, 0)
+	for _, proof := range c.Proofs() {
+		encoded, err := proof.Encode()
+		if err != nil {
+			log.Errorf("[%v] cannot encode the proof against %v: %v", c.ID(), proof.Offender, err)
+			continue
+		}
+		height, rank := proof.Position()
+		var blocks []string
+		for _, id := range proof.Blocks() {
+			blocks = append(blocks, fmt.Sprintf("%x", id))
+		}
+		evidence = append(evidence, message.Evidence{
+			Kind:     string(proof.Kind),
+			Offender: proof.Offender,
+			Height:   height,
+			Rank:     rank,
+			Blocks:   blocks,
+			Reporter: proof.Reporter,
+			Proof:    encoded,
+		})
+	}
+	return evidence
+}
+
+func (c *Collector) proposal(id crypto.Identifier, proposer identity.NodeID, signed Signed, height int) []*EquivocationProof {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var proofs []*EquivocationProof
+	if height > c.top {
+		c.top = height
+		c.prune()
+	}
+	if height < c.top-depth {
+		return nil
+	}
+	c.headers[id] = Signed{Block: signed.Block, ViewBlock: signed.ViewBlock}
+	h, rank := signed.position()
+	pos := position{Signer: proposer, Height: h, Rank: rank}
+	first, exists := c.proposals[pos]
+	if !exists {
+		c.proposals[pos] = signed
+	} else if first.id() != id {
+		proofs = append(proofs, c.report(DoubleProposal, proposer, first, signed)...)
+	}
+	waiting := c.pending[id]
+	delete(c.pending, id)
+	for _, conflict := range waiting {
+		proofs = append(proofs, c.complete(conflict)...)
+	}
+	return proofs
+}
+
+func (c *Collector) conflict(conflict *conflict) []*EquivocationProof {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conflict.height < c.top-depth {
+		return nil
+	}
+	return c.complete(conflict)
+}
+
+// complete builds the proof of the conflict if I have the headers of both blocks,
+// otherwise the conflict waits for the header that is missing
+func (c *Collector) complete(conflict *conflict) []*EquivocationProof {
+	var signed [2]Signed
+	for i, id := range conflict.ids {
+		header, exists := c.headers[id]
+		if !exists {
+			c.pending[id] = append(c.pending[id], conflict)
+			return nil
+		}
+		signed[i] = Signed{Block: header.Block, ViewBlock: header.ViewBlock, Signature: conflict.sigs[i]}
+	}
+	return c.report(conflict.kind, conflict.offender, signed[0], signed[1])
+}
+
+// report builds and keeps the proof if I have none against the offender on the position
+func (c *Collector) report(kind Kind, offender identity.NodeID, first Signed, second Signed) []*EquivocationProof {
+	proof := &EquivocationProof{
+		Kind:     kind,
+		Offender: offender,
+		First:    first,
+		Second:   second,
+		Reporter: c.ID(),
+	}
+	if err := proof.check(); err != nil {
+		log.Warningf("[%v] cannot prove the %v of %v: %v", c.ID(), kind, offender, err)
+		return nil
+	}
+	if _, exists := c.proofs[c.positionOf(proof)]; exists {
+		return nil
+	}
+	sig, err := c.sign(proof.ID())
+	if err != nil {
+		log.Errorf("[%v] cannot sign the proof against %v: %v", c.ID(), offender, err)
+		return nil
+	}
+	proof.Sig = sig
+	if !c.add(proof) {
+		return nil
+	}
+	return []*EquivocationProof{proof}
+}
+
+// add keeps the proof, it returns false if I already have one against the offender on the position
+func (c *Collector) add(proof *EquivocationProof) bool {
+	pos := c.positionOf(proof)
+	if _, exists := c.proofs[pos]; exists {
+		return false
+	}
+	c.proofs[pos] = proof
+	c.order = append(c.order, pos)
+	c.found.With(string(proof.Kind)).Inc()
+	log.Warningf("[%v] %v committed a %v on height %v, rank %v, reported by %v", c.ID(), proof.Offender, proof.Kind, pos.Height, pos.Rank, proof.Reporter)
+	return true
+}
+
+func (c *Collector) positionOf(proof *EquivocationProof) position {
+	height, rank := proof.Position()
+	return position{Signer: proof.Offender, Height: height, Rank: rank}
+}
+
+func (c *Collector) gossip(proofs []*EquivocationProof) {
+	for _, proof := range proofs {
+		c.Broadcast(proof)
+	}
+}
+
+// prune forgets the blocks and the conflicts far below the highest height, the proofs are kept
+func (c *Collector) prune() {
+	for id, header := range c.headers {
+		if height, _ := header.position(); height < c.top-depth {
+			delete(c.headers, id)
+		}
+	}
+	for pos := range c.proposals {
+		if pos.Height < c.top-depth {
+			delete(c.proposals, pos)
+		}
+	}
+	for id, conflicts := range c.pending {
+		if conflicts[0].height < c.top-depth {
+			delete(c.pending, id)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Package evidence detects the replicas that sign two different blocks on the same height and rank,
+// or in the same view, and keeps proofs of it that anyone can check with the public keys of the replicas.
+package evidence
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"banyan/blockchain"
+	view "banyan/blockchain_view"
+	"banyan/crypto"
+	"banyan/identity"
+)
+
+// Kind tells which messages of the offender conflict
+type Kind string
+
+const (
+	DoubleProposal     Kind = "double_proposal"     // two proposals on a height and rank (in a view)
+	DoubleNotarization Kind = "double_notarization" // notarization shares for two blocks on a height and rank
+	DoubleFinalization Kind = "double_finalization" // finalization shares for two blocks on a height and rank
+	DoubleVote         Kind = "double_vote"         // votes for two blocks in a view
+)
+
+// Signed is a signature over the id of a block with the header of the block,
+// which binds the signature to a height and rank (Banyan, ICC) or to a view (HotStuff, Streamlet)
+type Signed struct {
+	Block     *blockchain.Header
+	ViewBlock *view.Header
+	Signature crypto.Signature
+}
+
+func signedBlock(header *blockchain.Header, sig crypto.Signature) Signed {
+	return Signed{Block: header, Signature: sig}
+}
+
+func signedViewBlock(header *view.Header, sig crypto.Signature) Signed {
+	return Signed{ViewBlock: header, Signature: sig}
+}
+
+func (s Signed) id() crypto.Identifier {
+	if s.Block != nil {
+		return s.Block.ID()
+	}
+	return s.ViewBlock.ID()
+}
+
+// position returns the height and rank of the block, the view and 0 in HotStuff and Streamlet
+func (s Signed) position() (int, int) {
+	if s.Block != nil {
+		return s.Block.Height, s.Block.Rank
+	}
+	return int(s.ViewBlock.View), 0
+}
+
+// EquivocationProof shows that the offender signed two different blocks on the same height and rank, or in the same view.
+// An honest replica never does, neither as a leader nor as a voter, and since proposals and shares (votes) are all
+// signatures over the id of the block, the proof holds whichever of them the offender sent.
+type EquivocationProof struct {
+	Kind     Kind
+	Offender identity.NodeID
+	First    Signed
+	Second   Signed
+	Reporter identity.NodeID  // the replica that detected the equivocation
+	Sig      crypto.Signature // signature of the reporter over the id of the proof
+}
+
+// ID returns the id of the proof, which the reporter signs
+func (p *EquivocationProof) ID() crypto.Identifier {
+	unsigned := *p
+	unsigned.Sig = nil
+	return crypto.MakeID(&unsigned)
+}
+
+// Position returns the height and rank the offender equivocated on, the view and 0 in HotStuff and Streamlet
+func (p *EquivocationProof) Position() (int, int) {
+	return p.First.position()
+}
+
+// Blocks returns the ids of the conflicting blocks
+func (p *EquivocationProof) Blocks() [2]crypto.Identifier {
+	return [2]crypto.Identifier{p.First.id(), p.Second.id()}
+}
+
+// Verify checks that the offender signed the two blocks and that they are different blocks of the same height and rank (view)
+func (p *EquivocationProof) Verify() error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	for _, signed := range []Signed{p.First, p.Second} {
+		data := signed.id()
+		if p.Kind == DoubleFinalization {
+			data = blockchain.FinalizeID(data)
+		}
+		ok, err := crypto.PubVerify(signed.Signature, crypto.IDToByte(data), p.Offender)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("invalid signature of %v over block %x", p.Offender, signed.id())
+		}
+	}
+	ok, err := crypto.PubVerify(p.Sig, crypto.IDToByte(p.ID()), p.Reporter)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("invalid signature of the reporter %v", p.Reporter)
+	}
+	return nil
+}
+
+// check checks everything but the signatures
+func (p *EquivocationProof) check() error {
+	for _, signed := range []Signed{p.First, p.Second} {
+		if (signed.Block == nil) == (signed.ViewBlock == nil) {
+			return errors.New("a signed block needs exactly one header")
+		}
+	}
+	if (p.First.Block == nil) != (p.Second.Block == nil) {
+		return errors.New("the headers are of different protocols")
+	}
+	if p.First.id() == p.Second.id() {
+		return fmt.Errorf("both signatures are over block %x", p.First.id())
+	}
+	height, rank := p.First.position()
+	otherHeight, otherRank := p.Second.position()
+	if height != otherHeight || rank != otherRank {
+		return fmt.Errorf("the blocks are on different positions, (%v, %v) and (%v, %v)", height, rank, otherHeight, otherRank)
+	}
+	if p.Kind == DoubleProposal {
+		for _, signed := range []Signed{p.First, p.Second} {
+			if (signed.Block != nil && signed.Block.Proposer != p.Offender) || (signed.ViewBlock != nil && signed.ViewBlock.Proposer != p.Offender) {
+				return fmt.Errorf("block %x is not proposed by %v", signed.id(), p.Offender)
+			}
+		}
+	}
+	return nil
+}
+
+// Encode returns the gob encoding of the proof
+func (p *EquivocationProof) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(p)
+	return buf.Bytes(), err
+}
+
+// Decode decodes a proof encoded with Encode
+func Decode(data []byte) (*EquivocationProof, error) {
+	p := new(EquivocationProof)
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(p)
+	return p, err
+}
@@ -0,0 +1,224 @@
+package evidence
+
+import (
+	"testing"
+
+	"banyan/blockchain"
+	view "banyan/blockchain_view"
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/metrics"
+	"banyan/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testNode records what the collector gossips
+type testNode struct {
+	id       identity.NodeID
+	sent     []interface{}
+	registry *metrics.Registry
+}
+
+func (n *testNode) ID() identity.NodeID {
+	return n.id
+}
+
+func (n *testNode) Broadcast(m interface{}) {
+	n.sent = append(n.sent, m)
+}
+
+func (n *testNode) Metrics() *metrics.Registry {
+	return n.registry
+}
+
+func newTestCollector() (*Collector, *testNode) {
+	n := &testNode{id: "1", registry: metrics.NewRegistry()}
+	c := NewCollector(n)
+	c.sign = func(crypto.Identifier) (crypto.Signature, error) {
+		return crypto.Signature{1}, nil
+	}
+	return c, n
+}
+
+func block(height int, rank int, proposer identity.NodeID, parent string) *blockchain.Block {
+	b := &blockchain.Block{Height: height, Rank: rank, Proposer: proposer, PrevID: crypto.MakeID(parent), Sig: crypto.Signature{2}}
+	b.ID = b.Header().ID()
+	return b
+}
+
+func viewBlock(v int, proposer identity.NodeID, parent string) *view.Block {
+	b := &view.Block{View: types.View(v), Proposer: proposer, PrevID: crypto.MakeID(parent), Sig: crypto.Signature{2}}
+	b.ID = b.Header().ID()
+	return b
+}
+
+func proof(kind Kind, offender identity.NodeID, first *blockchain.Block, second *blockchain.Block) *EquivocationProof {
+	return &EquivocationProof{
+		Kind:     kind,
+		Offender: offender,
+		First:    signedBlock(first.Header(), first.Sig),
+		Second:   signedBlock(second.Header(), second.Sig),
+		Reporter: "1",
+	}
+}
+
+func TestCheck(t *testing.T) {
+	a, b := block(1, 0, "2", "x"), block(1, 0, "2", "y")
+	require.NoError(t, proof(DoubleProposal, "2", a, b).check())
+	require.NoError(t, proof(DoubleNotarization, "3", a, b).check())
+
+	require.Error(t, proof(DoubleProposal, "2", a, a).check(), "the same block twice")
+	require.Error(t, proof(DoubleProposal, "3", a, b).check(), "not the proposer")
+	require.Error(t, proof(DoubleNotarization, "3", a, block(1, 1, "3", "y")).check(), "another rank")
+	require.Error(t, proof(DoubleNotarization, "3", a, block(2, 0, "2", "y")).check(), "another height")
+
+	mixed := proof(DoubleVote, "3", a, b)
+	mixed.Second = signedViewBlock(viewBlock(1, "2", "y").Header(), nil)
+	require.Error(t, mixed.check(), "headers of different protocols")
+	mixed.Second = Signed{}
+	require.Error(t, mixed.check(), "no header")
+
+	x, y := viewBlock(3, "4", "x"), viewBlock(3, "4", "y")
+	votes := &EquivocationProof{
+		Kind:     DoubleVote,
+		Offender: "2",
+		First:    signedViewBlock(x.Header(), nil),
+		Second:   signedViewBlock(y.Header(), nil),
+	}
+	require.NoError(t, votes.check())
+	height, rank := votes.Position()
+	require.Equal(t, 3, height)
+	require.Equal(t, 0, rank)
+	require.Equal(t, [2]crypto.Identifier{x.ID, y.ID}, votes.Blocks())
+}
+
+func TestDoubleProposal(t *testing.T) {
+	c, n := newTestCollector()
+	c.Block(block(1, 0, "2", "x"))
+	c.Block(block(1, 1, "3", "x"))
+	c.Block(block(2, 0, "3", "y"))
+	require.Empty(t, n.sent)
+
+	c.Block(block(1, 0, "2", "y"))
+	require.Len(t, n.sent, 1)
+	p := n.sent[0].(*EquivocationProof)
+	require.Equal(t, DoubleProposal, p.Kind)
+	require.Equal(t, identity.NodeID("2"), p.Offender)
+	require.Equal(t, identity.NodeID("1"), p.Reporter)
+	require.NotEmpty(t, p.Sig)
+
+	// a single proof for each offender and position
+	c.Block(block(1, 0, "2", "z"))
+	require.Len(t, n.sent, 1)
+	require.Equal(t, []*EquivocationProof{p}, c.Proofs())
+	require.Equal(t, float64(1), c.found.With(string(DoubleProposal)).Value())
+}
+
+func TestConflictWaitsForBlocks(t *testing.T) {
+	c, n := newTestCollector()
+	a, b := block(4, 0, "2", "x"), block(4, 0, "2", "y")
+	first := &blockchain.NotarizationShare{Height: 4, Rank: -1, Voter: "3", BlockID: a.ID, Signature: crypto.Signature{3}}
+	second := &blockchain.NotarizationShare{Height: 4, Rank: -1, Voter: "3", BlockID: b.ID, Signature: crypto.Signature{4}}
+	c.Notarization(first, second)
+	require.Empty(t, n.sent)
+
+	c.Block(a)
+	require.Empty(t, n.sent)
+	c.Block(b)
+	// the double proposal of the leader and the double notarization of the voter
+	require.Len(t, n.sent, 2)
+	p := n.sent[1].(*EquivocationProof)
+	require.Equal(t, DoubleNotarization, p.Kind)
+	require.Equal(t, identity.NodeID("3"), p.Offender)
+	require.Equal(t, first.Signature, p.First.Signature)
+	require.Equal(t, second.Signature, p.Second.Signature)
+	height, rank := p.Position()
+	require.Equal(t, 4, height)
+	require.Equal(t, 0, rank)
+}
+
+func TestVote(t *testing.T) {
+	c, n := newTestCollector()
+	x, y := viewBlock(5, "1", "x"), viewBlock(5, "1", "y")
+	c.ViewBlock(x)
+	c.ViewBlock(y)
+	require.Len(t, n.sent, 1)
+	c.Vote(&view.Vote{View: 5, Voter: "3", BlockID: x.ID}, &view.Vote{View: 5, Voter: "3", BlockID: y.ID})
+	require.Len(t, n.sent, 2)
+	require.Equal(t, DoubleVote, n.sent[1].(*EquivocationProof).Kind)
+}
+
+func TestReceive(t *testing.T) {
+	c, n := newTestCollector()
+	invalid := proof(DoubleProposal, "2", block(1, 0, "2", "x"), block(1, 0, "2", "x"))
+	require.Error(t, c.Receive(invalid))
+	require.Empty(t, c.Proofs())
+	require.Empty(t, n.sent)
+}
+
+func TestEvidence(t *testing.T) {
+	c, _ := newTestCollector()
+	require.NotNil(t, c.Evidence())
+	a, b := block(2, 1, "3", "x"), block(2, 1, "3", "y")
+	c.Block(a)
+	c.Block(b)
+	evidence := c.Evidence()
+	require.Len(t, evidence, 1)
+	require.Equal(t, string(DoubleProposal), evidence[0].Kind)
+	require.Equal(t, identity.NodeID("3"), evidence[0].Offender)
+	require.Equal(t, 2, evidence[0].Height)
+	require.Equal(t, 1, evidence[0].Rank)
+	require.Len(t, evidence[0].Blocks, 2)
+
+	decoded, err := Decode(evidence[0].Proof)
+	require.NoError(t, err)
+	require.Equal(t, c.Proofs()[0].ID(), decoded.ID())
+	require.Equal(t, [2]crypto.Identifier{a.ID, b.ID}, decoded.Blocks())
+}
+
+func TestPrune(t *testing.T) {
+	c, n := newTestCollector()
+	a, b := block(1, 0, "2", "x"), block(1, 0, "2", "y")
+	c.Block(a)
+	c.Notarization(
+		&blockchain.NotarizationShare{Height: 1, Voter: "3", BlockID: a.ID},
+		&blockchain.NotarizationShare{Height: 1, Voter: "3", BlockID: b.ID},
+	)
+	c.Block(block(depth+2, 0, "2", "z"))
+	require.Empty(t, c.headers[a.ID].Block)
+	require.Empty(t, c.pending)
+
+	// too old to be kept
+	c.Block(b)
+	require.Empty(t, n.sent)
+}
+
+// the finalization shares in a proof are checked over the finalization digest, not over the id of the block
+func TestVerifyDoubleFinalization(t *testing.T) {
+	require.NoError(t, crypto.GenerateKeys(crypto.ECDSA_P256, []identity.NodeID{"1", "2", "3"}))
+	defer crypto.UseKeys(nil)
+	a, b := block(4, 0, "2", "x"), block(4, 0, "2", "y")
+	first := blockchain.MakeFShare(4, 0, "3", a.ID)
+	second := blockchain.MakeFShare(4, 0, "3", b.ID)
+	p := &EquivocationProof{
+		Kind:     DoubleFinalization,
+		Offender: "3",
+		First:    signedBlock(a.Header(), first.Signature),
+		Second:   signedBlock(b.Header(), second.Signature),
+		Reporter: "1",
+	}
+	sign := func() {
+		sig, err := crypto.PrivSign(crypto.IDToByte(p.ID()), "1", nil)
+		require.NoError(t, err)
+		p.Sig = sig
+	}
+	sign()
+	require.NoError(t, p.Verify())
+
+	// notarization shares do not prove a double finalization
+	p.First.Signature = blockchain.MakeNShare(4, 0, "3", a.ID).Signature
+	p.Second.Signature = blockchain.MakeNShare(4, 0, "3", b.ID).Signature
+	sign()
+	require.Error(t, p.Verify())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"banyan/log"
+	"banyan/twins"
+)
+
+var algorithm = flag.String("algorithm", "banyan,icc,hotstuff,streamlet", "comma separated BFT consensus algorithms to explore")
+var n = flag.Int("n", 4, "number of replicas")
+var f = flag.Int("f", -1, "number of faulty replicas tolerated, (n-1)/3 if negative")
+var p = flag.Int("p", 1, "parameter p of Banyan")
+var twinNo = flag.Int("twins", -1, "number of replicas with a twin, f if negative")
+var rounds = flag.Int("rounds", 8, "number of rounds whose leader and partition are drawn")
+var round = flag.Duration("round", 300*time.Millisecond, "virtual time a round lasts")
+var timeout = flag.Duration("timeout", 300*time.Millisecond, "timeout of a rank (view)")
+var duration = flag.Duration("duration", 4*time.Second, "virtual time each scenario runs for")
+var latency = flag.String("latency", "uniform:5ms,30ms", "latency model, as in simulate")
+var scenarios = flag.Int("scenarios", 100, "number of scenarios to run for each algorithm")
+var seed = flag.Int64("seed", 1, "seed of the first scenario, the next ones take the next seeds")
+var save = flag.String("save", "", "directory receiving the failing scenarios, minimized")
+var replay = flag.Bool("replay", false, "replay the scenarios saved in the files given as arguments instead")
+
+func main() {
+	_ = flag.Set("log_level", "error")
+	flag.Parse()
+	log.Setup()
+
+	if *replay {
+		os.Exit(replayAll(flag.Args()))
+	}
+	if *f < 0 {
+		*f = (*n - 1) / 3
+	}
+	if *twinNo < 0 {
+		*twinNo = *f
+	}
+	failed := 0
+	for _, alg := range strings.Split(*algorithm, ",") {
+		o := twins.Options{
+			Algorithm: strings.TrimSpace(alg),
+			N:         *n,
+			F:         *f,
+			P:         *p,
+			Twins:     *twinNo,
+			Rounds:    *rounds,
+			Round:     *round,
+			Timeout:   *timeout,
+			Duration:  *duration,
+			Latency:   *latency,
+		}
+		start := time.Now()
+		failing, err := twins.Explore(o, *seed, *scenarios, *save)
+		for _, s := range failing {
+			fmt.Printf("%v\n  %v\n", s, strings.Join(s.Violations, "\n  "))
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%v: %v of %v scenarios failed (%v)\n", o.Algorithm, len(failing), *scenarios, time.Since(start).Round(time.Millisecond))
+		failed += len(failing)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// replayAll runs the saved scenarios and tells whether they fail as they did when they were saved
+func replayAll(names []string) int {
+	code := 0
+	for _, name := range names {
+		s, err := twins.Load(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		result, err := twins.Run(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		failures := result.Describe()
+		reproduced := strings.Join(failures, "\n") == strings.Join(s.Violations, "\n")
+		fmt.Printf("%v: %v failures, reproduced: %v\n", name, len(failures), reproduced)
+		for _, failure := range failures {
+			fmt.Printf("  %v\n", failure)
+		}
+		if !reproduced {
+			code = 1
+		}
+	}
+	return code
+}
@@ -4,7 +4,7 @@ go 1.14
 
 require (
 	github.com/ailidani/paxi v0.0.0-20200918165309-7127c003b391
-	github.com/ethereum/go-ethereum v1.9.16 // indirect
+	github.com/ethereum/go-ethereum v1.9.16
 	github.com/kjzz/viper v1.3.7 // indirect
 	github.com/prometheus/common v0.10.0
 	github.com/spaolacci/murmur3 v1.1.0 // indirect
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"banyan"
+	"banyan/config"
+	"banyan/crypto"
+	"banyan/log"
+)
+
+var dir = flag.String("dir", "", "directory receiving the keys, key_dir of the configuration if empty")
+var scheme = flag.String("scheme", "", "signature scheme of the keys, the one of the configuration if empty")
+var force = flag.Bool("force", false, "overwrite the keys already in the directory")
+
+// keygen writes a private key file per node of the configuration, <id>.key, to be copied to that node alone, and the
+// registry of the public keys that every node gets
+func main() {
+	banyan.Init()
+	if *dir == "" {
+		*dir = config.GetConfig().KeyDir
+	}
+	if *scheme == "" {
+		*scheme = config.GetConfig().GetSignatureScheme()
+	}
+	ids := config.GetConfig().IDs()
+	if len(ids) == 0 {
+		log.Fatal("the configuration has no nodes")
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Node() < ids[j].Node() })
+	if err := crypto.WriteKeys(*dir, *scheme, ids, *force); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%v keys of %v nodes written to %v\n", *scheme, len(ids), *dir)
+}
@@ -29,6 +29,12 @@ func (lt *LocalTimeout) HeightIncreased(block_production_height int) {
 	lt.newHeightChan <- block_production_height // reset timer for the next view
 }
 
+func (lt *LocalTimeout) GetCurHeight() int {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	return lt.curHeight
+}
+
 func (lt *LocalTimeout) GetNewHeight() chan int {
 	return lt.newHeightChan
 }
@@ -36,3 +42,10 @@ func (lt *LocalTimeout) GetNewHeight() chan int {
 func (lt *LocalTimeout) GetTimeoutDuration() time.Duration {
 	return time.Duration(config.GetConfig().Timeout) * time.Millisecond
 }
+
+// Restore sets the height a recovered replica resumes from without signaling a new height
+func (lt *LocalTimeout) Restore(height int) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	lt.curHeight = height
+}
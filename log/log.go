@@ -125,6 +125,11 @@ func Setup() {
 	log.err = stdlog.New(multi, "[ERROR] ", format)
 }
 
+// sprintf formats like fmt.Errorf, the messages print the errors they wrap with %w
+func sprintf(format string, v ...interface{}) string {
+	return fmt.Errorf(format, v...).Error()
+}
+
 func Debug(v ...interface{}) {
 	if log.severity == DEBUG {
 		log.debug.Output(2, fmt.Sprint(v...))
@@ -133,7 +138,7 @@ func Debug(v ...interface{}) {
 
 func Debugf(format string, v ...interface{}) {
 	if log.severity == DEBUG {
-		log.debug.Output(2, fmt.Sprintf(format, v...))
+		log.debug.Output(2, sprintf(format, v...))
 	}
 }
 
@@ -145,7 +150,7 @@ func Info(v ...interface{}) {
 
 func Infof(format string, v ...interface{}) {
 	if log.severity <= INFO {
-		log.info.Output(2, fmt.Sprintf(format, v...))
+		log.info.Output(2, sprintf(format, v...))
 	}
 }
 
@@ -157,7 +162,7 @@ func Warning(v ...interface{}) {
 
 func Warningf(format string, v ...interface{}) {
 	if log.severity <= WARNING {
-		log.warning.Output(2, fmt.Sprintf(format, v...))
+		log.warning.Output(2, sprintf(format, v...))
 	}
 }
 
@@ -166,7 +171,7 @@ func Error(v ...interface{}) {
 }
 
 func Errorf(format string, v ...interface{}) {
-	log.err.Output(2, fmt.Sprintf(format, v...))
+	log.err.Output(2, sprintf(format, v...))
 }
 
 func Fatal(v ...interface{}) {
@@ -175,6 +180,7 @@ func Fatal(v ...interface{}) {
 }
 
 func Fatalf(format string, v ...interface{}) {
-	log.err.Output(2, fmt.Sprintf(format, v...))
-	stdlog.Fatalf(format, v...)
+	message := sprintf(format, v...)
+	log.err.Output(2, message)
+	stdlog.Fatal(message)
 }
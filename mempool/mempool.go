@@ -0,0 +1,243 @@
+package mempool
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+
+	"banyan/crypto"
+	"banyan/message"
+)
+
+var (
+	// ErrDuplicate is returned when the transaction is already known to the pool
+	ErrDuplicate = errors.New("duplicate transaction")
+	// ErrFull is returned when accepting the transaction would exceed the pool bounds
+	ErrFull = errors.New("mempool is full")
+)
+
+// MemPool keeps client transactions until they are committed.
+// Transactions move from pending (waiting to be proposed) to proposed (pulled into a block
+// by this replica) and are dropped once a block containing them is committed.
+type MemPool struct {
+	txns           *list.List // pending transactions in arrival order
+	pending        map[crypto.Identifier]*list.Element
+	proposed       map[crypto.Identifier]*message.Transaction
+	forwarded      map[crypto.Identifier]int // handed to the leader by this replica, to their entry in forwardedOrder
+	forwardedOrder []crypto.Identifier       // ring buffer bounding the forwarded set
+	forwardedNext  int
+	committed      map[crypto.Identifier]location
+	committedOrder []crypto.Identifier // ring buffer bounding the committed set
+	committedNext  int
+	maxCount       int
+	maxBytes       int
+	bytes          int
+	mu             sync.Mutex
+}
+
+// location is the block a committed transaction landed in
+type location struct {
+	height  int
+	blockID crypto.Identifier
+}
+
+// NewMemPool creates a pool holding at most maxCount pending transactions and maxBytes pending bytes
+func NewMemPool(maxCount int, maxBytes int) *MemPool {
+	return &MemPool{
+		txns:           list.New(),
+		pending:        make(map[crypto.Identifier]*list.Element),
+		proposed:       make(map[crypto.Identifier]*message.Transaction),
+		forwarded:      make(map[crypto.Identifier]int),
+		forwardedOrder: make([]crypto.Identifier, 0, maxCount),
+		committed:      make(map[crypto.Identifier]location),
+		committedOrder: make([]crypto.Identifier, 0, maxCount),
+		maxCount:       maxCount,
+		maxBytes:       maxBytes,
+	}
+}
+
+// Add appends a new transaction to the pending queue.
+// A transaction this replica forwarded is queued again, the leader may have dropped it.
+func (mp *MemPool) Add(tx *message.Transaction) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	delete(mp.forwarded, tx.ID)
+	if mp.known(tx.ID) {
+		return ErrDuplicate
+	}
+	if mp.txns.Len() >= mp.maxCount || mp.bytes+tx.Size() > mp.maxBytes {
+		return ErrFull
+	}
+	mp.push(tx)
+	return nil
+}
+
+// AddAll queues the transactions that are not known yet, or none of them if they do not all fit
+func (mp *MemPool) AddAll(txns []*message.Transaction) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	count, bytes := mp.txns.Len(), mp.bytes
+	fresh := make([]*message.Transaction, 0, len(txns))
+	seen := make(map[crypto.Identifier]bool, len(txns))
+	for _, tx := range txns {
+		delete(mp.forwarded, tx.ID)
+		if mp.known(tx.ID) || seen[tx.ID] {
+			continue
+		}
+		seen[tx.ID] = true
+		count++
+		bytes += tx.Size()
+		fresh = append(fresh, tx)
+	}
+	if count > mp.maxCount || bytes > mp.maxBytes {
+		return ErrFull
+	}
+	for _, tx := range fresh {
+		mp.push(tx)
+	}
+	return nil
+}
+
+func (mp *MemPool) push(tx *message.Transaction) {
+	mp.pending[tx.ID] = mp.txns.PushBack(tx)
+	mp.bytes += tx.Size()
+}
+
+// Batch pulls pending transactions in arrival order until maxBytes is reached.
+// A non-positive maxBytes only bounds the batch by what is pending.
+// The first transaction is always taken so that an oversized one cannot block the queue.
+func (mp *MemPool) Batch(maxBytes int) []*message.Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	batch := make([]*message.Transaction, 0)
+	size := 0
+	for e := mp.txns.Front(); e != nil; {
+		tx := e.Value.(*message.Transaction)
+		if maxBytes > 0 && len(batch) > 0 && size+tx.Size() > maxBytes {
+			break
+		}
+		next := e.Next()
+		mp.txns.Remove(e)
+		delete(mp.pending, tx.ID)
+		mp.bytes -= tx.Size()
+		mp.proposed[tx.ID] = tx
+		batch = append(batch, tx)
+		size += tx.Size()
+		e = next
+	}
+	return batch
+}
+
+// Forward records transactions that were sent to the leader instead of being queued locally.
+// Only the last maxCount of them are remembered, the leader may drop some and they would never commit.
+func (mp *MemPool) Forward(txns []*message.Transaction) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, tx := range txns {
+		if !mp.known(tx.ID) {
+			mp.markForwarded(tx.ID)
+		}
+	}
+}
+
+// Remove drops the transactions of a committed block from the pool
+// and remembers where they landed to reject resubmissions and answer status queries
+func (mp *MemPool) Remove(txns []*message.Transaction, height int, blockID crypto.Identifier) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, tx := range txns {
+		if e, ok := mp.pending[tx.ID]; ok {
+			mp.txns.Remove(e)
+			delete(mp.pending, tx.ID)
+			mp.bytes -= tx.Size()
+		}
+		delete(mp.proposed, tx.ID)
+		delete(mp.forwarded, tx.ID)
+		mp.markCommitted(tx.ID, location{height: height, blockID: blockID})
+	}
+}
+
+// Return puts the transactions of a forked block back at the front of the queue.
+// Only transactions this pool handed out are returned, others are owned by their proposer.
+func (mp *MemPool) Return(txns []*message.Transaction) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for i := len(txns) - 1; i >= 0; i-- {
+		tx, ok := mp.proposed[txns[i].ID]
+		if !ok {
+			continue
+		}
+		delete(mp.proposed, tx.ID)
+		mp.pending[tx.ID] = mp.txns.PushFront(tx)
+		mp.bytes += tx.Size()
+	}
+}
+
+// Status returns whether the transaction is pending or the block it was committed in
+func (mp *MemPool) Status(id crypto.Identifier) message.TransactionStatus {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if loc, ok := mp.committed[id]; ok {
+		return message.TransactionStatus{Status: message.TxCommitted, Height: loc.height, BlockID: loc.blockID}
+	}
+	if mp.known(id) {
+		return message.TransactionStatus{Status: message.TxPending}
+	}
+	return message.TransactionStatus{Status: message.TxUnknown}
+}
+
+// Size returns the number of pending transactions
+func (mp *MemPool) Size() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.txns.Len()
+}
+
+func (mp *MemPool) known(id crypto.Identifier) bool {
+	if _, ok := mp.pending[id]; ok {
+		return true
+	}
+	if _, ok := mp.proposed[id]; ok {
+		return true
+	}
+	if _, ok := mp.forwarded[id]; ok {
+		return true
+	}
+	_, ok := mp.committed[id]
+	return ok
+}
+
+func (mp *MemPool) markForwarded(id crypto.Identifier) {
+	if mp.maxCount <= 0 {
+		return
+	}
+	if _, ok := mp.forwarded[id]; ok {
+		return
+	}
+	if len(mp.forwardedOrder) < mp.maxCount {
+		mp.forwarded[id] = len(mp.forwardedOrder)
+		mp.forwardedOrder = append(mp.forwardedOrder, id)
+		return
+	}
+	// the oldest entry may have been committed or resubmitted already, or forwarded again since
+	if oldest := mp.forwardedOrder[mp.forwardedNext]; mp.forwarded[oldest] == mp.forwardedNext {
+		delete(mp.forwarded, oldest)
+	}
+	mp.forwarded[id] = mp.forwardedNext
+	mp.forwardedOrder[mp.forwardedNext] = id
+	mp.forwardedNext = (mp.forwardedNext + 1) % mp.maxCount
+}
+
+func (mp *MemPool) markCommitted(id crypto.Identifier, loc location) {
+	if _, ok := mp.committed[id]; ok || mp.maxCount <= 0 {
+		return
+	}
+	if len(mp.committedOrder) < mp.maxCount {
+		mp.committedOrder = append(mp.committedOrder, id)
+	} else {
+		delete(mp.committed, mp.committedOrder[mp.committedNext])
+		mp.committedOrder[mp.committedNext] = id
+		mp.committedNext = (mp.committedNext + 1) % mp.maxCount
+	}
+	mp.committed[id] = loc
+}
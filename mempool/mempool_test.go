@@ -0,0 +1,132 @@
+package mempool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"banyan/crypto"
+	"banyan/message"
+)
+
+func makeTx(cmd string, nonce uint64) *message.Transaction {
+	return message.NewTransaction([]byte(cmd), "client", nonce)
+}
+
+// duplicated transactions are rejected while pending, proposed and committed
+func TestAddDuplicate(t *testing.T) {
+	mp := NewMemPool(10, 1000)
+	tx := makeTx("PUT a 1", 1)
+	require.NoError(t, mp.Add(tx))
+	require.Equal(t, ErrDuplicate, mp.Add(tx))
+	batch := mp.Batch(0)
+	require.Len(t, batch, 1)
+	require.Equal(t, ErrDuplicate, mp.Add(tx))
+	mp.Remove(batch, 1, crypto.MakeID("block"))
+	require.Equal(t, ErrDuplicate, mp.Add(tx))
+}
+
+// the status follows a transaction from submission to commit
+func TestStatus(t *testing.T) {
+	mp := NewMemPool(10, 1000)
+	tx1, tx2 := makeTx("a", 1), makeTx("b", 2)
+	require.Equal(t, message.TxUnknown, mp.Status(tx1.ID).Status)
+	require.NoError(t, mp.Add(tx1))
+	mp.Forward([]*message.Transaction{tx2})
+	require.Equal(t, message.TxPending, mp.Status(tx1.ID).Status)
+	require.Equal(t, message.TxPending, mp.Status(tx2.ID).Status)
+	blockID := crypto.MakeID("block")
+	mp.Remove([]*message.Transaction{tx1, tx2}, 7, blockID)
+	status := mp.Status(tx2.ID)
+	require.Equal(t, message.TxCommitted, status.Status)
+	require.Equal(t, 7, status.Height)
+	require.Equal(t, blockID, status.BlockID)
+}
+
+// the pool is bounded by count and bytes
+func TestAddFull(t *testing.T) {
+	mp := NewMemPool(2, 10)
+	require.NoError(t, mp.Add(makeTx("12345", 1)))
+	require.Equal(t, ErrFull, mp.Add(makeTx("123456", 2)))
+	require.NoError(t, mp.Add(makeTx("12345", 3)))
+	require.Equal(t, ErrFull, mp.Add(makeTx("1", 4)))
+}
+
+// a batch is cut at the byte limit but always makes progress
+func TestBatch(t *testing.T) {
+	mp := NewMemPool(10, 1000)
+	require.NoError(t, mp.Add(makeTx("0123456789", 1)))
+	require.NoError(t, mp.Add(makeTx("0123456789", 2)))
+	require.NoError(t, mp.Add(makeTx("0123456789", 3)))
+	require.Len(t, mp.Batch(25), 2)
+	require.Equal(t, 1, mp.Size())
+	require.Len(t, mp.Batch(5), 1)
+	require.Equal(t, 0, mp.Size())
+	require.Len(t, mp.Batch(5), 0)
+}
+
+// transactions of a forked block go back to the front of the queue
+func TestReturn(t *testing.T) {
+	mp := NewMemPool(10, 1000)
+	tx1, tx2, tx3 := makeTx("a", 1), makeTx("b", 2), makeTx("c", 3)
+	require.NoError(t, mp.Add(tx1))
+	require.NoError(t, mp.Add(tx2))
+	batch := mp.Batch(1)
+	require.Equal(t, tx1.ID, batch[0].ID)
+	require.NoError(t, mp.Add(tx3))
+	// transactions this pool did not hand out are ignored
+	mp.Return(append(batch, makeTx("d", 4)))
+	require.Equal(t, 3, mp.Size())
+	batch = mp.Batch(0)
+	require.Equal(t, tx1.ID, batch[0].ID)
+	require.Equal(t, tx2.ID, batch[1].ID)
+	require.Equal(t, tx3.ID, batch[2].ID)
+}
+
+// a batch that does not fit is rejected as a whole, the known transactions of a batch are skipped
+func TestAddAll(t *testing.T) {
+	mp := NewMemPool(3, 1000)
+	tx1, tx2, tx3, tx4 := makeTx("a", 1), makeTx("b", 2), makeTx("c", 3), makeTx("d", 4)
+	require.NoError(t, mp.Add(tx1))
+	require.Equal(t, ErrFull, mp.AddAll([]*message.Transaction{tx2, tx3, tx4}))
+	require.Equal(t, 1, mp.Size())
+	require.Equal(t, message.TxUnknown, mp.Status(tx2.ID).Status)
+	require.NoError(t, mp.AddAll([]*message.Transaction{tx1, tx2, tx2, tx3}))
+	require.Equal(t, 3, mp.Size())
+}
+
+// the forwarded transactions are bounded, and queued again when the client resubmits them
+func TestForward(t *testing.T) {
+	mp := NewMemPool(2, 1000)
+	tx1, tx2, tx3 := makeTx("a", 1), makeTx("b", 2), makeTx("c", 3)
+	mp.Forward([]*message.Transaction{tx1, tx2})
+	require.Equal(t, message.TxPending, mp.Status(tx1.ID).Status)
+	mp.Forward([]*message.Transaction{tx3})
+	require.Equal(t, message.TxUnknown, mp.Status(tx1.ID).Status, "the oldest is forgotten")
+	require.Equal(t, message.TxPending, mp.Status(tx2.ID).Status)
+	require.Len(t, mp.forwarded, 2)
+
+	// the leader dropped tx2, the client submits it again to this replica, now the leader
+	require.NoError(t, mp.Add(tx2))
+	require.Equal(t, 1, mp.Size())
+	require.Equal(t, ErrDuplicate, mp.Add(tx2))
+}
+
+// a transaction forwarded again is forgotten after the ones forwarded before it, not with its first entry
+func TestForwardAgain(t *testing.T) {
+	mp := NewMemPool(2, 1000)
+	require.NoError(t, mp.Add(makeTx("x", 1)))
+	require.NoError(t, mp.Add(makeTx("y", 2)))
+	tx1, tx2, tx3, tx4 := makeTx("a", 1), makeTx("b", 2), makeTx("c", 3), makeTx("d", 4)
+	mp.Forward([]*message.Transaction{tx1, tx2, tx3})
+	mp.Forward([]*message.Transaction{tx3})
+	require.Len(t, mp.forwarded, 2, "forwarded once")
+
+	// the client submits tx3 again to this replica, which is full, and it is forwarded again
+	require.Equal(t, ErrFull, mp.Add(tx3))
+	mp.Forward([]*message.Transaction{tx3})
+	mp.Forward([]*message.Transaction{tx4})
+	require.Equal(t, message.TxPending, mp.Status(tx3.ID).Status)
+	require.Equal(t, message.TxPending, mp.Status(tx4.ID).Status)
+	require.Equal(t, message.TxUnknown, mp.Status(tx2.ID).Status)
+}
@@ -1,6 +1,9 @@
 package message
 
 import (
+	"time"
+
+	"banyan/crypto"
 	"banyan/identity"
 )
 
@@ -18,6 +21,165 @@ type QueryReply struct {
 	Info string
 }
 
+/**************************
+ *  Transaction Related   *
+ **************************/
+
+// Transaction is a client command that is ordered by the replication protocol
+type Transaction struct {
+	ID        crypto.Identifier
+	Command   []byte
+	ClientID  string
+	Nonce     uint64
+	Timestamp time.Time
+}
+
+type rawTransaction struct {
+	Command  []byte
+	ClientID string
+	Nonce    uint64
+}
+
+// NewTransaction creates a transaction whose id is the hash of its content
+func NewTransaction(command []byte, clientID string, nonce uint64) *Transaction {
+	tx := &Transaction{
+		Command:   command,
+		ClientID:  clientID,
+		Nonce:     nonce,
+		Timestamp: time.Now(),
+	}
+	tx.ID = crypto.MakeID(&rawTransaction{
+		Command:  command,
+		ClientID: clientID,
+		Nonce:    nonce,
+	})
+	return tx
+}
+
+// Size returns the number of payload bytes the transaction occupies in a block
+func (tx *Transaction) Size() int {
+	return len(tx.Command)
+}
+
+// Transaction status values reported to clients
+const (
+	TxUnknown   = "unknown"
+	TxPending   = "pending"
+	TxCommitted = "committed"
+)
+
+// TransactionRequest carries transactions submitted by a client through the http server
+type TransactionRequest struct {
+	Txns []*Transaction
+	C    chan TransactionReply
+}
+
+func (r *TransactionRequest) Reply(reply TransactionReply) {
+	r.C <- reply
+}
+
+// TransactionReply reports whether the submitted transactions were accepted
+type TransactionReply struct {
+	Err error
+}
+
+// ForwardedTransactions carries client transactions from the receiving node to the next leader
+type ForwardedTransactions struct {
+	From identity.NodeID
+	Txns []*Transaction
+}
+
+func (m ForwardedTransactions) Sender() identity.NodeID {
+	return m.From
+}
+
+// TransactionQuery asks for the status of a transaction
+type TransactionQuery struct {
+	ID crypto.Identifier
+	C  chan TransactionStatus
+}
+
+func (r *TransactionQuery) Reply(reply TransactionStatus) {
+	r.C <- reply
+}
+
+// TransactionStatus tells whether a transaction is pending or in which block it was committed
+type TransactionStatus struct {
+	Status  string
+	Height  int
+	BlockID crypto.Identifier
+}
+
+// StateQuery reads the replicated state, an empty command only asks for the state digest
+type StateQuery struct {
+	Command []byte
+	C       chan StateReply
+}
+
+func (r *StateQuery) Reply(reply StateReply) {
+	r.C <- reply
+}
+
+// StateReply carries the height and digest of the applied state and the value read by the command
+type StateReply struct {
+	Height int
+	Digest crypto.Identifier
+	Value  []byte
+	Err    error
+}
+
+// CommitStatsQuery asks how the committed blocks were finalized
+type CommitStatsQuery struct {
+	C chan CommitStats
+}
+
+func (r *CommitStatsQuery) Reply(reply CommitStats) {
+	r.C <- reply
+}
+
+// CommitStats counts the committed blocks per commit path (fast, slow, implicit),
+// FastRatio is the share of the fast path among the blocks that were finalized themselves
+type CommitStats struct {
+	Committed int                   `json:"committed"`
+	FastRatio float64               `json:"fast_ratio"`
+	Paths     map[string]*PathStats `json:"paths"`
+}
+
+// PathStats describes the blocks committed on one path, the latency is measured from the proposal
+type PathStats struct {
+	Count            int             `json:"count"`
+	MeanLatencyMs    float64         `json:"mean_latency_ms"`
+	LatencyHistogram []LatencyBucket `json:"latency_histogram"`
+}
+
+// LatencyBucket counts the blocks committed within UpperMs of the proposal but not within the previous bucket,
+// the last bucket has no upper bound
+type LatencyBucket struct {
+	UpperMs int64 `json:"le_ms,omitempty"`
+	Count   int   `json:"count"`
+}
+
+// EvidenceQuery asks for the equivocation proofs a replica has collected
+type EvidenceQuery struct {
+	C chan []Evidence
+}
+
+func (r *EvidenceQuery) Reply(reply []Evidence) {
+	r.C <- reply
+}
+
+// Evidence describes an equivocation proof, Proof is its gob encoding that can be checked
+// with the public keys of the replicas alone. Height is the view in HotStuff and Streamlet.
+type Evidence struct {
+	Kind     string          `json:"kind"`
+	Offender identity.NodeID `json:"offender"`
+	Height   int             `json:"height"`
+	Rank     int             `json:"rank"`
+	Blocks   []string        `json:"blocks"`
+	Reporter identity.NodeID `json:"reporter"`
+	Proof    []byte          `json:"proof"`
+}
+
 /**************************
  *     Config Related     *
  **************************/
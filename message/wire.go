@@ -0,0 +1,44 @@
+package message
+
+import (
+	"banyan/types/encoding/binary"
+)
+
+// MarshalWire writes the transaction in the binary encoding of the wire
+func (tx *Transaction) MarshalWire(w *binary.Writer) {
+	w.Fixed(tx.ID[:])
+	w.Bytes(tx.Command)
+	w.String(tx.ClientID)
+	w.Uint64(tx.Nonce)
+	w.Time(tx.Timestamp)
+}
+
+func (tx *Transaction) UnmarshalWire(r *binary.Reader) {
+	r.Fixed(tx.ID[:])
+	tx.Command = r.Bytes()
+	tx.ClientID = r.String()
+	tx.Nonce = r.Uint64()
+	tx.Timestamp = r.Time()
+}
+
+// WriteTransactions writes the payload of a block
+func WriteTransactions(w *binary.Writer, txs []*Transaction) {
+	w.Uint64(uint64(len(txs)))
+	for _, tx := range txs {
+		tx.MarshalWire(w)
+	}
+}
+
+// ReadTransactions reads the payload of a block, nil if it is empty
+func ReadTransactions(r *binary.Reader) []*Transaction {
+	n := r.Len(32) // a transaction takes its id at least
+	if n == 0 {
+		return nil
+	}
+	txs := make([]*Transaction, n)
+	for i := range txs {
+		txs[i] = new(Transaction)
+		txs[i].UnmarshalWire(r)
+	}
+	return txs
+}
@@ -0,0 +1,329 @@
+// Package metrics keeps the counters and latency histograms of a node and exposes them
+// in the Prometheus text exposition format or as JSON.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the default upper bounds of latency histograms, in seconds
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry keeps the metrics of a node, it is safe for concurrent use
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+	names    []string // in the order of registration
+}
+
+type family struct {
+	name     string
+	help     string
+	label    string // empty if the metric has no label
+	buckets  []float64
+	counters map[string]*Counter
+	hists    map[string]*Histogram
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+func (r *Registry) family(name string, help string, label string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, exists := r.families[name]
+	if exists {
+		return f
+	}
+	f = &family{name: name, help: help, label: label, buckets: buckets}
+	if buckets == nil {
+		f.counters = make(map[string]*Counter)
+	} else {
+		f.hists = make(map[string]*Histogram)
+	}
+	r.families[name] = f
+	r.names = append(r.names, name)
+	return f
+}
+
+// Counter returns the counter with the name, it is created on the first call
+func (r *Registry) Counter(name string, help string) *Counter {
+	return r.CounterVec(name, help, "").With("")
+}
+
+// CounterVec returns the counters with the name partitioned by the label
+func (r *Registry) CounterVec(name string, help string, label string) *CounterVec {
+	return &CounterVec{registry: r, family: r.family(name, help, label, nil)}
+}
+
+// Histogram returns the histogram with the name, it is created on the first call
+func (r *Registry) Histogram(name string, help string, buckets []float64) *Histogram {
+	return r.HistogramVec(name, help, "", buckets).With("")
+}
+
+// HistogramVec returns the histograms with the name partitioned by the label
+func (r *Registry) HistogramVec(name string, help string, label string, buckets []float64) *HistogramVec {
+	return &HistogramVec{registry: r, family: r.family(name, help, label, buckets)}
+}
+
+// CounterVec is a set of counters that differ in the value of one label
+type CounterVec struct {
+	registry *Registry
+	family   *family
+}
+
+// With returns the counter with the label value
+func (v *CounterVec) With(value string) *Counter {
+	v.registry.mu.Lock()
+	defer v.registry.mu.Unlock()
+	c, exists := v.family.counters[value]
+	if !exists {
+		c = new(Counter)
+		v.family.counters[value] = c
+	}
+	return c
+}
+
+// HistogramVec is a set of histograms that differ in the value of one label
+type HistogramVec struct {
+	registry *Registry
+	family   *family
+}
+
+// With returns the histogram with the label value
+func (v *HistogramVec) With(value string) *Histogram {
+	v.registry.mu.Lock()
+	defer v.registry.mu.Unlock()
+	h, exists := v.family.hists[value]
+	if !exists {
+		h = &Histogram{buckets: v.family.buckets, counts: make([]uint64, len(v.family.buckets)+1)}
+		v.family.hists[value] = h
+	}
+	return h
+}
+
+// Counter only goes up, a nil counter counts nothing
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.value += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// Histogram counts the observed values in buckets, a nil histogram observes nothing
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // upper bounds
+	counts  []uint64  // per bucket, not cumulative, the last one has no upper bound
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[sort.SearchFloat64s(h.buckets, v)]++
+	h.sum += v
+	h.count++
+}
+
+// ObserveDuration observes the duration in seconds
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// Snapshot returns the current state of the histogram
+func (h *Histogram) Snapshot() HistogramStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := HistogramStats{Count: h.count, Sum: h.sum}
+	for i, count := range h.counts {
+		bucket := Bucket{Count: count}
+		if i < len(h.buckets) {
+			bucket.UpperBound = h.buckets[i]
+		} else {
+			bucket.UpperBound = math.Inf(1)
+		}
+		stats.Buckets = append(stats.Buckets, bucket)
+	}
+	return stats
+}
+
+// HistogramStats is a snapshot of a histogram, the bucket counts are not cumulative
+type HistogramStats struct {
+	Count   uint64   `json:"count"`
+	Sum     float64  `json:"sum"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// Mean returns the mean of the observed values, 0 if there is none
+func (s HistogramStats) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// Bucket counts the values above the previous upper bound and up to this one
+type Bucket struct {
+	UpperBound float64 `json:"-"`
+	Count      uint64  `json:"count"`
+}
+
+// MarshalJSON writes the unbounded upper bound as "+Inf", JSON has no infinity
+func (b Bucket) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`{"le":%q,"count":%d}`, formatFloat(b.UpperBound), b.Count)), nil
+}
+
+// Stats is a snapshot of all the metrics, keyed by the metric name and then by the label value,
+// which is empty for metrics without a label
+type Stats struct {
+	Counters   map[string]map[string]float64        `json:"counters"`
+	Histograms map[string]map[string]HistogramStats `json:"histograms"`
+}
+
+// Snapshot returns the current state of all metrics
+func (r *Registry) Snapshot() Stats {
+	stats := Stats{
+		Counters:   make(map[string]map[string]float64),
+		Histograms: make(map[string]map[string]HistogramStats),
+	}
+	for _, f := range r.sortedFamilies() {
+		if f.hists == nil {
+			values := make(map[string]float64)
+			for _, c := range r.children(f) {
+				values[c.value] = c.counter.Value()
+			}
+			stats.Counters[f.name] = values
+			continue
+		}
+		values := make(map[string]HistogramStats)
+		for _, c := range r.children(f) {
+			values[c.value] = c.hist.Snapshot()
+		}
+		stats.Histograms[f.name] = values
+	}
+	return stats
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	for _, f := range r.sortedFamilies() {
+		kind := "counter"
+		if f.hists != nil {
+			kind = "histogram"
+		}
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, kind)
+		if err != nil {
+			return err
+		}
+		for _, c := range r.children(f) {
+			labels := ""
+			if f.label != "" {
+				labels = fmt.Sprintf("%s=%q", f.label, c.value)
+			}
+			if c.counter != nil {
+				_, err = fmt.Fprintf(w, "%s%s %s\n", f.name, braces(labels), formatFloat(c.counter.Value()))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			err = writeHistogram(w, f.name, labels, c.hist.Snapshot())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeHistogram(w io.Writer, name string, labels string, stats HistogramStats) error {
+	var cumulative uint64
+	for _, bucket := range stats.Buckets {
+		cumulative += bucket.Count
+		le := fmt.Sprintf("le=%q", formatFloat(bucket.UpperBound))
+		if labels != "" {
+			le = labels + "," + le
+		}
+		_, err := fmt.Fprintf(w, "%s_bucket{%s} %d\n", name, le, cumulative)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", name, braces(labels), formatFloat(stats.Sum), name, braces(labels), stats.Count)
+	return err
+}
+
+// sortedFamilies returns the families in the order of registration
+func (r *Registry) sortedFamilies() []*family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	families := make([]*family, len(r.names))
+	for i, name := range r.names {
+		families[i] = r.families[name]
+	}
+	return families
+}
+
+type child struct {
+	value   string
+	counter *Counter
+	hist    *Histogram
+}
+
+// children returns the metrics of the family ordered by the label value
+func (r *Registry) children(f *family) []child {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var children []child
+	for value, counter := range f.counters {
+		children = append(children, child{value: value, counter: counter})
+	}
+	for value, hist := range f.hists {
+		children = append(children, child{value: value, hist: hist})
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].value < children[j].value })
+	return children
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
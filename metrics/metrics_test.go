@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheus(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("committed_blocks_total", "Committed blocks.").Inc()
+	sent := r.CounterVec("messages_sent_total", "Messages sent.", "type")
+	sent.With("Vote").Add(2)
+	sent.With("Block").Inc()
+	h := r.Histogram("commit_latency_seconds", "Commit latency.", []float64{0.1, 1})
+	h.ObserveDuration(50 * time.Millisecond)
+	h.ObserveDuration(500 * time.Millisecond)
+	h.ObserveDuration(5 * time.Second)
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WritePrometheus(&buf))
+	require.Equal(t, `# HELP committed_blocks_total Committed blocks.
+# TYPE committed_blocks_total counter
+committed_blocks_total 1
+# HELP messages_sent_total Messages sent.
+# TYPE messages_sent_total counter
+messages_sent_total{type="Block"} 1
+messages_sent_total{type="Vote"} 2
+# HELP commit_latency_seconds Commit latency.
+# TYPE commit_latency_seconds histogram
+commit_latency_seconds_bucket{le="0.1"} 1
+commit_latency_seconds_bucket{le="1"} 2
+commit_latency_seconds_bucket{le="+Inf"} 3
+commit_latency_seconds_sum 5.55
+commit_latency_seconds_count 3
+`, buf.String())
+}
+
+func TestSnapshot(t *testing.T) {
+	r := NewRegistry()
+	r.CounterVec("committed_blocks_total", "Committed blocks.", "path").With("fast").Add(3)
+	h := r.HistogramVec("commit_latency_seconds", "Commit latency.", "path", []float64{1}).With("fast")
+	h.Observe(0.5)
+	h.Observe(2)
+
+	stats := r.Snapshot()
+	require.Equal(t, 3.0, stats.Counters["committed_blocks_total"]["fast"])
+	latency := stats.Histograms["commit_latency_seconds"]["fast"]
+	require.Equal(t, uint64(2), latency.Count)
+	require.Equal(t, 1.25, latency.Mean())
+	require.Equal(t, uint64(1), latency.Buckets[1].Count)
+
+	// the unbounded bucket survives the encoding
+	b, err := json.Marshal(stats)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `{"le":"+Inf","count":1}`)
+}
+
+// nil metrics are no-ops, e.g., when a transport is not measured
+func TestNil(t *testing.T) {
+	var c *Counter
+	c.Inc()
+	var h *Histogram
+	h.Observe(1)
+}
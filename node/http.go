@@ -2,11 +2,18 @@ package node
 
 import (
 	"banyan/config"
+	"banyan/crypto"
+	"banyan/identity"
 	"banyan/log"
 	"banyan/message"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 // http request header names
@@ -15,10 +22,52 @@ const (
 	HTTPCommandID = "Cid"
 )
 
+// txRequest is the json body of a submitted transaction
+type txRequest struct {
+	Command  string `json:"command"`
+	ClientID string `json:"client_id"`
+	Nonce    uint64 `json:"nonce"`
+}
+
+// txStatus is the json body describing a transaction
+type txStatus struct {
+	ID      string `json:"id"`
+	Status  string `json:"status"`
+	Height  int    `json:"height,omitempty"`
+	BlockID string `json:"block_id,omitempty"`
+}
+
+// stateReply is the json body describing the replicated state
+type stateReply struct {
+	Height int    `json:"height"`
+	Digest string `json:"digest"`
+	Value  string `json:"value,omitempty"`
+}
+
+// linkRequest is the json body setting the fault of a link, a missing end stands for every node
+type linkRequest struct {
+	From identity.NodeID `json:"from"`
+	To   identity.NodeID `json:"to"`
+	config.LinkFault
+}
+
 // serve serves the http REST API request from clients
 func (n *node) http() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/query", n.handleQuery)
+	mux.HandleFunc("/tx", n.handleSubmit)
+	mux.HandleFunc("/tx/", n.handleTxStatus)
+	mux.HandleFunc("/state", n.handleState)
+	mux.HandleFunc("/kv/", n.handleRead)
+	mux.HandleFunc("/commits", n.handleCommits)
+	mux.HandleFunc("/evidence", n.handleEvidence)
+	mux.HandleFunc("/metrics", n.handleMetrics)
+	mux.HandleFunc("/stats", n.handleStats)
+	mux.HandleFunc("/connections", n.handleConnections)
+	mux.HandleFunc("/fault", n.handleFault)
+	mux.HandleFunc("/fault/link", n.handleFaultLink)
+	mux.HandleFunc("/fault/partition", n.handleFaultPartition)
+	mux.HandleFunc("/fault/heal", n.handleFaultHeal)
 
 	// http string should be in form of ":8080"
 	ip, err := url.Parse(config.Configuration.HTTPAddrs[n.id])
@@ -45,3 +94,277 @@ func (n *node) handleQuery(w http.ResponseWriter, r *http.Request) {
 		log.Error(err)
 	}
 }
+
+// maxSubmitBytes bounds the body of a submission, a batch of transactions
+const maxSubmitBytes = 16 << 20
+
+// handleSubmit accepts a single transaction object or an array of them
+// and replies with the transaction ids
+func (n *node) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSubmitBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var reqs []txRequest
+	batched := bytes.HasPrefix(bytes.TrimSpace(body), []byte("["))
+	if batched {
+		err = json.Unmarshal(body, &reqs)
+	} else {
+		reqs = make([]txRequest, 1)
+		err = json.Unmarshal(body, &reqs[0])
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	request := message.TransactionRequest{
+		Txns: make([]*message.Transaction, len(reqs)),
+		C:    make(chan message.TransactionReply),
+	}
+	ids := make([]string, len(reqs))
+	for i, req := range reqs {
+		request.Txns[i] = message.NewTransaction([]byte(req.Command), req.ClientID, req.Nonce)
+		ids[i] = hex.EncodeToString(crypto.IDToByte(request.Txns[i].ID))
+	}
+	n.TxChan <- request
+	reply := <-request.C
+	if reply.Err != nil {
+		http.Error(w, reply.Err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if batched {
+		err = json.NewEncoder(w).Encode(map[string][]string{"ids": ids})
+	} else {
+		err = json.NewEncoder(w).Encode(map[string]string{"id": ids[0]})
+	}
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// handleTxStatus replies whether the transaction is pending or where it was committed
+func (n *node) handleTxStatus(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	idHex := strings.TrimPrefix(r.URL.Path, "/tx/")
+	id, err := crypto.ParseID(idHex)
+	if err != nil {
+		http.Error(w, "invalid transaction id", http.StatusBadRequest)
+		return
+	}
+	query := message.TransactionQuery{
+		ID: id,
+		C:  make(chan message.TransactionStatus),
+	}
+	n.TxChan <- query
+	status := <-query.C
+
+	reply := txStatus{ID: idHex, Status: status.Status}
+	if status.Status == message.TxCommitted {
+		reply.Height = status.Height
+		reply.BlockID = hex.EncodeToString(crypto.IDToByte(status.BlockID))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status == message.TxUnknown {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	err = json.NewEncoder(w).Encode(reply)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// handleState replies with the height and digest of the applied state,
+// replicas that executed the same blocks report the same digest
+func (n *node) handleState(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	n.queryState(w, nil)
+}
+
+// handleRead reads a key from the key-value state
+func (n *node) handleRead(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	key := strings.TrimPrefix(r.URL.Path, "/kv/")
+	if key == "" {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+	n.queryState(w, []byte("GET "+key))
+}
+
+// handleCommits replies with the number of blocks committed on each path and their latencies
+func (n *node) handleCommits(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	query := message.CommitStatsQuery{C: make(chan message.CommitStats)}
+	n.TxChan <- query
+	stats := <-query.C
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(stats)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// handleEvidence replies with the equivocation proofs the replica has built or received
+func (n *node) handleEvidence(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	query := message.EvidenceQuery{C: make(chan []message.Evidence)}
+	n.TxChan <- query
+	evidence := <-query.C
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(evidence)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// handleConnections replies with the state of the connection to each peer, and the connections the node accepted
+// under its own id
+func (n *node) handleConnections(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(n.Connections())
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// handleMetrics writes the metrics of the node, and the signature timings of the process, in the Prometheus text
+// exposition format
+func (n *node) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	err := n.metrics.WritePrometheus(w)
+	if err == nil {
+		err = crypto.Metrics.WritePrometheus(w)
+	}
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// handleStats replies with the metrics of the node as JSON
+func (n *node) handleStats(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json")
+	stats := n.metrics.Snapshot()
+	signatures := crypto.Metrics.Snapshot()
+	for name, values := range signatures.Histograms {
+		stats.Histograms[name] = values
+	}
+	err := json.NewEncoder(w).Encode(stats)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// handleFault replies with the network faults injected now
+func (n *node) handleFault(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	n.writeFaults(w)
+}
+
+// handleFaultLink sets the fault of a link, a zero fault removes it
+func (n *node) handleFaultLink(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request linkRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to := string(request.From), string(request.To)
+	if from == "" {
+		from = "*"
+	}
+	if to == "" {
+		to = "*"
+	}
+	err = n.faults.SetLink(from, to, request.LinkFault)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Infof("[%v] link %v-%v: %+v", n.id, from, to, request.LinkFault)
+	n.writeFaults(w)
+}
+
+// handleFaultPartition splits the nodes into the groups of the body, e.g. [["1", "2"], ["3", "4"]]
+func (n *node) handleFaultPartition(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var groups [][]identity.NodeID
+	err := json.NewDecoder(r.Body).Decode(&groups)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = n.faults.Partition(groups)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Infof("[%v] partition: %v", n.id, groups)
+	n.writeFaults(w)
+}
+
+// handleFaultHeal removes all the network faults
+func (n *node) handleFaultHeal(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	n.faults.Heal()
+	log.Infof("[%v] the network is healed", n.id)
+	n.writeFaults(w)
+}
+
+func (n *node) writeFaults(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(n.faults.Config())
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func (n *node) queryState(w http.ResponseWriter, command []byte) {
+	query := message.StateQuery{
+		Command: command,
+		C:       make(chan message.StateReply),
+	}
+	n.TxChan <- query
+	reply := <-query.C
+	if reply.Err != nil {
+		http.Error(w, reply.Err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(stateReply{
+		Height: reply.Height,
+		Digest: hex.EncodeToString(crypto.IDToByte(reply.Digest)),
+		Value:  string(reply.Value),
+	})
+	if err != nil {
+		log.Error(err)
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"reflect"
 	"sync"
 
+	"banyan/byzantine"
 	"banyan/config"
 	"banyan/identity"
 	"banyan/log"
+	"banyan/metrics"
 	"banyan/socket"
 )
 
@@ -20,6 +22,7 @@ type Node interface {
 	Run()
 	Register(m interface{}, f interface{})
 	IsByz() bool
+	Metrics() *metrics.Registry
 }
 
 // node implements Node interface
@@ -33,16 +36,31 @@ type node struct {
 	handles     map[string]reflect.Value
 	server      *http.Server
 	isByz       bool
+	metrics     *metrics.Registry
+	faults      *socket.Faults
 
 	sync.RWMutex
 }
 
-// NewNode creates a new Node object from configuration
+// NewNode creates a new Node object from configuration,
+// a Byzantine node sends what the strategy configured for it decides
 func NewNode(id identity.NodeID, isByz bool) Node {
+	registry := metrics.NewRegistry()
+	var behavior socket.Behavior
+	if isByz {
+		strategy, err := byzantine.New(config.GetConfig().StrategyOf(id), id)
+		if err != nil {
+			log.Fatalf("[%v] %v", id, err)
+		}
+		behavior = strategy
+	}
+	faults := socket.ProcessFaults()
 	return &node{
-		id:     id,
-		isByz:  isByz,
-		Socket: socket.NewSocket(id, config.Configuration.Addrs, isByz),
+		id:      id,
+		isByz:   isByz,
+		metrics: registry,
+		faults:  faults,
+		Socket:  socket.NewSocket(id, config.Configuration.Addrs, config.Configuration.Codec, behavior, faults, registry),
 		//Database:    NewDatabase(),
 		MessageChan: make(chan interface{}, 1024),
 		TxChan:      make(chan interface{}, 1024),
@@ -58,6 +76,11 @@ func (n *node) IsByz() bool {
 	return n.isByz
 }
 
+// Metrics returns the counters and histograms of the node, served at /metrics and /stats
+func (n *node) Metrics() *metrics.Registry {
+	return n.metrics
+}
+
 // Register a handle function for each message type
 func (n *node) Register(m interface{}, f interface{}) {
 	t := reflect.TypeOf(m)
@@ -122,51 +145,3 @@ func (n *node) handle() {
 		f.Call([]reflect.Value{v})
 	}
 }
-
-/*
-func (n *node) Forward(id NodeID, m Transaction) {
-	key := m.Command.Key
-	url := config.HTTPAddrs[id] + "/" + strconv.Itoa(int(key))
-
-	log.Debugf("Node %v forwarding %v to %s", n.NodeID(), m, id)
-
-	method := http.MethodGet
-	var body io.Reader
-	if !m.Command.IsRead() {
-		method = http.MethodPut
-		body = bytes.NewBuffer(m.Command.Value)
-	}
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		log.Error(err)
-		return
-	}
-	req.Header.Set(HTTPClientID, string(n.id))
-	req.Header.Set(HTTPCommandID, strconv.Itoa(m.Command.CommandID))
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Error(err)
-		m.TransactionReply(TransactionReply{
-			Command: m.Command,
-			Err:     err,
-		})
-		return
-	}
-	defer res.Body.Close()
-	if res.StatusCode == http.StatusOK {
-		b, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			log.Error(err)
-		}
-		m.TransactionReply(TransactionReply{
-			Command: m.Command,
-			Value:   Value(b),
-		})
-	} else {
-		m.TransactionReply(TransactionReply{
-			Command: m.Command,
-			Err:     errors.New(res.Status),
-		})
-	}
-}
-*/
@@ -13,6 +13,11 @@ type TMO struct {
 	HighQC *blockchain.QC
 }
 
+// Sender returns the node that timed out, the TMO is not signed and only the node itself sends it
+func (tmo TMO) Sender() identity.NodeID {
+	return tmo.NodeID
+}
+
 type TC struct {
 	types.View
 	crypto.AggSig
@@ -5,12 +5,13 @@ import (
 	"banyan/config"
 	"banyan/crypto"
 	"banyan/election"
+	"banyan/evidence"
 	"banyan/local_timeout"
 	"banyan/log"
+	"banyan/mempool"
 	"banyan/node"
+	"banyan/store"
 	"fmt"
-	"math/rand"
-	"time"
 )
 
 type Banyan struct {
@@ -18,21 +19,25 @@ type Banyan struct {
 	election.Election
 	bc               *blockchain.BlockChain // all blocks I have
 	lt               *local_timeout.LocalTimeout
+	mp               *mempool.MemPool             // transactions waiting to be proposed
 	NSharesBagBanyan *blockchain.NSharesBagBanyan // notarization shares I've collected
 	fSharesBag       *blockchain.FSharesBag       // finalization shares I've collected
 	headHeight       int                          // highest notarized block height
 	headId           crypto.Identifier            // id of the head
-	sentNRank        map[int]int                  // what is the min rank of a notarization I sent on this height
-	sentNSharesNo    map[int]int                  // how many notarization shares have I sent for blocks on this height?
-	sentNShareId     map[int]crypto.Identifier    // what is the id of the (some) block for which I sent a notarization share on this height?
-	sentFShare       map[int]struct{}             // have I sent a finalization share for this height?
+	proposed         *blockchain.Block            // the last block I proposed
+	resend           map[int]struct{}             // heights I voted on before a restart, the shares may not have been delivered
+	voted            *blockchain.VotingState      // the shares I have sent and the blocks I have proposed, per height
 	isNotarized      map[crypto.Identifier]struct{}
 	isFinalized      map[crypto.Identifier]struct{}
 	lastShippedBlock crypto.Identifier
+	shippedHeight    int
+	st               *store.Store               // nil if nothing is persisted
+	evidence         *evidence.Collector        // equivocations of the other replicas
+	committed        *blockchain.CommittedCache // recently committed blocks, served to the peers that are catching up
+	sync             *syncer
 	shipQueue        map[crypto.Identifier]struct{}
-	committedBlocks  chan *blockchain.Block
+	committedBlocks  chan *blockchain.CommittedRecord
 	forkedBlocks     chan *blockchain.Block
-	rand             *rand.Rand
 	echoedBlock      map[crypto.Identifier]struct{}
 }
 
@@ -40,7 +45,10 @@ func NewBanyan(
 	node node.Node,
 	elec election.Election,
 	lt *local_timeout.LocalTimeout,
-	committedBlocks chan *blockchain.Block,
+	mp *mempool.MemPool,
+	st *store.Store,
+	ev *evidence.Collector,
+	committedBlocks chan *blockchain.CommittedRecord,
 	forkedBlocks chan *blockchain.Block,
 	f int,
 	p int) *Banyan {
@@ -49,22 +57,30 @@ func NewBanyan(
 	banyan.Election = elec
 	banyan.bc = blockchain.NewBlockchain(config.GetConfig().N)
 	banyan.lt = lt
+	banyan.mp = mp
 	banyan.NSharesBagBanyan = blockchain.NewNSharesBagBanyan(config.GetConfig().N, config.GetConfig().F, config.GetConfig().P)
 	banyan.fSharesBag = blockchain.NewFSharesBag(config.GetConfig().N)
 	banyan.headHeight = 0
 	banyan.headId = crypto.MakeID("genesis")
-	banyan.sentNRank = make(map[int]int)
-	banyan.sentNSharesNo = make(map[int]int)
-	banyan.sentNShareId = make(map[int]crypto.Identifier)
-	banyan.sentFShare = make(map[int]struct{})
+	banyan.voted = blockchain.NewVotingState()
+	banyan.resend = make(map[int]struct{})
 	banyan.isNotarized = make(map[crypto.Identifier]struct{})
 	banyan.isFinalized = make(map[crypto.Identifier]struct{})
 	banyan.lastShippedBlock = crypto.MakeID("genesis")
 	banyan.shipQueue = make(map[crypto.Identifier]struct{})
 	banyan.committedBlocks = committedBlocks
 	banyan.forkedBlocks = forkedBlocks
-	banyan.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
 	banyan.echoedBlock = make(map[crypto.Identifier]struct{}, 10000)
+	banyan.committed = blockchain.NewCommittedCache(committedCacheSize)
+	banyan.sync = newSyncer(node)
+	banyan.st = st
+	banyan.evidence = ev
+	if st != nil {
+		err := banyan.restore()
+		if err != nil {
+			log.Fatalf("[%v] cannot recover from the store: %v", banyan.ID(), err)
+		}
+	}
 
 	return banyan
 }
@@ -80,11 +96,18 @@ func (banyan *Banyan) ProcessBlock(block *blockchain.Block) error {
 		return fmt.Errorf("received a proposal (height %v) from an invalid leader (%v)", block.Height, block.Proposer)
 	}
 	if block.Proposer != banyan.ID() {
+		if !block.VerifyID() {
+			return fmt.Errorf("received a block (height %v) whose id does not match its content", block.Height)
+		}
 		blockIsVerified, _ := crypto.PubVerify(block.Sig, crypto.IDToByte(block.ID), block.Proposer)
 		if !blockIsVerified {
-			log.Warningf("[%v] received a block with an invalid signature", banyan.ID())
+			return fmt.Errorf("received a block (height %v) with an invalid signature of %v", block.Height, block.Proposer)
 		}
 	}
+	banyan.evidence.Block(block)
+	if !banyan.extendsPreviousHeight(block) {
+		return fmt.Errorf("received a proposal (height %v) whose parent is not on the previous height", block.Height)
+	}
 
 	// add a new block!
 	_, exists := banyan.echoedBlock[block.ID]
@@ -101,25 +124,30 @@ func (banyan *Banyan) ProcessBlock(block *blockchain.Block) error {
 	}
 
 	// should I send a notarization share?
-	if (banyan.headHeight < block.Height) && ((banyan.sentNSharesNo[block.Height] == 0) || (banyan.sentNRank[block.Height] > block.Rank)) {
+	if _, lost := banyan.resend[block.Height]; lost && (banyan.voted.SentNShareId[block.Height] == block.ID) {
+		// I voted for this block before a restart, the shares may not have been delivered
+		banyan.resendShares(block)
+	} else if (banyan.headHeight < block.Height) && ((banyan.voted.SentNSharesNo[block.Height] == 0) || (banyan.voted.SentNRank[block.Height] > block.Rank)) {
 		shareRank := block.Rank
-		if (shareRank == 0) && (banyan.sentNSharesNo[block.Height] == 0) {
+		if (shareRank == 0) && (banyan.voted.SentNSharesNo[block.Height] == 0) {
 			shareRank = -1
 		}
 		notarizationShare := blockchain.MakeNShare(block.Height, shareRank, banyan.ID(), block.ID)
-		banyan.sentNSharesNo[block.Height] += 1
-		banyan.sentNRank[block.Height] = block.Rank
-		banyan.sentNShareId[block.Height] = block.ID
+		banyan.voted.SentNSharesNo[block.Height] += 1
+		banyan.voted.SentNRank[block.Height] = block.Rank
+		banyan.voted.SentNShareId[block.Height] = block.ID
+		banyan.saveVotingState()
 		banyan.Broadcast(notarizationShare)
 		banyan.ProcessNotarizationShare(notarizationShare)
 	}
 
 	// should I send a finalization share?
 	_, isN := banyan.isNotarized[block.ID]
-	_, sentF := banyan.sentFShare[block.Height]
-	if isN && (!sentF) && (banyan.sentNSharesNo[block.Height] == 1) && (banyan.sentNShareId[block.Height] == block.ID) {
+	sentF := banyan.voted.SentFShare[block.Height]
+	if isN && (!sentF) && (banyan.voted.SentNSharesNo[block.Height] == 1) && (banyan.voted.SentNShareId[block.Height] == block.ID) {
 		finalizationShare := blockchain.MakeFShare(block.Height, block.Rank, banyan.ID(), block.ID)
-		banyan.sentFShare[block.Height] = struct{}{}
+		banyan.voted.SentFShare[block.Height] = true
+		banyan.saveVotingState()
 		banyan.Broadcast(finalizationShare)
 		banyan.ProcessFinalizationShare(finalizationShare)
 	}
@@ -127,32 +155,90 @@ func (banyan *Banyan) ProcessBlock(block *blockchain.Block) error {
 	return nil
 }
 
-func (banyan *Banyan) TryToShip(id crypto.Identifier) {
-	if banyan.bc.Exists(id) {
-		block, _ := banyan.bc.GetBlockByID(id)
-		if banyan.lastShippedBlock == block.PrevID {
-			// commiting the block
-			committed, forked, err := banyan.bc.CommitBlock(id, block.Height)
-			if err != nil {
-				log.Errorf("[%v] cannot commit blocks, %w", banyan.ID(), err)
-				return
-			}
-			for _, cBlock := range committed {
-				banyan.committedBlocks <- cBlock
-			}
-			for _, fBlock := range forked {
-				banyan.forkedBlocks <- fBlock
-			}
+// extendsPreviousHeight checks the parent of a proposal if I have it, a block extends a block of the previous height
+func (banyan *Banyan) extendsPreviousHeight(block *blockchain.Block) bool {
+	if block.Height-1 == banyan.shippedHeight {
+		return block.PrevID == banyan.lastShippedBlock
+	}
+	parent, err := banyan.bc.GetBlockByID(block.PrevID)
+	return err != nil || parent.Height == block.Height-1
+}
 
-			banyan.lastShippedBlock = id
+func (banyan *Banyan) TryToShip(id crypto.Identifier) {
+	block, err := banyan.bc.GetBlockByID(id)
+	if err != nil {
+		// the finalized block is missing, ask a peer for it
+		banyan.shipQueue[id] = struct{}{}
+		banyan.sync.request(id, &blockchain.BlockRequest{From: banyan.ID(), ID: id})
+		return
+	}
+	if block.Height <= banyan.shippedHeight {
+		delete(banyan.shipQueue, id)
+		return
+	}
+	// the ancestors of a finalized block are committed with it, all of them have to be here
+	for ancestor := block; ancestor.PrevID != banyan.lastShippedBlock; {
+		parent, err := banyan.bc.GetBlockByID(ancestor.PrevID)
+		// the parent of a block on the height after the last committed one has to be the last committed block
+		if ancestor.Height-1 <= banyan.shippedHeight || (err == nil && parent.Height <= banyan.shippedHeight) {
+			log.Errorf("[%v] finalized block %x does not extend the committed chain", banyan.ID(), id)
 			delete(banyan.shipQueue, id)
+			return
+		}
+		if err != nil {
+			banyan.shipQueue[id] = struct{}{}
+			banyan.fetchAncestors(ancestor)
+			return
+		}
+		ancestor = parent
+	}
 
-			for queued := range banyan.shipQueue {
-				banyan.TryToShip(queued)
-			}
+	// commiting the block
+	committed, forked, err := banyan.bc.CommitBlock(id, block.Height)
+	if err != nil {
+		log.Errorf("[%v] cannot commit blocks, %w", banyan.ID(), err)
+		return
+	}
+	for _, cBlock := range committed {
+		banyan.committedBlocks <- banyan.persistCommitted(cBlock)
+	}
+	for _, fBlock := range forked {
+		banyan.forkedBlocks <- fBlock
+	}
+
+	banyan.lastShippedBlock = id
+	banyan.shippedHeight = block.Height
+	banyan.voted.Prune(block.Height)
+	for height := range banyan.resend {
+		if height <= block.Height {
+			delete(banyan.resend, height)
 		}
-	} else {
-		banyan.shipQueue[id] = struct{}{}
+	}
+	delete(banyan.shipQueue, id)
+	if banyan.headHeight < block.Height {
+		// I was catching up
+		banyan.isNotarized[id] = struct{}{}
+		banyan.headHeight = block.Height
+		banyan.headId = id
+		banyan.lt.HeightIncreased(block.Height + 1)
+	}
+
+	for queued := range banyan.shipQueue {
+		banyan.TryToShip(queued)
+	}
+}
+
+// fetchAncestors asks a peer for the missing parent of the block,
+// and for the committed blocks in between if more than one may be missing
+func (banyan *Banyan) fetchAncestors(block *blockchain.Block) {
+	banyan.sync.request(block.PrevID, &blockchain.BlockRequest{From: banyan.ID(), ID: block.PrevID})
+	missing := block.Height - 1 - banyan.shippedHeight
+	if missing > 1 {
+		banyan.sync.request(rangeKey, &blockchain.BlockRequest{
+			From:   banyan.ID(),
+			Height: banyan.shippedHeight + 1,
+			Count:  missing,
+		})
 	}
 }
 
@@ -168,7 +254,7 @@ func (banyan *Banyan) ProcessNotarizationShare(ns *blockchain.NotarizationShare)
 	}
 
 	log.Debugf("[%v] is processing NS from [%v], block id: %x", banyan.ID(), ns.Voter, ns.BlockID)
-	if ns.Voter != banyan.ID() {
+	if ns.Voter != banyan.ID() && !ns.IsVerified() {
 		voteIsVerified, err := crypto.PubVerify(ns.Signature, crypto.IDToByte(ns.BlockID), ns.Voter)
 		if err != nil {
 			log.Fatalf("[%v] Error in verifying the signature in vote id: %x", banyan.ID(), ns.BlockID)
@@ -178,30 +264,43 @@ func (banyan *Banyan) ProcessNotarizationShare(ns *blockchain.NotarizationShare)
 			log.Warningf("[%v] received a vote with invalid signature. vote id: %x", banyan.ID(), ns.BlockID)
 			return
 		}
+		if ns.Rank == -1 {
+			fastIsVerified, _ := crypto.PubVerify(ns.FastSignature, crypto.IDToByte(blockchain.FastID(ns.BlockID)), ns.Voter)
+			if !fastIsVerified {
+				log.Warningf("[%v] received a rank -1 vote with invalid fast signature. vote id: %x", banyan.ID(), ns.BlockID)
+				return
+			}
+		}
 	}
-	new_isN, new_isF := banyan.NSharesBagBanyan.Add(ns)
+	if first := banyan.NSharesBagBanyan.Conflict(ns); first != nil {
+		banyan.evidence.Notarization(first, ns)
+	}
+	notarization, fastFinalization := banyan.NSharesBagBanyan.Add(ns)
 
-	if !isN && new_isN {
+	if !isN && notarization != nil {
 		// block is notarized!
 		banyan.isNotarized[ns.BlockID] = struct{}{}
+		banyan.bc.AddNotarization(notarization)
 		if banyan.headHeight < ns.Height {
 			banyan.headHeight = ns.Height
 			banyan.headId = ns.BlockID
 			banyan.lt.HeightIncreased(ns.Height + 1)
 		}
 
-		_, sentF := banyan.sentFShare[ns.Height]
-		if (!sentF) && (banyan.sentNSharesNo[ns.Height] == 1) && (banyan.sentNShareId[ns.Height] == ns.BlockID) {
+		sentF := banyan.voted.SentFShare[ns.Height]
+		if (!sentF) && (banyan.voted.SentNSharesNo[ns.Height] == 1) && (banyan.voted.SentNShareId[ns.Height] == ns.BlockID) {
 			finalizationShare := blockchain.MakeFShare(ns.Height, ns.Rank, banyan.ID(), ns.BlockID)
-			banyan.sentFShare[ns.Height] = struct{}{}
+			banyan.voted.SentFShare[ns.Height] = true
+			banyan.saveVotingState()
 			banyan.Broadcast(finalizationShare)
 			banyan.ProcessFinalizationShare(finalizationShare)
 		}
 	}
 
-	if new_isF {
+	if fastFinalization != nil {
 		// block is fast-path finalized!
 		banyan.isFinalized[ns.BlockID] = struct{}{}
+		banyan.bc.AddFastFinalization(fastFinalization)
 		banyan.TryToShip(ns.BlockID)
 	}
 }
@@ -212,8 +311,8 @@ func (banyan *Banyan) ProcessFinalizationShare(fs *blockchain.FinalizationShare)
 		return
 	}
 	log.Debugf("[%v] is processing FS, block id: %x", banyan.ID(), fs.BlockID)
-	if fs.Voter != banyan.ID() {
-		voteIsVerified, err := crypto.PubVerify(fs.Signature, crypto.IDToByte(fs.BlockID), fs.Voter)
+	if fs.Voter != banyan.ID() && !fs.IsVerified() {
+		voteIsVerified, err := crypto.PubVerify(fs.Signature, crypto.IDToByte(blockchain.FinalizeID(fs.BlockID)), fs.Voter)
 		if err != nil {
 			log.Fatalf("[%v] Error in verifying the signature in vote id: %x", banyan.ID(), fs.BlockID)
 			return
@@ -223,18 +322,204 @@ func (banyan *Banyan) ProcessFinalizationShare(fs *blockchain.FinalizationShare)
 			return
 		}
 	}
-	isBuilt := banyan.fSharesBag.Add(fs)
+	if first := banyan.fSharesBag.Conflict(fs); first != nil {
+		banyan.evidence.Finalization(first, fs)
+	}
+	isBuilt, finalization := banyan.fSharesBag.Add(fs)
 	if !isBuilt {
 		return
 	}
 
 	// block is finalized!
 	banyan.isFinalized[fs.BlockID] = struct{}{}
+	if finalization != nil {
+		banyan.bc.AddFinalization(finalization)
+	}
 	banyan.TryToShip(fs.BlockID)
 }
 
+// MakeProposal proposes the same block again if I have already proposed on this height with this rank,
+// e.g., before a restart, and returns nil if I have proposed with a higher rank
 func (banyan *Banyan) MakeProposal(height int, rank int, payloadSize int) *blockchain.Block {
+	proposed := banyan.proposed
+	if proposed != nil && ((proposed.Height > height) || (proposed.Height == height && proposed.Rank >= rank)) {
+		if proposed.Height == height && proposed.Rank == rank {
+			return proposed
+		}
+		return nil
+	}
 	prevID := banyan.headId
-	block := blockchain.MakeBlock(height, rank, prevID, banyan.ID(), payloadSize, banyan.rand)
+	payload := banyan.mp.Batch(payloadSize)
+	block := blockchain.MakeBlock(height, rank, prevID, banyan.ID(), payload)
+	banyan.proposed = block
+	if banyan.st != nil {
+		err := banyan.st.Put(proposalKey, block)
+		if err != nil {
+			log.Fatalf("[%v] cannot persist the proposal: %v", banyan.ID(), err)
+		}
+	}
 	return block
 }
+
+// persistCommitted keeps a committed block together with the certificates and the shares that notarized and finalized it,
+// the record is returned to be passed on to the replica
+func (banyan *Banyan) persistCommitted(block *blockchain.Block) *blockchain.CommittedRecord {
+	record := &blockchain.CommittedRecord{
+		Block:              block,
+		Notarization:       banyan.bc.GetNotarization(block.ID),
+		Finalization:       banyan.bc.GetFinalization(block.ID),
+		FastFinalization:   banyan.bc.GetFastFinalization(block.ID),
+		NotarizationShares: banyan.NSharesBagBanyan.Shares(block.ID),
+		FinalizationShares: banyan.fSharesBag.Shares(block.ID),
+	}
+	if record.FastFinalization != nil {
+		record.Path = blockchain.FastPath
+	} else if _, isF := banyan.isFinalized[block.ID]; isF {
+		record.Path = blockchain.SlowPath
+	} else {
+		record.Path = blockchain.ImplicitPath
+	}
+	banyan.committed.Add(record)
+	if banyan.st == nil {
+		return record
+	}
+	err := banyan.st.Append(block.Height, *record)
+	if err != nil {
+		log.Errorf("[%v] cannot persist the committed block, height: %v, id: %x: %v", banyan.ID(), block.Height, block.ID, err)
+	}
+	return record
+}
+
+// saveVotingState has to be called before a share is sent
+func (banyan *Banyan) saveVotingState() {
+	if banyan.st == nil {
+		return
+	}
+	err := banyan.st.Put(votingStateKey, banyan.voted)
+	if err != nil {
+		log.Fatalf("[%v] cannot persist the voting state: %v", banyan.ID(), err)
+	}
+}
+
+// restore resumes from the last committed block and the shares I sent before a restart
+func (banyan *Banyan) restore() error {
+	_, err := banyan.st.Load(votingStateKey, banyan.voted)
+	if err != nil {
+		return err
+	}
+	for height := range banyan.voted.SentNSharesNo {
+		banyan.resend[height] = struct{}{}
+	}
+	var proposed blockchain.Block
+	saved, err := banyan.st.Load(proposalKey, &proposed)
+	if err != nil {
+		return err
+	}
+	if saved {
+		banyan.proposed = &proposed
+	}
+	record, err := lastCommitted(banyan.st)
+	if err != nil || record == nil {
+		return err
+	}
+	block := record.Block
+	banyan.bc.Restore(block)
+	banyan.headHeight = block.Height
+	banyan.headId = block.ID
+	banyan.isNotarized[block.ID] = struct{}{}
+	banyan.isFinalized[block.ID] = struct{}{}
+	banyan.lastShippedBlock = block.ID
+	banyan.shippedHeight = block.Height
+	banyan.committed.Add(record)
+	banyan.lt.Restore(block.Height + 1)
+	log.Infof("[%v] recovered at height %v, id: %x", banyan.ID(), block.Height, block.ID)
+	return nil
+}
+
+// resendShares sends again the shares I sent for the block before a restart
+func (banyan *Banyan) resendShares(block *blockchain.Block) {
+	delete(banyan.resend, block.Height)
+	shareRank := block.Rank
+	if (shareRank == 0) && (banyan.voted.SentNSharesNo[block.Height] == 1) {
+		shareRank = -1
+	}
+	notarizationShare := blockchain.MakeNShare(block.Height, shareRank, banyan.ID(), block.ID)
+	banyan.Broadcast(notarizationShare)
+	banyan.ProcessNotarizationShare(notarizationShare)
+	if banyan.voted.SentFShare[block.Height] {
+		finalizationShare := blockchain.MakeFShare(block.Height, block.Rank, banyan.ID(), block.ID)
+		banyan.Broadcast(finalizationShare)
+		banyan.ProcessFinalizationShare(finalizationShare)
+	}
+}
+
+// ProcessBlockRequest sends the requested blocks I have, nothing if I have none of them
+func (banyan *Banyan) ProcessBlockRequest(request *blockchain.BlockRequest) {
+	response := &blockchain.BlockResponse{From: banyan.ID(), ID: request.ID}
+	if request.Count > 0 {
+		records, err := committedRange(banyan.committed, banyan.st, request.Height, request.Count)
+		if err != nil {
+			log.Errorf("[%v] cannot read the committed blocks from height %v: %v", banyan.ID(), request.Height, err)
+			return
+		}
+		response.Records = records
+	} else if record, ok := banyan.committed.GetByID(request.ID); ok {
+		response.Records = []*blockchain.CommittedRecord{record}
+	} else if block, err := banyan.bc.GetBlockByID(request.ID); err == nil {
+		response.Records = []*blockchain.CommittedRecord{{
+			Block:            block,
+			Notarization:     banyan.bc.GetNotarization(block.ID),
+			Finalization:     banyan.bc.GetFinalization(block.ID),
+			FastFinalization: banyan.bc.GetFastFinalization(block.ID),
+		}}
+	}
+	if len(response.Records) == 0 {
+		return
+	}
+	log.Debugf("[%v] sends %v blocks to %v", banyan.ID(), len(response.Records), request.From)
+	banyan.Send(request.From, response)
+}
+
+// ProcessBlockResponse adds the fetched blocks, the ones that come with a valid finalization are shipped
+// together with their ancestors
+func (banyan *Banyan) ProcessBlockResponse(response *blockchain.BlockResponse) {
+	if response.ID == (crypto.Identifier{}) {
+		banyan.sync.done(rangeKey)
+	} else {
+		banyan.sync.done(response.ID)
+	}
+	for _, record := range response.Records {
+		block := record.Block
+		if block == nil || !block.VerifyID() {
+			log.Warningf("[%v] received an invalid block from %v", banyan.ID(), response.From)
+			return
+		}
+		if block.Height <= banyan.shippedHeight {
+			// a finalized block I was waiting for on a height I have committed already, it is not coming
+			delete(banyan.shipQueue, block.ID)
+			continue
+		}
+		if !banyan.Election.IsLeader(block.Proposer, block.Height, block.Rank) {
+			log.Warningf("[%v] received a block (height %v) from an invalid leader (%v)", banyan.ID(), block.Height, block.Proposer)
+			return
+		}
+		if ok, _ := crypto.PubVerify(block.Sig, crypto.IDToByte(block.ID), block.Proposer); !ok {
+			log.Warningf("[%v] received a block (height %v) with an invalid signature of %v", banyan.ID(), block.Height, block.Proposer)
+			return
+		}
+		keepCertificates(banyan.bc, record, blockchain.BanyanQuorum(config.GetConfig().N, config.GetConfig().F), config.GetConfig().N-config.GetConfig().P)
+		if _, isF := banyan.isFinalized[block.ID]; !isF && record.Finalized(config.GetConfig().N, config.GetConfig().N-config.GetConfig().P) {
+			banyan.isFinalized[block.ID] = struct{}{}
+			banyan.shipQueue[block.ID] = struct{}{}
+		}
+		if block.ID == response.ID && block.Height > banyan.headHeight {
+			// a proposal I missed, it is processed as if it was delivered late
+			_ = banyan.ProcessBlock(block)
+		} else {
+			banyan.bc.AddBlock(block)
+		}
+	}
+	for queued := range banyan.shipQueue {
+		banyan.TryToShip(queued)
+	}
+}
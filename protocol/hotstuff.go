@@ -2,17 +2,18 @@ package protocol
 
 import (
 	"fmt"
-	"math/rand"
 	"sync"
-	"time"
 
 	blockchain "banyan/blockchain_view"
 	"banyan/config"
 	"banyan/crypto"
 	"banyan/election"
+	"banyan/evidence"
 	"banyan/log"
+	"banyan/mempool"
 	"banyan/node"
 	"banyan/pacemaker"
+	"banyan/store"
 	"banyan/types"
 )
 
@@ -20,16 +21,24 @@ type HotStuff struct {
 	node.Node
 	election.Election
 	pm              *pacemaker.Pacemaker
+	mp              *mempool.MemPool
 	lastVotedView   types.View
 	preferredView   types.View
 	highQC          *blockchain.QC
+	proposed        *blockchain.Block          // the last block I proposed
+	st              *store.Store               // nil if nothing is persisted
+	evidence        *evidence.Collector        // equivocations of the other replicas
+	committed       *blockchain.CommittedCache // recently committed blocks, served to the peers that are catching up
+	sync            *syncer
+	pendingCommit   *blockchain.Block       // waits for its missing ancestors
+	votedBlocks     []blockchain.VotedBlock // blocks I voted for that are not committed yet
+	synced          types.View              // the highest view fetched in the range mode
 	bc              *blockchain.BlockChain
 	committedBlocks chan *blockchain.Block
 	forkedBlocks    chan *blockchain.Block
 	bufferedQCs     map[crypto.Identifier]*blockchain.QC
 	bufferedBlocks  map[types.View]*blockchain.Block
 	mu              sync.Mutex
-	rand            *rand.Rand
 	echoedBlock     map[crypto.Identifier]struct{}
 }
 
@@ -37,20 +46,33 @@ func NewHotStuff(
 	node node.Node,
 	pm *pacemaker.Pacemaker,
 	elec election.Election,
+	mp *mempool.MemPool,
+	st *store.Store,
+	ev *evidence.Collector,
 	committedBlocks chan *blockchain.Block,
 	forkedBlocks chan *blockchain.Block) *HotStuff {
 	hs := new(HotStuff)
 	hs.Node = node
 	hs.Election = elec
 	hs.pm = pm
+	hs.mp = mp
 	hs.bc = blockchain.NewBlockchain(config.GetConfig().N)
 	hs.bufferedBlocks = make(map[types.View]*blockchain.Block)
 	hs.bufferedQCs = make(map[crypto.Identifier]*blockchain.QC)
 	hs.highQC = &blockchain.QC{View: 0}
 	hs.committedBlocks = committedBlocks
 	hs.forkedBlocks = forkedBlocks
-	hs.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
 	hs.echoedBlock = make(map[crypto.Identifier]struct{}, 10000)
+	hs.committed = blockchain.NewCommittedCache(committedCacheSize)
+	hs.sync = newSyncer(node)
+	hs.st = st
+	hs.evidence = ev
+	if st != nil {
+		err := hs.restore()
+		if err != nil {
+			log.Fatalf("[%v] cannot recover from the store: %v", hs.ID(), err)
+		}
+	}
 	return hs
 }
 
@@ -58,11 +80,15 @@ func (hs *HotStuff) ProcessBlock(block *blockchain.Block) error {
 	log.Debugf("[%v] is processing block from %v, view: %v, id: %x", hs.ID(), block.Proposer.Node(), block.View, block.ID)
 	curView := hs.pm.GetCurView()
 	if block.Proposer != hs.ID() {
+		if !block.VerifyID() {
+			return fmt.Errorf("received a block (view %v) whose id does not match its content", block.View)
+		}
 		blockIsVerified, _ := crypto.PubVerify(block.Sig, crypto.IDToByte(block.ID), block.Proposer)
 		if !blockIsVerified {
-			log.Warningf("[%v] received a block with an invalid signature", hs.ID())
+			return fmt.Errorf("received a block (view %v) with an invalid signature of %v", block.View, block.Proposer)
 		}
 	}
+	hs.evidence.ViewBlock(block)
 	if block.View > curView+1 {
 		//	buffer the block
 		hs.bufferedBlocks[block.View-1] = block
@@ -71,6 +97,7 @@ func (hs *HotStuff) ProcessBlock(block *blockchain.Block) error {
 	}
 	if block.QC != nil {
 		hs.updateHighQC(block.QC)
+		hs.bc.AddQC(block.QC)
 	} else {
 		return fmt.Errorf("the block should contain a QC")
 	}
@@ -102,12 +129,20 @@ func (hs *HotStuff) ProcessBlock(block *blockchain.Block) error {
 	shouldVote, err := hs.votingRule(block)
 	if err != nil {
 		log.Errorf("[%v] cannot decide whether to vote the block, %w", hs.ID(), err)
+		hs.fetchMissing(block.ID)
 		return err
 	}
 	if !shouldVote {
 		log.Debugf("[%v] is not going to vote for block, id: %x", hs.ID(), block.ID)
 		return nil
 	}
+	err = hs.updateLastVotedView(block.View)
+	if err != nil {
+		return err
+	}
+	hs.saveVotedBlock(block)
+	hs.votedBlocks = append(hs.votedBlocks, blockchain.VotedBlock{View: block.View, ID: block.ID})
+	hs.saveVotingState()
 	vote := blockchain.MakeVote(block.View, hs.ID(), block.ID)
 	// vote is sent to the next leader
 	voteAggregator := hs.FindLeaderForView(block.View + 1)
@@ -128,7 +163,7 @@ func (hs *HotStuff) ProcessBlock(block *blockchain.Block) error {
 
 func (hs *HotStuff) ProcessVote(vote *blockchain.Vote) {
 	log.Debugf("[%v] is processing the vote, block id: %x", hs.ID(), vote.BlockID)
-	if vote.Voter != hs.ID() {
+	if vote.Voter != hs.ID() && !vote.IsVerified() {
 		voteIsVerified, err := crypto.PubVerify(vote.Signature, crypto.IDToByte(vote.BlockID), vote.Voter)
 		if err != nil {
 			log.Warningf("[%v] Error in verifying the signature in vote id: %x", hs.ID(), vote.BlockID)
@@ -139,6 +174,9 @@ func (hs *HotStuff) ProcessVote(vote *blockchain.Vote) {
 			return
 		}
 	}
+	if first := hs.bc.VoteConflict(vote); first != nil {
+		hs.evidence.Vote(first, vote)
+	}
 	isBuilt, qc := hs.bc.AddVote(vote)
 	if !isBuilt {
 		log.Debugf("[%v] not sufficient votes to build a QC, block id: %x", hs.ID(), vote.BlockID)
@@ -176,9 +214,25 @@ func (hs *HotStuff) ProcessLocalTmo(view types.View) {
 	hs.ProcessRemoteTmo(tmo)
 }
 
+// MakeProposal proposes the same block again if I have already proposed in this view,
+// e.g., before a restart, and returns nil if I have proposed in a higher view
 func (hs *HotStuff) MakeProposal(view types.View, payloadSize int) *blockchain.Block {
+	if hs.proposed != nil && hs.proposed.View >= view {
+		if hs.proposed.View == view {
+			return hs.proposed
+		}
+		return nil
+	}
 	qc := hs.forkChoice()
-	block := blockchain.MakeBlock(view, qc, qc.BlockID, hs.ID(), payloadSize, hs.rand)
+	payload := hs.mp.Batch(payloadSize)
+	block := blockchain.MakeBlock(view, qc, qc.BlockID, hs.ID(), payload)
+	hs.proposed = block
+	if hs.st != nil {
+		err := hs.st.Put(proposalKey, block)
+		if err != nil {
+			log.Fatalf("[%v] cannot persist the proposal: %v", hs.ID(), err)
+		}
+	}
 	return block
 }
 
@@ -218,7 +272,8 @@ func (hs *HotStuff) processCertificate(qc *blockchain.QC) {
 	if qc.View < hs.pm.GetCurView() {
 		return
 	}
-	if qc.Leader != hs.ID() {
+	// nobody signs the QC of view 0, every replica starts from it
+	if qc.Leader != hs.ID() && qc.View > 0 {
 		quorumIsVerified, _ := crypto.VerifyQuorumSignature(qc.AggSig, qc.BlockID, qc.Signers)
 		if !quorumIsVerified {
 			log.Warningf("[%v] received a quorum with invalid signatures", hs.ID())
@@ -229,6 +284,7 @@ func (hs *HotStuff) processCertificate(qc *blockchain.QC) {
 	if err != nil {
 		hs.bufferedQCs[qc.BlockID] = qc
 		log.Debugf("[%v] a qc is buffered, view: %v, id: %x", hs.ID(), qc.View, qc.BlockID)
+		hs.fetchMissing(qc.BlockID)
 		return
 	}
 	hs.pm.AdvanceView(qc.View)
@@ -240,6 +296,23 @@ func (hs *HotStuff) processCertificate(qc *blockchain.QC) {
 	if !ok {
 		return
 	}
+	hs.commit(block)
+}
+
+// commit commits the block together with its ancestors, the missing ones are fetched first
+func (hs *HotStuff) commit(block *blockchain.Block) {
+	missing, ok := hs.bc.MissingAncestor(block.ID)
+	if ok {
+		if hs.pendingCommit == nil || hs.pendingCommit.View < block.View {
+			hs.pendingCommit = block
+		}
+		hs.sync.request(missing, &blockchain.BlockRequest{From: hs.ID(), ID: missing})
+		hs.fetchRange()
+		return
+	}
+	if hs.pendingCommit != nil && hs.pendingCommit.View <= block.View {
+		hs.pendingCommit = nil
+	}
 	// forked blocks are found when pruning
 	committedBlocks, forkedBlocks, err := hs.bc.CommitBlock(block.ID, hs.pm.GetCurView())
 	if err != nil {
@@ -247,14 +320,29 @@ func (hs *HotStuff) processCertificate(qc *blockchain.QC) {
 		return
 	}
 	for _, cBlock := range committedBlocks {
+		hs.persistCommitted(cBlock)
 		hs.committedBlocks <- cBlock
 	}
+	var voted []blockchain.VotedBlock
+	for _, b := range hs.votedBlocks {
+		if b.View > block.View {
+			voted = append(voted, b)
+		} else if hs.st != nil {
+			if err := hs.st.Delete(votedBlockKey(b.ID)); err != nil {
+				log.Errorf("[%v] cannot remove the voted block %x from the store: %v", hs.ID(), b.ID, err)
+			}
+		}
+	}
+	hs.votedBlocks = voted
 	for _, fBlock := range forkedBlocks {
 		hs.forkedBlocks <- fBlock
 	}
 }
 
 func (hs *HotStuff) votingRule(block *blockchain.Block) (bool, error) {
+	if block.View <= hs.lastVotedView {
+		return false, nil
+	}
 	if block.View <= 2 {
 		return true, nil
 	}
@@ -262,7 +350,7 @@ func (hs *HotStuff) votingRule(block *blockchain.Block) (bool, error) {
 	if err != nil {
 		return false, fmt.Errorf("cannot vote for block: %w", err)
 	}
-	if (block.View <= hs.lastVotedView) || (parentBlock.View < hs.preferredView) {
+	if parentBlock.View < hs.preferredView {
 		return false, nil
 	}
 	return true, nil
@@ -305,6 +393,195 @@ func (hs *HotStuff) updatePreferredView(qc *blockchain.QC) error {
 	}
 	if grandParentBlock.View > hs.preferredView {
 		hs.preferredView = grandParentBlock.View
+		hs.saveVotingState()
+	}
+	return nil
+}
+
+// persistCommitted keeps a committed block together with its QC
+func (hs *HotStuff) persistCommitted(block *blockchain.Block) {
+	record := &blockchain.CommittedRecord{
+		Block: block,
+		QC:    hs.bc.GetQC(block.ID),
+	}
+	hs.committed.Add(record)
+	if hs.st == nil {
+		return
+	}
+	err := hs.st.Append(int(block.View), *record)
+	if err != nil {
+		log.Errorf("[%v] cannot persist the committed block, view: %v, id: %x: %v", hs.ID(), block.View, block.ID, err)
+	}
+}
+
+// saveVotedBlock keeps the block voted for until it is committed, the voting state has its id only
+func (hs *HotStuff) saveVotedBlock(block *blockchain.Block) {
+	if hs.st == nil {
+		return
+	}
+	err := hs.st.Put(votedBlockKey(block.ID), block)
+	if err != nil {
+		log.Fatalf("[%v] cannot persist the block voted for: %v", hs.ID(), err)
+	}
+}
+
+// saveVotingState has to be called before a vote is sent
+func (hs *HotStuff) saveVotingState() {
+	if hs.st == nil {
+		return
+	}
+	err := hs.st.Put(votingStateKey, &blockchain.VotingState{
+		LastVotedView: hs.lastVotedView,
+		PreferredView: hs.preferredView,
+		HighQC:        hs.GetHighQC(),
+		Voted:         hs.votedBlocks,
+	})
+	if err != nil {
+		log.Fatalf("[%v] cannot persist the voting state: %v", hs.ID(), err)
+	}
+}
+
+// restore resumes from the last committed block and the votes I sent before a restart
+func (hs *HotStuff) restore() error {
+	var state blockchain.VotingState
+	saved, err := hs.st.Load(votingStateKey, &state)
+	if err != nil {
+		return err
+	}
+	if saved {
+		hs.lastVotedView = state.LastVotedView
+		hs.preferredView = state.PreferredView
+		if state.HighQC != nil {
+			hs.highQC = state.HighQC
+		}
+	}
+	record, err := lastCommittedView(hs.st)
+	if err != nil {
+		return err
+	}
+	var proposed blockchain.Block
+	saved, err = hs.st.Load(proposalKey, &proposed)
+	if err != nil {
+		return err
+	}
+	view := hs.lastVotedView
+	if saved {
+		hs.proposed = &proposed
+		if proposed.View > view {
+			view = proposed.View
+		}
+	}
+	if record != nil {
+		hs.bc.Restore(record.Block)
+		hs.committed.Add(record)
+		for _, voted := range state.Voted {
+			if voted.View <= record.Block.View {
+				continue
+			}
+			// the peers may need the block
+			var block blockchain.Block
+			saved, err := hs.st.Load(votedBlockKey(voted.ID), &block)
+			if err != nil {
+				return err
+			}
+			if saved {
+				hs.bc.AddBlock(&block)
+			}
+			hs.votedBlocks = append(hs.votedBlocks, voted)
+		}
+		if record.Block.View > view {
+			view = record.Block.View
+		}
+		log.Infof("[%v] recovered at view %v, id: %x", hs.ID(), record.Block.View, record.Block.ID)
+	}
+	if view > 0 {
+		hs.pm.AdvanceView(view)
 	}
 	return nil
 }
+
+// fetchRange asks a peer for the committed blocks above the ones I have
+func (hs *HotStuff) fetchRange() {
+	from := types.View(hs.bc.GetHighestCommitted())
+	if hs.synced > from {
+		from = hs.synced
+	}
+	hs.sync.request(rangeKey, &blockchain.BlockRequest{From: hs.ID(), View: from + 1, Count: blockchain.MaxSyncCount})
+}
+
+// fetchMissing asks a peer for the block or for its first missing ancestor
+func (hs *HotStuff) fetchMissing(id crypto.Identifier) {
+	missing, ok := hs.bc.MissingAncestor(id)
+	if ok {
+		hs.sync.request(missing, &blockchain.BlockRequest{From: hs.ID(), ID: missing})
+	}
+}
+
+// ProcessBlockRequest sends the requested blocks I have, nothing if I have none of them
+func (hs *HotStuff) ProcessBlockRequest(request *blockchain.BlockRequest) {
+	response := &blockchain.BlockResponse{From: hs.ID(), ID: request.ID}
+	if request.Count > 0 {
+		records, err := committedRangeView(hs.committed, hs.st, request.View, request.Count)
+		if err != nil {
+			log.Errorf("[%v] cannot read the committed blocks from view %v: %v", hs.ID(), request.View, err)
+			return
+		}
+		response.Records = records
+	} else if record, ok := hs.committed.GetByID(request.ID); ok {
+		response.Records = []*blockchain.CommittedRecord{record}
+	} else if block, err := hs.bc.GetBlockByID(request.ID); err == nil {
+		response.Records = []*blockchain.CommittedRecord{{Block: block, QC: hs.bc.GetQC(block.ID)}}
+	}
+	if len(response.Records) == 0 {
+		return
+	}
+	log.Debugf("[%v] sends %v blocks to %v", hs.ID(), len(response.Records), request.From)
+	hs.Send(request.From, response)
+}
+
+// ProcessBlockResponse adds the fetched blocks, a requested block has to match the id referenced by a QC
+// or by its child and the blocks of a range have to come with their QCs
+func (hs *HotStuff) ProcessBlockResponse(response *blockchain.BlockResponse) {
+	if response.ID == (crypto.Identifier{}) {
+		hs.sync.done(rangeKey)
+	} else {
+		hs.sync.done(response.ID)
+	}
+	for _, record := range response.Records {
+		block := record.Block
+		if block == nil || !block.VerifyID() {
+			log.Warningf("[%v] received an invalid block from %v", hs.ID(), response.From)
+			return
+		}
+		if response.ID != block.ID && !record.Certified(config.GetConfig().N) {
+			log.Warningf("[%v] received a block without a valid QC from %v", hs.ID(), response.From)
+			return
+		}
+		if response.ID != block.ID && block.View > hs.synced {
+			hs.synced = block.View
+		}
+		if int(block.View) <= hs.bc.GetHighestCommitted() || hs.bc.Exists(block.ID) {
+			continue
+		}
+		if !hs.Election.IsLeaderView(block.Proposer, block.View) {
+			log.Warningf("[%v] received a block (view %v) from an invalid leader (%v)", hs.ID(), block.View, block.Proposer)
+			return
+		}
+		if ok, _ := crypto.PubVerify(block.Sig, crypto.IDToByte(block.ID), block.Proposer); !ok {
+			log.Warningf("[%v] received a block (view %v) with an invalid signature of %v", hs.ID(), block.View, block.Proposer)
+			return
+		}
+		hs.bc.AddBlock(block)
+		if block.QC != nil {
+			hs.bc.AddQC(block.QC)
+		}
+		qc, ok := hs.bufferedQCs[block.ID]
+		if ok {
+			delete(hs.bufferedQCs, block.ID)
+			hs.processCertificate(qc)
+		}
+	}
+	if hs.pendingCommit != nil {
+		hs.commit(hs.pendingCommit)
+	}
+}
@@ -5,12 +5,13 @@ import (
 	"banyan/config"
 	"banyan/crypto"
 	"banyan/election"
+	"banyan/evidence"
 	"banyan/local_timeout"
 	"banyan/log"
+	"banyan/mempool"
 	"banyan/node"
+	"banyan/store"
 	"fmt"
-	"math/rand"
-	"time"
 )
 
 type Icc struct {
@@ -18,20 +19,25 @@ type Icc struct {
 	election.Election
 	bc               *blockchain.BlockChain // all blocks I have
 	lt               *local_timeout.LocalTimeout
-	nSharesBag       *blockchain.NSharesBag    // notarization shares I've collected
-	fSharesBag       *blockchain.FSharesBag    // finalization shares I've collected
-	headHeight       int                       // highest notarized block height
-	headId           crypto.Identifier         // id of the head
-	sentNSharesNo    map[int]int               // how many notarization shares have I sent for blocks on this height?
-	sentNShareId     map[int]crypto.Identifier // what is the id of the (some) block for which I sent a notarization share on this height?
-	sentFShare       map[int]struct{}          // have I sent a finalization share for this height?
+	mp               *mempool.MemPool        // transactions waiting to be proposed
+	nSharesBag       *blockchain.NSharesBag  // notarization shares I've collected
+	fSharesBag       *blockchain.FSharesBag  // finalization shares I've collected
+	headHeight       int                     // highest notarized block height
+	headId           crypto.Identifier       // id of the head
+	proposed         *blockchain.Block       // the last block I proposed
+	resend           map[int]struct{}        // heights I voted on before a restart, the shares may not have been delivered
+	voted            *blockchain.VotingState // the shares I have sent and the blocks I have proposed, per height
 	isNotarized      map[crypto.Identifier]struct{}
 	isFinalized      map[crypto.Identifier]struct{}
 	lastShippedBlock crypto.Identifier
+	shippedHeight    int
+	st               *store.Store               // nil if nothing is persisted
+	evidence         *evidence.Collector        // equivocations of the other replicas
+	committed        *blockchain.CommittedCache // recently committed blocks, served to the peers that are catching up
+	sync             *syncer
 	shipQueue        map[crypto.Identifier]struct{}
-	committedBlocks  chan *blockchain.Block
+	committedBlocks  chan *blockchain.CommittedRecord
 	forkedBlocks     chan *blockchain.Block
-	rand             *rand.Rand
 	echoedBlock      map[crypto.Identifier]struct{}
 }
 
@@ -39,28 +45,40 @@ func NewIcc(
 	node node.Node,
 	elec election.Election,
 	lt *local_timeout.LocalTimeout,
-	committedBlocks chan *blockchain.Block,
+	mp *mempool.MemPool,
+	st *store.Store,
+	ev *evidence.Collector,
+	committedBlocks chan *blockchain.CommittedRecord,
 	forkedBlocks chan *blockchain.Block) *Icc {
 	icc := new(Icc)
 	icc.Node = node
 	icc.Election = elec
 	icc.bc = blockchain.NewBlockchain(config.GetConfig().N)
 	icc.lt = lt
+	icc.mp = mp
 	icc.nSharesBag = blockchain.NewNSharesBag(config.GetConfig().N)
 	icc.fSharesBag = blockchain.NewFSharesBag(config.GetConfig().N)
 	icc.headHeight = 0
 	icc.headId = crypto.MakeID("genesis")
-	icc.sentNSharesNo = make(map[int]int)
-	icc.sentNShareId = make(map[int]crypto.Identifier)
-	icc.sentFShare = make(map[int]struct{})
+	icc.voted = blockchain.NewVotingState()
+	icc.resend = make(map[int]struct{})
 	icc.isNotarized = make(map[crypto.Identifier]struct{})
 	icc.isFinalized = make(map[crypto.Identifier]struct{})
 	icc.lastShippedBlock = crypto.MakeID("genesis")
 	icc.shipQueue = make(map[crypto.Identifier]struct{})
 	icc.committedBlocks = committedBlocks
 	icc.forkedBlocks = forkedBlocks
-	icc.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
 	icc.echoedBlock = make(map[crypto.Identifier]struct{}, 10000)
+	icc.committed = blockchain.NewCommittedCache(committedCacheSize)
+	icc.sync = newSyncer(node)
+	icc.st = st
+	icc.evidence = ev
+	if st != nil {
+		err := icc.restore()
+		if err != nil {
+			log.Fatalf("[%v] cannot recover from the store: %v", icc.ID(), err)
+		}
+	}
 
 	return icc
 }
@@ -76,11 +94,18 @@ func (icc *Icc) ProcessBlock(block *blockchain.Block) error {
 		return fmt.Errorf("received a proposal (height %v) from an invalid leader (%v)", block.Height, block.Proposer)
 	}
 	if block.Proposer != icc.ID() {
+		if !block.VerifyID() {
+			return fmt.Errorf("received a block (height %v) whose id does not match its content", block.Height)
+		}
 		blockIsVerified, _ := crypto.PubVerify(block.Sig, crypto.IDToByte(block.ID), block.Proposer)
 		if !blockIsVerified {
-			log.Warningf("[%v] received a block with an invalid signature", icc.ID())
+			return fmt.Errorf("received a block (height %v) with an invalid signature of %v", block.Height, block.Proposer)
 		}
 	}
+	icc.evidence.Block(block)
+	if !icc.extendsPreviousHeight(block) {
+		return fmt.Errorf("received a proposal (height %v) whose parent is not on the previous height", block.Height)
+	}
 
 	// add a new block!
 	_, exists := icc.echoedBlock[block.ID]
@@ -97,20 +122,27 @@ func (icc *Icc) ProcessBlock(block *blockchain.Block) error {
 	}
 
 	// should I send a notarization share?
-	if icc.headHeight < block.Height {
+	if _, lost := icc.resend[block.Height]; lost && (icc.voted.SentNShareId[block.Height] == block.ID) {
+		// I voted for this block before a restart, the shares may not have been delivered
+		icc.resendShares(block)
+	} else if icc.headHeight < block.Height && !icc.voted.SharedRank(block.Height, block.Rank) {
+		// a second block of the same rank comes from an equivocating leader, sharing it would be an equivocation too
 		notarizationShare := blockchain.MakeNShare(block.Height, block.Rank, icc.ID(), block.ID)
-		icc.sentNSharesNo[block.Height] += 1
-		icc.sentNShareId[block.Height] = block.ID
+		icc.voted.SentNSharesNo[block.Height] += 1
+		icc.voted.ShareRank(block.Height, block.Rank)
+		icc.voted.SentNShareId[block.Height] = block.ID
+		icc.saveVotingState()
 		icc.Broadcast(notarizationShare)
 		icc.ProcessNotarizationShare(notarizationShare)
 	}
 
 	// should I send a finalization share?
 	_, isN := icc.isNotarized[block.ID]
-	_, sentF := icc.sentFShare[block.Height]
-	if isN && (!sentF) && (icc.sentNSharesNo[block.Height] == 1) && (icc.sentNShareId[block.Height] == block.ID) {
+	sentF := icc.voted.SentFShare[block.Height]
+	if isN && (!sentF) && (icc.voted.SentNSharesNo[block.Height] == 1) && (icc.voted.SentNShareId[block.Height] == block.ID) {
 		finalizationShare := blockchain.MakeFShare(block.Height, block.Rank, icc.ID(), block.ID)
-		icc.sentFShare[block.Height] = struct{}{}
+		icc.voted.SentFShare[block.Height] = true
+		icc.saveVotingState()
 		icc.Broadcast(finalizationShare)
 		icc.ProcessFinalizationShare(finalizationShare)
 	}
@@ -118,32 +150,90 @@ func (icc *Icc) ProcessBlock(block *blockchain.Block) error {
 	return nil
 }
 
+// extendsPreviousHeight checks the parent of a proposal if I have it, a block extends a block of the previous height
+func (icc *Icc) extendsPreviousHeight(block *blockchain.Block) bool {
+	if block.Height-1 == icc.shippedHeight {
+		return block.PrevID == icc.lastShippedBlock
+	}
+	parent, err := icc.bc.GetBlockByID(block.PrevID)
+	return err != nil || parent.Height == block.Height-1
+}
+
 func (icc *Icc) TryToShip(id crypto.Identifier) {
-	if icc.bc.Exists(id) {
-		block, _ := icc.bc.GetBlockByID(id)
-		if icc.lastShippedBlock == block.PrevID {
-			// commiting the block
-			committed, forked, err := icc.bc.CommitBlock(id, block.Height)
-			if err != nil {
-				log.Errorf("[%v] cannot commit blocks, %w", icc.ID(), err)
-				return
-			}
-			for _, cBlock := range committed {
-				icc.committedBlocks <- cBlock
-			}
-			for _, fBlock := range forked {
-				icc.forkedBlocks <- fBlock
-			}
-
-			icc.lastShippedBlock = id
+	block, err := icc.bc.GetBlockByID(id)
+	if err != nil {
+		// the finalized block is missing, ask a peer for it
+		icc.shipQueue[id] = struct{}{}
+		icc.sync.request(id, &blockchain.BlockRequest{From: icc.ID(), ID: id})
+		return
+	}
+	if block.Height <= icc.shippedHeight {
+		delete(icc.shipQueue, id)
+		return
+	}
+	// the ancestors of a finalized block are committed with it, all of them have to be here
+	for ancestor := block; ancestor.PrevID != icc.lastShippedBlock; {
+		parent, err := icc.bc.GetBlockByID(ancestor.PrevID)
+		// the parent of a block on the height after the last committed one has to be the last committed block
+		if ancestor.Height-1 <= icc.shippedHeight || (err == nil && parent.Height <= icc.shippedHeight) {
+			log.Errorf("[%v] finalized block %x does not extend the committed chain", icc.ID(), id)
 			delete(icc.shipQueue, id)
+			return
+		}
+		if err != nil {
+			icc.shipQueue[id] = struct{}{}
+			icc.fetchAncestors(ancestor)
+			return
+		}
+		ancestor = parent
+	}
+
+	// commiting the block
+	committed, forked, err := icc.bc.CommitBlock(id, block.Height)
+	if err != nil {
+		log.Errorf("[%v] cannot commit blocks, %w", icc.ID(), err)
+		return
+	}
+	for _, cBlock := range committed {
+		icc.committedBlocks <- icc.persistCommitted(cBlock)
+	}
+	for _, fBlock := range forked {
+		icc.forkedBlocks <- fBlock
+	}
 
-			for queued := range icc.shipQueue {
-				icc.TryToShip(queued)
-			}
+	icc.lastShippedBlock = id
+	icc.shippedHeight = block.Height
+	icc.voted.Prune(block.Height)
+	for height := range icc.resend {
+		if height <= block.Height {
+			delete(icc.resend, height)
 		}
-	} else {
-		icc.shipQueue[id] = struct{}{}
+	}
+	delete(icc.shipQueue, id)
+	if icc.headHeight < block.Height {
+		// I was catching up
+		icc.isNotarized[id] = struct{}{}
+		icc.headHeight = block.Height
+		icc.headId = id
+		icc.lt.HeightIncreased(block.Height + 1)
+	}
+
+	for queued := range icc.shipQueue {
+		icc.TryToShip(queued)
+	}
+}
+
+// fetchAncestors asks a peer for the missing parent of the block,
+// and for the committed blocks in between if more than one may be missing
+func (icc *Icc) fetchAncestors(block *blockchain.Block) {
+	icc.sync.request(block.PrevID, &blockchain.BlockRequest{From: icc.ID(), ID: block.PrevID})
+	missing := block.Height - 1 - icc.shippedHeight
+	if missing > 1 {
+		icc.sync.request(rangeKey, &blockchain.BlockRequest{
+			From:   icc.ID(),
+			Height: icc.shippedHeight + 1,
+			Count:  missing,
+		})
 	}
 }
 
@@ -153,7 +243,7 @@ func (icc *Icc) ProcessNotarizationShare(ns *blockchain.NotarizationShare) {
 		return
 	}
 	log.Debugf("[%v] is processing NS from [%v], block id: %x", icc.ID(), ns.Voter, ns.BlockID)
-	if ns.Voter != icc.ID() {
+	if ns.Voter != icc.ID() && !ns.IsVerified() {
 		voteIsVerified, err := crypto.PubVerify(ns.Signature, crypto.IDToByte(ns.BlockID), ns.Voter)
 		if err != nil {
 			log.Fatalf("[%v] Error in verifying the signature in vote id: %x", icc.ID(), ns.BlockID)
@@ -164,23 +254,30 @@ func (icc *Icc) ProcessNotarizationShare(ns *blockchain.NotarizationShare) {
 			return
 		}
 	}
-	isBuilt := icc.nSharesBag.Add(ns)
+	if first := icc.nSharesBag.Conflict(ns); first != nil {
+		icc.evidence.Notarization(first, ns)
+	}
+	isBuilt, notarization := icc.nSharesBag.Add(ns)
 	if !isBuilt {
 		return
 	}
 
 	// block is notarized!
 	icc.isNotarized[ns.BlockID] = struct{}{}
+	if notarization != nil {
+		icc.bc.AddNotarization(notarization)
+	}
 	if icc.headHeight < ns.Height {
 		icc.headHeight = ns.Height
 		icc.headId = ns.BlockID
 		icc.lt.HeightIncreased(ns.Height + 1)
 	}
 
-	_, sentF := icc.sentFShare[ns.Height]
-	if (!sentF) && (icc.sentNSharesNo[ns.Height] == 1) && (icc.sentNShareId[ns.Height] == ns.BlockID) {
+	sentF := icc.voted.SentFShare[ns.Height]
+	if (!sentF) && (icc.voted.SentNSharesNo[ns.Height] == 1) && (icc.voted.SentNShareId[ns.Height] == ns.BlockID) {
 		finalizationShare := blockchain.MakeFShare(ns.Height, ns.Rank, icc.ID(), ns.BlockID)
-		icc.sentFShare[ns.Height] = struct{}{}
+		icc.voted.SentFShare[ns.Height] = true
+		icc.saveVotingState()
 		icc.Broadcast(finalizationShare)
 		icc.ProcessFinalizationShare(finalizationShare)
 	}
@@ -192,8 +289,8 @@ func (icc *Icc) ProcessFinalizationShare(fs *blockchain.FinalizationShare) {
 		return
 	}
 	log.Debugf("[%v] is processing FS, block id: %x", icc.ID(), fs.BlockID)
-	if fs.Voter != icc.ID() {
-		voteIsVerified, err := crypto.PubVerify(fs.Signature, crypto.IDToByte(fs.BlockID), fs.Voter)
+	if fs.Voter != icc.ID() && !fs.IsVerified() {
+		voteIsVerified, err := crypto.PubVerify(fs.Signature, crypto.IDToByte(blockchain.FinalizeID(fs.BlockID)), fs.Voter)
 		if err != nil {
 			log.Fatalf("[%v] Error in verifying the signature in vote id: %x", icc.ID(), fs.BlockID)
 			return
@@ -203,18 +300,194 @@ func (icc *Icc) ProcessFinalizationShare(fs *blockchain.FinalizationShare) {
 			return
 		}
 	}
-	isBuilt := icc.fSharesBag.Add(fs)
+	if first := icc.fSharesBag.Conflict(fs); first != nil {
+		icc.evidence.Finalization(first, fs)
+	}
+	isBuilt, finalization := icc.fSharesBag.Add(fs)
 	if !isBuilt {
 		return
 	}
 
 	// block is finalized!
 	icc.isFinalized[fs.BlockID] = struct{}{}
+	if finalization != nil {
+		icc.bc.AddFinalization(finalization)
+	}
 	icc.TryToShip(fs.BlockID)
 }
 
+// MakeProposal proposes the same block again if I have already proposed on this height with this rank,
+// e.g., before a restart, and returns nil if I have proposed with a higher rank
 func (icc *Icc) MakeProposal(height int, rank int, payloadSize int) *blockchain.Block {
+	proposed := icc.proposed
+	if proposed != nil && ((proposed.Height > height) || (proposed.Height == height && proposed.Rank >= rank)) {
+		if proposed.Height == height && proposed.Rank == rank {
+			return proposed
+		}
+		return nil
+	}
 	prevID := icc.headId
-	block := blockchain.MakeBlock(height, rank, prevID, icc.ID(), payloadSize, icc.rand)
+	payload := icc.mp.Batch(payloadSize)
+	block := blockchain.MakeBlock(height, rank, prevID, icc.ID(), payload)
+	icc.proposed = block
+	if icc.st != nil {
+		err := icc.st.Put(proposalKey, block)
+		if err != nil {
+			log.Fatalf("[%v] cannot persist the proposal: %v", icc.ID(), err)
+		}
+	}
 	return block
 }
+
+// persistCommitted keeps a committed block together with the certificates and the shares that notarized and finalized it,
+// the record is returned to be passed on to the replica
+func (icc *Icc) persistCommitted(block *blockchain.Block) *blockchain.CommittedRecord {
+	record := &blockchain.CommittedRecord{
+		Block:              block,
+		Notarization:       icc.bc.GetNotarization(block.ID),
+		Finalization:       icc.bc.GetFinalization(block.ID),
+		NotarizationShares: icc.nSharesBag.Shares(block.ID),
+		FinalizationShares: icc.fSharesBag.Shares(block.ID),
+	}
+	if _, isF := icc.isFinalized[block.ID]; !isF {
+		record.Path = blockchain.ImplicitPath
+	}
+	icc.committed.Add(record)
+	if icc.st == nil {
+		return record
+	}
+	err := icc.st.Append(block.Height, *record)
+	if err != nil {
+		log.Errorf("[%v] cannot persist the committed block, height: %v, id: %x: %v", icc.ID(), block.Height, block.ID, err)
+	}
+	return record
+}
+
+// saveVotingState has to be called before a share is sent
+func (icc *Icc) saveVotingState() {
+	if icc.st == nil {
+		return
+	}
+	err := icc.st.Put(votingStateKey, icc.voted)
+	if err != nil {
+		log.Fatalf("[%v] cannot persist the voting state: %v", icc.ID(), err)
+	}
+}
+
+// restore resumes from the last committed block and the shares I sent before a restart
+func (icc *Icc) restore() error {
+	_, err := icc.st.Load(votingStateKey, icc.voted)
+	if err != nil {
+		return err
+	}
+	for height := range icc.voted.SentNSharesNo {
+		icc.resend[height] = struct{}{}
+	}
+	var proposed blockchain.Block
+	saved, err := icc.st.Load(proposalKey, &proposed)
+	if err != nil {
+		return err
+	}
+	if saved {
+		icc.proposed = &proposed
+	}
+	record, err := lastCommitted(icc.st)
+	if err != nil || record == nil {
+		return err
+	}
+	block := record.Block
+	icc.bc.Restore(block)
+	icc.headHeight = block.Height
+	icc.headId = block.ID
+	icc.isNotarized[block.ID] = struct{}{}
+	icc.isFinalized[block.ID] = struct{}{}
+	icc.lastShippedBlock = block.ID
+	icc.shippedHeight = block.Height
+	icc.committed.Add(record)
+	icc.lt.Restore(block.Height + 1)
+	log.Infof("[%v] recovered at height %v, id: %x", icc.ID(), block.Height, block.ID)
+	return nil
+}
+
+// resendShares sends again the shares I sent for the block before a restart
+func (icc *Icc) resendShares(block *blockchain.Block) {
+	delete(icc.resend, block.Height)
+	notarizationShare := blockchain.MakeNShare(block.Height, block.Rank, icc.ID(), block.ID)
+	icc.Broadcast(notarizationShare)
+	icc.ProcessNotarizationShare(notarizationShare)
+	if icc.voted.SentFShare[block.Height] {
+		finalizationShare := blockchain.MakeFShare(block.Height, block.Rank, icc.ID(), block.ID)
+		icc.Broadcast(finalizationShare)
+		icc.ProcessFinalizationShare(finalizationShare)
+	}
+}
+
+// ProcessBlockRequest sends the requested blocks I have, nothing if I have none of them
+func (icc *Icc) ProcessBlockRequest(request *blockchain.BlockRequest) {
+	response := &blockchain.BlockResponse{From: icc.ID(), ID: request.ID}
+	if request.Count > 0 {
+		records, err := committedRange(icc.committed, icc.st, request.Height, request.Count)
+		if err != nil {
+			log.Errorf("[%v] cannot read the committed blocks from height %v: %v", icc.ID(), request.Height, err)
+			return
+		}
+		response.Records = records
+	} else if record, ok := icc.committed.GetByID(request.ID); ok {
+		response.Records = []*blockchain.CommittedRecord{record}
+	} else if block, err := icc.bc.GetBlockByID(request.ID); err == nil {
+		response.Records = []*blockchain.CommittedRecord{{
+			Block:        block,
+			Notarization: icc.bc.GetNotarization(block.ID),
+			Finalization: icc.bc.GetFinalization(block.ID),
+		}}
+	}
+	if len(response.Records) == 0 {
+		return
+	}
+	log.Debugf("[%v] sends %v blocks to %v", icc.ID(), len(response.Records), request.From)
+	icc.Send(request.From, response)
+}
+
+// ProcessBlockResponse adds the fetched blocks, the ones that come with a valid finalization are shipped
+// together with their ancestors
+func (icc *Icc) ProcessBlockResponse(response *blockchain.BlockResponse) {
+	if response.ID == (crypto.Identifier{}) {
+		icc.sync.done(rangeKey)
+	} else {
+		icc.sync.done(response.ID)
+	}
+	for _, record := range response.Records {
+		block := record.Block
+		if block == nil || !block.VerifyID() {
+			log.Warningf("[%v] received an invalid block from %v", icc.ID(), response.From)
+			return
+		}
+		if block.Height <= icc.shippedHeight {
+			// a finalized block I was waiting for on a height I have committed already, it is not coming
+			delete(icc.shipQueue, block.ID)
+			continue
+		}
+		if !icc.Election.IsLeader(block.Proposer, block.Height, block.Rank) {
+			log.Warningf("[%v] received a block (height %v) from an invalid leader (%v)", icc.ID(), block.Height, block.Proposer)
+			return
+		}
+		if ok, _ := crypto.PubVerify(block.Sig, crypto.IDToByte(block.ID), block.Proposer); !ok {
+			log.Warningf("[%v] received a block (height %v) with an invalid signature of %v", icc.ID(), block.Height, block.Proposer)
+			return
+		}
+		keepCertificates(icc.bc, record, blockchain.SuperMajority(config.GetConfig().N), 0)
+		if _, isF := icc.isFinalized[block.ID]; !isF && record.Finalized(config.GetConfig().N, 0) {
+			icc.isFinalized[block.ID] = struct{}{}
+			icc.shipQueue[block.ID] = struct{}{}
+		}
+		if block.ID == response.ID && block.Height > icc.headHeight {
+			// a proposal I missed, it is processed as if it was delivered late
+			_ = icc.ProcessBlock(block)
+		} else {
+			icc.bc.AddBlock(block)
+		}
+	}
+	for queued := range icc.shipQueue {
+		icc.TryToShip(queued)
+	}
+}
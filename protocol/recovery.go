@@ -0,0 +1,47 @@
+package protocol
+
+import (
+	"fmt"
+
+	"banyan/blockchain"
+	view "banyan/blockchain_view"
+	"banyan/crypto"
+	"banyan/store"
+)
+
+// names of the protocol state kept in the store
+const (
+	votingStateKey = "voting"
+	proposalKey    = "proposal"
+)
+
+// votedBlockKey names a block voted for and not committed yet, each one is written once
+func votedBlockKey(id crypto.Identifier) string {
+	return fmt.Sprintf("voted-%x", id)
+}
+
+// lastCommitted returns the last block committed before a restart, nil if nothing was committed
+func lastCommitted(st *store.Store) (*blockchain.CommittedRecord, error) {
+	v, ok, err := st.Last()
+	if err != nil || !ok {
+		return nil, err
+	}
+	record, ok := v.(blockchain.CommittedRecord)
+	if !ok {
+		return nil, fmt.Errorf("unexpected record in the store: %T", v)
+	}
+	return &record, nil
+}
+
+// lastCommittedView returns the last block committed before a restart, nil if nothing was committed
+func lastCommittedView(st *store.Store) (*view.CommittedRecord, error) {
+	v, ok, err := st.Last()
+	if err != nil || !ok {
+		return nil, err
+	}
+	record, ok := v.(view.CommittedRecord)
+	if !ok {
+		return nil, fmt.Errorf("unexpected record in the store: %T", v)
+	}
+	return &record, nil
+}
@@ -2,16 +2,17 @@ package protocol
 
 import (
 	"fmt"
-	"math/rand"
-	"time"
 
 	blockchain "banyan/blockchain_view"
 	"banyan/config"
 	"banyan/crypto"
 	"banyan/election"
+	"banyan/evidence"
 	"banyan/log"
+	"banyan/mempool"
 	"banyan/node"
 	"banyan/pacemaker"
+	"banyan/store"
 	"banyan/types"
 )
 
@@ -19,6 +20,7 @@ type Streamlet struct {
 	node.Node
 	election.Election
 	pm                     *pacemaker.Pacemaker
+	mp                     *mempool.MemPool
 	bc                     *blockchain.BlockChain
 	notarizedChain         [][]*blockchain.Block
 	bufferedBlocks         map[crypto.Identifier]*blockchain.Block
@@ -28,7 +30,13 @@ type Streamlet struct {
 	forkedBlocks           chan *blockchain.Block
 	echoedBlock            map[crypto.Identifier]struct{}
 	echoedVote             map[crypto.Identifier]struct{}
-	rand                   *rand.Rand
+	lastVotedView          types.View
+	proposed               *blockchain.Block          // the last block I proposed
+	st                     *store.Store               // nil if nothing is persisted
+	evidence               *evidence.Collector        // equivocations of the other replicas
+	committed              *blockchain.CommittedCache // recently committed blocks, served to the peers that are catching up
+	sync                   *syncer
+	synced                 types.View // the highest view fetched in the range mode
 }
 
 // NewStreamlet creates a new Streamlet instance
@@ -36,23 +44,39 @@ func NewStreamlet(
 	node node.Node,
 	pm *pacemaker.Pacemaker,
 	elec election.Election,
+	mp *mempool.MemPool,
+	st *store.Store,
+	ev *evidence.Collector,
 	committedBlocks chan *blockchain.Block,
 	forkedBlocks chan *blockchain.Block) *Streamlet {
 	sl := new(Streamlet)
 	sl.Node = node
 	sl.Election = elec
 	sl.pm = pm
+	sl.mp = mp
 	sl.committedBlocks = committedBlocks
 	sl.forkedBlocks = forkedBlocks
 	sl.bc = blockchain.NewBlockchain(config.GetConfig().N)
+	sl.bc.SetGenesis(crypto.MakeID("Genesis block"))
 	sl.bufferedBlocks = make(map[crypto.Identifier]*blockchain.Block)
 	sl.bufferedQCs = make(map[crypto.Identifier]*blockchain.QC)
 	sl.bufferedNotarizedBlock = make(map[crypto.Identifier]*blockchain.QC)
 	sl.notarizedChain = make([][]*blockchain.Block, 0)
 	sl.echoedBlock = make(map[crypto.Identifier]struct{}, 10000)
 	sl.echoedVote = make(map[crypto.Identifier]struct{})
-	sl.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
-	sl.pm.AdvanceView(0)
+	sl.committed = blockchain.NewCommittedCache(committedCacheSize)
+	sl.sync = newSyncer(node)
+	sl.st = st
+	sl.evidence = ev
+	if st != nil {
+		err := sl.restore()
+		if err != nil {
+			log.Fatalf("[%v] cannot recover from the store: %v", sl.ID(), err)
+		}
+	}
+	if sl.pm.GetCurView() == 0 {
+		sl.pm.AdvanceView(0)
+	}
 	return sl
 }
 
@@ -73,22 +97,27 @@ func (sl *Streamlet) ProcessBlock(block *blockchain.Block) error {
 	if block.View < curView {
 		return fmt.Errorf("received a stale block")
 	}
+	if block.Proposer != sl.ID() {
+		if !block.VerifyID() {
+			return fmt.Errorf("received a block (view %v) whose id does not match its content", block.View)
+		}
+		blockIsVerified, _ := crypto.PubVerify(block.Sig, crypto.IDToByte(block.ID), block.Proposer)
+		if !blockIsVerified {
+			return fmt.Errorf("received a block (view %v) with an invalid signature of %v", block.View, block.Proposer)
+		}
+	}
 	_, err := sl.bc.GetBlockByID(block.PrevID)
 	if err != nil && block.View > 1 {
 		// buffer future blocks
 		sl.bufferedBlocks[block.PrevID] = block
 		log.Debugf("[%v] buffer the block for future processing, view: %v, id: %x", sl.ID(), block.View, block.ID)
+		sl.sync.request(block.PrevID, &blockchain.BlockRequest{From: sl.ID(), ID: block.PrevID})
 		return nil
 	}
 	if !sl.Election.IsLeaderView(block.Proposer, block.View) {
 		return fmt.Errorf("received a proposal (%v) from an invalid leader (%v)", block.View, block.Proposer)
 	}
-	if block.Proposer != sl.ID() {
-		blockIsVerified, _ := crypto.PubVerify(block.Sig, crypto.IDToByte(block.ID), block.Proposer)
-		if !blockIsVerified {
-			log.Warningf("[%v] received a block with an invalid signature", sl.ID())
-		}
-	}
+	sl.evidence.ViewBlock(block)
 	_, exists := sl.echoedBlock[block.ID]
 	if !exists {
 		sl.echoedBlock[block.ID] = struct{}{}
@@ -102,6 +131,13 @@ func (sl *Streamlet) ProcessBlock(block *blockchain.Block) error {
 		log.Debugf("[%v] buffer the block for future processing, view: %v, id: %x", sl.ID(), block.View, block.ID)
 		return nil
 	}
+	// vote only for the first proposal of a view
+	if block.View <= sl.lastVotedView {
+		log.Debugf("[%v] has already voted in view %v, id: %x", sl.ID(), block.View, block.ID)
+		return nil
+	}
+	sl.lastVotedView = block.View
+	sl.saveVotingState()
 	vote := blockchain.MakeVote(block.View, sl.ID(), block.ID)
 	// vote to the current leader
 	sl.ProcessVote(vote)
@@ -121,7 +157,7 @@ func (sl *Streamlet) ProcessBlock(block *blockchain.Block) error {
 
 func (sl *Streamlet) ProcessVote(vote *blockchain.Vote) {
 	log.Debugf("[%v] is processing the vote, block id: %x", sl.ID(), vote.BlockID)
-	if vote.Voter != sl.ID() {
+	if vote.Voter != sl.ID() && !vote.IsVerified() {
 		voteIsVerified, err := crypto.PubVerify(vote.Signature, crypto.IDToByte(vote.BlockID), vote.Voter)
 		if err != nil {
 			log.Fatalf("[%v] Error in verifying the signature in vote id: %x", sl.ID(), vote.BlockID)
@@ -138,6 +174,9 @@ func (sl *Streamlet) ProcessVote(vote *blockchain.Vote) {
 		sl.echoedBlock[vote.BlockID] = struct{}{}
 		sl.Broadcast(vote)
 	}
+	if first := sl.bc.VoteConflict(vote); first != nil {
+		sl.evidence.Vote(first, vote)
+	}
 	isBuilt, qc := sl.bc.AddVote(vote)
 	if !isBuilt {
 		log.Debugf("[%v] votes are not sufficient to build a qc, view: %v, block id: %x", sl.ID(), vote.View, vote.BlockID)
@@ -170,14 +209,30 @@ func (sl *Streamlet) ProcessLocalTmo(view types.View) {
 	sl.ProcessRemoteTmo(tmo)
 }
 
+// MakeProposal proposes the same block again if I have already proposed in this view,
+// e.g., before a restart, and returns nil if I have proposed in a higher view
 func (sl *Streamlet) MakeProposal(view types.View, payloadSize int) *blockchain.Block {
+	if sl.proposed != nil && sl.proposed.View >= view {
+		if sl.proposed.View == view {
+			return sl.proposed
+		}
+		return nil
+	}
 	prevID := sl.forkChoice()
+	payload := sl.mp.Batch(payloadSize)
 	block := blockchain.MakeBlock(view, &blockchain.QC{
 		View:      0,
 		BlockID:   prevID,
 		AggSig:    nil,
 		Signature: nil,
-	}, prevID, sl.ID(), payloadSize, sl.rand)
+	}, prevID, sl.ID(), payload)
+	sl.proposed = block
+	if sl.st != nil {
+		err := sl.st.Put(proposalKey, block)
+		if err != nil {
+			log.Fatalf("[%v] cannot persist the proposal: %v", sl.ID(), err)
+		}
+	}
 	return block
 }
 
@@ -196,7 +251,7 @@ func (sl *Streamlet) processTC(tc *pacemaker.TC) {
 	if tc.View < sl.pm.GetCurView() {
 		return
 	}
-	go sl.pm.AdvanceView(tc.View)
+	sl.pm.AdvanceView(tc.View)
 }
 
 // 1. advance view
@@ -212,6 +267,7 @@ func (sl *Streamlet) processCertificate(qc *blockchain.QC) {
 	if err != nil && qc.View > 1 {
 		log.Debugf("[%v] buffered the QC, view: %v, id: %x", sl.ID(), qc.View, qc.BlockID)
 		sl.bufferedQCs[qc.BlockID] = qc
+		sl.sync.request(qc.BlockID, &blockchain.BlockRequest{From: sl.ID(), ID: qc.BlockID})
 		return
 	}
 	if qc.Leader != sl.ID() {
@@ -231,16 +287,42 @@ func (sl *Streamlet) processCertificate(qc *blockchain.QC) {
 	if qc.View < 3 {
 		return
 	}
-	ok, block := sl.commitRule()
-	if !ok {
+	if !sl.commit() {
 		return
 	}
+	b, ok := sl.bufferedBlocks[qc.BlockID]
+	if ok {
+		log.Debugf("[%v] found a buffered block by qc, qc.BlockID: %x", sl.ID(), qc.BlockID)
+		_ = sl.ProcessBlock(b)
+		delete(sl.bufferedBlocks, qc.BlockID)
+	}
+	qc, ok = sl.bufferedNotarizedBlock[qc.BlockID]
+	if ok {
+		log.Debugf("[%v] found a bufferred qc, view: %v, block id: %x", sl.ID(), qc.View, qc.BlockID)
+		sl.processCertificate(qc)
+		delete(sl.bufferedQCs, qc.BlockID)
+	}
+}
+
+// commit commits the middle one of the last three notarized blocks if their views are consecutive
+func (sl *Streamlet) commit() bool {
+	ok, block := sl.commitRule()
+	if !ok || int(block.View) <= sl.bc.GetHighestCommitted() {
+		return false
+	}
+	missing, ok := sl.bc.MissingAncestor(block.ID)
+	if ok {
+		sl.sync.request(missing, &blockchain.BlockRequest{From: sl.ID(), ID: missing})
+		sl.fetchRange()
+		return false
+	}
 	committedBlocks, forkedBlocks, err := sl.bc.CommitBlock(block.ID, sl.pm.GetCurView())
 	if err != nil {
 		log.Errorf("[%v] cannot commit blocks", sl.ID())
-		return
+		return false
 	}
 	for _, cBlock := range committedBlocks {
+		sl.persistCommitted(cBlock)
 		sl.committedBlocks <- cBlock
 		delete(sl.echoedBlock, cBlock.ID)
 		delete(sl.echoedVote, cBlock.ID)
@@ -250,18 +332,7 @@ func (sl *Streamlet) processCertificate(qc *blockchain.QC) {
 		sl.forkedBlocks <- fBlock
 		log.Debugf("[%v] is going to collect forked block, view: %v, id: %x", sl.ID(), fBlock.View, fBlock.ID)
 	}
-	b, ok := sl.bufferedBlocks[qc.BlockID]
-	if ok {
-		log.Debugf("[%v] found a buffered block by qc, qc.BlockID: %x", sl.ID(), qc.BlockID)
-		_ = sl.ProcessBlock(b)
-		delete(sl.bufferedBlocks, qc.BlockID)
-	}
-	qc, ok = sl.bufferedNotarizedBlock[qc.BlockID]
-	if ok {
-		log.Debugf("[%v] found a bufferred qc, view: %v, block id: %x", sl.ID(), qc.View, qc.BlockID)
-		sl.processCertificate(qc)
-		delete(sl.bufferedQCs, qc.BlockID)
-	}
+	return true
 }
 
 func (sl *Streamlet) updateNotarizedChain(qc *blockchain.QC) error {
@@ -278,7 +349,7 @@ func (sl *Streamlet) updateNotarizedChain(qc *blockchain.QC) error {
 		sl.notarizedChain = append(sl.notarizedChain, newArray)
 		return nil
 	}
-	for i := sl.GetNotarizedHeight() - 1; i >= 0 || i >= sl.GetNotarizedHeight()-3; i-- {
+	for i := sl.GetNotarizedHeight() - 1; i >= 0 && i >= sl.GetNotarizedHeight()-3; i-- {
 		lastBlocks := sl.notarizedChain[i]
 		for _, b := range lastBlocks {
 			if b.ID == block.PrevID {
@@ -313,6 +384,10 @@ func (sl *Streamlet) votingRule(block *blockchain.Block) bool {
 	if block.View <= 2 {
 		return true
 	}
+	// before any block is notarized the longest notarized chain is the genesis
+	if sl.GetNotarizedHeight() == 0 {
+		return block.PrevID == crypto.MakeID("Genesis block")
+	}
 	lastBlocks := sl.notarizedChain[sl.GetNotarizedHeight()-1]
 	for _, b := range lastBlocks {
 		if block.PrevID == b.ID {
@@ -352,3 +427,175 @@ func (sl *Streamlet) commitRule() (bool, *blockchain.Block) {
 	}
 	return false, nil
 }
+
+// persistCommitted keeps a committed block together with its QC
+func (sl *Streamlet) persistCommitted(block *blockchain.Block) {
+	record := &blockchain.CommittedRecord{
+		Block: block,
+		QC:    sl.bc.GetQC(block.ID),
+	}
+	sl.committed.Add(record)
+	if sl.st == nil {
+		return
+	}
+	err := sl.st.Append(int(block.View), *record)
+	if err != nil {
+		log.Errorf("[%v] cannot persist the committed block, view: %v, id: %x: %v", sl.ID(), block.View, block.ID, err)
+	}
+}
+
+// saveVotingState has to be called before a vote is sent
+func (sl *Streamlet) saveVotingState() {
+	if sl.st == nil {
+		return
+	}
+	err := sl.st.Put(votingStateKey, &blockchain.VotingState{
+		LastVotedView: sl.lastVotedView,
+	})
+	if err != nil {
+		log.Fatalf("[%v] cannot persist the voting state: %v", sl.ID(), err)
+	}
+}
+
+// restore resumes from the last committed block, which becomes the tail of the notarized chain,
+// and the votes I sent before a restart
+func (sl *Streamlet) restore() error {
+	var state blockchain.VotingState
+	_, err := sl.st.Load(votingStateKey, &state)
+	if err != nil {
+		return err
+	}
+	sl.lastVotedView = state.LastVotedView
+	record, err := lastCommittedView(sl.st)
+	if err != nil {
+		return err
+	}
+	var proposed blockchain.Block
+	saved, err := sl.st.Load(proposalKey, &proposed)
+	if err != nil {
+		return err
+	}
+	view := sl.lastVotedView
+	if saved {
+		sl.proposed = &proposed
+		if proposed.View > view {
+			view = proposed.View
+		}
+	}
+	if record != nil {
+		sl.bc.Restore(record.Block)
+		sl.committed.Add(record)
+		sl.notarizedChain = append(sl.notarizedChain, []*blockchain.Block{record.Block})
+		if record.Block.View > view {
+			view = record.Block.View
+		}
+		log.Infof("[%v] recovered at view %v, id: %x", sl.ID(), record.Block.View, record.Block.ID)
+	}
+	if view > 0 {
+		sl.pm.AdvanceView(view)
+	}
+	return nil
+}
+
+// ProcessBlockRequest sends the requested blocks I have, nothing if I have none of them
+func (sl *Streamlet) ProcessBlockRequest(request *blockchain.BlockRequest) {
+	response := &blockchain.BlockResponse{From: sl.ID(), ID: request.ID}
+	if request.Count > 0 {
+		records, err := committedRangeView(sl.committed, sl.st, request.View, request.Count)
+		if err != nil {
+			log.Errorf("[%v] cannot read the committed blocks from view %v: %v", sl.ID(), request.View, err)
+			return
+		}
+		response.Records = records
+	} else if record, ok := sl.committed.GetByID(request.ID); ok {
+		response.Records = []*blockchain.CommittedRecord{record}
+	} else if block, err := sl.bc.GetBlockByID(request.ID); err == nil {
+		response.Records = []*blockchain.CommittedRecord{{Block: block, QC: sl.bc.GetQC(block.ID)}}
+	}
+	if len(response.Records) == 0 {
+		return
+	}
+	log.Debugf("[%v] sends %v blocks to %v", sl.ID(), len(response.Records), request.From)
+	sl.Send(request.From, response)
+}
+
+// ProcessBlockResponse adds the fetched blocks, a requested block has to match the id referenced by a QC
+// or by its child and the blocks of a range have to come with their QCs.
+// The blocks with a valid QC extend the notarized chain.
+func (sl *Streamlet) ProcessBlockResponse(response *blockchain.BlockResponse) {
+	if response.ID == (crypto.Identifier{}) {
+		sl.sync.done(rangeKey)
+	} else {
+		sl.sync.done(response.ID)
+	}
+	for _, record := range response.Records {
+		block := record.Block
+		if block == nil || !block.VerifyID() {
+			log.Warningf("[%v] received an invalid block from %v", sl.ID(), response.From)
+			return
+		}
+		certified := record.Certified(config.GetConfig().N)
+		if response.ID != block.ID && !certified {
+			log.Warningf("[%v] received a block without a valid QC from %v", sl.ID(), response.From)
+			return
+		}
+		if response.ID != block.ID && block.View > sl.synced {
+			sl.synced = block.View
+		}
+		if int(block.View) <= sl.bc.GetHighestCommitted() {
+			continue
+		}
+		if !sl.Election.IsLeaderView(block.Proposer, block.View) {
+			log.Warningf("[%v] received a block (view %v) from an invalid leader (%v)", sl.ID(), block.View, block.Proposer)
+			return
+		}
+		if ok, _ := crypto.PubVerify(block.Sig, crypto.IDToByte(block.ID), block.Proposer); !ok {
+			log.Warningf("[%v] received a block (view %v) with an invalid signature of %v", sl.ID(), block.View, block.Proposer)
+			return
+		}
+		if !sl.bc.Exists(block.ID) {
+			sl.bc.AddBlock(block)
+		}
+		if certified && sl.bc.GetQC(block.ID) == nil {
+			sl.bc.AddQC(record.QC)
+			sl.notarize(record.QC)
+		}
+		b, ok := sl.bufferedBlocks[block.ID]
+		if ok {
+			delete(sl.bufferedBlocks, block.ID)
+			_ = sl.ProcessBlock(b)
+		}
+		qc, ok := sl.bufferedQCs[block.ID]
+		if ok {
+			delete(sl.bufferedQCs, block.ID)
+			sl.processCertificate(qc)
+		}
+	}
+	sl.commit()
+}
+
+// fetchRange asks a peer for the committed blocks above the ones I have
+func (sl *Streamlet) fetchRange() {
+	from := types.View(sl.bc.GetHighestCommitted())
+	if sl.synced > from {
+		from = sl.synced
+	}
+	sl.sync.request(rangeKey, &blockchain.BlockRequest{From: sl.ID(), View: from + 1, Count: blockchain.MaxSyncCount})
+}
+
+// notarize extends the notarized chain with the block of a verified QC,
+// and with the notarized blocks that were waiting for it
+func (sl *Streamlet) notarize(qc *blockchain.QC) {
+	for {
+		err := sl.updateNotarizedChain(qc)
+		if err != nil {
+			return
+		}
+		next, ok := sl.bufferedNotarizedBlock[qc.BlockID]
+		if !ok {
+			return
+		}
+		delete(sl.bufferedNotarizedBlock, qc.BlockID)
+		qc = next
+	}
+}
@@ -0,0 +1,181 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"banyan/blockchain"
+	view "banyan/blockchain_view"
+	"banyan/config"
+	"banyan/identity"
+	"banyan/message"
+	"banyan/node"
+	"banyan/store"
+	"banyan/types"
+)
+
+// number of committed blocks kept in memory to serve the peers that are catching up
+const committedCacheSize = 1000
+
+// the range request in flight, there is at most one
+const rangeKey = "range"
+
+// bytes of transactions sent in one range response, at least one block is sent, the response has to fit in a
+// frame of 16MB once encoded
+const syncBytes = 4 << 20
+
+// syncer sends block requests to the peers in turn,
+// a request is not repeated until the previous one has been answered or has timed out
+type syncer struct {
+	node.Node
+	peers    []identity.NodeID
+	next     int
+	interval time.Duration
+	sent     map[interface{}]time.Time
+}
+
+func newSyncer(n node.Node) *syncer {
+	s := &syncer{
+		Node:     n,
+		interval: time.Duration(config.GetConfig().Timeout) * time.Millisecond,
+		sent:     make(map[interface{}]time.Time),
+	}
+	for _, id := range config.GetConfig().IDs() {
+		if id != n.ID() {
+			s.peers = append(s.peers, id)
+		}
+	}
+	sort.Slice(s.peers, func(i, j int) bool { return s.peers[i].Node() < s.peers[j].Node() })
+	return s
+}
+
+// request sends the message to the next peer, the key identifies the request
+func (s *syncer) request(key interface{}, m interface{}) {
+	if len(s.peers) == 0 {
+		return
+	}
+	now := s.now()
+	if sent, ok := s.sent[key]; ok && now.Sub(sent) < s.interval {
+		return
+	}
+	for k, sent := range s.sent {
+		if now.Sub(sent) >= s.interval {
+			delete(s.sent, k)
+		}
+	}
+	s.sent[key] = now
+	s.Send(s.peers[s.next], m)
+	s.next = (s.next + 1) % len(s.peers)
+}
+
+// clock is implemented by the nodes that do not run on the wall clock, e.g. in the simulator
+type clock interface {
+	Now() time.Time
+}
+
+func (s *syncer) now() time.Time {
+	if c, ok := s.Node.(clock); ok {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// done allows the request to be sent again right away
+func (s *syncer) done(key interface{}) {
+	delete(s.sent, key)
+}
+
+// keepCertificates adds the valid certificates of a fetched block to the chain, so that I can pass them on,
+// the fast path is disabled if fastQuorum is 0
+func keepCertificates(bc *blockchain.BlockChain, record *blockchain.CommittedRecord, notarizationQuorum int, fastQuorum int) {
+	id, height := record.Block.ID, record.Block.Height
+	if n := record.Notarization; n != nil && n.BlockID == id && n.Height == height && blockchain.VerifyNotarization(n, notarizationQuorum) == nil {
+		bc.AddNotarization(n)
+	}
+	if f := record.Finalization; f != nil && f.BlockID == id && f.Height == height && blockchain.VerifyFinalization(f, blockchain.SuperMajority(config.GetConfig().N)) == nil {
+		bc.AddFinalization(f)
+	}
+	if f := record.FastFinalization; f != nil && f.BlockID == id && f.Height == height && blockchain.VerifyFastFinalization(f, fastQuorum) == nil {
+		bc.AddFastFinalization(f)
+	}
+}
+
+// committedRange returns the committed blocks from the height on, from memory if possible
+func committedRange(cache *blockchain.CommittedCache, st *store.Store, from int, count int) ([]*blockchain.CommittedRecord, error) {
+	if count > blockchain.MaxSyncCount {
+		count = blockchain.MaxSyncCount
+	}
+	records, ok := cache.Range(from, count)
+	if ok || st == nil {
+		return fitRange(records), nil
+	}
+	values, err := st.Range(from, count)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		record, ok := v.(blockchain.CommittedRecord)
+		if !ok {
+			return nil, fmt.Errorf("unexpected record in the store: %T", v)
+		}
+		records = append(records, &record)
+	}
+	return fitRange(records), nil
+}
+
+// fitRange returns as many of the records as fit in a range response
+func fitRange(records []*blockchain.CommittedRecord) []*blockchain.CommittedRecord {
+	size := 0
+	for i, record := range records {
+		size += payloadSize(record.Block.Payload)
+		if i > 0 && size > syncBytes {
+			return records[:i]
+		}
+	}
+	return records
+}
+
+// committedRangeView returns the committed blocks from the view on, from memory if possible
+func committedRangeView(cache *view.CommittedCache, st *store.Store, from types.View, count int) ([]*view.CommittedRecord, error) {
+	if count > view.MaxSyncCount {
+		count = view.MaxSyncCount
+	}
+	records, ok := cache.Range(from, count)
+	if ok || st == nil {
+		return fitRangeView(records), nil
+	}
+	values, err := st.Range(int(from), count)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		record, ok := v.(view.CommittedRecord)
+		if !ok {
+			return nil, fmt.Errorf("unexpected record in the store: %T", v)
+		}
+		records = append(records, &record)
+	}
+	return fitRangeView(records), nil
+}
+
+// fitRangeView returns as many of the records as fit in a range response
+func fitRangeView(records []*view.CommittedRecord) []*view.CommittedRecord {
+	size := 0
+	for i, record := range records {
+		size += payloadSize(record.Block.Payload)
+		if i > 0 && size > syncBytes {
+			return records[:i]
+		}
+	}
+	return records
+}
+
+// payloadSize returns the bytes of the transactions
+func payloadSize(payload []*message.Transaction) int {
+	size := 0
+	for _, tx := range payload {
+		size += tx.Size()
+	}
+	return size
+}
@@ -0,0 +1,85 @@
+package replica
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"banyan/blockchain"
+	"banyan/message"
+	"banyan/metrics"
+)
+
+var commitPaths = []blockchain.CommitPath{blockchain.FastPath, blockchain.SlowPath, blockchain.ImplicitPath}
+
+// commitStats counts the committed blocks per commit path with a latency histogram for each path,
+// it is updated by the commit listener and read by the query handlers
+type commitStats struct {
+	blocks  *metrics.CounterVec
+	latency *metrics.HistogramVec
+}
+
+func newCommitStats(registry *metrics.Registry) *commitStats {
+	s := &commitStats{
+		blocks:  registry.CounterVec("banyan_commit_path_blocks_total", "Blocks committed on each commit path.", "path"),
+		latency: registry.HistogramVec("banyan_commit_path_latency_seconds", "Time from the proposal of a block to its commit on each commit path.", "path", metrics.LatencyBuckets),
+	}
+	for _, path := range commitPaths {
+		s.blocks.With(path.String())
+		s.latency.With(path.String())
+	}
+	return s
+}
+
+func (s *commitStats) add(path blockchain.CommitPath, latency time.Duration) {
+	s.blocks.With(path.String()).Inc()
+	s.latency.With(path.String()).ObserveDuration(latency)
+}
+
+func (s *commitStats) snapshot() message.CommitStats {
+	stats := message.CommitStats{Paths: make(map[string]*message.PathStats)}
+	for _, path := range commitPaths {
+		latency := s.latency.With(path.String()).Snapshot()
+		reply := &message.PathStats{
+			Count:         int(latency.Count),
+			MeanLatencyMs: latency.Mean() * 1000,
+		}
+		for _, bucket := range latency.Buckets {
+			b := message.LatencyBucket{Count: int(bucket.Count)}
+			if !math.IsInf(bucket.UpperBound, 1) {
+				b.UpperMs = int64(math.Round(bucket.UpperBound * 1000))
+			}
+			reply.LatencyHistogram = append(reply.LatencyHistogram, b)
+		}
+		stats.Paths[path.String()] = reply
+		stats.Committed += reply.Count
+	}
+	fast, slow := stats.Paths[blockchain.FastPath.String()].Count, stats.Paths[blockchain.SlowPath.String()].Count
+	if fast+slow > 0 {
+		stats.FastRatio = float64(fast) / float64(fast+slow)
+	}
+	return stats
+}
+
+// String describes the commit paths in the /query output
+func (s *commitStats) String() string {
+	stats := s.snapshot()
+	var b strings.Builder
+	fmt.Fprintf(&b, "Fast path ratio: %.3f.\n", stats.FastRatio)
+	for _, path := range commitPaths {
+		ps := stats.Paths[path.String()]
+		fmt.Fprintf(&b, "%v path: %v blocks, mean latency %.1f ms, latency histogram", path, ps.Count, ps.MeanLatencyMs)
+		upper := int64(0)
+		for _, bucket := range ps.LatencyHistogram {
+			if bucket.UpperMs > 0 {
+				upper = bucket.UpperMs
+				fmt.Fprintf(&b, " <=%vms:%v", bucket.UpperMs, bucket.Count)
+			} else {
+				fmt.Fprintf(&b, " >%vms:%v", upper, bucket.Count)
+			}
+		}
+		b.WriteString(".\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package replica
+
+import (
+	"testing"
+	"time"
+
+	"banyan/blockchain"
+	"banyan/metrics"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitStats(t *testing.T) {
+	s := newCommitStats(metrics.NewRegistry())
+	s.add(blockchain.FastPath, 4*time.Millisecond)
+	s.add(blockchain.FastPath, 8*time.Millisecond)
+	s.add(blockchain.FastPath, 6*time.Millisecond)
+	s.add(blockchain.SlowPath, 40*time.Millisecond)
+	s.add(blockchain.ImplicitPath, 20*time.Second)
+
+	stats := s.snapshot()
+	require.Equal(t, 5, stats.Committed)
+	// the implicit commits do not count towards the ratio
+	require.Equal(t, 0.75, stats.FastRatio)
+
+	fast := stats.Paths["fast"]
+	require.Equal(t, 3, fast.Count)
+	require.InDelta(t, 6.0, fast.MeanLatencyMs, 1e-9)
+	require.Equal(t, int64(5), fast.LatencyHistogram[0].UpperMs)
+	require.Equal(t, 1, fast.LatencyHistogram[0].Count)
+	require.Equal(t, 2, fast.LatencyHistogram[1].Count)
+	require.Equal(t, 1, stats.Paths["slow"].LatencyHistogram[3].Count)
+	last := stats.Paths["implicit"].LatencyHistogram[len(metrics.LatencyBuckets)]
+	require.Equal(t, int64(0), last.UpperMs)
+	require.Equal(t, 1, last.Count)
+}
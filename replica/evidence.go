@@ -0,0 +1,15 @@
+package replica
+
+import (
+	"banyan/evidence"
+	"banyan/identity"
+	"banyan/log"
+)
+
+// receiveProof keeps an equivocation proof gossiped by a peer
+func receiveProof(id identity.NodeID, collector *evidence.Collector, proof evidence.EquivocationProof) {
+	err := collector.Receive(&proof)
+	if err != nil {
+		log.Warningf("[%v] received an invalid proof against %v from %v: %v", id, proof.Offender, proof.Reporter, err)
+	}
+}
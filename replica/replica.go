@@ -9,14 +9,19 @@ import (
 
 	"banyan/blockchain"
 	"banyan/config"
+	"banyan/dissemination"
 	"banyan/election"
+	"banyan/evidence"
 	"banyan/identity"
 	"banyan/local_timeout"
 	"banyan/log"
+	"banyan/mempool"
 	"banyan/message"
 	"banyan/node"
 	"banyan/protocol"
-	"strconv"
+	"banyan/statemachine"
+	"banyan/store"
+	"banyan/verifier"
 )
 
 type Replica struct {
@@ -26,67 +31,90 @@ type Replica struct {
 	lt              *local_timeout.LocalTimeout
 	start           chan bool // signal to start the node
 	isStarted       atomic.Bool
+	mempool         *mempool.MemPool
+	sm              statemachine.StateMachine
+	store           *store.Store // nil if nothing is persisted
+	appliedHeight   atomic.Int64 // height of the last block applied to the state machine
+	commits         *commitStats // how the committed blocks were finalized
+	evidence        *evidence.Collector
+	dissemination   *dissemination.Node // nil unless the blocks are sent in chunks
+	verifier        *verifier.Verifier  // nil if the protocol checks the signatures of the shares
 	isByz           bool
 	strategy        string
 	timer           *time.Timer // timeout for each rank
-	committedBlocks chan *blockchain.Block
+	committedBlocks chan *blockchain.CommittedRecord
 	forkedBlocks    chan *blockchain.Block
 	eventChan       chan interface{}
 
 	/* for monitoring node statistics */
 
-	experimentStartTime time.Time
-	experimentDuration  time.Duration
-
-	allBlockLatency      []time.Duration
-	myBlockLatency       []time.Duration
-	allBlockTimes        []time.Duration
-	lastBlockProposeTime time.Time
+	stats                *stats
 	oneBlockPayloadBytes int
-	committedBlockNo     int
 	lastHeightTime       time.Time
-	experimentStarted    bool
 }
 
 // NewReplica creates a new replica instance
 func NewReplica(id identity.NodeID, alg string, isByz bool) *Replica {
 	r := new(Replica)
 	r.Node = node.NewNode(id, isByz)
+	if config.GetConfig().ErasureCoding {
+		r.dissemination = dissemination.NewNode(r.Node, config.GetConfig().F)
+		// the Byzantine strategies rewrite whole blocks, a Byzantine proposer sends them as they are
+		if !isByz {
+			r.Node = r.dissemination
+		}
+		r.Register(dissemination.Chunk{}, r.handleChunk)
+	}
 	if isByz {
-		log.Infof("[%v] is Byzantine", r.ID())
+		log.Infof("[%v] is Byzantine, strategy: %v", r.ID(), config.GetConfig().StrategyOf(id))
 	}
 	r.Election = election.NewRotation(config.GetConfig().N)
 
-	r.allBlockLatency = make([]time.Duration, 10000)
-	r.myBlockLatency = make([]time.Duration, 10000)
-	r.allBlockTimes = make([]time.Duration, 10000)
-	r.experimentDuration = time.Second * time.Duration(config.GetConfig().ExperimentDuration)
-	r.experimentStarted = false
-
 	r.oneBlockPayloadBytes = config.GetConfig().PayloadSize
+	r.stats = newStats(id, r.Metrics(), time.Second*time.Duration(config.GetConfig().ExperimentDuration), r.oneBlockPayloadBytes)
+	r.mempool = mempool.NewMemPool(config.GetConfig().MemSize, config.GetConfig().MemBytes)
+	sm, err := statemachine.New(config.GetConfig().StateMachine)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.sm = sm
+	r.commits = newCommitStats(r.Metrics())
+	r.store = openStore(id)
+	r.evidence = evidence.NewCollector(r.Node)
 	r.isByz = isByz
-	r.strategy = config.GetConfig().Strategy
+	r.strategy = config.GetConfig().StrategyOf(id)
 	r.lt = local_timeout.NewLocalTimeout()
 	r.start = make(chan bool)
 	r.eventChan = make(chan interface{}, 100)
-	r.committedBlocks = make(chan *blockchain.Block, 100)
+	r.verifier = verifier.NewVerifier(id, config.GetConfig().VerifyWorkers, config.GetConfig().VerifyBatch, r.Metrics(), r.eventChan)
+	r.committedBlocks = make(chan *blockchain.CommittedRecord, 100)
 	r.forkedBlocks = make(chan *blockchain.Block, 100)
 	r.Register(blockchain.Block{}, r.HandleBlock)
 	r.Register(blockchain.NotarizationShare{}, r.HandleNotarizationShare)
 	r.Register(blockchain.FinalizationShare{}, r.HandleFinalizationShare)
+	r.Register(blockchain.BlockRequest{}, r.HandleBlockRequest)
+	r.Register(blockchain.BlockResponse{}, r.HandleBlockResponse)
 	r.Register(message.Query{}, r.handleQuery)
-	gob.Register(blockchain.Block{})
-	gob.Register(blockchain.NotarizationShare{})
-	gob.Register(blockchain.FinalizationShare{})
+	r.Register(message.TransactionRequest{}, r.handleTransactionRequest)
+	r.Register(message.TransactionQuery{}, r.handleTransactionQuery)
+	r.Register(message.ForwardedTransactions{}, r.handleForwardedTransactions)
+	r.Register(message.StateQuery{}, r.handleStateQuery)
+	r.Register(message.CommitStatsQuery{}, r.handleCommitStatsQuery)
+	r.Register(message.EvidenceQuery{}, r.handleEvidenceQuery)
+	r.Register(evidence.EquivocationProof{}, r.handleEquivocationProof)
+
+	// the store persists the committed records with gob
+	gob.Register(blockchain.CommittedRecord{})
 
 	switch alg {
 	case "icc":
-		r.Safety = protocol.NewIcc(r.Node, r.Election, r.lt, r.committedBlocks, r.forkedBlocks)
+		r.Safety = protocol.NewIcc(r.Node, r.Election, r.lt, r.mempool, r.store, r.evidence, r.committedBlocks, r.forkedBlocks)
 	case "banyan":
-		r.Safety = protocol.NewBanyan(r.Node, r.Election, r.lt, r.committedBlocks, r.forkedBlocks, config.GetConfig().F, config.GetConfig().P)
+		r.Safety = protocol.NewBanyan(r.Node, r.Election, r.lt, r.mempool, r.store, r.evidence, r.committedBlocks, r.forkedBlocks, config.GetConfig().F, config.GetConfig().P)
 	default:
-		r.Safety = protocol.NewBanyan(r.Node, r.Election, r.lt, r.committedBlocks, r.forkedBlocks, config.GetConfig().F, config.GetConfig().P)
+		r.Safety = protocol.NewBanyan(r.Node, r.Election, r.lt, r.mempool, r.store, r.evidence, r.committedBlocks, r.forkedBlocks, config.GetConfig().F, config.GetConfig().P)
 	}
+	r.recover()
 	return r
 }
 
@@ -99,75 +127,127 @@ func (r *Replica) HandleBlock(block blockchain.Block) {
 
 func (r *Replica) HandleNotarizationShare(vote blockchain.NotarizationShare) {
 	log.Debugf("[%v] received a N share frm %v, blockID is %x", r.ID(), vote.Voter, vote.BlockID)
+	if r.verifier != nil {
+		r.verifier.Submit(&vote)
+		return
+	}
 	r.eventChan <- vote
 }
 
 func (r *Replica) HandleFinalizationShare(vote blockchain.FinalizationShare) {
 	log.Debugf("[%v] received a F share frm %v, blockID is %x", r.ID(), vote.Voter, vote.BlockID)
+	if r.verifier != nil {
+		r.verifier.Submit(&vote)
+		return
+	}
 	r.eventChan <- vote
 }
 
+// handleChunk rebuilds the blocks sent in chunks
+func (r *Replica) handleChunk(chunk dissemination.Chunk) {
+	m, err := r.dissemination.Add(chunk)
+	if err != nil {
+		log.Warningf("[%v] dropped a chunk from %v: %v", r.ID(), chunk.From, err)
+		return
+	}
+	if block, ok := m.(blockchain.Block); ok {
+		r.HandleBlock(block)
+	}
+}
+
+func (r *Replica) HandleBlockRequest(request blockchain.BlockRequest) {
+	log.Debugf("[%v] received a block request from %v, id: %x", r.ID(), request.From, request.ID)
+	r.eventChan <- request
+}
+
+func (r *Replica) HandleBlockResponse(response blockchain.BlockResponse) {
+	log.Debugf("[%v] received %v blocks from %v", r.ID(), len(response.Records), response.From)
+	r.eventChan <- response
+}
+
 // handleQuery replies a query with the statistics of the node
 func (r *Replica) handleQuery(m message.Query) {
 	r.startSignal()
+	m.Reply(message.QueryReply{Info: r.stats.report() + r.commits.String()})
+}
 
-	if !(r.experimentStarted && r.experimentStartTime.Add(r.experimentDuration).Before(time.Now())) {
-		status := fmt.Sprintf("Committed blocks: %v.\n", r.committedBlockNo)
-		m.Reply(message.QueryReply{Info: status})
-		return
-	}
+// handleTransactionRequest queues or forwards transactions submitted by a client.
+// Transactions go to the leader of the next height since the current one may have proposed already.
+func (r *Replica) handleTransactionRequest(m message.TransactionRequest) {
+	r.startSignal()
+	err := submitTransactions(r.Node, r.mempool, r.FindLeaderFor(r.lt.GetCurHeight()+1, 0), m.Txns)
+	m.Reply(message.TransactionReply{Err: err})
+}
 
-	response := "blockPayloadSize\n"
-	response += strconv.Itoa(r.oneBlockPayloadBytes) + "\n"
+func (r *Replica) handleForwardedTransactions(m message.ForwardedTransactions) {
+	addForwardedTransactions(r.Node, r.mempool, m)
+}
 
-	response += "committedBlocks\n"
-	response += strconv.Itoa(r.committedBlockNo) + "\n"
+// handleTransactionQuery replies with the status of a transaction
+func (r *Replica) handleTransactionQuery(m message.TransactionQuery) {
+	m.Reply(r.mempool.Status(m.ID))
+}
 
-	response += "allBlockLatency\n"
-	for i := 3; i <= r.committedBlockNo; i++ {
-		response += strconv.Itoa(int(r.allBlockLatency[i].Milliseconds())) + ","
-	}
+// handleStateQuery replies with the replicated state
+func (r *Replica) handleStateQuery(m message.StateQuery) {
+	queryState(r.sm, int(r.appliedHeight.Load()), m)
+}
 
-	response += "\nproposerLatency\n"
-	for i := 3; i <= r.committedBlockNo; i++ {
-		response += strconv.Itoa(int(r.myBlockLatency[i].Milliseconds())) + ","
-	}
+// handleCommitStatsQuery replies with the number of blocks committed on each path
+func (r *Replica) handleCommitStatsQuery(m message.CommitStatsQuery) {
+	m.Reply(r.commits.snapshot())
+}
 
-	response += "\nblockTime\n"
-	for i := 3; i <= r.committedBlockNo; i++ {
-		response += strconv.Itoa(int(r.allBlockTimes[i].Milliseconds())) + ","
-	}
+// handleEvidenceQuery replies with the equivocation proofs collected so far
+func (r *Replica) handleEvidenceQuery(m message.EvidenceQuery) {
+	m.Reply(r.evidence.Evidence())
+}
 
-	m.Reply(message.QueryReply{Info: response})
+func (r *Replica) handleEquivocationProof(proof evidence.EquivocationProof) {
+	receiveProof(r.ID(), r.evidence, proof)
 }
 
 /* Processors */
 
-func (r *Replica) processCommittedBlock(block *blockchain.Block) {
-	if block.Height == 3 {
-		r.experimentStartTime = time.Now()
-		r.experimentStarted = true
-	}
-	if r.experimentStartTime.Add(r.experimentDuration).Before(time.Now()) && (block.Height > 3) {
+// recover applies the blocks committed before a restart to the state machine
+func (r *Replica) recover() {
+	if r.store == nil {
 		return
 	}
-
-	proposeTime := block.Timestamp
-	if block.Height > 1 {
-		r.allBlockTimes[block.Height] = proposeTime.Sub(r.lastBlockProposeTime)
+	recovered := 0
+	err := r.store.Replay(func(v interface{}) error {
+		record, ok := v.(blockchain.CommittedRecord)
+		if !ok {
+			return fmt.Errorf("unexpected record in the store: %T", v)
+		}
+		block := record.Block
+		r.mempool.Remove(block.Payload, block.Height, block.ID)
+		r.sm.Apply(&statemachine.Block{Height: block.Height, ID: block.ID, Txns: block.Payload})
+		r.appliedHeight.Store(int64(block.Height))
+		recovered++
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("[%v] cannot replay the store: %v", r.ID(), err)
 	}
-	now := time.Now()
-	r.allBlockLatency[block.Height] = now.Sub(proposeTime)
-	if block.Proposer == r.ID() {
-		r.myBlockLatency[block.Height] = r.allBlockLatency[block.Height]
+	if recovered > 0 {
+		log.Infof("[%v] replayed %v committed blocks", r.ID(), recovered)
 	}
-	r.committedBlockNo++
-	r.lastBlockProposeTime = proposeTime
+}
 
-	log.Infof("[%v] the block is committed, height: %v, id: %x", r.ID(), block.Height, block.ID)
+func (r *Replica) processCommittedBlock(record *blockchain.CommittedRecord) {
+	block := record.Block
+	r.mempool.Remove(block.Payload, block.Height, block.ID)
+	r.sm.Apply(&statemachine.Block{Height: block.Height, ID: block.ID, Txns: block.Payload})
+	r.appliedHeight.Store(int64(block.Height))
+	r.commits.add(record.Path, time.Since(block.Timestamp))
+	r.stats.commit(block.Proposer, block.Timestamp)
+	log.Infof("[%v] the block is committed, height: %v, path: %v, id: %x, prev: %x", r.ID(), block.Height, record.Path, block.ID, block.PrevID)
 }
 
 func (r *Replica) processForkedBlock(block *blockchain.Block) {
+	r.mempool.Return(block.Payload)
+	r.stats.fork()
 	log.Infof("[%v] the block is forked, No. of transactions: %v, height: %v, id: %x", r.ID(), len(block.Payload), block.Height, block.ID)
 }
 
@@ -180,6 +260,10 @@ func (r *Replica) proposeIfLeader(height int, rank int) {
 
 func (r *Replica) proposeBlock(height int, rank int) {
 	block := r.Safety.MakeProposal(height, rank, r.oneBlockPayloadBytes)
+	if block == nil {
+		log.Warningf("[%v] has already proposed on height %v with rank %v", r.ID(), height, rank)
+		return
+	}
 	block.Timestamp = time.Now()
 	r.Broadcast(block)
 	_ = r.Safety.ProcessBlock(block)
@@ -187,7 +271,7 @@ func (r *Replica) proposeBlock(height int, rank int) {
 
 // ListenLocalEvent listens new height and timeout events
 func (r *Replica) ListenLocalEvent() {
-	block_production_height := 1
+	block_production_height := r.lt.GetCurHeight() // above 1 if recovered from the store
 	block_production_rank := 0
 	<-r.start
 	r.proposeIfLeader(block_production_height, block_production_rank)
@@ -209,6 +293,7 @@ func (r *Replica) ListenLocalEvent() {
 				log.Debugf("[%v] the last height lasted %v milliseconds", r.ID(), lasts.Milliseconds())
 				break L
 			case <-r.timer.C:
+				r.stats.timeout()
 				block_production_rank += 1
 				r.proposeIfLeader(block_production_height, block_production_rank)
 				break L
@@ -251,8 +336,16 @@ func (r *Replica) Start() {
 			r.Safety.ProcessBlock(&v)
 		case blockchain.NotarizationShare:
 			r.Safety.ProcessNotarizationShare(&v)
+		case *blockchain.NotarizationShare: // from the verifier
+			r.Safety.ProcessNotarizationShare(v)
 		case blockchain.FinalizationShare:
 			r.Safety.ProcessFinalizationShare(&v)
+		case *blockchain.FinalizationShare:
+			r.Safety.ProcessFinalizationShare(v)
+		case blockchain.BlockRequest:
+			r.Safety.ProcessBlockRequest(&v)
+		case blockchain.BlockResponse:
+			r.Safety.ProcessBlockResponse(&v)
 		}
 	}
 }
@@ -3,21 +3,27 @@ package replica
 import (
 	"encoding/gob"
 	"fmt"
-	"strconv"
 	"time"
 
 	"go.uber.org/atomic"
 
 	blockchain "banyan/blockchain_view"
+	"banyan/byzantine"
 	"banyan/config"
+	"banyan/dissemination"
 	"banyan/election"
+	"banyan/evidence"
 	"banyan/identity"
 	"banyan/log"
+	"banyan/mempool"
 	"banyan/message"
 	"banyan/node"
 	"banyan/pacemaker"
 	"banyan/protocol"
+	"banyan/statemachine"
+	"banyan/store"
 	"banyan/types"
+	"banyan/verifier"
 )
 
 type ReplicaView struct {
@@ -27,6 +33,13 @@ type ReplicaView struct {
 	pm              *pacemaker.Pacemaker
 	start           chan bool // signal to start the node
 	isStarted       atomic.Bool
+	mempool         *mempool.MemPool
+	sm              statemachine.StateMachine
+	store           *store.Store // nil if nothing is persisted
+	appliedHeight   atomic.Int64 // height of the last block applied to the state machine
+	evidence        *evidence.Collector
+	dissemination   *dissemination.Node // nil unless the blocks are sent in chunks
+	verifier        *verifier.Verifier  // nil if the protocol checks the signatures of the shares
 	isByz           bool
 	strategy        string
 	timer           *time.Timer // timeout for each view
@@ -36,60 +49,73 @@ type ReplicaView struct {
 
 	/* for monitoring node statistics */
 
-	experimentStartTime time.Time
-	experimentDuration  time.Duration
-
-	allBlockLatency      []time.Duration
-	myBlockLatency       []time.Duration
-	allBlockTimes        []time.Duration
-	lastBlockProposeTime time.Time
+	stats                *stats
 	oneBlockPayloadBytes int
-	committedBlockNo     int
 	lastViewTime         time.Time
-	experimentStarted    bool
 }
 
 // NewReplica creates a new replica instance
 func NewReplicaView(id identity.NodeID, alg string, isByz bool) *ReplicaView {
 	r := new(ReplicaView)
 	r.Node = node.NewNode(id, isByz)
+	if config.GetConfig().ErasureCoding {
+		r.dissemination = dissemination.NewNode(r.Node, config.GetConfig().F)
+		// the Byzantine strategies rewrite whole blocks, a Byzantine proposer sends them as they are
+		if !isByz {
+			r.Node = r.dissemination
+		}
+		r.Register(dissemination.Chunk{}, r.handleChunk)
+	}
 	if isByz {
-		log.Infof("[%v] is Byzantine", r.ID())
+		log.Infof("[%v] is Byzantine, strategy: %v", r.ID(), config.GetConfig().StrategyOf(id))
 	}
 	r.Election = election.NewRotation(config.GetConfig().N)
 
-	r.allBlockLatency = make([]time.Duration, 10000)
-	r.myBlockLatency = make([]time.Duration, 10000)
-	r.allBlockTimes = make([]time.Duration, 10000)
-	r.experimentDuration = time.Second * time.Duration(config.GetConfig().ExperimentDuration)
-	r.experimentStarted = false
-
 	r.oneBlockPayloadBytes = config.GetConfig().PayloadSize
+	r.stats = newStats(id, r.Metrics(), time.Second*time.Duration(config.GetConfig().ExperimentDuration), r.oneBlockPayloadBytes)
+	r.mempool = mempool.NewMemPool(config.GetConfig().MemSize, config.GetConfig().MemBytes)
+	sm, err := statemachine.New(config.GetConfig().StateMachine)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.sm = sm
+	r.store = openStore(id)
+	r.evidence = evidence.NewCollector(r.Node)
 	r.isByz = isByz
-	r.strategy = config.GetConfig().Strategy
+	r.strategy = config.GetConfig().StrategyOf(id)
 	r.pm = pacemaker.NewPacemaker(config.GetConfig().N)
 	r.start = make(chan bool)
 	r.eventChan = make(chan interface{}, 100)
+	r.verifier = verifier.NewVerifier(id, config.GetConfig().VerifyWorkers, config.GetConfig().VerifyBatch, r.Metrics(), r.eventChan)
 	r.committedBlocks = make(chan *blockchain.Block, 100)
 	r.forkedBlocks = make(chan *blockchain.Block, 100)
 	r.Register(blockchain.Block{}, r.HandleBlock)
 	r.Register(blockchain.Vote{}, r.HandleVote)
 	r.Register(pacemaker.TMO{}, r.HandleTmo)
+	r.Register(blockchain.BlockRequest{}, r.HandleBlockRequest)
+	r.Register(blockchain.BlockResponse{}, r.HandleBlockResponse)
 	r.Register(message.Query{}, r.handleQuery)
-	gob.Register(blockchain.Block{})
-	gob.Register(blockchain.Vote{})
-	gob.Register(pacemaker.TC{})
-	gob.Register(pacemaker.TMO{})
+	r.Register(message.TransactionRequest{}, r.handleTransactionRequest)
+	r.Register(message.TransactionQuery{}, r.handleTransactionQuery)
+	r.Register(message.ForwardedTransactions{}, r.handleForwardedTransactions)
+	r.Register(message.StateQuery{}, r.handleStateQuery)
+	r.Register(message.CommitStatsQuery{}, r.handleCommitStatsQuery)
+	r.Register(message.EvidenceQuery{}, r.handleEvidenceQuery)
+	r.Register(evidence.EquivocationProof{}, r.handleEquivocationProof)
+
+	// the store persists the committed records with gob
+	gob.Register(blockchain.CommittedRecord{})
 
 	// Is there a better way to reduce the number of parameters?
 	switch alg {
 	case "hotstuff":
-		r.SafetyView = protocol.NewHotStuff(r.Node, r.pm, r.Election, r.committedBlocks, r.forkedBlocks)
+		r.SafetyView = protocol.NewHotStuff(r.Node, r.pm, r.Election, r.mempool, r.store, r.evidence, r.committedBlocks, r.forkedBlocks)
 	case "streamlet":
-		r.SafetyView = protocol.NewStreamlet(r.Node, r.pm, r.Election, r.committedBlocks, r.forkedBlocks)
+		r.SafetyView = protocol.NewStreamlet(r.Node, r.pm, r.Election, r.mempool, r.store, r.evidence, r.committedBlocks, r.forkedBlocks)
 	default:
-		r.SafetyView = protocol.NewHotStuff(r.Node, r.pm, r.Election, r.committedBlocks, r.forkedBlocks)
+		r.SafetyView = protocol.NewHotStuff(r.Node, r.pm, r.Election, r.mempool, r.store, r.evidence, r.committedBlocks, r.forkedBlocks)
 	}
+	r.recover()
 	return r
 }
 
@@ -105,6 +131,10 @@ func (r *ReplicaView) HandleVote(vote blockchain.Vote) {
 		return
 	}
 	log.Debugf("[%v] received a vote frm %v, blockID is %x", r.ID(), vote.Voter, vote.BlockID)
+	if r.verifier != nil {
+		r.verifier.Submit(&vote)
+		return
+	}
 	r.eventChan <- vote
 }
 
@@ -116,71 +146,114 @@ func (r *ReplicaView) HandleTmo(tmo pacemaker.TMO) {
 	r.eventChan <- tmo
 }
 
+// handleChunk rebuilds the blocks sent in chunks
+func (r *ReplicaView) handleChunk(chunk dissemination.Chunk) {
+	m, err := r.dissemination.Add(chunk)
+	if err != nil {
+		log.Warningf("[%v] dropped a chunk from %v: %v", r.ID(), chunk.From, err)
+		return
+	}
+	if block, ok := m.(blockchain.Block); ok {
+		r.HandleBlock(block)
+	}
+}
+
+func (r *ReplicaView) HandleBlockRequest(request blockchain.BlockRequest) {
+	log.Debugf("[%v] received a block request from %v, id: %x", r.ID(), request.From, request.ID)
+	r.eventChan <- request
+}
+
+func (r *ReplicaView) HandleBlockResponse(response blockchain.BlockResponse) {
+	log.Debugf("[%v] received %v blocks from %v", r.ID(), len(response.Records), response.From)
+	r.eventChan <- response
+}
+
 // handleQuery replies a query with the statistics of the node
 func (r *ReplicaView) handleQuery(m message.Query) {
-	if !r.isByz {
+	if !r.isSilent() {
 		r.startSignal()
 	}
+	m.Reply(message.QueryReply{Info: r.stats.report()})
+}
 
-	if !(r.experimentStarted && r.experimentStartTime.Add(r.experimentDuration).Before(time.Now())) {
-		status := fmt.Sprintf("Committed blocks: %v.\n", r.committedBlockNo)
-		m.Reply(message.QueryReply{Info: status})
-		return
+// handleTransactionRequest queues or forwards transactions submitted by a client.
+// Transactions go to the leader of the next view since the current one may have proposed already.
+func (r *ReplicaView) handleTransactionRequest(m message.TransactionRequest) {
+	if !r.isSilent() {
+		r.startSignal()
 	}
+	err := submitTransactions(r.Node, r.mempool, r.FindLeaderForView(r.pm.GetCurView()+1), m.Txns)
+	m.Reply(message.TransactionReply{Err: err})
+}
 
-	response := "blockPayloadSize\n"
-	response += strconv.Itoa(r.oneBlockPayloadBytes) + "\n"
+func (r *ReplicaView) handleForwardedTransactions(m message.ForwardedTransactions) {
+	addForwardedTransactions(r.Node, r.mempool, m)
+}
 
-	response += "committedBlocks\n"
-	response += strconv.Itoa(r.committedBlockNo) + "\n"
+// handleTransactionQuery replies with the status of a transaction
+func (r *ReplicaView) handleTransactionQuery(m message.TransactionQuery) {
+	m.Reply(r.mempool.Status(m.ID))
+}
 
-	response += "allBlockLatency\n"
-	for i := 3; i <= r.committedBlockNo; i++ {
-		response += strconv.Itoa(int(r.allBlockLatency[i].Milliseconds())) + ","
-	}
+// handleCommitStatsQuery replies with the number of committed blocks,
+// the view-based protocols have a single commit rule, so there are no paths to tell apart
+func (r *ReplicaView) handleCommitStatsQuery(m message.CommitStatsQuery) {
+	m.Reply(message.CommitStats{Committed: int(r.stats.committed.Value()), Paths: make(map[string]*message.PathStats)})
+}
 
-	response += "\nproposerLatency\n"
-	for i := 3; i <= r.committedBlockNo; i++ {
-		response += strconv.Itoa(int(r.myBlockLatency[i].Milliseconds())) + ","
-	}
+// handleStateQuery replies with the replicated state
+func (r *ReplicaView) handleStateQuery(m message.StateQuery) {
+	queryState(r.sm, int(r.appliedHeight.Load()), m)
+}
 
-	response += "\nblockTime\n"
-	for i := 3; i <= r.committedBlockNo; i++ {
-		response += strconv.Itoa(int(r.allBlockTimes[i].Milliseconds())) + ","
-	}
+// handleEvidenceQuery replies with the equivocation proofs collected so far
+func (r *ReplicaView) handleEvidenceQuery(m message.EvidenceQuery) {
+	m.Reply(r.evidence.Evidence())
+}
 
-	m.Reply(message.QueryReply{Info: response})
+func (r *ReplicaView) handleEquivocationProof(proof evidence.EquivocationProof) {
+	receiveProof(r.ID(), r.evidence, proof)
 }
 
 /* Processors */
 
-func (r *ReplicaView) processCommittedBlock(block *blockchain.Block) {
-	blockNum := r.committedBlockNo + 1
-	if blockNum == 3 {
-		r.experimentStartTime = time.Now()
-		r.experimentStarted = true
-	}
-	if r.experimentStartTime.Add(r.experimentDuration).Before(time.Now()) && (blockNum > 3) {
+// recover applies the blocks committed before a restart to the state machine
+func (r *ReplicaView) recover() {
+	if r.store == nil {
 		return
 	}
-	r.committedBlockNo++
-
-	proposeTime := block.Timestamp
-	if blockNum > 1 {
-		r.allBlockTimes[blockNum] = proposeTime.Sub(r.lastBlockProposeTime)
+	recovered := 0
+	err := r.store.Replay(func(v interface{}) error {
+		record, ok := v.(blockchain.CommittedRecord)
+		if !ok {
+			return fmt.Errorf("unexpected record in the store: %T", v)
+		}
+		block := record.Block
+		r.mempool.Remove(block.Payload, int(block.View), block.ID)
+		r.sm.Apply(&statemachine.Block{Height: int(block.View), ID: block.ID, Txns: block.Payload})
+		r.appliedHeight.Store(int64(int(block.View)))
+		recovered++
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("[%v] cannot replay the store: %v", r.ID(), err)
 	}
-	now := time.Now()
-	r.allBlockLatency[blockNum] = now.Sub(proposeTime)
-	if block.Proposer == r.ID() {
-		r.myBlockLatency[blockNum] = r.allBlockLatency[blockNum]
+	if recovered > 0 {
+		log.Infof("[%v] replayed %v committed blocks", r.ID(), recovered)
 	}
+}
 
-	r.lastBlockProposeTime = proposeTime
-
-	log.Infof("[%v] the block is committed, view: %v, id: %x", r.ID(), block.View, block.ID)
+func (r *ReplicaView) processCommittedBlock(block *blockchain.Block) {
+	r.mempool.Remove(block.Payload, int(block.View), block.ID)
+	r.sm.Apply(&statemachine.Block{Height: int(block.View), ID: block.ID, Txns: block.Payload})
+	r.appliedHeight.Store(int64(int(block.View)))
+	r.stats.commit(block.Proposer, block.Timestamp)
+	log.Infof("[%v] the block is committed, view: %v, id: %x, prev: %x", r.ID(), block.View, block.ID, block.PrevID)
 }
 
 func (r *ReplicaView) processForkedBlock(block *blockchain.Block) {
+	r.mempool.Return(block.Payload)
+	r.stats.fork()
 	log.Infof("[%v] the block is forked, No. of transactions: %v, view: %v, current view: %v, id: %x", r.ID(), len(block.Payload), block.View, r.pm.GetCurView(), block.ID)
 }
 
@@ -194,6 +267,10 @@ func (r *ReplicaView) processNewView(newView types.View) {
 
 func (r *ReplicaView) proposeBlock(view types.View) {
 	block := r.SafetyView.MakeProposal(view, r.oneBlockPayloadBytes)
+	if block == nil {
+		log.Warningf("[%v] has already proposed in view %v", r.ID(), view)
+		return
+	}
 	block.Timestamp = time.Now()
 	r.Broadcast(block)
 	_ = r.SafetyView.ProcessBlock(block)
@@ -201,7 +278,7 @@ func (r *ReplicaView) proposeBlock(view types.View) {
 
 // ListenLocalEvent listens new view and timeout events
 func (r *ReplicaView) ListenLocalEvent() {
-	silence := r.isByz
+	silence := r.isSilent()
 	if silence {
 		for {
 			<-r.pm.EnteringViewEvent()
@@ -225,6 +302,7 @@ func (r *ReplicaView) ListenLocalEvent() {
 				log.Debugf("[%v] the last view lasts %v milliseconds, current view: %v", r.ID(), lasts.Milliseconds(), view)
 				break L
 			case <-r.timer.C:
+				r.stats.timeout()
 				r.SafetyView.ProcessLocalTmo(r.pm.GetCurView())
 				break L
 			}
@@ -244,6 +322,11 @@ func (r *ReplicaView) ListenCommittedBlocks() {
 	}
 }
 
+// isSilent tells whether I am Byzantine and send nothing, I do not run the protocol then
+func (r *ReplicaView) isSilent() bool {
+	return r.isByz && byzantine.Silent(r.strategy)
+}
+
 func (r *ReplicaView) startSignal() {
 	if !r.isStarted.Load() {
 		log.Debugf("[%v] is boosting", r.ID())
@@ -256,7 +339,7 @@ func (r *ReplicaView) startSignal() {
 func (r *ReplicaView) Start() {
 	go r.Run()
 
-	silence := r.isByz
+	silence := r.isSilent()
 
 	go r.ListenLocalEvent()
 	go r.ListenCommittedBlocks()
@@ -273,8 +356,14 @@ func (r *ReplicaView) Start() {
 			r.SafetyView.ProcessBlock(&v)
 		case blockchain.Vote:
 			r.SafetyView.ProcessVote(&v)
+		case *blockchain.Vote: // from the verifier
+			r.SafetyView.ProcessVote(v)
 		case pacemaker.TMO:
 			r.SafetyView.ProcessRemoteTmo(&v)
+		case blockchain.BlockRequest:
+			r.SafetyView.ProcessBlockRequest(&v)
+		case blockchain.BlockResponse:
+			r.SafetyView.ProcessBlockResponse(&v)
 		}
 	}
 }
@@ -9,4 +9,6 @@ type Safety interface {
 	ProcessNotarizationShare(vote *blockchain.NotarizationShare)
 	ProcessFinalizationShare(vote *blockchain.FinalizationShare)
 	MakeProposal(height int, rank int, payloadSize int) *blockchain.Block
+	ProcessBlockRequest(request *blockchain.BlockRequest)
+	ProcessBlockResponse(response *blockchain.BlockResponse)
 }
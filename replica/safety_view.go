@@ -13,4 +13,6 @@ type SafetyView interface {
 	ProcessLocalTmo(view types.View)
 	MakeProposal(view types.View, payloadSize int) *blockchain.Block
 	GetChainStatus() string
+	ProcessBlockRequest(request *blockchain.BlockRequest)
+	ProcessBlockResponse(response *blockchain.BlockResponse)
 }
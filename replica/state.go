@@ -0,0 +1,16 @@
+package replica
+
+import (
+	"banyan/message"
+	"banyan/statemachine"
+)
+
+// queryState replies with the digest of the applied state and, if asked, the value read by the command
+func queryState(sm statemachine.StateMachine, height int, m message.StateQuery) {
+	reply := message.StateReply{Height: height}
+	reply.Digest, reply.Err = statemachine.Digest(sm)
+	if reply.Err == nil && len(m.Command) > 0 {
+		reply.Value, reply.Err = sm.Query(m.Command)
+	}
+	m.Reply(reply)
+}
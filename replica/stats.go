@@ -0,0 +1,126 @@
+package replica
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"banyan/identity"
+	"banyan/metrics"
+)
+
+// stats measures the committed blocks of a replica, both for the metrics of the node
+// and for the report of an experiment that /query returns once the experiment is over.
+// The experiment starts with the third committed block and lasts for the configured duration.
+type stats struct {
+	mu sync.Mutex
+	id identity.NodeID
+
+	committed       *metrics.Counter
+	forked          *metrics.Counter
+	timeouts        *metrics.Counter
+	commitLatency   *metrics.Histogram
+	proposerLatency *metrics.Histogram
+	blockInterval   *metrics.Histogram
+
+	experimentStartTime  time.Time
+	experimentDuration   time.Duration
+	experimentStarted    bool
+	payloadBytes         int
+	seenBlockNo          int // committed since the start of the process
+	committedBlockNo     int // committed during the experiment
+	lastBlockProposeTime time.Time
+	allBlockLatency      []time.Duration
+	myBlockLatency       []time.Duration // 0 for the blocks proposed by the others
+	allBlockTimes        []time.Duration
+}
+
+func newStats(id identity.NodeID, registry *metrics.Registry, duration time.Duration, payloadBytes int) *stats {
+	return &stats{
+		id:                 id,
+		committed:          registry.Counter("banyan_committed_blocks_total", "Blocks committed by the replica."),
+		forked:             registry.Counter("banyan_forked_blocks_total", "Blocks pruned because they did not make it to the committed chain."),
+		timeouts:           registry.Counter("banyan_timeouts_total", "Local timeouts of the replica."),
+		commitLatency:      registry.Histogram("banyan_commit_latency_seconds", "Time from the proposal of a block to its commit.", metrics.LatencyBuckets),
+		proposerLatency:    registry.Histogram("banyan_proposer_commit_latency_seconds", "Time from the proposal of a block to its commit, for the blocks the replica proposed.", metrics.LatencyBuckets),
+		blockInterval:      registry.Histogram("banyan_block_interval_seconds", "Time between the proposals of consecutive committed blocks.", metrics.LatencyBuckets),
+		experimentDuration: duration,
+		payloadBytes:       payloadBytes,
+	}
+}
+
+// commit records a committed block, it returns whether the block was committed during the experiment
+func (s *stats) commit(proposer identity.NodeID, proposeTime time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	latency := now.Sub(proposeTime)
+	s.committed.Inc()
+	s.commitLatency.ObserveDuration(latency)
+	if proposer == s.id {
+		s.proposerLatency.ObserveDuration(latency)
+	}
+	var interval time.Duration
+	if !s.lastBlockProposeTime.IsZero() {
+		interval = proposeTime.Sub(s.lastBlockProposeTime)
+		s.blockInterval.ObserveDuration(interval)
+	}
+	s.lastBlockProposeTime = proposeTime
+
+	s.seenBlockNo++
+	if s.seenBlockNo == 3 {
+		s.experimentStartTime = now
+		s.experimentStarted = true
+	}
+	if !s.experimentStarted || s.experimentStartTime.Add(s.experimentDuration).Before(now) {
+		return false
+	}
+	s.committedBlockNo++
+	s.allBlockLatency = append(s.allBlockLatency, latency)
+	if proposer == s.id {
+		s.myBlockLatency = append(s.myBlockLatency, latency)
+	} else {
+		s.myBlockLatency = append(s.myBlockLatency, 0)
+	}
+	s.allBlockTimes = append(s.allBlockTimes, interval)
+	return true
+}
+
+func (s *stats) fork() {
+	s.forked.Inc()
+}
+
+func (s *stats) timeout() {
+	s.timeouts.Inc()
+}
+
+// report returns the number of blocks committed so far while the experiment runs,
+// and the latencies of all of them once it is over
+func (s *stats) report() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !(s.experimentStarted && s.experimentStartTime.Add(s.experimentDuration).Before(time.Now())) {
+		return fmt.Sprintf("Committed blocks: %v.\n", s.committedBlockNo)
+	}
+
+	var b strings.Builder
+	b.WriteString("blockPayloadSize\n")
+	b.WriteString(strconv.Itoa(s.payloadBytes) + "\n")
+	b.WriteString("committedBlocks\n")
+	b.WriteString(strconv.Itoa(s.committedBlockNo) + "\n")
+	b.WriteString("allBlockLatency\n")
+	writeMilliseconds(&b, s.allBlockLatency)
+	b.WriteString("\nproposerLatency\n")
+	writeMilliseconds(&b, s.myBlockLatency)
+	b.WriteString("\nblockTime\n")
+	writeMilliseconds(&b, s.allBlockTimes)
+	return b.String()
+}
+
+func writeMilliseconds(b *strings.Builder, durations []time.Duration) {
+	for _, d := range durations {
+		b.WriteString(strconv.Itoa(int(d.Milliseconds())) + ",")
+	}
+}
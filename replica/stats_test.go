@@ -0,0 +1,44 @@
+package replica
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"banyan/identity"
+	"banyan/metrics"
+
+	"github.com/stretchr/testify/require"
+)
+
+// the experiment starts with the third block and records any number of blocks
+func TestStatsExperiment(t *testing.T) {
+	registry := metrics.NewRegistry()
+	me, other := identity.NewNodeID(1), identity.NewNodeID(2)
+	s := newStats(me, registry, time.Hour, 32)
+	proposed := time.Now().Add(-time.Second)
+	for i := 0; i < 20000; i++ {
+		proposer := other
+		if i%2 == 0 {
+			proposer = me
+		}
+		inExperiment := s.commit(proposer, proposed.Add(time.Duration(i)*time.Microsecond))
+		require.Equal(t, i >= 2, inExperiment)
+	}
+	s.fork()
+	s.timeout()
+
+	require.Equal(t, "Committed blocks: 19998.\n", s.report())
+	s.experimentStartTime = time.Now().Add(-2 * time.Hour)
+	report := s.report()
+	require.True(t, strings.HasPrefix(report, "blockPayloadSize\n32\ncommittedBlocks\n19998\nallBlockLatency\n"))
+	require.Len(t, s.allBlockLatency, 19998)
+	require.Len(t, s.myBlockLatency, 19998)
+
+	stats := registry.Snapshot()
+	require.Equal(t, 20000.0, stats.Counters["banyan_committed_blocks_total"][""])
+	require.Equal(t, 1.0, stats.Counters["banyan_forked_blocks_total"][""])
+	require.Equal(t, 1.0, stats.Counters["banyan_timeouts_total"][""])
+	require.Equal(t, uint64(10000), stats.Histograms["banyan_proposer_commit_latency_seconds"][""].Count)
+	require.Equal(t, uint64(19999), stats.Histograms["banyan_block_interval_seconds"][""].Count)
+}
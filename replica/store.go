@@ -0,0 +1,23 @@
+package replica
+
+import (
+	"path/filepath"
+
+	"banyan/config"
+	"banyan/identity"
+	"banyan/log"
+	"banyan/store"
+)
+
+// openStore opens the block store of the replica, nil if persistence is disabled
+func openStore(id identity.NodeID) *store.Store {
+	dir := config.GetConfig().StoreDir
+	if dir == "" {
+		return nil
+	}
+	st, err := store.Open(filepath.Join(dir, string(id)), config.GetConfig().StoreSync)
+	if err != nil {
+		log.Fatalf("[%v] cannot open the store: %v", id, err)
+	}
+	return st
+}
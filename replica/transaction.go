@@ -0,0 +1,28 @@
+package replica
+
+import (
+	"banyan/identity"
+	"banyan/log"
+	"banyan/mempool"
+	"banyan/message"
+	"banyan/node"
+)
+
+// submitTransactions queues client transactions if this replica is the given leader,
+// otherwise it forwards them so that they are proposed without waiting for our own turn.
+// A batch that does not fit in the pool is rejected as a whole.
+func submitTransactions(n node.Node, mp *mempool.MemPool, leader identity.NodeID, txns []*message.Transaction) error {
+	if leader != n.ID() {
+		mp.Forward(txns)
+		n.Send(leader, message.ForwardedTransactions{From: n.ID(), Txns: txns})
+		return nil
+	}
+	return mp.AddAll(txns)
+}
+
+// addForwardedTransactions queues transactions forwarded by another replica
+func addForwardedTransactions(n node.Node, mp *mempool.MemPool, m message.ForwardedTransactions) {
+	if err := mp.AddAll(m.Txns); err != nil {
+		log.Warningf("[%v] dropped %v transactions forwarded by %v: %v", n.ID(), len(m.Txns), m.From, err)
+	}
+}
@@ -0,0 +1,34 @@
+package replica
+
+import (
+	"banyan/blockchain"
+	view "banyan/blockchain_view"
+	"banyan/dissemination"
+	"banyan/evidence"
+	"banyan/message"
+	"banyan/pacemaker"
+	"banyan/transport"
+)
+
+// The tags of the messages the replicas send each other. A tag is never reused for another type, a message that
+// goes away leaves a gap, so that replicas of different versions keep understanding the messages they share.
+func init() {
+	transport.Register(1, message.ForwardedTransactions{})
+	transport.Register(2, evidence.EquivocationProof{})
+	transport.Register(3, dissemination.Chunk{})
+
+	// Banyan and ICC
+	transport.Register(10, blockchain.Block{})
+	transport.Register(11, blockchain.NotarizationShare{})
+	transport.Register(12, blockchain.FinalizationShare{})
+	transport.Register(13, blockchain.BlockRequest{})
+	transport.Register(14, blockchain.BlockResponse{})
+
+	// HotStuff and Streamlet
+	transport.Register(20, view.Block{})
+	transport.Register(21, view.Vote{})
+	transport.Register(22, view.BlockRequest{})
+	transport.Register(23, view.BlockResponse{})
+	transport.Register(24, pacemaker.TMO{})
+	transport.Register(25, pacemaker.TC{})
+}
@@ -3,7 +3,8 @@ package main
 import (
 	"banyan"
 	"flag"
-	"strconv"
+	"os"
+	"path/filepath"
 	"sync"
 
 	"banyan/config"
@@ -20,7 +21,7 @@ var simulation = flag.Bool("sim", false, "simulation mode")
 func initReplica(id identity.NodeID, isByz bool) {
 	log.Infof("node %v starting...", id)
 	if isByz {
-		log.Infof("node %v is Byzantine", id)
+		log.Infof("node %v is Byzantine, strategy: %v", id, config.GetConfig().StrategyOf(id))
 	}
 
 	switch *algorithm {
@@ -38,29 +39,33 @@ func initReplica(id identity.NodeID, isByz bool) {
 
 func main() {
 	banyan.Init()
-	// the private and public keys are generated here
-	errCrypto := crypto.SetKeys()
-	if errCrypto != nil {
-		log.Fatal("Could not generate keys:", errCrypto)
+	if err := loadKeys(); err != nil {
+		log.Fatal("Could not load keys: ", err)
 	}
 	if *simulation {
 		var wg sync.WaitGroup
 		wg.Add(1)
 		config.Simulation()
 		for id := range config.GetConfig().Addrs {
-			isByz := false
-			if id.Node() > config.GetConfig().N-config.GetConfig().ByzNo {
-				isByz = true
-			}
-			go initReplica(id, isByz)
+			go initReplica(id, config.GetConfig().IsByzantine(id))
 		}
 		wg.Wait()
 	} else {
-		isByz := false
-		i, _ := strconv.Atoi(*id)
-		if i > config.GetConfig().N-config.GetConfig().ByzNo {
-			isByz = true
-		}
-		initReplica(identity.NodeID(*id), isByz)
+		initReplica(identity.NodeID(*id), config.GetConfig().IsByzantine(identity.NodeID(*id)))
+	}
+}
+
+// loadKeys loads the private key of the node and the public keys of the registry written by keygen. The nodes of a
+// simulation all run in this process, they use the private keys of all the nodes, generated on the spot when there
+// are none.
+func loadKeys() error {
+	dir := config.GetConfig().KeyDir
+	if !*simulation {
+		return crypto.LoadKeys(dir, identity.NodeID(*id))
+	}
+	if _, err := os.Stat(filepath.Join(dir, crypto.RegistryFile)); os.IsNotExist(err) {
+		log.Infof("no keys in %v, the keys of the nodes are generated", dir)
+		return crypto.GenerateKeys(config.GetConfig().GetSignatureScheme(), config.GetConfig().IDs())
 	}
+	return crypto.LoadKeys(dir, config.GetConfig().IDs()...)
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"banyan/log"
+	"banyan/simulator"
+)
+
+var algorithm = flag.String("algorithm", "banyan", "BFT consensus algorithm (banyan, icc, hotstuff, streamlet)")
+var n = flag.Int("n", 4, "number of replicas")
+var f = flag.Int("f", -1, "number of faulty replicas tolerated, (n-1)/3 if negative")
+var p = flag.Int("p", 1, "parameter p of Banyan")
+var seed = flag.Int64("seed", 1, "seed of the message delays and the network faults")
+var duration = flag.Duration("duration", 10*time.Second, "virtual time to simulate")
+var timeout = flag.Duration("timeout", 350*time.Millisecond, "timeout of a rank (view)")
+var latency = flag.String("latency", "uniform:5ms,15ms", "latency model: fixed:<d>, uniform:<min>,<max>, normal:<mean>,<stddev> or regions:<count>:<local model>:<remote model>")
+var payload = flag.Int("payload", 0, "maximum bytes of transactions in a block")
+var byzNo = flag.Int("byzNo", 0, "the last byzNo replicas are Byzantine")
+var strategy = flag.String("strategy", "", "strategy of the Byzantine replicas, silence if empty")
+var faults = flag.String("faults", "", "network faults as in the faults section of config.json, e.g. {\"partition\": [[\"1\", \"2\"]]}")
+var out = flag.String("out", "", "optional json file receiving the report with every commit")
+
+func main() {
+	_ = flag.Set("log_level", "error")
+	flag.Parse()
+	log.Setup()
+
+	c := simulator.Config{
+		Algorithm:   *algorithm,
+		N:           *n,
+		F:           *f,
+		P:           *p,
+		Seed:        *seed,
+		Duration:    *duration,
+		Timeout:     *timeout,
+		PayloadSize: *payload,
+		ByzNo:       *byzNo,
+		Strategy:    *strategy,
+	}
+	if c.F < 0 {
+		c.F = (c.N - 1) / 3
+	}
+	var err error
+	c.Latency, err = simulator.ParseLatency(*latency)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *faults != "" {
+		err = json.Unmarshal([]byte(*faults), &c.Faults)
+		if err != nil {
+			log.Fatalf("invalid faults: %v", err)
+		}
+	}
+	sim, err := simulator.New(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report := sim.Run()
+	fmt.Print(report)
+	if *out != "" {
+		err = writeReport(*out, report)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func writeReport(name string, report *simulator.Report) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
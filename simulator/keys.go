@@ -0,0 +1,50 @@
+package simulator
+
+import (
+	"bytes"
+
+	"banyan/crypto"
+	"banyan/identity"
+)
+
+// simAlgorithm is the signature scheme of the simulated replicas
+const simAlgorithm = "SIM_HASH"
+
+// simKey signs with the hash of the id of the node and the data. Anyone who knows the id can forge it, but it is
+// deterministic and far cheaper than a real signature, which lets the simulator run hundreds of replicas.
+type simKey struct {
+	id identity.NodeID
+}
+
+func simKeys(n int) []crypto.PrivateKey {
+	keys := make([]crypto.PrivateKey, n)
+	for i := range keys {
+		keys[i] = simKey{id: identity.NewNodeID(i + 1)}
+	}
+	return keys
+}
+
+func (k simKey) Algorithm() string {
+	return simAlgorithm
+}
+
+func (k simKey) Sign(data []byte, _ crypto.Hasher) (crypto.Signature, error) {
+	return k.sign(data), nil
+}
+
+func (k simKey) PublicKey() crypto.PublicKey {
+	return k
+}
+
+func (k simKey) Verify(sig crypto.Signature, hash crypto.Hash) (bool, error) {
+	return bytes.Equal(sig, k.sign(hash)), nil
+}
+
+func (k simKey) sign(data []byte) []byte {
+	return crypto.NewSHA3_256().ComputeHash(append([]byte(k.id+":"), data...))
+}
+
+// Encode returns the id, which is all there is to the key
+func (k simKey) Encode() ([]byte, error) {
+	return []byte(k.id), nil
+}
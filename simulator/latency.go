@@ -0,0 +1,125 @@
+package simulator
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"banyan/identity"
+)
+
+// Latency draws the one-way delay of a message from a node to a peer, the simulator passes the source of randomness
+// so that the same seed draws the same delays
+type Latency interface {
+	Delay(from identity.NodeID, to identity.NodeID, r *rand.Rand) time.Duration
+}
+
+// Fixed delays every message by the same time
+type Fixed time.Duration
+
+func (f Fixed) Delay(identity.NodeID, identity.NodeID, *rand.Rand) time.Duration {
+	return time.Duration(f)
+}
+
+// Uniform delays the messages by a time drawn uniformly between Min and Max
+type Uniform struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+func (u Uniform) Delay(_ identity.NodeID, _ identity.NodeID, r *rand.Rand) time.Duration {
+	if u.Max <= u.Min {
+		return u.Min
+	}
+	return u.Min + time.Duration(r.Int63n(int64(u.Max-u.Min)+1))
+}
+
+// Normal delays the messages by a time drawn from a normal distribution, cut off at zero
+type Normal struct {
+	Mean   time.Duration
+	StdDev time.Duration
+}
+
+func (n Normal) Delay(_ identity.NodeID, _ identity.NodeID, r *rand.Rand) time.Duration {
+	d := n.Mean + time.Duration(r.NormFloat64()*float64(n.StdDev))
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// Regions places the nodes in regions in turn, node i in region (i-1) mod Count,
+// the messages within a region are delayed by Local and the others by Remote
+type Regions struct {
+	Count  int
+	Local  Latency
+	Remote Latency
+}
+
+func (g Regions) Delay(from identity.NodeID, to identity.NodeID, r *rand.Rand) time.Duration {
+	if (from.Node()-1)%g.Count == (to.Node()-1)%g.Count {
+		return g.Local.Delay(from, to, r)
+	}
+	return g.Remote.Delay(from, to, r)
+}
+
+// ParseLatency reads a latency model: "fixed:10ms", "uniform:5ms,20ms", "normal:50ms,10ms",
+// or "regions:3:<local model>:<remote model>", e.g. "regions:3:fixed:1ms:normal:80ms,10ms"
+func ParseLatency(s string) (Latency, error) {
+	model, params := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		model, params = s[:i], s[i+1:]
+	}
+	if model == "regions" {
+		return parseRegions(params)
+	}
+	durations, err := parseDurations(params)
+	if err != nil {
+		return nil, fmt.Errorf("latency %q: %v", s, err)
+	}
+	switch {
+	case model == "fixed" && len(durations) == 1:
+		return Fixed(durations[0]), nil
+	case model == "uniform" && len(durations) == 2 && durations[0] <= durations[1]:
+		return Uniform{Min: durations[0], Max: durations[1]}, nil
+	case model == "normal" && len(durations) == 2:
+		return Normal{Mean: durations[0], StdDev: durations[1]}, nil
+	}
+	return nil, fmt.Errorf("invalid latency %q", s)
+}
+
+func parseRegions(params string) (Latency, error) {
+	parts := strings.Split(params, ":")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid regions %q, expected <count>:<model>:<params>:<model>:<params>", params)
+	}
+	var count int
+	if _, err := fmt.Sscanf(parts[0], "%d", &count); err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid number of regions %q", parts[0])
+	}
+	local, err := ParseLatency(parts[1] + ":" + parts[2])
+	if err != nil {
+		return nil, err
+	}
+	remote, err := ParseLatency(parts[3] + ":" + parts[4])
+	if err != nil {
+		return nil, err
+	}
+	return Regions{Count: count, Local: local, Remote: remote}, nil
+}
+
+func parseDurations(s string) ([]time.Duration, error) {
+	var durations []time.Duration
+	for _, field := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("negative duration %v", d)
+		}
+		durations = append(durations, d)
+	}
+	return durations, nil
+}
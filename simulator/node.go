@@ -0,0 +1,76 @@
+package simulator
+
+import (
+	"time"
+
+	"banyan/identity"
+	"banyan/metrics"
+	"banyan/socket"
+	"banyan/transport"
+)
+
+// simNode is the node of a simulated replica, it hands the messages the protocol sends to the simulator
+// instead of a socket and tells the virtual time
+type simNode struct {
+	sim      *Simulator
+	index    int // the position of the node in the simulator
+	id       identity.NodeID
+	behavior socket.Behavior // nil if the node is honest
+	metrics  *metrics.Registry
+}
+
+func (n *simNode) ID() identity.NodeID {
+	return n.id
+}
+
+// Send sends the message to the peer, or what the strategy of a Byzantine node decides instead
+func (n *simNode) Send(to identity.NodeID, m interface{}) {
+	if n.behavior != nil {
+		n.behavior.Outgoing(to, m, n.send)
+		return
+	}
+	n.send(to, m)
+}
+
+// Broadcast sends the message to all peers, in the order of their ids
+func (n *simNode) Broadcast(m interface{}) {
+	for _, peer := range n.sim.ids {
+		if peer != n.id {
+			n.Send(peer, m)
+		}
+	}
+}
+
+func (n *simNode) send(to identity.NodeID, m interface{}) {
+	n.sim.send(n.index, to, m)
+}
+
+// Recv is not used, the simulator delivers the messages to the replica
+func (n *simNode) Recv() interface{} {
+	return nil
+}
+
+// Connections is empty, the simulator has no network
+func (n *simNode) Connections() map[identity.NodeID]transport.State {
+	return nil
+}
+
+func (n *simNode) Close() {}
+
+func (n *simNode) Run() {}
+
+// Register is not used, the simulator calls the protocol for each message
+func (n *simNode) Register(interface{}, interface{}) {}
+
+func (n *simNode) IsByz() bool {
+	return n.behavior != nil
+}
+
+func (n *simNode) Metrics() *metrics.Registry {
+	return n.metrics
+}
+
+// Now returns the virtual time, the protocols time their block requests with it
+func (n *simNode) Now() time.Time {
+	return n.sim.Now()
+}
@@ -0,0 +1,56 @@
+package simulator
+
+import (
+	"container/heap"
+	"time"
+)
+
+// event is a message delivered to a node, a timer of a node, or an action scheduled with At
+type event struct {
+	at   time.Duration // virtual time since the start
+	node int           // the node the event happens on, -1 for an action
+	from int           // the sender of a message, -1 for a timer or an action
+	seq  uint64        // messages sent on the link before, timers of the node or actions scheduled before
+
+	m    interface{} // the message
+	fire func()      // the timer or the action
+}
+
+// before orders the events by time, and the events at the same time by where they come from rather than by when they
+// were scheduled: the order a replica sends messages in may follow the order of a map, which changes from run to run
+func (e *event) before(o *event) bool {
+	if e.at != o.at {
+		return e.at < o.at
+	}
+	if e.node != o.node {
+		return e.node < o.node
+	}
+	if e.from != o.from {
+		return e.from < o.from
+	}
+	return e.seq < o.seq
+}
+
+// queue is a priority queue of the events, the next one first
+type queue []*event
+
+func (q queue) Len() int            { return len(q) }
+func (q queue) Less(i, j int) bool  { return q[i].before(q[j]) }
+func (q queue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *queue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+
+func (q *queue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return e
+}
+
+func (q *queue) push(e *event) {
+	heap.Push(q, e)
+}
+
+func (q *queue) pop() *event {
+	return heap.Pop(q).(*event)
+}
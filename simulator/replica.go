@@ -0,0 +1,257 @@
+package simulator
+
+import (
+	"banyan/blockchain"
+	view "banyan/blockchain_view"
+	"banyan/config"
+	"banyan/election"
+	"banyan/evidence"
+	"banyan/local_timeout"
+	"banyan/log"
+	"banyan/mempool"
+	"banyan/pacemaker"
+	"banyan/protocol"
+	"banyan/replica"
+	"banyan/types"
+)
+
+// capacity of the channels the protocols pass the committed blocks on, they are drained after every event
+// but a replica that catches up commits many blocks at once
+const committedCapacity = 10000
+
+// simReplica is a replica driven by the simulator, in place of the goroutines and the timers of package replica
+type simReplica interface {
+	node() *simNode
+	start()
+	deliver(m interface{})
+	timeouts() int
+}
+
+// driver is what the replicas of both kinds share, the timer in virtual time
+type driver struct {
+	*simNode
+	timer    uint64 // timers set so far, only the last one fires
+	timedOut int
+}
+
+func (d *driver) node() *simNode {
+	return d.simNode
+}
+
+func (d *driver) timeouts() int {
+	return d.timedOut
+}
+
+// resetTimer makes the timeout fire after the configured time unless the timer is reset again before
+func (d *driver) resetTimer(timeout func()) {
+	d.timer++
+	timer := d.timer
+	d.sim.timer(d.index, func() {
+		if timer == d.timer {
+			d.timedOut++
+			timeout()
+		}
+	})
+}
+
+func (d *driver) receiveProof(collector *evidence.Collector, proof evidence.EquivocationProof) {
+	err := collector.Receive(&proof)
+	if err != nil {
+		log.Warningf("[%v] received an invalid proof against %v from %v: %v", d.id, proof.Offender, proof.Reporter, err)
+	}
+}
+
+// heightReplica runs Banyan or ICC, it proposes on each new height and on a higher rank after each timeout
+type heightReplica struct {
+	driver
+	replica.Safety
+	election.Election
+	lt        *local_timeout.LocalTimeout
+	evidence  *evidence.Collector
+	height    int
+	rank      int
+	committed chan *blockchain.CommittedRecord
+	forked    chan *blockchain.Block
+}
+
+func newHeightReplica(n *simNode, alg string) *heightReplica {
+	r := &heightReplica{
+		driver:    driver{simNode: n},
+		Election:  n.sim.config.Election,
+		lt:        local_timeout.NewLocalTimeout(),
+		evidence:  evidence.NewCollector(n),
+		committed: make(chan *blockchain.CommittedRecord, committedCapacity),
+		forked:    make(chan *blockchain.Block, committedCapacity),
+	}
+	mp := mempool.NewMemPool(config.GetConfig().MemSize, config.GetConfig().MemBytes)
+	switch alg {
+	case "icc":
+		r.Safety = protocol.NewIcc(n, r.Election, r.lt, mp, nil, r.evidence, r.committed, r.forked)
+	default:
+		r.Safety = protocol.NewBanyan(n, r.Election, r.lt, mp, nil, r.evidence, r.committed, r.forked, config.GetConfig().F, config.GetConfig().P)
+	}
+	return r
+}
+
+func (r *heightReplica) start() {
+	r.height = r.lt.GetCurHeight()
+	r.proposeIfLeader()
+	r.resetTimer(r.timeout)
+	r.drain()
+}
+
+func (r *heightReplica) timeout() {
+	r.rank++
+	r.proposeIfLeader()
+	r.resetTimer(r.timeout)
+	r.drain()
+}
+
+func (r *heightReplica) deliver(m interface{}) {
+	switch v := m.(type) {
+	case blockchain.Block:
+		_ = r.Safety.ProcessBlock(&v)
+	case blockchain.NotarizationShare:
+		r.Safety.ProcessNotarizationShare(&v)
+	case blockchain.FinalizationShare:
+		r.Safety.ProcessFinalizationShare(&v)
+	case blockchain.BlockRequest:
+		r.Safety.ProcessBlockRequest(&v)
+	case blockchain.BlockResponse:
+		r.Safety.ProcessBlockResponse(&v)
+	case evidence.EquivocationProof:
+		r.receiveProof(r.evidence, v)
+	}
+	r.drain()
+}
+
+func (r *heightReplica) proposeIfLeader() {
+	if !r.IsLeader(r.id, r.height, r.rank) {
+		return
+	}
+	block := r.Safety.MakeProposal(r.height, r.rank, config.GetConfig().PayloadSize)
+	if block == nil {
+		return
+	}
+	block.Timestamp = r.sim.Now()
+	r.Broadcast(block)
+	_ = r.Safety.ProcessBlock(block)
+}
+
+// drain handles what the protocol signaled while processing an event: new heights and committed blocks
+func (r *heightReplica) drain() {
+	for {
+		select {
+		case height := <-r.lt.GetNewHeight():
+			r.height, r.rank = height, 0
+			r.proposeIfLeader()
+			r.resetTimer(r.timeout)
+		case record := <-r.committed:
+			block := record.Block
+			r.sim.commit(r.index, Commit{
+				Height:   block.Height,
+				ID:       block.ID,
+				PrevID:   block.PrevID,
+				Proposer: block.Proposer,
+				Path:     record.Path.String(),
+				Proposed: block.Timestamp.Sub(Epoch),
+			})
+		case <-r.forked:
+		default:
+			return
+		}
+	}
+}
+
+// viewReplica runs HotStuff or Streamlet, it proposes when it enters a view it leads
+type viewReplica struct {
+	driver
+	replica.SafetyView
+	election.Election
+	pm        *pacemaker.Pacemaker
+	evidence  *evidence.Collector
+	committed chan *view.Block
+	forked    chan *view.Block
+}
+
+func newViewReplica(n *simNode, alg string) *viewReplica {
+	r := &viewReplica{
+		driver:    driver{simNode: n},
+		Election:  n.sim.config.Election,
+		pm:        pacemaker.NewPacemaker(config.GetConfig().N),
+		evidence:  evidence.NewCollector(n),
+		committed: make(chan *view.Block, committedCapacity),
+		forked:    make(chan *view.Block, committedCapacity),
+	}
+	mp := mempool.NewMemPool(config.GetConfig().MemSize, config.GetConfig().MemBytes)
+	switch alg {
+	case "streamlet":
+		r.SafetyView = protocol.NewStreamlet(n, r.pm, r.Election, mp, nil, r.evidence, r.committed, r.forked)
+	default:
+		r.SafetyView = protocol.NewHotStuff(n, r.pm, r.Election, mp, nil, r.evidence, r.committed, r.forked)
+	}
+	return r
+}
+
+func (r *viewReplica) start() {
+	r.resetTimer(r.timeout)
+	r.drain()
+}
+
+func (r *viewReplica) timeout() {
+	r.SafetyView.ProcessLocalTmo(r.pm.GetCurView())
+	r.resetTimer(r.timeout)
+	r.drain()
+}
+
+func (r *viewReplica) deliver(m interface{}) {
+	switch v := m.(type) {
+	case view.Block:
+		_ = r.SafetyView.ProcessBlock(&v)
+	case view.Vote:
+		r.SafetyView.ProcessVote(&v)
+	case pacemaker.TMO:
+		r.SafetyView.ProcessRemoteTmo(&v)
+	case view.BlockRequest:
+		r.SafetyView.ProcessBlockRequest(&v)
+	case view.BlockResponse:
+		r.SafetyView.ProcessBlockResponse(&v)
+	case evidence.EquivocationProof:
+		r.receiveProof(r.evidence, v)
+	}
+	r.drain()
+}
+
+func (r *viewReplica) propose(v types.View) {
+	block := r.SafetyView.MakeProposal(v, config.GetConfig().PayloadSize)
+	if block == nil {
+		return
+	}
+	block.Timestamp = r.sim.Now()
+	r.Broadcast(block)
+	_ = r.SafetyView.ProcessBlock(block)
+}
+
+// drain handles what the protocol signaled while processing an event: new views and committed blocks
+func (r *viewReplica) drain() {
+	for {
+		select {
+		case v := <-r.pm.EnteringViewEvent():
+			if r.IsLeaderView(r.id, v) {
+				r.propose(v)
+			}
+			r.resetTimer(r.timeout)
+		case block := <-r.committed:
+			r.sim.commit(r.index, Commit{
+				Height:   int(block.View),
+				ID:       block.ID,
+				PrevID:   block.PrevID,
+				Proposer: block.Proposer,
+				Proposed: block.Timestamp.Sub(Epoch),
+			})
+		case <-r.forked:
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"banyan/config"
+	"banyan/crypto"
+	"banyan/identity"
+)
+
+// Commit is a block committed by a replica, the times are virtual times since the start
+type Commit struct {
+	Height    int // the view in HotStuff and Streamlet
+	ID        crypto.Identifier
+	PrevID    crypto.Identifier
+	Proposer  identity.NodeID
+	Path      string // fast, slow or implicit in Banyan and ICC
+	Proposed  time.Duration
+	Committed time.Duration
+}
+
+// Latency returns the time from the proposal of the block to its commit
+func (c Commit) Latency() time.Duration {
+	return c.Committed - c.Proposed
+}
+
+type jsonCommit struct {
+	Height    int             `json:"height"`
+	ID        string          `json:"id"`
+	PrevID    string          `json:"prev_id"`
+	Proposer  identity.NodeID `json:"proposer"`
+	Path      string          `json:"path,omitempty"`
+	Proposed  time.Duration   `json:"proposed_ns"`
+	Committed time.Duration   `json:"committed_ns"`
+}
+
+// MarshalJSON writes the ids in hex
+func (c Commit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonCommit{c.Height, fmt.Sprintf("%x", c.ID), fmt.Sprintf("%x", c.PrevID), c.Proposer, c.Path, c.Proposed, c.Committed})
+}
+
+// UnmarshalJSON reads a commit written by MarshalJSON
+func (c *Commit) UnmarshalJSON(data []byte) error {
+	var j jsonCommit
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return err
+	}
+	c.ID, err = crypto.ParseID(j.ID)
+	if err != nil {
+		return err
+	}
+	c.PrevID, err = crypto.ParseID(j.PrevID)
+	if err != nil {
+		return err
+	}
+	c.Height, c.Proposer, c.Path, c.Proposed, c.Committed = j.Height, j.Proposer, j.Path, j.Proposed, j.Committed
+	return nil
+}
+
+// Report is the outcome of a simulation
+type Report struct {
+	Algorithm string
+	N         int
+	Seed      int64
+	Duration  time.Duration // virtual time, until Stop if the simulation was stopped
+	Wall      time.Duration // time the simulation took to run
+	Byzantine []identity.NodeID
+
+	Commits  map[identity.NodeID][]Commit // the blocks each replica committed, in order
+	Blocks   int                          // the highest number of blocks a replica committed
+	Timeouts int
+	Events   int
+	Messages map[string]int // messages delivered by type
+	Dropped  int            // messages lost to the network faults
+
+	// commit latency over all the blocks committed by the replicas
+	MeanLatency time.Duration
+	P50Latency  time.Duration
+	P99Latency  time.Duration
+
+	// Digest is a hash of every commit with the replica and the virtual time, the same seed gives the same digest
+	Digest crypto.Identifier
+}
+
+func (s *Simulator) report(wall time.Duration) *Report {
+	r := &Report{
+		Algorithm: s.config.Algorithm,
+		N:         s.config.N,
+		Seed:      s.config.Seed,
+		Duration:  s.now,
+		Wall:      wall,
+		Commits:   make(map[identity.NodeID][]Commit),
+		Events:    s.processed,
+		Messages:  s.messages,
+		Dropped:   s.dropped,
+	}
+	var latencies []time.Duration
+	var sum time.Duration
+	twins := make(map[identity.NodeID]bool)
+	for _, id := range s.config.Twins {
+		twins[id] = true
+	}
+	for i, commits := range s.commits {
+		id := s.instances[i]
+		// the commits of the second instance of a twin are only counted in the digest
+		if i < len(s.ids) {
+			r.Commits[id] = commits
+			if config.GetConfig().IsByzantine(id) || twins[id] {
+				r.Byzantine = append(r.Byzantine, id)
+			}
+		}
+		r.Timeouts += s.replicas[i].timeouts()
+		if len(commits) > r.Blocks {
+			r.Blocks = len(commits)
+		}
+		for _, c := range commits {
+			latencies = append(latencies, c.Latency())
+			sum += c.Latency()
+		}
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		r.MeanLatency = sum / time.Duration(len(latencies))
+		r.P50Latency = latencies[len(latencies)/2]
+		r.P99Latency = latencies[len(latencies)*99/100]
+	}
+	r.Digest = crypto.MakeID(s.commits)
+	return r
+}
+
+// String summarizes the report
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "algorithm: %v, replicas: %v, seed: %v\n", r.Algorithm, r.N, r.Seed)
+	messages := 0
+	for _, count := range r.Messages {
+		messages += count
+	}
+	fmt.Fprintf(&b, "virtual time: %v, wall time: %v, events: %v, messages: %v, dropped: %v\n", r.Duration, r.Wall.Round(time.Millisecond), r.Events, messages, r.Dropped)
+	fmt.Fprintf(&b, "committed blocks: %v (%.2f per second), timeouts: %v\n", r.Blocks, float64(r.Blocks)/r.Duration.Seconds(), r.Timeouts)
+	fmt.Fprintf(&b, "commit latency: mean %v, p50 %v, p99 %v\n", r.MeanLatency.Round(time.Microsecond), r.P50Latency.Round(time.Microsecond), r.P99Latency.Round(time.Microsecond))
+	fmt.Fprintf(&b, "digest: %x\n", r.Digest)
+	return b.String()
+}
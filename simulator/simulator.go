@@ -0,0 +1,370 @@
+// Package simulator runs all the replicas of a protocol in a single goroutine on a virtual clock. The messages are
+// delivered after delays drawn from a latency model with a seeded source of randomness, and the events are processed
+// in an order that does not depend on the machine, so that a seed reproduces the exact same execution however many
+// replicas there are.
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"time"
+
+	"banyan/byzantine"
+	"banyan/config"
+	"banyan/crypto"
+	"banyan/election"
+	"banyan/identity"
+	"banyan/metrics"
+	"banyan/socket"
+)
+
+// Epoch is the wall clock time the virtual time starts at
+var Epoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// reorderHold is how long a message overtaken on a link without delay is held back, as on a socket
+const reorderHold = 10 * time.Millisecond
+
+// Config describes a simulation
+type Config struct {
+	Algorithm   string // banyan, icc, hotstuff or streamlet
+	N           int
+	F           int
+	P           int
+	Seed        int64
+	Duration    time.Duration // virtual time the simulation runs for
+	Timeout     time.Duration
+	Latency     Latency
+	PayloadSize int                        // maximum bytes of transactions in a block
+	ByzNo       int                        // the last ByzNo replicas are Byzantine
+	Strategy    string                     // strategy of the Byzantine replicas, silence if empty
+	Strategies  map[identity.NodeID]string // strategy of individual replicas, the replicas listed are Byzantine
+	Faults      config.FaultConfig         // network faults from the start, they can be changed with Faults
+
+	// Twins run twice, with the same identity and keys, the second instance of Twins[i] is instance N+i. A message to
+	// a twin goes to both instances, and the twins count as Byzantine.
+	Twins []identity.NodeID
+	// Election decides the leader of each height and rank (view), the replicas take turns if nil
+	Election election.Election
+
+	// OnCommit, if set, is called with every block a replica commits as soon as it does
+	OnCommit func(id identity.NodeID, c Commit)
+}
+
+// Simulator runs the replicas on a virtual clock
+type Simulator struct {
+	config    Config
+	ids       []identity.NodeID // the identities of the replicas
+	instances []identity.NodeID // the identity of each instance, the twins run twice
+	replicas  []simReplica
+	silent    []bool
+	faults    *socket.Faults
+	groups    []int // the group of each instance in the partition set by Isolate, nil if there is none
+
+	now     time.Duration
+	events  queue
+	links   map[[2]int]*link
+	timers  []uint64 // timers of each node so far
+	actions uint64
+	source  splitmix
+	rand    *rand.Rand
+
+	commits   [][]Commit
+	stopped   bool
+	processed int
+	messages  map[string]int // messages delivered by type
+	dropped   int
+}
+
+// link is the state of the messages sent from a node to a peer
+type link struct {
+	sent uint64        // messages sent so far
+	last time.Duration // when the last message in order arrives, messages on a link arrive in order like over TCP
+}
+
+// New sets up a simulation. The replicas share the global configuration, which is replaced, and use keys
+// that are cheap to sign with in place of the configured signature scheme.
+func New(c Config) (*Simulator, error) {
+	if c.N < 1 {
+		return nil, errors.New("no replicas to simulate")
+	}
+	if c.Timeout <= 0 || c.Duration <= 0 {
+		return nil, errors.New("the timeout and the duration have to be positive")
+	}
+	if c.Latency == nil {
+		return nil, errors.New("no latency model")
+	}
+	switch c.Algorithm {
+	case "banyan", "icc", "hotstuff", "streamlet":
+	default:
+		return nil, fmt.Errorf("unknown algorithm %v", c.Algorithm)
+	}
+	twins := make(map[identity.NodeID]bool)
+	for _, id := range c.Twins {
+		if id.Node() < 1 || id.Node() > c.N || twins[id] {
+			return nil, fmt.Errorf("invalid twin %v", id)
+		}
+		twins[id] = true
+	}
+	faults, err := socket.NewFaults(c.Faults)
+	if err != nil {
+		return nil, err
+	}
+	if c.Election == nil {
+		c.Election = election.NewRotation(c.N)
+	}
+	configure(c)
+	crypto.UseKeys(simKeys(c.N))
+
+	instances := c.N + len(c.Twins)
+	s := &Simulator{
+		config:   c,
+		faults:   faults,
+		links:    make(map[[2]int]*link),
+		timers:   make([]uint64, instances),
+		commits:  make([][]Commit, instances),
+		silent:   make([]bool, instances),
+		messages: make(map[string]int),
+	}
+	s.rand = rand.New(&s.source)
+	for i := 0; i < c.N; i++ {
+		s.ids = append(s.ids, identity.NewNodeID(i+1))
+	}
+	s.instances = append(append(s.instances, s.ids...), c.Twins...)
+	for i, id := range s.instances {
+		n := &simNode{sim: s, index: i, id: id, metrics: metrics.NewRegistry()}
+		if config.GetConfig().IsByzantine(id) {
+			strategy := config.GetConfig().StrategyOf(id)
+			if strategy == byzantine.ForkAndDelay {
+				return nil, fmt.Errorf("strategy %v holds its proposals back on the wall clock and cannot be simulated", strategy)
+			}
+			s.silent[i] = byzantine.Silent(strategy)
+			n.behavior, err = byzantine.New(strategy, id)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if c.Algorithm == "banyan" || c.Algorithm == "icc" {
+			s.replicas = append(s.replicas, newHeightReplica(n, c.Algorithm))
+		} else {
+			s.replicas = append(s.replicas, newViewReplica(n, c.Algorithm))
+		}
+	}
+	return s, nil
+}
+
+// configure replaces the global configuration the protocols read with the one of the simulation
+func configure(c Config) {
+	conf := config.MakeDefaultConfig()
+	conf.Addrs = make(map[identity.NodeID]string)
+	conf.HTTPAddrs = make(map[identity.NodeID]string)
+	for i := 1; i <= c.N; i++ {
+		id := identity.NewNodeID(i)
+		conf.Addrs[id] = "sim://" + string(id)
+	}
+	conf.N = c.N
+	conf.F = c.F
+	conf.P = c.P
+	conf.Timeout = int(c.Timeout / time.Millisecond)
+	conf.ExperimentDuration = int(c.Duration / time.Second)
+	conf.PayloadSize = c.PayloadSize
+	conf.ByzNo = c.ByzNo
+	conf.Strategy = c.Strategy
+	conf.Strategies = c.Strategies
+	conf.Faults = c.Faults
+	config.Configuration = conf
+}
+
+// Now returns the virtual time
+func (s *Simulator) Now() time.Time {
+	return Epoch.Add(s.now)
+}
+
+// Faults returns the network faults of the simulation, they can be changed from an action scheduled with At
+func (s *Simulator) Faults() *socket.Faults {
+	return s.faults
+}
+
+// Isolate partitions the instances into groups that cannot reach each other, unlike the partitions of Faults it
+// can separate twins. The instances not listed form a group of their own, and no groups heal the partition.
+func (s *Simulator) Isolate(groups [][]int) {
+	if len(groups) == 0 {
+		s.groups = nil
+		return
+	}
+	s.groups = make([]int, len(s.instances))
+	for i := range s.groups {
+		s.groups[i] = len(groups)
+	}
+	for g, group := range groups {
+		for _, i := range group {
+			if i >= 0 && i < len(s.groups) {
+				s.groups[i] = g
+			}
+		}
+	}
+}
+
+// At runs the action at the virtual time since the start, e.g. to partition the network
+func (s *Simulator) At(at time.Duration, action func()) {
+	s.actions++
+	s.events.push(&event{at: at, node: -1, from: -1, seq: s.actions, fire: action})
+}
+
+// Stop ends the simulation after the current event, e.g. from OnCommit once enough blocks are committed
+func (s *Simulator) Stop() {
+	s.stopped = true
+}
+
+// Run runs the simulation for the configured virtual time and reports what happened
+func (s *Simulator) Run() *Report {
+	started := time.Now()
+	for i, r := range s.replicas {
+		if !s.silent[i] {
+			r.start()
+		}
+	}
+	for !s.stopped && len(s.events) > 0 && s.events[0].at <= s.config.Duration {
+		e := s.events.pop()
+		s.now = e.at
+		s.processed++
+		if e.fire != nil {
+			e.fire()
+			continue
+		}
+		s.messages[messageType(e.m)]++
+		if s.silent[e.node] {
+			continue
+		}
+		n := s.replicas[e.node].node()
+		if n.behavior != nil {
+			n.behavior.Incoming(e.m, n.send)
+		}
+		s.replicas[e.node].deliver(e.m)
+	}
+	if !s.stopped {
+		s.now = s.config.Duration
+	}
+	return s.report(time.Since(started))
+}
+
+// send sends the message to the instances of the replica, both if it has a twin
+func (s *Simulator) send(from int, to identity.NodeID, m interface{}) {
+	target := to.Node() - 1
+	if target < 0 || target >= len(s.ids) {
+		return
+	}
+	s.transmit(from, target, m)
+	for i, twin := range s.config.Twins {
+		if twin == to {
+			s.transmit(from, len(s.ids)+i, m)
+		}
+	}
+}
+
+// transmit schedules the delivery of the message after the delay of the link, unless the faults of the link lose it
+func (s *Simulator) transmit(from int, target int, m interface{}) {
+	to := s.instances[target]
+	key := [2]int{from, target}
+	l, exists := s.links[key]
+	if !exists {
+		l = new(link)
+		s.links[key] = l
+	}
+	l.sent++
+	// the draws of a message only depend on the seed, the link and the number of messages sent on it before
+	s.source.state = mix(uint64(s.config.Seed), uint64(from), uint64(target), l.sent)
+
+	fault, cut := s.faults.Link(s.instances[from], to)
+	if s.groups != nil && s.groups[from] != s.groups[target] {
+		cut = true
+	}
+	if cut || (fault.Drop > 0 && s.rand.Float64() < fault.Drop) {
+		s.dropped++
+		return
+	}
+	copies := 1
+	if fault.Duplicate > 0 && s.rand.Float64() < fault.Duplicate {
+		copies = 2
+	}
+	// the receiver gets a copy of the message as it was when it was sent
+	v := reflect.ValueOf(m)
+	if v.Kind() == reflect.Ptr {
+		m = v.Elem().Interface()
+	}
+	for i := 0; i < copies; i++ {
+		at := s.now + s.config.Latency.Delay(s.instances[from], to, s.rand) + time.Duration(fault.Delay)*time.Millisecond
+		if fault.Jitter > 0 {
+			at += time.Duration(s.rand.Int63n(int64(fault.Jitter)*int64(time.Millisecond) + 1))
+		}
+		if fault.Reorder > 0 && s.rand.Float64() < fault.Reorder {
+			// the messages sent after it overtake it
+			hold := time.Duration(fault.Delay+fault.Jitter) * time.Millisecond
+			if hold == 0 {
+				hold = reorderHold
+			}
+			at += hold
+		} else {
+			if at < l.last {
+				at = l.last
+			}
+			l.last = at
+		}
+		s.events.push(&event{at: at, node: target, from: from, seq: l.sent*2 + uint64(i), m: m})
+	}
+}
+
+// timer runs fire on the node after the timeout
+func (s *Simulator) timer(node int, fire func()) {
+	s.timers[node]++
+	s.events.push(&event{at: s.now + s.config.Timeout, node: node, from: -1, seq: s.timers[node], fire: fire})
+}
+
+func (s *Simulator) commit(node int, c Commit) {
+	c.Committed = s.now
+	s.commits[node] = append(s.commits[node], c)
+	if s.config.OnCommit != nil {
+		s.config.OnCommit(s.instances[node], c)
+	}
+}
+
+// messageType names the type of the message, the same for a value and a pointer to it
+func messageType(m interface{}) string {
+	t := reflect.TypeOf(m)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.String()
+}
+
+// splitmix is a source of randomness whose state can be set before each draw
+type splitmix struct {
+	state uint64
+}
+
+func (s *splitmix) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *splitmix) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+func (s *splitmix) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+// mix combines the values into the state of a splitmix
+func mix(values ...uint64) uint64 {
+	var s splitmix
+	for _, v := range values {
+		s.state ^= v
+		s.state = s.Uint64()
+	}
+	return s.state
+}
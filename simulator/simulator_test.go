@@ -0,0 +1,124 @@
+package simulator
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"banyan/config"
+	"banyan/identity"
+
+	"github.com/stretchr/testify/require"
+)
+
+func run(t *testing.T, c Config) *Report {
+	_ = flag.Set("log_level", "error")
+	if c.N == 0 {
+		c.N, c.F, c.P = 4, 1, 1
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 350 * time.Millisecond
+	}
+	if c.Latency == nil {
+		c.Latency = Normal{Mean: 20 * time.Millisecond, StdDev: 8 * time.Millisecond}
+	}
+	s, err := New(c)
+	require.NoError(t, err)
+	return s.Run()
+}
+
+func TestProtocols(t *testing.T) {
+	for _, alg := range []string{"banyan", "icc", "hotstuff", "streamlet"} {
+		r := run(t, Config{Algorithm: alg, Seed: 1, Duration: 2 * time.Second})
+		require.Greater(t, r.Blocks, 10, alg)
+		require.Greater(t, int64(r.MeanLatency), int64(20*time.Millisecond), alg)
+		for id, commits := range r.Commits {
+			require.NotEmpty(t, commits, "%v: replica %v commits nothing", alg, id)
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	for _, alg := range []string{"banyan", "hotstuff"} {
+		c := Config{Algorithm: alg, Seed: 3, Duration: time.Second, Faults: config.FaultConfig{Links: map[string]config.LinkFault{
+			"*-*": {Jitter: 5, Duplicate: 0.05, Reorder: 0.05},
+		}}}
+		first, second := run(t, c), run(t, c)
+		require.Equal(t, first.Digest, second.Digest, alg)
+		require.Equal(t, first.Events, second.Events, alg)
+		c.Seed = 4
+		require.NotEqual(t, first.Digest, run(t, c).Digest, alg)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	s, err := New(Config{Algorithm: "hotstuff", N: 4, F: 1, Seed: 1, Duration: 4 * time.Second, Timeout: 200 * time.Millisecond, Latency: Fixed(10 * time.Millisecond)})
+	require.NoError(t, err)
+	s.At(time.Second, func() {
+		require.NoError(t, s.Faults().Partition([][]identity.NodeID{{"1", "2"}, {"3", "4"}}))
+	})
+	s.At(2*time.Second, s.Faults().Heal)
+	r := s.Run()
+	var during, after int
+	for _, c := range r.Commits["1"] {
+		if c.Committed > time.Second+100*time.Millisecond && c.Committed < 2*time.Second {
+			during++
+		}
+		if c.Committed > 2*time.Second {
+			after++
+		}
+	}
+	require.Zero(t, during, "no quorum in a partition")
+	require.Greater(t, after, 0, "commits resume once healed")
+	require.Greater(t, r.Dropped, 0)
+	require.Greater(t, r.Timeouts, 0)
+}
+
+func TestByzantine(t *testing.T) {
+	r := run(t, Config{Algorithm: "banyan", Seed: 1, Duration: 2 * time.Second, ByzNo: 1})
+	require.Empty(t, r.Commits["4"], "a silent replica does not run")
+	require.NotEmpty(t, r.Commits["1"])
+
+	_, err := New(Config{Algorithm: "banyan", N: 4, F: 1, P: 1, Duration: time.Second, Timeout: time.Second, Latency: Fixed(0), ByzNo: 1, Strategy: "fork_and_delay"})
+	require.Error(t, err)
+}
+
+func TestParseLatency(t *testing.T) {
+	l, err := ParseLatency("fixed:10ms")
+	require.NoError(t, err)
+	require.Equal(t, Fixed(10*time.Millisecond), l)
+	l, err = ParseLatency("uniform:5ms, 20ms")
+	require.NoError(t, err)
+	require.Equal(t, Uniform{Min: 5 * time.Millisecond, Max: 20 * time.Millisecond}, l)
+	l, err = ParseLatency("regions:3:fixed:1ms:normal:80ms,10ms")
+	require.NoError(t, err)
+	require.Equal(t, Regions{Count: 3, Local: Fixed(time.Millisecond), Remote: Normal{Mean: 80 * time.Millisecond, StdDev: 10 * time.Millisecond}}, l)
+
+	for _, invalid := range []string{"fixed", "fixed:1ms,2ms", "uniform:20ms,5ms", "normal:-1ms,1ms", "gamma:1ms", "regions:0:fixed:1ms:fixed:1ms"} {
+		_, err = ParseLatency(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestTwins(t *testing.T) {
+	_ = flag.Set("log_level", "error")
+	commits := make(map[identity.NodeID]int)
+	var s *Simulator
+	s, err := New(Config{Algorithm: "hotstuff", N: 4, F: 1, Seed: 1, Duration: 2 * time.Second, Timeout: 200 * time.Millisecond,
+		Latency: Fixed(10 * time.Millisecond), Twins: []identity.NodeID{"2"},
+		OnCommit: func(id identity.NodeID, c Commit) {
+			commits[id]++
+			if commits["1"] == 60 {
+				s.Stop()
+			}
+		}})
+	require.NoError(t, err)
+	// the second instance of replica 2 is cut off until the twins propose different blocks
+	s.Isolate([][]int{{4}})
+	s.At(500*time.Millisecond, func() { s.Isolate(nil) })
+	r := s.Run()
+	require.Equal(t, []identity.NodeID{"2"}, r.Byzantine)
+	require.Len(t, r.Commits["1"], 60)
+	require.Less(t, int64(r.Duration), int64(2*time.Second), "stopped")
+	require.Greater(t, r.Messages["evidence.EquivocationProof"], 0, "the twins proposed different blocks")
+}
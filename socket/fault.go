@@ -0,0 +1,239 @@
+package socket
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"banyan/config"
+	"banyan/identity"
+	"banyan/log"
+)
+
+// anyNode names every node on one side of a link
+const anyNode = "*"
+
+// reorderDelay is how long a message overtaken on a link without delay is held back
+const reorderDelay = 10 * time.Millisecond
+
+// Faults holds the faults injected on the links between the nodes. The sockets of the nodes running in the same process
+// share them, so that a fault set on one node of a simulation applies to all of them.
+type Faults struct {
+	mu        sync.RWMutex
+	links     map[string]config.LinkFault
+	partition [][]identity.NodeID
+	groups    map[identity.NodeID]int // the group of each node listed in the partition
+}
+
+var processFaults struct {
+	once   sync.Once
+	faults *Faults
+}
+
+// ProcessFaults returns the faults of the nodes running in this process, they start as configured
+func ProcessFaults() *Faults {
+	processFaults.once.Do(func() {
+		faults, err := NewFaults(config.GetConfig().Faults)
+		if err != nil {
+			log.Fatalf("invalid network faults: %v", err)
+		}
+		processFaults.faults = faults
+	})
+	return processFaults.faults
+}
+
+// NewFaults creates the faults described by the configuration
+func NewFaults(c config.FaultConfig) (*Faults, error) {
+	f := &Faults{links: make(map[string]config.LinkFault)}
+	for name, fault := range c.Links {
+		sides := strings.Split(name, "-")
+		if len(sides) != 2 {
+			return nil, fmt.Errorf("link %q is not named from-to", name)
+		}
+		err := f.SetLink(sides[0], sides[1], fault)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, f.Partition(c.Partition)
+}
+
+// Link returns the fault of the link from a node to a peer, and whether the partition cuts the link
+func (f *Faults) Link(from identity.NodeID, to identity.NodeID) (config.LinkFault, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	cut := f.groupOf(from) != f.groupOf(to)
+	for _, name := range []string{linkName(string(from), string(to)), linkName(string(from), anyNode), linkName(anyNode, string(to)), linkName(anyNode, anyNode)} {
+		if fault, exists := f.links[name]; exists {
+			return fault, cut
+		}
+	}
+	return config.LinkFault{}, cut
+}
+
+// SetLink sets the fault of the link from a node to a peer, either can be "*", a zero fault removes it
+func (f *Faults) SetLink(from string, to string, fault config.LinkFault) error {
+	if from == "" || to == "" {
+		return fmt.Errorf("link %q has no end", linkName(from, to))
+	}
+	if fault.Drop < 0 || fault.Drop > 1 || fault.Duplicate < 0 || fault.Duplicate > 1 || fault.Reorder < 0 || fault.Reorder > 1 {
+		return fmt.Errorf("the probabilities of link %v are not between 0 and 1", linkName(from, to))
+	}
+	if fault.Delay < 0 || fault.Jitter < 0 || fault.Bandwidth < 0 {
+		return fmt.Errorf("negative delay, jitter or bandwidth on link %v", linkName(from, to))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if fault == (config.LinkFault{}) {
+		delete(f.links, linkName(from, to))
+		return nil
+	}
+	f.links[linkName(from, to)] = fault
+	return nil
+}
+
+// Partition splits the nodes into groups that cannot reach each other, the nodes not listed form a group,
+// no groups heal the partition
+func (f *Faults) Partition(groups [][]identity.NodeID) error {
+	assigned := make(map[identity.NodeID]int)
+	for i, group := range groups {
+		for _, id := range group {
+			if _, exists := assigned[id]; exists {
+				return fmt.Errorf("node %v is in two groups", id)
+			}
+			assigned[id] = i
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.partition = groups
+	f.groups = assigned
+	return nil
+}
+
+// Heal removes all the faults
+func (f *Faults) Heal() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.links = make(map[string]config.LinkFault)
+	f.partition = nil
+	f.groups = nil
+}
+
+// Config describes the faults injected now
+func (f *Faults) Config() config.FaultConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	c := config.FaultConfig{Links: make(map[string]config.LinkFault), Partition: f.partition}
+	for name, fault := range f.links {
+		c.Links[name] = fault
+	}
+	return c
+}
+
+func (f *Faults) groupOf(id identity.NodeID) int {
+	if group, listed := f.groups[id]; listed {
+		return group
+	}
+	return -1
+}
+
+func linkName(from string, to string) string {
+	return from + "-" + to
+}
+
+// delayed reports whether the messages on the link are not sent right away
+func delayed(fault config.LinkFault) bool {
+	return fault.Delay > 0 || fault.Jitter > 0 || fault.Bandwidth > 0 || fault.Reorder > 0
+}
+
+// link holds the messages sent to a peer back as long as the fault of the link says,
+// they leave in the order they were sent like over a TCP connection
+type link struct {
+	deliver func(m interface{})
+
+	mu    sync.Mutex
+	queue []scheduled
+	wake  chan struct{}
+	free  time.Time // when the messages queued so far are through at the bandwidth of the link
+}
+
+type scheduled struct {
+	m  interface{}
+	at time.Time
+}
+
+func newLink(deliver func(m interface{})) *link {
+	l := &link{deliver: deliver, wake: make(chan struct{}, 1)}
+	go l.run()
+	return l
+}
+
+// push queues the message, an overtaken message leaves on its own after the ones sent after it
+func (l *link) push(m interface{}, fault config.LinkFault, overtaken bool) {
+	now := time.Now()
+	at := now
+	l.mu.Lock()
+	if fault.Bandwidth > 0 {
+		if l.free.Before(now) {
+			l.free = now
+		}
+		l.free = l.free.Add(time.Duration(size(m)) * time.Second / time.Duration(fault.Bandwidth))
+		at = l.free
+	}
+	at = at.Add(time.Duration(fault.Delay) * time.Millisecond)
+	if fault.Jitter > 0 {
+		at = at.Add(time.Duration(rand.Int63n(int64(fault.Jitter)*int64(time.Millisecond) + 1)))
+	}
+	if overtaken {
+		l.mu.Unlock()
+		hold := time.Duration(fault.Delay+fault.Jitter) * time.Millisecond
+		if hold == 0 {
+			hold = reorderDelay
+		}
+		time.AfterFunc(at.Add(hold).Sub(now), func() { l.deliver(m) })
+		return
+	}
+	l.queue = append(l.queue, scheduled{m: m, at: at})
+	l.mu.Unlock()
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (l *link) run() {
+	for {
+		l.mu.Lock()
+		if len(l.queue) == 0 {
+			l.mu.Unlock()
+			<-l.wake
+			continue
+		}
+		next := l.queue[0]
+		l.queue = l.queue[1:]
+		l.mu.Unlock()
+		time.Sleep(time.Until(next.at))
+		l.deliver(next.m)
+	}
+}
+
+// size estimates the bytes the message takes on the wire
+func size(m interface{}) int {
+	var counter byteCounter
+	err := gob.NewEncoder(&counter).Encode(m)
+	if err != nil {
+		return 0
+	}
+	return int(counter)
+}
+
+type byteCounter int
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,121 @@
+package socket
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"banyan/config"
+	"banyan/identity"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultsLinks(t *testing.T) {
+	faults, err := NewFaults(config.FaultConfig{Links: map[string]config.LinkFault{
+		"*-*": {Delay: 1},
+		"1-*": {Delay: 2},
+		"*-3": {Delay: 3},
+		"1-3": {Delay: 4},
+	}})
+	require.NoError(t, err)
+	delay := func(from identity.NodeID, to identity.NodeID) int {
+		fault, cut := faults.Link(from, to)
+		require.False(t, cut)
+		return fault.Delay
+	}
+	require.Equal(t, 4, delay("1", "3"))
+	require.Equal(t, 2, delay("1", "2"))
+	require.Equal(t, 3, delay("2", "3"))
+	require.Equal(t, 1, delay("2", "1"))
+
+	// a zero fault removes the link
+	require.NoError(t, faults.SetLink("1", "3", config.LinkFault{}))
+	require.Equal(t, 2, delay("1", "3"))
+	require.Len(t, faults.Config().Links, 3)
+
+	require.Error(t, faults.SetLink("1", "2", config.LinkFault{Drop: 1.5}))
+	require.Error(t, faults.SetLink("1", "2", config.LinkFault{Delay: -1}))
+	require.Error(t, faults.SetLink("", "2", config.LinkFault{Delay: 1}))
+	_, err = NewFaults(config.FaultConfig{Links: map[string]config.LinkFault{"1": {Delay: 1}}})
+	require.Error(t, err)
+
+	faults.Heal()
+	require.Equal(t, 0, delay("1", "3"))
+	require.Empty(t, faults.Config().Links)
+}
+
+func TestFaultsPartition(t *testing.T) {
+	faults, err := NewFaults(config.FaultConfig{Partition: [][]identity.NodeID{{"1", "2"}, {"3"}}})
+	require.NoError(t, err)
+	cut := func(from identity.NodeID, to identity.NodeID) bool {
+		_, cut := faults.Link(from, to)
+		return cut
+	}
+	require.False(t, cut("1", "2"))
+	require.True(t, cut("1", "3"))
+	require.True(t, cut("3", "2"))
+	// the nodes not listed form a group
+	require.True(t, cut("4", "1"))
+	require.False(t, cut("4", "5"))
+
+	require.Error(t, faults.Partition([][]identity.NodeID{{"1"}, {"1", "2"}}))
+	require.True(t, cut("1", "3"), "a rejected partition leaves the previous one")
+
+	require.NoError(t, faults.Partition(nil))
+	require.False(t, cut("1", "3"))
+}
+
+// received records the messages a link delivers
+type received struct {
+	sync.Mutex
+	messages []interface{}
+}
+
+func (r *received) deliver(m interface{}) {
+	r.Lock()
+	defer r.Unlock()
+	r.messages = append(r.messages, m)
+}
+
+func (r *received) get() []interface{} {
+	r.Lock()
+	defer r.Unlock()
+	return append([]interface{}(nil), r.messages...)
+}
+
+func TestLinkDelay(t *testing.T) {
+	var r received
+	l := newLink(r.deliver)
+	start := time.Now()
+	fault := config.LinkFault{Delay: 20, Jitter: 10}
+	for i := 0; i < 5; i++ {
+		l.push(i, fault, false)
+	}
+	require.Eventually(t, func() bool { return len(r.get()) == 5 }, time.Second, time.Millisecond)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(20*time.Millisecond))
+	// the jitter does not reorder the messages
+	require.Equal(t, []interface{}{0, 1, 2, 3, 4}, r.get())
+}
+
+func TestLinkReorder(t *testing.T) {
+	var r received
+	l := newLink(r.deliver)
+	l.push(0, config.LinkFault{Reorder: 1}, true)
+	l.push(1, config.LinkFault{}, false)
+	require.Eventually(t, func() bool { return len(r.get()) == 2 }, time.Second, time.Millisecond)
+	require.Equal(t, []interface{}{1, 0}, r.get())
+}
+
+func TestLinkBandwidth(t *testing.T) {
+	var r received
+	l := newLink(r.deliver)
+	m := make([]byte, 1000)
+	bandwidth := size(m) * 20 // 20 messages per second
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		l.push(m, config.LinkFault{Bandwidth: bandwidth}, false)
+	}
+	require.Eventually(t, func() bool { return len(r.get()) == 3 }, time.Second, time.Millisecond)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(150*time.Millisecond))
+}
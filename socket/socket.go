@@ -1,11 +1,14 @@
 package socket
 
 import (
+	"math/rand"
+	"reflect"
 	"sync"
 	"time"
 
 	"banyan/identity"
 	"banyan/log"
+	"banyan/metrics"
 	"banyan/transport"
 	"banyan/utils"
 )
@@ -22,28 +25,69 @@ type Socket interface {
 	// Recv receives a message
 	Recv() interface{}
 
+	// Connections describes the connections to the peers, and the ones accepted by the node under its own id
+	Connections() map[identity.NodeID]transport.State
+
 	Close()
 }
 
+// Behavior is the fault injected by a Byzantine node, it decides what the node sends
+type Behavior interface {
+	// Outgoing is called instead of sending m to the peer, the behavior sends what it wants with send
+	Outgoing(to identity.NodeID, m interface{}, send func(to identity.NodeID, m interface{}))
+
+	// Incoming is called with every message the node receives before it is handled,
+	// the behavior may send more with send
+	Incoming(m interface{}, send func(to identity.NodeID, m interface{}))
+}
+
 type socket struct {
-	silence   bool
+	behavior  Behavior // nil if the node is honest
+	faults    *Faults
 	id        identity.NodeID
 	addresses map[identity.NodeID]string
+	codec     string
 	nodes     map[identity.NodeID]transport.Transport
+	links     map[identity.NodeID]*link // the links that have held messages back
+
+	// measurement
+	messagesSent     *metrics.CounterVec
+	messagesReceived *metrics.CounterVec
+	bytesSent        *metrics.Counter
+	bytesReceived    *metrics.Counter
+	dropped          *metrics.CounterVec
+	injected         *metrics.CounterVec
 
 	lock sync.RWMutex // locking map nodes
+	mu   sync.Mutex   // locking map links
 }
 
-// NewSocket return Socket interface instance given self NodeID, node list, transport and codec name
-func NewSocket(id identity.NodeID, addrs map[identity.NodeID]string, silence bool) Socket {
+// NewSocket return Socket interface instance given self NodeID, node list, transport and codec name,
+// the traffic is counted in the registry, the behavior of a Byzantine node is nil for an honest one
+// and the faults are injected on the messages the node sends
+func NewSocket(id identity.NodeID, addrs map[identity.NodeID]string, codec string, behavior Behavior, faults *Faults, registry *metrics.Registry) Socket {
+	if _, err := transport.CodecID(codec); err != nil {
+		log.Fatal(err)
+	}
 	socket := &socket{
-		silence:   silence,
-		id:        id,
-		addresses: addrs,
-		nodes:     make(map[identity.NodeID]transport.Transport),
+		codec:            codec,
+		behavior:         behavior,
+		faults:           faults,
+		id:               id,
+		addresses:        addrs,
+		nodes:            make(map[identity.NodeID]transport.Transport),
+		links:            make(map[identity.NodeID]*link),
+		messagesSent:     registry.CounterVec("banyan_messages_sent_total", "Messages sent to the peers.", "type"),
+		messagesReceived: registry.CounterVec("banyan_messages_received_total", "Messages received from the peers.", "type"),
+		bytesSent:        registry.Counter("banyan_sent_bytes_total", "Bytes written to the network."),
+		bytesReceived:    registry.Counter("banyan_received_bytes_total", "Bytes read from the network."),
+		dropped:          registry.CounterVec("banyan_dropped_messages_total", "Messages dropped because the queue to the peer was full.", "peer"),
+		injected:         registry.CounterVec("banyan_injected_faults_total", "Messages dropped, duplicated or reordered by the injected network faults.", "fault"),
 	}
 
 	socket.nodes[id] = transport.NewTransport(addrs[id])
+	socket.nodes[id].Measure(socket.bytesSent, socket.bytesReceived, nil)
+	socket.nodes[id].Authenticate(id, "")
 	socket.nodes[id].Listen()
 
 	return socket
@@ -51,7 +95,53 @@ func NewSocket(id identity.NodeID, addrs map[identity.NodeID]string, silence boo
 
 func (s *socket) Send(to identity.NodeID, m interface{}) {
 	//log.Debugf("node %s send message %+v to %v", s.id, m, to)
+	if s.behavior != nil {
+		s.behavior.Outgoing(to, m, s.send)
+		return
+	}
+	s.send(to, m)
+}
+
+// send injects the faults of the link to the peer before the message leaves
+func (s *socket) send(to identity.NodeID, m interface{}) {
+	fault, cut := s.faults.Link(s.id, to)
+	if cut {
+		s.injected.With("partition").Inc()
+		return
+	}
+	if fault.Drop > 0 && rand.Float64() < fault.Drop {
+		s.injected.With("drop").Inc()
+		return
+	}
+	copies := 1
+	if fault.Duplicate > 0 && rand.Float64() < fault.Duplicate {
+		s.injected.With("duplicate").Inc()
+		copies = 2
+	}
+	for i := 0; i < copies; i++ {
+		s.mu.Lock()
+		l, exists := s.links[to]
+		if !exists && delayed(fault) {
+			l = newLink(func(m interface{}) { s.deliver(to, m) })
+			s.links[to] = l
+			exists = true
+		}
+		s.mu.Unlock()
+		if exists {
+			// once messages were held back on the link, the next ones queue behind them
+			overtaken := fault.Reorder > 0 && rand.Float64() < fault.Reorder
+			if overtaken {
+				s.injected.With("reorder").Inc()
+			}
+			l.push(m, fault, overtaken)
+			continue
+		}
+		s.deliver(to, m)
+	}
+}
 
+// deliver puts the message to the outbound queue of the peer
+func (s *socket) deliver(to identity.NodeID, m interface{}) {
 	s.lock.RLock()
 	t, exists := s.nodes[to]
 	s.lock.RUnlock()
@@ -64,18 +154,27 @@ func (s *socket) Send(to identity.NodeID, m interface{}) {
 			return
 		}
 		t = transport.NewTransport(address)
+		t.Measure(s.bytesSent, s.bytesReceived, s.dropped.With(string(to)))
+		_ = t.SetCodec(s.codec)
+		t.Authenticate(s.id, to)
+		// tcp and tls dial in the background, only a channel waits for its peer to listen
 		err := utils.Retry(t.Dial, 100, time.Duration(50)*time.Millisecond)
 		if err != nil {
-			panic(err)
+			log.Errorf("[%v] cannot send to node %v: %v", s.id, to, err)
+			return
 		}
 		s.lock.Lock()
-		s.nodes[to] = t
+		if existing, raced := s.nodes[to]; raced {
+			t.Close()
+			t = existing
+		} else {
+			s.nodes[to] = t
+		}
 		s.lock.Unlock()
 	}
 
-	if !s.silence {
-		t.Send(m)
-	}
+	t.Send(m)
+	s.messagesSent.With(messageType(m)).Inc()
 }
 
 func (s *socket) Recv() interface{} {
@@ -84,6 +183,10 @@ func (s *socket) Recv() interface{} {
 	s.lock.RUnlock()
 	for {
 		m := t.Recv()
+		s.messagesReceived.With(messageType(m)).Inc()
+		if s.behavior != nil {
+			s.behavior.Incoming(m, s.send)
+		}
 		return m
 	}
 }
@@ -99,8 +202,27 @@ func (s *socket) Broadcast(m interface{}) {
 	//log.Debugf("node %s done  broadcasting message %+v", s.id, m)
 }
 
+func (s *socket) Connections() map[identity.NodeID]transport.State {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	states := make(map[identity.NodeID]transport.State, len(s.nodes))
+	for id, t := range s.nodes {
+		states[id] = t.State()
+	}
+	return states
+}
+
 func (s *socket) Close() {
 	for _, t := range s.nodes {
 		t.Close()
 	}
 }
+
+// messageType names the type of the message, the same for a value and a pointer to it
+func messageType(m interface{}) string {
+	t := reflect.TypeOf(m)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.String()
+}
@@ -0,0 +1,119 @@
+package statemachine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// KV command names
+const (
+	GET    = "GET"
+	PUT    = "PUT"
+	DELETE = "DELETE"
+)
+
+// ErrNotFound is returned when reading or deleting a missing key
+var ErrNotFound = errors.New("key not found")
+
+// KVStore is an in-memory key-value store executing commands of the form
+// "PUT <key> <value>", "GET <key>" and "DELETE <key>"
+type KVStore struct {
+	data map[string]string
+	mu   sync.RWMutex
+}
+
+func NewKVStore() *KVStore {
+	return &KVStore{
+		data: make(map[string]string),
+	}
+}
+
+func (kv *KVStore) Apply(block *Block) *Result {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	result := &Result{
+		Height:  block.Height,
+		Outputs: make([]Output, len(block.Txns)),
+	}
+	for i, tx := range block.Txns {
+		value, err := kv.execute(tx.Command)
+		result.Outputs[i] = Output{TxID: tx.ID, Value: value, Err: err}
+	}
+	return result
+}
+
+func (kv *KVStore) Query(command []byte) ([]byte, error) {
+	op, key, _, err := parse(command)
+	if err != nil {
+		return nil, err
+	}
+	if op != GET {
+		return nil, fmt.Errorf("%s is not a read-only command", op)
+	}
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	value, ok := kv.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return []byte(value), nil
+}
+
+// Snapshot encodes the store as json, map keys are sorted by the encoder
+func (kv *KVStore) Snapshot() ([]byte, error) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	return json.Marshal(kv.data)
+}
+
+func (kv *KVStore) Restore(snapshot []byte) error {
+	data := make(map[string]string)
+	if err := json.Unmarshal(snapshot, &data); err != nil {
+		return err
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.data = data
+	return nil
+}
+
+func (kv *KVStore) execute(command []byte) ([]byte, error) {
+	op, key, value, err := parse(command)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case GET:
+		v, ok := kv.data[key]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		return []byte(v), nil
+	case PUT:
+		kv.data[key] = value
+		return nil, nil
+	default:
+		if _, ok := kv.data[key]; !ok {
+			return nil, ErrNotFound
+		}
+		delete(kv.data, key)
+		return nil, nil
+	}
+}
+
+// parse splits a command into its operation, key and value
+func parse(command []byte) (string, string, string, error) {
+	fields := strings.SplitN(string(command), " ", 3)
+	op := strings.ToUpper(fields[0])
+	switch {
+	case op == PUT && len(fields) == 3:
+		return op, fields[1], fields[2], nil
+	case (op == GET || op == DELETE) && len(fields) == 2:
+		return op, fields[1], "", nil
+	default:
+		return "", "", "", fmt.Errorf("malformed command %q", command)
+	}
+}
@@ -0,0 +1,56 @@
+package statemachine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"banyan/message"
+)
+
+func makeBlock(height int, commands ...string) *Block {
+	block := &Block{Height: height}
+	for i, cmd := range commands {
+		block.Txns = append(block.Txns, message.NewTransaction([]byte(cmd), "client", uint64(i)))
+	}
+	return block
+}
+
+// commands are executed in order and malformed ones fail without aborting the block
+func TestKVApply(t *testing.T) {
+	kv := NewKVStore()
+	result := kv.Apply(makeBlock(1, "PUT a hello world", "GET a", "PUT b 2", "DELETE b", "GET b", "PATCH a"))
+	require.Equal(t, 1, result.Height)
+	require.Len(t, result.Outputs, 6)
+	require.NoError(t, result.Outputs[0].Err)
+	require.Equal(t, "hello world", string(result.Outputs[1].Value))
+	require.NoError(t, result.Outputs[3].Err)
+	require.Equal(t, ErrNotFound, result.Outputs[4].Err)
+	require.Error(t, result.Outputs[5].Err)
+
+	value, err := kv.Query([]byte("GET a"))
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(value))
+	_, err = kv.Query([]byte("PUT a 1"))
+	require.Error(t, err)
+}
+
+// replicas applying the same blocks reach the same digest, and snapshots restore it
+func TestKVSnapshot(t *testing.T) {
+	kv1, kv2 := NewKVStore(), NewKVStore()
+	kv1.Apply(makeBlock(1, "PUT a 1", "PUT b 2", "PUT c 3"))
+	kv2.Apply(makeBlock(1, "PUT c 3", "PUT b 2", "PUT a 1"))
+	d1, err := Digest(kv1)
+	require.NoError(t, err)
+	d2, err := Digest(kv2)
+	require.NoError(t, err)
+	require.Equal(t, d1, d2)
+
+	snapshot, err := kv1.Snapshot()
+	require.NoError(t, err)
+	kv3 := NewKVStore()
+	require.NoError(t, kv3.Restore(snapshot))
+	d3, err := Digest(kv3)
+	require.NoError(t, err)
+	require.Equal(t, d1, d3)
+}
@@ -0,0 +1,60 @@
+package statemachine
+
+import (
+	"fmt"
+
+	"banyan/crypto"
+	"banyan/message"
+)
+
+// Block is the protocol independent view of a committed block handed to the state machine
+type Block struct {
+	Height int
+	ID     crypto.Identifier
+	Txns   []*message.Transaction
+}
+
+// Output is the outcome of executing a single transaction
+type Output struct {
+	TxID  crypto.Identifier
+	Value []byte
+	Err   error
+}
+
+// Result is the outcome of executing a committed block
+type Result struct {
+	Height  int
+	Outputs []Output
+}
+
+// StateMachine is the replicated application, blocks are applied in commit order
+type StateMachine interface {
+	// Apply executes the transactions of a committed block
+	Apply(block *Block) *Result
+	// Query executes a read-only command against the current state
+	Query(command []byte) ([]byte, error)
+	// Snapshot returns a deterministic encoding of the current state
+	Snapshot() ([]byte, error)
+	// Restore replaces the current state by a snapshot
+	Restore(snapshot []byte) error
+}
+
+// New creates the state machine registered under the given name
+func New(name string) (StateMachine, error) {
+	switch name {
+	case "", "kv":
+		return NewKVStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown state machine %s", name)
+	}
+}
+
+// Digest returns the hash of the snapshot of a state machine,
+// two replicas that applied the same blocks have the same digest
+func Digest(sm StateMachine) (crypto.Identifier, error) {
+	snapshot, err := sm.Snapshot()
+	if err != nil {
+		return crypto.Identifier{}, err
+	}
+	return crypto.HashToID(crypto.NewSHA3_256().ComputeHash(snapshot)), nil
+}
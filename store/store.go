@@ -0,0 +1,406 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"banyan/log"
+)
+
+const (
+	segmentPrefix = "segment-"
+	segmentSuffix = ".log"
+	stateSuffix   = ".gob"
+	// each record starts with the payload length, the crc32 of the payload and the height
+	headerSize = 16
+)
+
+// a new segment is started once the active one reaches this size
+var maxSegmentSize int64 = 64 * 1024 * 1024
+
+// record wraps persisted values so that gob keeps their concrete type
+type record struct {
+	Value interface{}
+}
+
+// position locates a record in the segment files
+type position struct {
+	segment int
+	offset  int64
+}
+
+// Store persists committed blocks in append-only segment files and keeps small pieces
+// of protocol state, e.g., the voting state, in separate files that are replaced atomically.
+// Concrete types of the persisted values have to be registered with gob.
+type Store struct {
+	dir        string
+	sync       bool
+	active     *os.File
+	activeNo   int
+	activeSize int64
+	index      map[int]position // height of a committed block -> its record
+	heights    []int            // heights in append order
+	mu         sync.Mutex
+}
+
+// Open opens the store in dir, creating it if needed.
+// A record torn by a crash at the end of the last segment is truncated.
+// If sync is set, every committed block is flushed to stable storage before returning; the state always is.
+func Open(dir string, sync bool) (*Store, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create store directory: %w", err)
+	}
+	s := &Store{
+		dir:   dir,
+		sync:  sync,
+		index: make(map[int]position),
+	}
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	for i, no := range segments {
+		valid, err := scanSegment(s.segmentPath(no), func(offset int64, height int, _ []byte) error {
+			s.index[height] = position{segment: no, offset: offset}
+			s.heights = append(s.heights, height)
+			return nil
+		})
+		if err == nil {
+			continue
+		}
+		if i < len(segments)-1 {
+			return nil, fmt.Errorf("segment %v is corrupted: %w", no, err)
+		}
+		log.Warningf("truncating segment %v at offset %v: %v", no, valid, err)
+		err = os.Truncate(s.segmentPath(no), valid)
+		if err != nil {
+			return nil, fmt.Errorf("cannot truncate segment %v: %w", no, err)
+		}
+	}
+	if len(segments) > 0 {
+		s.activeNo = segments[len(segments)-1]
+	}
+	err = s.openActive()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Append persists the value of a committed block at the given height
+func (s *Store) Append(height int, v interface{}) error {
+	var payload bytes.Buffer
+	err := gob.NewEncoder(&payload).Encode(&record{Value: v})
+	if err != nil {
+		return fmt.Errorf("cannot encode record: %w", err)
+	}
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+	binary.BigEndian.PutUint64(header[8:16], uint64(height))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.activeSize >= maxSegmentSize {
+		err = s.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	offset := s.activeSize
+	_, err = s.active.Write(append(header, payload.Bytes()...))
+	if err != nil {
+		return fmt.Errorf("cannot append record: %w", err)
+	}
+	if s.sync {
+		err = s.active.Sync()
+		if err != nil {
+			return fmt.Errorf("cannot sync segment: %w", err)
+		}
+	}
+	s.activeSize += int64(headerSize + payload.Len())
+	s.index[height] = position{segment: s.activeNo, offset: offset}
+	s.heights = append(s.heights, height)
+	return nil
+}
+
+// Get returns the value persisted at the given height
+func (s *Store) Get(height int) (interface{}, error) {
+	s.mu.Lock()
+	pos, ok := s.index[height]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no record at height %v", height)
+	}
+	file, err := os.Open(s.segmentPath(pos.segment))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	_, err = file.Seek(pos.offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	_, payload, err := readRecord(bufio.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+	return decode(payload)
+}
+
+// Range returns up to count values persisted from the given height on, in append order
+func (s *Store) Range(from int, count int) ([]interface{}, error) {
+	s.mu.Lock()
+	i := sort.SearchInts(s.heights, from)
+	end := i + count
+	if end > len(s.heights) {
+		end = len(s.heights)
+	}
+	heights := append([]int(nil), s.heights[i:end]...)
+	s.mu.Unlock()
+	var values []interface{}
+	for _, height := range heights {
+		v, err := s.Get(height)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// Last returns the value persisted last, false if the store is empty
+func (s *Store) Last() (interface{}, bool, error) {
+	s.mu.Lock()
+	if len(s.heights) == 0 {
+		s.mu.Unlock()
+		return nil, false, nil
+	}
+	height := s.heights[len(s.heights)-1]
+	s.mu.Unlock()
+	v, err := s.Get(height)
+	return v, err == nil, err
+}
+
+// Replay calls f on every persisted value in append order
+func (s *Store) Replay(f func(v interface{}) error) error {
+	s.mu.Lock()
+	segments, err := s.segments()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, no := range segments {
+		_, err := scanSegment(s.segmentPath(no), func(_ int64, _ int, payload []byte) error {
+			v, err := decode(payload)
+			if err != nil {
+				return err
+			}
+			return f(v)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put replaces the state persisted under the name. The state is flushed to stable storage whatever the sync of the
+// store, a replica that loses its voting state to a power failure could vote twice.
+func (s *Store) Put(name string, v interface{}) error {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return fmt.Errorf("cannot encode state: %w", err)
+	}
+	path := filepath.Join(s.dir, name+stateSuffix)
+	tmp := path + ".tmp"
+	// replicas save their state from more than one goroutine
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(buf.Bytes())
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write state: %w", err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return err
+	}
+	// the rename is durable once the directory is
+	dir, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot sync the store directory: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the state persisted under the name, if any
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(filepath.Join(s.dir, name+stateSuffix))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Load decodes the state persisted under the name into v, false if none was saved
+func (s *Store) Load(name string, v interface{}) (bool, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, name+stateSuffix))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+	if err != nil {
+		return false, fmt.Errorf("cannot decode state: %w", err)
+	}
+	return true, nil
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active.Close()
+}
+
+func (s *Store) segmentPath(no int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%08d%s", segmentPrefix, no, segmentSuffix))
+}
+
+// segments returns the numbers of the segment files in ascending order
+func (s *Store) segments() ([]int, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []int
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		var no int
+		_, err := fmt.Sscanf(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), "%d", &no)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, no)
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+func (s *Store) openActive() error {
+	file, err := os.OpenFile(s.segmentPath(s.activeNo), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open segment: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.active = file
+	s.activeSize = info.Size()
+	return nil
+}
+
+func (s *Store) rotate() error {
+	err := s.active.Close()
+	if err != nil {
+		return err
+	}
+	s.activeNo++
+	return s.openActive()
+}
+
+// scanSegment calls f on every complete record of the segment and returns the end of the last valid one
+func scanSegment(path string, f func(offset int64, height int, payload []byte) error) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	var offset int64
+	for {
+		height, payload, err := readRecord(r)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		err = f(offset, height, payload)
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(headerSize + len(payload))
+	}
+}
+
+// readRecord reads the next record, io.EOF is only returned at a record boundary
+func readRecord(r io.Reader) (int, []byte, error) {
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(r, header)
+	if err == io.EOF {
+		return 0, nil, io.EOF
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("torn record header (%v bytes): %w", n, err)
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	height := int(binary.BigEndian.Uint64(header[8:16]))
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return 0, nil, fmt.Errorf("torn record payload: %w", err)
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return 0, nil, fmt.Errorf("checksum mismatch at height %v", height)
+	}
+	return height, payload, nil
+}
+
+func decode(payload []byte) (interface{}, error) {
+	var rec record
+	err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&rec)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode record: %w", err)
+	}
+	return rec.Value, nil
+}
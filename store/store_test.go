@@ -0,0 +1,164 @@
+package store
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testRecord struct {
+	Height int
+	Data   []byte
+}
+
+type testState struct {
+	Voted map[int]bool
+}
+
+func init() {
+	gob.Register(testRecord{})
+}
+
+func appendRecords(t *testing.T, s *Store, from int, to int) {
+	for h := from; h <= to; h++ {
+		require.NoError(t, s.Append(h, testRecord{Height: h, Data: []byte("block")}))
+	}
+}
+
+// records survive a reopen and are replayed in append order
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, true)
+	require.NoError(t, err)
+	appendRecords(t, s, 1, 5)
+	require.NoError(t, s.Close())
+
+	s, err = Open(dir, true)
+	require.NoError(t, err)
+	appendRecords(t, s, 6, 7)
+	var heights []int
+	require.NoError(t, s.Replay(func(v interface{}) error {
+		heights = append(heights, v.(testRecord).Height)
+		return nil
+	}))
+	require.Equal(t, []int{1, 2, 3, 4, 5, 6, 7}, heights)
+
+	v, err := s.Get(3)
+	require.NoError(t, err)
+	require.Equal(t, 3, v.(testRecord).Height)
+	v, ok, err := s.Last()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 7, v.(testRecord).Height)
+	_, err = s.Get(8)
+	require.Error(t, err)
+}
+
+// a record torn by a crash is dropped and the store keeps appending after the last valid one
+func TestTornTail(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, false)
+	require.NoError(t, err)
+	appendRecords(t, s, 1, 3)
+	require.NoError(t, s.Close())
+
+	path := filepath.Join(dir, "segment-00000000.log")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+
+	s, err = Open(dir, false)
+	require.NoError(t, err)
+	v, _, err := s.Last()
+	require.NoError(t, err)
+	require.Equal(t, 2, v.(testRecord).Height)
+	appendRecords(t, s, 3, 4)
+	count := 0
+	require.NoError(t, s.Replay(func(v interface{}) error {
+		count++
+		return nil
+	}))
+	require.Equal(t, 4, count)
+}
+
+func TestEmpty(t *testing.T) {
+	s, err := Open(t.TempDir(), false)
+	require.NoError(t, err)
+	_, ok, err := s.Last()
+	require.NoError(t, err)
+	require.False(t, ok)
+	var state testState
+	saved, err := s.Load("state", &state)
+	require.NoError(t, err)
+	require.False(t, saved)
+}
+
+// a state is replaced as a whole
+func TestState(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, true)
+	require.NoError(t, err)
+	require.NoError(t, s.Put("state", &testState{Voted: map[int]bool{1: true}}))
+	require.NoError(t, s.Put("state", &testState{Voted: map[int]bool{2: true}}))
+	require.NoError(t, s.Close())
+
+	s, err = Open(dir, true)
+	require.NoError(t, err)
+	var state testState
+	saved, err := s.Load("state", &state)
+	require.NoError(t, err)
+	require.True(t, saved)
+	require.Equal(t, map[int]bool{2: true}, state.Voted)
+
+	require.NoError(t, s.Delete("state"))
+	require.NoError(t, s.Delete("state"))
+	saved, err = s.Load("state", &state)
+	require.NoError(t, err)
+	require.False(t, saved)
+}
+
+// records are read back across segments
+func TestRotate(t *testing.T) {
+	defer func(size int64) { maxSegmentSize = size }(maxSegmentSize)
+	maxSegmentSize = 100
+	dir := t.TempDir()
+	s, err := Open(dir, false)
+	require.NoError(t, err)
+	appendRecords(t, s, 1, 10)
+	segments, err := s.segments()
+	require.NoError(t, err)
+	require.Greater(t, len(segments), 1)
+	require.NoError(t, s.Close())
+
+	s, err = Open(dir, false)
+	require.NoError(t, err)
+	for h := 1; h <= 10; h++ {
+		v, err := s.Get(h)
+		require.NoError(t, err)
+		require.Equal(t, h, v.(testRecord).Height)
+	}
+}
+
+// a range starts at the first height not below the requested one
+func TestRange(t *testing.T) {
+	s, err := Open(t.TempDir(), false)
+	require.NoError(t, err)
+	defer s.Close()
+	for _, h := range []int{2, 4, 6, 8} {
+		require.NoError(t, s.Append(h, testRecord{Height: h}))
+	}
+	values, err := s.Range(3, 2)
+	require.NoError(t, err)
+	require.Len(t, values, 2)
+	require.Equal(t, 4, values[0].(testRecord).Height)
+	require.Equal(t, 6, values[1].(testRecord).Height)
+	values, err = s.Range(7, 10)
+	require.NoError(t, err)
+	require.Len(t, values, 1)
+	values, err = s.Range(9, 10)
+	require.NoError(t, err)
+	require.Empty(t, values)
+}
@@ -0,0 +1,250 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"banyan/identity"
+	"banyan/log"
+)
+
+const (
+	dialTimeout = 2 * time.Second
+	minBackoff  = 50 * time.Millisecond // wait before the second attempt to reach a peer
+	maxBackoff  = 5 * time.Second       // longest wait between two attempts
+)
+
+// State describes the connection of a transport to its peer, or the connections a listening transport accepted
+type State struct {
+	Address     string    `json:"address"`
+	Connected   bool      `json:"connected"`
+	Since       time.Time `json:"since"`                 // when the transport connected, or lost the connection
+	Attempts    int       `json:"attempts"`              // dials since the last connection
+	Reconnects  int       `json:"reconnects"`            // connections after the first one
+	Queued      int       `json:"queued"`                // messages waiting to be sent
+	Dropped     int       `json:"dropped"`               // messages dropped because the queue was full
+	Retransmits int       `json:"retransmits,omitempty"` // udp messages sent again for want of an acknowledgment
+	Duplicates  int       `json:"duplicates,omitempty"`  // datagrams of udp messages already delivered, dropped
+	Error       string    `json:"error,omitempty"`
+	Inbound     []Inbound `json:"inbound,omitempty"`
+}
+
+// Inbound is a connection accepted from a peer
+type Inbound struct {
+	Remote string          `json:"remote"`
+	Peer   identity.NodeID `json:"peer,omitempty"` // authenticated with tls
+	Since  time.Time       `json:"since"`
+}
+
+func (t *transport) State() State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.state
+	state.Address = t.uri.String()
+	state.Queued = len(t.send)
+	state.Inbound = nil
+	for _, conn := range t.inbound {
+		state.Inbound = append(state.Inbound, conn)
+	}
+	return state
+}
+
+func (t *transport) setState(connected bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if connected != t.state.Connected || t.state.Since.IsZero() {
+		t.state.Since = time.Now()
+	}
+	if connected {
+		if t.connections > 0 {
+			t.state.Reconnects++
+		}
+		t.connections++
+		t.state.Attempts = 0
+		t.state.Error = ""
+	} else {
+		t.state.Attempts++
+		if err != nil {
+			t.state.Error = err.Error()
+		}
+	}
+	t.state.Connected = connected
+}
+
+// keepConnected sends the queued messages over a connection made with dial until the transport is closed. When the
+// connection breaks it dials again, at once and then with exponential backoff, and sends the message that failed
+// first: the peer may get it twice, but the queue loses nothing.
+func (t *transport) keepConnected(dial func() (net.Conn, error)) {
+	backoff := time.Duration(0)
+	var pending interface{} // the message the last connection failed to send
+	for {
+		if backoff > 0 {
+			select {
+			case <-t.close:
+				return
+			case <-time.After(backoff):
+			}
+		}
+		conn, err := dial()
+		var e *encoder
+		if err == nil {
+			e, err = t.hello(conn)
+		}
+		if err != nil {
+			log.Debugf("[%v] cannot reach %v: %v", t.local, t.uri.Host, err)
+			t.setState(false, err)
+			backoff = nextBackoff(backoff)
+			continue
+		}
+		t.setState(true, nil)
+		backoff = 0
+		pending, err = t.write(conn, e, pending)
+		conn.Close()
+		if err == nil {
+			return // closed
+		}
+		log.Warningf("[%v] lost the connection to %v: %v", t.local, t.uri.Host, err)
+		t.setState(false, err)
+	}
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff < minBackoff {
+		return minBackoff
+	}
+	if backoff*2 > maxBackoff {
+		return maxBackoff
+	}
+	return backoff * 2
+}
+
+// hello negotiates the version of the wire format and the codec with the peer that accepted the connection
+func (t *transport) hello(conn net.Conn) (*encoder, error) {
+	_ = conn.SetDeadline(time.Now().Add(dialTimeout))
+	e, err := hello(conn, t.codec)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return e, nil
+}
+
+// write sends the pending message, if any, and the queued ones until the transport is closed or the connection
+// breaks, it returns the message it failed to send then
+func (t *transport) write(conn net.Conn, e *encoder, pending interface{}) (interface{}, error) {
+	w := countingWriter{conn, t.sent}
+	send := func(m interface{}) error {
+		frame, err := e.frame(m)
+		if errors.Is(err, errResync) {
+			// the message is lost, the peer can decode the next ones on a new connection
+			log.Error(err)
+			return err
+		}
+		if err != nil {
+			// the message cannot be encoded, the connection is fine
+			log.Error(err)
+			return nil
+		}
+		_, err = w.Write(frame)
+		return err
+	}
+	if pending != nil {
+		if err := send(pending); err != nil {
+			return resend(pending, err), err
+		}
+	}
+	for {
+		m, ok := t.next()
+		if !ok {
+			return nil, nil
+		}
+		if err := send(m); err != nil {
+			return resend(m, err), err
+		}
+	}
+}
+
+// resend returns the message to send again on the next connection, unless it is the one that broke the stream
+func resend(m interface{}, err error) interface{} {
+	if errors.Is(err, errResync) {
+		return nil
+	}
+	return m
+}
+
+// accept serves the connections of the listener until the transport is closed, the listening transport counts as
+// connected meanwhile
+func (t *transport) accept(listener net.Listener, serve func(conn net.Conn)) {
+	t.setState(true, nil)
+	go func() {
+		<-t.close
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-t.close:
+				return
+			default:
+			}
+			log.Error("Accept error: ", err)
+			time.Sleep(minBackoff)
+			continue
+		}
+		go serve(conn)
+	}
+}
+
+// read receives the messages of the connection, of the peer if it was authenticated, until the connection breaks
+// or carries something that does not decode, which only closes this connection
+func (t *transport) read(conn net.Conn, peer identity.NodeID) {
+	t.mu.Lock()
+	if t.inbound == nil {
+		t.inbound = make(map[net.Conn]Inbound)
+	}
+	t.inbound[conn] = Inbound{Remote: conn.RemoteAddr().String(), Peer: peer, Since: time.Now()}
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.inbound, conn)
+		t.mu.Unlock()
+		conn.Close()
+	}()
+
+	_ = conn.SetDeadline(time.Now().Add(dialTimeout))
+	decoder, err := welcome(conn)
+	if err != nil {
+		log.Warningf("[%v] refused the connection from %v: %v", t.local, conn.RemoteAddr(), err)
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	r := countingReader{conn, t.received}
+	for {
+		m, err := decoder.read(r)
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if errors.Is(err, errUnknownTag) {
+			log.Warningf("[%v] skipped a message from %v: %v", t.local, conn.RemoteAddr(), err)
+			continue
+		}
+		if err != nil {
+			log.Warningf("[%v] closes the connection from %v: %v", t.local, conn.RemoteAddr(), err)
+			return
+		}
+		if s, ok := m.(Sender); ok && peer != "" && s.Sender() != peer {
+			log.Warningf("[%v] dropped a %T of node %v sent by node %v", t.local, m, s.Sender(), peer)
+			continue
+		}
+		select {
+		case <-t.close:
+			return
+		case t.recv <- m:
+		}
+	}
+}
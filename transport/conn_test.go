@@ -0,0 +1,173 @@
+package transport
+
+import (
+	"flag"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"banyan/metrics"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	_ = flag.Set("log_level", "error")
+	os.Exit(m.Run())
+}
+
+// newTransport returns a transport of the address, closed at the end of the test
+func newTransport(t testing.TB, addr string) Transport {
+	transport := NewTransport(addr)
+	t.Cleanup(transport.Close)
+	return transport
+}
+
+// freeAddress returns an address of the scheme nothing listens on
+func freeAddress(t testing.TB, scheme string) string {
+	if scheme == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+		return scheme + "://" + conn.LocalAddr().String()
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	return scheme + "://" + l.Addr().String()
+}
+
+// receive returns the next message the transport receives, nil if none arrives within 2 seconds
+func receive(t Transport) interface{} {
+	var recv chan interface{}
+	switch t := t.(type) {
+	case *tcp:
+		recv = t.recv
+	case *tlsTransport:
+		recv = t.recv
+	case *udp:
+		recv = t.recv
+	}
+	select {
+	case m := <-recv:
+		return m
+	case <-time.After(2 * time.Second):
+		return nil
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	addr := freeAddress(t, "tcp")
+	client := newTransport(t, addr)
+	require.NoError(t, client.Dial(), "the peer is not listening yet")
+	client.Send(relayedMessage{Text: "before"})
+	require.Eventually(t, func() bool { return client.State().Attempts > 0 }, time.Second, 10*time.Millisecond)
+	require.False(t, client.State().Connected)
+
+	server := newTransport(t, addr)
+	server.Listen()
+	require.Equal(t, relayedMessage{Text: "before"}, receive(server), "the queued message is sent once the peer listens")
+	require.True(t, client.State().Connected)
+
+	// the peer restarts
+	server.Close()
+	require.Eventually(t, func() bool {
+		client.Send(relayedMessage{Text: "lost?"})
+		return !client.State().Connected
+	}, 2*time.Second, 10*time.Millisecond)
+	server = newTransport(t, addr)
+	server.Listen()
+	client.Send(relayedMessage{Text: "after"})
+	for {
+		m := receive(server)
+		require.NotNil(t, m)
+		if m == (relayedMessage{Text: "after"}) {
+			break
+		}
+	}
+	state := client.State()
+	require.True(t, state.Connected)
+	require.Equal(t, 1, state.Reconnects)
+	require.Empty(t, state.Error)
+}
+
+func TestDecodeError(t *testing.T) {
+	addr := freeAddress(t, "tcp")
+	server := newTransport(t, addr)
+	server.Listen()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr[len("tcp://"):])
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	garbage, err := net.Dial("tcp", addr[len("tcp://"):])
+	require.NoError(t, err)
+	defer garbage.Close()
+	_, err = garbage.Write([]byte(strings.Repeat("not a gob stream ", 16)))
+	require.NoError(t, err)
+	// the server closes that connection
+	require.NoError(t, garbage.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = garbage.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.False(t, isTimeout(err), "the connection is closed")
+
+	client := newTransport(t, addr)
+	require.NoError(t, client.Dial())
+	client.Send(relayedMessage{Text: "still served"})
+	require.Equal(t, relayedMessage{Text: "still served"}, receive(server))
+	require.Len(t, server.State().Inbound, 1)
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+func TestDropOldest(t *testing.T) {
+	addr := freeAddress(t, "tcp")
+	client := newTransport(t, addr)
+	client.(*tcp).send = make(chan interface{}, 2)
+	dropped := metrics.NewRegistry().Counter("dropped", "")
+	client.Measure(nil, nil, dropped)
+	for _, text := range []string{"1", "2", "3", "4"} {
+		client.Send(relayedMessage{Text: text})
+	}
+	require.Equal(t, 2, client.State().Queued)
+	require.Equal(t, 2, client.State().Dropped)
+	require.Equal(t, float64(2), dropped.Value())
+
+	server := newTransport(t, addr)
+	server.Listen()
+	require.NoError(t, client.Dial())
+	require.Equal(t, relayedMessage{Text: "3"}, receive(server))
+	require.Equal(t, relayedMessage{Text: "4"}, receive(server))
+}
+
+// a message sent while or after the transport closes is dropped, and closing twice does nothing
+func TestSendAfterClose(t *testing.T) {
+	for _, scheme := range []string{"tcp", "udp"} {
+		addr := freeAddress(t, scheme)
+		server := newTransport(t, addr)
+		server.Listen()
+		client := newTransport(t, addr)
+		require.NoError(t, client.Dial())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 1000; i++ {
+				client.Send(relayedMessage{Text: "racing"})
+			}
+		}()
+		client.Close()
+		<-done
+		client.Send(relayedMessage{Text: "late"})
+		client.Close()
+		server.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,104 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/gob"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"banyan/crypto"
+	"banyan/identity"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testMessage struct {
+	From identity.NodeID
+	Text string
+}
+
+func (m testMessage) Sender() identity.NodeID {
+	return m.From
+}
+
+type relayedMessage struct {
+	Text string
+}
+
+func init() {
+	Register(1000, testMessage{})
+	Register(1001, relayedMessage{})
+}
+
+// listenTLS starts node 1 listening on a free port
+func listenTLS(t *testing.T) (Transport, string) {
+	require.NoError(t, crypto.GenerateKeys(crypto.ED25519, []identity.NodeID{"1", "2", "3"}))
+	tlsCertificates.Range(func(id, _ interface{}) bool {
+		tlsCertificates.Delete(id)
+		return true
+	})
+	addr := freeAddress(t, "tls")
+	server := newTransport(t, addr)
+	server.Authenticate("1", "")
+	server.Listen()
+	return server, addr
+}
+
+func TestTLS(t *testing.T) {
+	server, addr := listenTLS(t)
+	client := newTransport(t, addr)
+	client.Authenticate("2", "1")
+	require.NoError(t, client.Dial())
+
+	client.Send(testMessage{From: "3", Text: "claims to be node 3"})
+	client.Send(testMessage{From: "2", Text: "hello"})
+	client.Send(relayedMessage{Text: "relayed"})
+	require.Equal(t, testMessage{From: "2", Text: "hello"}, receive(server), "the message of node 3 is dropped")
+	require.Equal(t, relayedMessage{Text: "relayed"}, receive(server))
+
+	// node 2 does not reach node 1 when it dials node 3, it keeps trying
+	client = newTransport(t, addr)
+	client.Authenticate("2", "3")
+	require.NoError(t, client.Dial())
+	require.Eventually(t, func() bool {
+		state := client.State()
+		return !state.Connected && strings.Contains(state.Error, "reached node 1")
+	}, time.Second, 10*time.Millisecond)
+
+	// nor does a node without a key
+	client = newTransport(t, addr)
+	client.Authenticate("4", "1")
+	require.Error(t, client.Dial())
+}
+
+func TestTLSUnboundCertificate(t *testing.T) {
+	server, addr := listenTLS(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	conf := &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	var m interface{} = testMessage{From: "2"}
+	conn, err := tls.Dial("tcp", addr[len("tls://"):], conf)
+	if err == nil {
+		// with TLS 1.3 the server checks the certificate of the client after the client is done
+		_ = gob.NewEncoder(conn).Encode(&m)
+		defer conn.Close()
+	}
+	require.Nil(t, receive(server), "a peer with a certificate not bound to a node is rejected")
+
+	// a plain TCP peer neither
+	plain, err := net.Dial("tcp", addr[len("tls://"):])
+	require.NoError(t, err)
+	defer plain.Close()
+	_ = gob.NewEncoder(plain).Encode(&m)
+	require.Nil(t, receive(server))
+}
@@ -1,19 +1,20 @@
 package transport
 
 import (
-	"bytes"
-	"encoding/gob"
 	"errors"
 	"flag"
+	"io"
 	"net"
 	"net/url"
 	"strings"
 	"sync"
 
+	"banyan/identity"
 	"banyan/log"
+	"banyan/metrics"
 )
 
-var Scheme = flag.String("transport", "tcp", "transport scheme (tcp, udp, chan), default tcp")
+var Scheme = flag.String("transport", "tcp", "transport scheme (tcp, tls, udp, chan), default tcp")
 
 // Transport = transport + pipe + client + server
 type Transport interface {
@@ -26,14 +27,35 @@ type Transport interface {
 	// Recv waits for message from t.recv chan
 	Recv() interface{}
 
-	// Dial connects to remote server non-blocking once connected
+	// Dial connects to remote server non-blocking once connected, tcp and tls connect in the background and
+	// reconnect whenever the connection breaks
 	Dial() error
 
 	// Listen waits for connections, non-blocking once listener starts
 	Listen()
 
-	// Close closes send channel and stops listener
+	// Close stops the listener and the dialer, the messages sent after it are dropped; it can be called again
 	Close()
+
+	// Measure counts the bytes written to and read from the network, and the messages dropped because the queue
+	// to the peer was full, it has to be called before Dial or Listen, no bytes are counted over channels
+	Measure(sent *metrics.Counter, received *metrics.Counter, dropped *metrics.Counter)
+
+	// SetCodec sets the codec of the messages sent over tcp, tls and udp, gob unless it is called before Dial
+	SetCodec(name string) error
+
+	// State describes the connection to the peer, or the connections accepted when listening
+	State() State
+
+	// Authenticate sets the node the transport speaks for and the peer it dials, empty when listening, it has to be
+	// called before Dial or Listen, only tls authenticates the peers
+	Authenticate(local identity.NodeID, peer identity.NodeID)
+}
+
+// Sender is a message that names the node sending it, a transport that authenticates its peers drops it when the
+// peer is another node. The messages that the nodes relay, like blocks and the votes of Streamlet, do not implement it.
+type Sender interface {
+	Sender() identity.NodeID
 }
 
 // NewTransport creates new transport object with url
@@ -51,6 +73,7 @@ func NewTransport(addr string) Transport {
 		send:  make(chan interface{}, 10240),
 		recv:  make(chan interface{}, 10240),
 		close: make(chan struct{}),
+		codec: codecGob,
 	}
 
 	switch uri.Scheme {
@@ -62,6 +85,10 @@ func NewTransport(addr string) Transport {
 		t := new(tcp)
 		t.transport = transport
 		return t
+	case "tls":
+		t := new(tlsTransport)
+		t.transport = transport
+		return t
 	case "udp":
 		t := new(udp)
 		t.transport = transport
@@ -73,48 +100,123 @@ func NewTransport(addr string) Transport {
 }
 
 type transport struct {
-	uri   *url.URL
-	send  chan interface{}
-	recv  chan interface{}
-	close chan struct{}
+	uri      *url.URL
+	send     chan interface{}
+	recv     chan interface{}
+	close    chan struct{}
+	closed   sync.Once
+	sent     *metrics.Counter // bytes, nil if not measured
+	received *metrics.Counter
+	dropped  *metrics.Counter // messages
+	local    identity.NodeID
+	peer     identity.NodeID
+	codec    byte
+
+	mu          sync.Mutex
+	state       State
+	connections int // made to the peer so far
+	inbound     map[net.Conn]Inbound
+}
+
+// countingWriter counts the bytes written to the connection
+type countingWriter struct {
+	io.Writer
+	bytes *metrics.Counter
 }
 
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.bytes.Add(float64(n))
+	return n, err
+}
+
+// countingReader counts the bytes read from the connection
+type countingReader struct {
+	io.Reader
+	bytes *metrics.Counter
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.bytes.Add(float64(n))
+	return n, err
+}
+
+// Send queues the message, when the queue is full the oldest message in it is dropped
 func (t *transport) Send(m interface{}) {
-	t.send <- m
+	select {
+	case <-t.close:
+		return
+	default:
+	}
+	for {
+		select {
+		case t.send <- m:
+			return
+		default:
+		}
+		select {
+		case <-t.send:
+			t.dropped.Inc()
+			t.mu.Lock()
+			t.state.Dropped++
+			t.mu.Unlock()
+		default:
+		}
+	}
 }
 
 func (t *transport) Recv() interface{} {
 	return <-t.recv
 }
 
+// Close does not close t.send, so that a Send racing with it does not panic
 func (t *transport) Close() {
-	close(t.send)
-	close(t.close)
+	t.closed.Do(func() {
+		close(t.close)
+	})
 }
 
-func (t *transport) Scheme() string {
-	return t.uri.Scheme
+// next returns the next queued message, false once the transport is closed
+func (t *transport) next() (interface{}, bool) {
+	select {
+	case <-t.close:
+		return nil, false
+	case m := <-t.send:
+		return m, true
+	}
 }
 
-func (t *transport) Dial() error {
-	conn, err := net.Dial(t.Scheme(), t.uri.Host)
+func (t *transport) Measure(sent *metrics.Counter, received *metrics.Counter, dropped *metrics.Counter) {
+	t.sent = sent
+	t.received = received
+	t.dropped = dropped
+}
+
+func (t *transport) Authenticate(local identity.NodeID, peer identity.NodeID) {
+	t.local = local
+	t.peer = peer
+}
+
+func (t *transport) SetCodec(name string) error {
+	codec, err := CodecID(name)
 	if err != nil {
 		return err
 	}
+	t.codec = codec
+	return nil
+}
 
-	go func(conn net.Conn) {
-		// w := bufio.NewWriter(conn)
-		// codec := NewCodec(config.Codec, conn)
-		encoder := gob.NewEncoder(conn)
-		defer conn.Close()
-		for m := range t.send {
-			err := encoder.Encode(&m)
-			if err != nil {
-				log.Error(err)
-			}
-		}
-	}(conn)
+func (t *transport) Scheme() string {
+	return t.uri.Scheme
+}
 
+// Dial keeps a connection to the peer in the background
+func (t *transport) Dial() error {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	go t.keepConnected(func() (net.Conn, error) {
+		return dialer.Dial(t.Scheme(), t.uri.Host)
+	})
 	return nil
 }
 
@@ -134,105 +236,9 @@ func (t *tcp) Listen() {
 		log.Fatal("TCP Listener error: ", err)
 	}
 
-	go func(listener net.Listener) {
-		defer listener.Close()
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				log.Error("TCP Accept error: ", err)
-				continue
-			}
-
-			go func(conn net.Conn) {
-				// codec := NewCodec(config.Codec, conn)
-				decoder := gob.NewDecoder(conn)
-				defer conn.Close()
-				//r := bufio.NewReader(conn)
-				for {
-					select {
-					case <-t.close:
-						return
-					default:
-						var m interface{}
-						err := decoder.Decode(&m)
-						if err != nil {
-							log.Error(err)
-							panic("panic many bad!")
-						}
-						t.recv <- m
-					}
-				}
-			}(conn)
-
-		}
-	}(listener)
-}
-
-/*
-*****************************
-/*     UDP communication      *
-/*****************************
-*/
-type udp struct {
-	*transport
-}
-
-func (u *udp) Dial() error {
-	addr, err := net.ResolveUDPAddr("udp", u.uri.Host)
-	if err != nil {
-		log.Fatal("UDP resolve address error: ", err)
-	}
-	conn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		return err
-	}
-
-	go func(conn *net.UDPConn) {
-		// packet := make([]byte, 1500)
-		// w := bytes.NewBuffer(packet)
-		w := new(bytes.Buffer)
-		for m := range u.send {
-			gob.NewEncoder(w).Encode(&m)
-			_, err := conn.Write(w.Bytes())
-			if err != nil {
-				log.Error(err)
-			}
-			w.Reset()
-		}
-	}(conn)
-
-	return nil
-}
-
-func (u *udp) Listen() {
-	addr, err := net.ResolveUDPAddr("udp", ":"+u.uri.Port())
-	if err != nil {
-		log.Fatal("UDP resolve address error: ", err)
-	}
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		log.Fatal("UDP Listener error: ", err)
-	}
-	go func(conn *net.UDPConn) {
-		packet := make([]byte, 1500)
-		defer conn.Close()
-		for {
-			select {
-			case <-u.close:
-				return
-			default:
-				_, err := conn.Read(packet)
-				if err != nil {
-					log.Error(err)
-					continue
-				}
-				r := bytes.NewReader(packet)
-				var m interface{}
-				gob.NewDecoder(r).Decode(&m)
-				u.recv <- m
-			}
-		}
-	}(conn)
+	go t.accept(listener, func(conn net.Conn) {
+		t.read(conn, "")
+	})
 }
 
 /*******************************
@@ -257,8 +263,13 @@ func (c *channel) Dial() error {
 	if !ok {
 		return errors.New("server not ready")
 	}
+	c.setState(true, nil)
 	go func(conn chan<- interface{}) {
-		for m := range c.send {
+		for {
+			m, ok := c.next()
+			if !ok {
+				return
+			}
 			conn <- m
 		}
 	}(conn)
@@ -0,0 +1,465 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"banyan/log"
+)
+
+/*
+*****************************
+/*     UDP communication      *
+/*****************************
+*/
+
+// Over udp a message is cut in fragments that fit in a datagram. A datagram carries the version, the codec, flags,
+// the stream of the dialer, the sequence number of the message in the stream, and the index and the number of the
+// fragments of the message. The listener puts the fragments of a stream back together and drops the messages it
+// already delivered. With -retransmit the messages of a single datagram, like the shares and votes, are acknowledged
+// and sent again until they are; a lost fragment of a larger message, like a block, loses the message, the replicas
+// request the blocks they miss.
+
+var Retransmit = flag.Bool("retransmit", false, "acknowledge and retransmit the messages sent in a single datagram over udp")
+
+const (
+	maxDatagram = 1400 // fits in an Ethernet frame with the IP and UDP headers
+	udpHeader   = 15
+	maxFragment = maxDatagram - udpHeader
+	udpBuffer   = 4 << 20 // socket buffers that hold the fragments of a few blocks
+
+	flagAck        = 1 // the datagram acknowledges the message of the sequence number
+	flagAckRequest = 2 // the dialer waits for an acknowledgment
+
+	retransmitTimeout = 50 * time.Millisecond // doubles with every retransmission
+	maxRetransmits    = 5
+	reassemblyTimeout = 2 * time.Second // a message whose fragments have not all arrived by then is lost
+	maxPartials       = 64              // messages of a stream put back together at the same time
+	dedupWindow       = 4096            // the messages of a stream older than that many are dropped
+	maxMessage        = 16 << 20        // bytes of the largest frame sent over udp
+	maxFragments      = (maxMessage + maxFragment - 1) / maxFragment
+	maxStreams        = 1024        // streams the listener follows, the least recently active is dropped
+	streamTimeout     = time.Minute // a stream without datagrams for that long is dropped
+)
+
+type udp struct {
+	*transport
+
+	// dialer
+	stream      uint32
+	seq         uint32
+	acked       bool // -retransmit as it was when the transport dialed
+	ackMu       sync.Mutex
+	unacked     map[uint32]*unacked
+	retransmits int
+}
+
+type unacked struct {
+	datagram []byte
+	sent     time.Time
+	tries    int
+}
+
+// header is the header of a datagram
+type header struct {
+	codec  byte
+	flags  byte
+	stream uint32
+	seq    uint32
+	index  uint16
+	count  uint16
+}
+
+func (h header) put(d []byte) {
+	d[0] = maxWireVersion
+	d[1] = h.codec
+	d[2] = h.flags
+	binary.BigEndian.PutUint32(d[3:], h.stream)
+	binary.BigEndian.PutUint32(d[7:], h.seq)
+	binary.BigEndian.PutUint16(d[11:], h.index)
+	binary.BigEndian.PutUint16(d[13:], h.count)
+}
+
+func parseHeader(d []byte) (header, error) {
+	if len(d) < udpHeader {
+		return header{}, errors.New("a datagram shorter than its header")
+	}
+	if d[0] != maxWireVersion {
+		return header{}, fmt.Errorf("a datagram of version %d of the wire format", d[0])
+	}
+	h := header{
+		codec:  d[1],
+		flags:  d[2],
+		stream: binary.BigEndian.Uint32(d[3:]),
+		seq:    binary.BigEndian.Uint32(d[7:]),
+		index:  binary.BigEndian.Uint16(d[11:]),
+		count:  binary.BigEndian.Uint16(d[13:]),
+	}
+	if h.flags&flagAck == 0 && (newCodec(h.codec) == nil || h.index >= h.count || h.count > maxFragments) {
+		return header{}, fmt.Errorf("a datagram of codec %d, fragment %d of %d", h.codec, h.index, h.count)
+	}
+	return h, nil
+}
+
+// fragment cuts the frame of a message in datagrams
+func fragment(h header, frame []byte) ([][]byte, error) {
+	if len(frame) > maxMessage {
+		return nil, fmt.Errorf("a frame of %d bytes, more than the %d bytes of a message over udp", len(frame), maxMessage)
+	}
+	count := (len(frame) + maxFragment - 1) / maxFragment
+	h.count = uint16(count)
+	datagrams := make([][]byte, count)
+	for i := range datagrams {
+		chunk := frame[i*maxFragment:]
+		if len(chunk) > maxFragment {
+			chunk = chunk[:maxFragment]
+		}
+		h.index = uint16(i)
+		datagrams[i] = make([]byte, udpHeader+len(chunk))
+		h.put(datagrams[i])
+		copy(datagrams[i][udpHeader:], chunk)
+	}
+	return datagrams, nil
+}
+
+// Dial sends the messages from a socket of its own, which gets the acknowledgments
+func (u *udp) Dial() error {
+	addr, err := net.ResolveUDPAddr("udp", u.uri.Host)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return err
+	}
+	_ = conn.SetWriteBuffer(udpBuffer)
+	u.setState(true, nil)
+	u.stream = rand.Uint32()
+	u.unacked = make(map[uint32]*unacked)
+
+	u.acked = *Retransmit
+
+	go u.write(conn)
+	go u.readAcks(conn)
+	if u.acked {
+		go u.retransmit(conn)
+	}
+	return nil
+}
+
+func (u *udp) write(conn *net.UDPConn) {
+	defer conn.Close()
+	up := true
+	for {
+		m, ok := u.next()
+		if !ok {
+			return
+		}
+		// a datagram stands on its own, a gob stream does not span datagrams
+		frame, err := newEncoder(u.codec).frame(m)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		u.seq++
+		datagrams, err := fragment(header{codec: u.codec, stream: u.stream, seq: u.seq}, frame)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if u.acked && len(datagrams) == 1 {
+			datagrams[0][2] |= flagAckRequest
+			u.ackMu.Lock()
+			u.unacked[u.seq] = &unacked{datagram: datagrams[0], sent: time.Now()}
+			u.ackMu.Unlock()
+		}
+		for _, d := range datagrams {
+			n, err := conn.Write(d)
+			u.sent.Add(float64(n))
+			// the peer is not listening, or was not when a datagram arrived
+			if err != nil && up {
+				log.Debugf("[%v] cannot reach %v: %v", u.local, u.uri.Host, err)
+				u.setState(false, err)
+			}
+			if err == nil && !up {
+				u.setState(true, nil)
+			}
+			up = err == nil
+		}
+	}
+}
+
+// readAcks removes the acknowledged messages from the ones to retransmit
+func (u *udp) readAcks(conn *net.UDPConn) {
+	d := make([]byte, maxDatagram)
+	for {
+		n, err := conn.Read(d)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		h, err := parseHeader(d[:n])
+		if err != nil || h.flags&flagAck == 0 || h.stream != u.stream {
+			continue
+		}
+		u.ackMu.Lock()
+		delete(u.unacked, h.seq)
+		u.ackMu.Unlock()
+	}
+}
+
+func (u *udp) retransmit(conn *net.UDPConn) {
+	ticker := time.NewTicker(retransmitTimeout / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-u.close:
+			return
+		case now := <-ticker.C:
+			u.ackMu.Lock()
+			for seq, m := range u.unacked {
+				if now.Sub(m.sent) < retransmitTimeout<<m.tries {
+					continue
+				}
+				if m.tries == maxRetransmits {
+					delete(u.unacked, seq)
+					continue
+				}
+				m.tries++
+				m.sent = now
+				u.retransmits++
+				n, _ := conn.Write(m.datagram)
+				u.sent.Add(float64(n))
+			}
+			u.ackMu.Unlock()
+		}
+	}
+}
+
+func (u *udp) State() State {
+	state := u.transport.State()
+	u.ackMu.Lock()
+	state.Retransmits = u.retransmits
+	u.ackMu.Unlock()
+	return state
+}
+
+func (u *udp) Listen() {
+	addr, err := net.ResolveUDPAddr("udp", ":"+u.uri.Port())
+	if err != nil {
+		log.Fatal("UDP resolve address error: ", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		log.Fatal("UDP Listener error: ", err)
+	}
+	_ = conn.SetReadBuffer(udpBuffer)
+	u.setState(true, nil)
+	go func() {
+		<-u.close
+		conn.Close()
+	}()
+	go u.read(conn)
+}
+
+// stream is what the listener knows of the messages of a dialer
+type stream struct {
+	partials  map[uint32]*partial
+	delivered map[uint32]bool
+	top       uint32    // the last sequence number delivered
+	seen      time.Time // the last datagram
+}
+
+// partial is a message whose fragments have not all arrived
+type partial struct {
+	fragments [][]byte
+	missing   int
+	size      int
+	started   time.Time
+}
+
+func (s *stream) isDelivered(seq uint32) bool {
+	if s.top > dedupWindow && seq <= s.top-dedupWindow {
+		return true
+	}
+	return s.delivered[seq]
+}
+
+func (s *stream) deliver(seq uint32) {
+	delete(s.partials, seq)
+	s.delivered[seq] = true
+	if seq > s.top {
+		s.top = seq
+	}
+	if len(s.delivered) > 2*dedupWindow {
+		for old := range s.delivered {
+			if old <= s.top-dedupWindow {
+				delete(s.delivered, old)
+			}
+		}
+	}
+}
+
+// add keeps the fragment and returns the frame of the message once it is complete
+func (s *stream) add(h header, chunk []byte, now time.Time) []byte {
+	p, exists := s.partials[h.seq]
+	if !exists {
+		if len(s.partials) == maxPartials {
+			s.dropOldest()
+		}
+		p = &partial{fragments: make([][]byte, h.count), missing: int(h.count), started: now}
+		s.partials[h.seq] = p
+	}
+	if int(h.count) != len(p.fragments) || p.fragments[h.index] != nil {
+		return nil
+	}
+	p.fragments[h.index] = append([]byte(nil), chunk...)
+	p.missing--
+	p.size += len(chunk)
+	if p.missing > 0 {
+		return nil
+	}
+	frame := make([]byte, 0, p.size)
+	for _, f := range p.fragments {
+		frame = append(frame, f...)
+	}
+	return frame
+}
+
+func (s *stream) dropOldest() {
+	var oldest uint32
+	var started time.Time
+	for seq, p := range s.partials {
+		if started.IsZero() || p.started.Before(started) {
+			oldest, started = seq, p.started
+		}
+	}
+	delete(s.partials, oldest)
+}
+
+// expire drops the messages that take too long to arrive
+func (s *stream) expire(now time.Time) {
+	for seq, p := range s.partials {
+		if now.Sub(p.started) > reassemblyTimeout {
+			delete(s.partials, seq)
+		}
+	}
+}
+
+type streamKey struct {
+	addr   string
+	stream uint32
+}
+
+// streamTable holds the streams of the dialers, a sender of datagrams with ever new streams only pushes out the idle ones
+type streamTable map[streamKey]*stream
+
+// get returns the stream, new if it is not followed yet
+func (ss streamTable) get(key streamKey, now time.Time) *stream {
+	s, exists := ss[key]
+	if !exists {
+		if len(ss) >= maxStreams {
+			var idlest streamKey
+			var seen time.Time
+			for k, s := range ss {
+				if seen.IsZero() || s.seen.Before(seen) {
+					idlest, seen = k, s.seen
+				}
+			}
+			delete(ss, idlest)
+		}
+		s = &stream{partials: make(map[uint32]*partial), delivered: make(map[uint32]bool)}
+		ss[key] = s
+	}
+	s.seen = now
+	return s
+}
+
+// expire drops the idle streams and the messages that take too long to arrive
+func (ss streamTable) expire(now time.Time) {
+	for key, s := range ss {
+		if now.Sub(s.seen) > streamTimeout {
+			delete(ss, key)
+			continue
+		}
+		s.expire(now)
+	}
+}
+
+// read puts the messages back together and delivers each once, until the transport is closed
+func (u *udp) read(conn *net.UDPConn) {
+	streams := make(streamTable)
+	d := make([]byte, 1<<16)
+	swept := time.Now()
+	for {
+		n, from, err := conn.ReadFromUDP(d)
+		if err != nil {
+			select {
+			case <-u.close:
+				return
+			default:
+			}
+			log.Error(err)
+			continue
+		}
+		u.received.Add(float64(n))
+		h, err := parseHeader(d[:n])
+		if err != nil {
+			log.Warningf("[%v] dropped a datagram from %v: %v", u.local, from, err)
+			continue
+		}
+		if h.flags&flagAck != 0 {
+			continue
+		}
+		now := time.Now()
+		s := streams.get(streamKey{from.String(), h.stream}, now)
+		if now.Sub(swept) > reassemblyTimeout {
+			streams.expire(now)
+			swept = now
+		}
+
+		if s.isDelivered(h.seq) {
+			u.mu.Lock()
+			u.state.Duplicates++
+			u.mu.Unlock()
+			// the acknowledgment was lost
+			u.ack(conn, from, h)
+			continue
+		}
+		frame := s.add(h, d[udpHeader:n], now)
+		if frame == nil {
+			continue
+		}
+		s.deliver(h.seq)
+		u.ack(conn, from, h)
+		m, err := newDecoder(h.codec).read(bytes.NewReader(frame))
+		if err != nil {
+			log.Warningf("[%v] dropped a message from %v: %v", u.local, from, err)
+			continue
+		}
+		select {
+		case <-u.close:
+			return
+		case u.recv <- m:
+		}
+	}
+}
+
+func (u *udp) ack(conn *net.UDPConn, to *net.UDPAddr, h header) {
+	if h.flags&flagAckRequest == 0 {
+		return
+	}
+	d := make([]byte, udpHeader)
+	header{codec: h.codec, flags: flagAck, stream: h.stream, seq: h.seq}.put(d)
+	n, _ := conn.WriteToUDP(d, to)
+	u.sent.Add(float64(n))
+}
@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDPFragments(t *testing.T) {
+	addr := freeAddress(t, "udp")
+	server := newTransport(t, addr)
+	server.Listen()
+	for _, codec := range []string{"gob", "binary"} {
+		client := newTransport(t, addr)
+		require.NoError(t, client.SetCodec(codec))
+		require.NoError(t, client.Dial())
+		large := relayedMessage{Text: strings.Repeat("a block ", 100000)}
+		client.Send(countedMessage{Count: 1})
+		client.Send(large)
+		client.Send(countedMessage{Count: 2})
+		require.Equal(t, countedMessage{Count: 1}, receive(server))
+		require.Equal(t, large, receive(server))
+		require.Equal(t, countedMessage{Count: 2}, receive(server))
+		client.Close()
+	}
+}
+
+// the listener puts the fragments back together in any order, and delivers a message once
+func TestUDPReassembly(t *testing.T) {
+	addr := freeAddress(t, "udp")
+	server := newTransport(t, addr)
+	server.Listen()
+	conn, err := net.Dial("udp", addr[len("udp://"):])
+	require.NoError(t, err)
+	defer conn.Close()
+
+	large := relayedMessage{Text: strings.Repeat("x", 3*maxFragment)}
+	frame, err := newEncoder(codecGob).frame(large)
+	require.NoError(t, err)
+	datagrams, err := fragment(header{codec: codecGob, stream: 7, seq: 1}, frame)
+	require.NoError(t, err)
+	require.Len(t, datagrams, 4)
+	for i := len(datagrams) - 1; i >= 0; i-- {
+		_, err = conn.Write(datagrams[i])
+		require.NoError(t, err)
+		_, err = conn.Write(datagrams[i])
+		require.NoError(t, err)
+	}
+	require.Equal(t, large, receive(server))
+	for _, d := range datagrams {
+		_, err = conn.Write(d)
+		require.NoError(t, err)
+	}
+	_, err = conn.Write([]byte("garbage"))
+	require.NoError(t, err)
+
+	frame, err = newEncoder(codecGob).frame(countedMessage{Count: 2})
+	require.NoError(t, err)
+	datagrams, err = fragment(header{codec: codecGob, stream: 7, seq: 2}, frame)
+	require.NoError(t, err)
+	_, err = conn.Write(datagrams[0])
+	require.NoError(t, err)
+	require.Equal(t, countedMessage{Count: 2}, receive(server), "the duplicates and the garbage are dropped")
+	require.Equal(t, 5, server.State().Duplicates)
+}
+
+// a datagram cannot make the listener hold more than a message of maxMessage bytes, nor follow more than maxStreams
+func TestUDPBounds(t *testing.T) {
+	_, err := fragment(header{codec: codecGob}, make([]byte, maxMessage+1))
+	require.Error(t, err)
+	d := make([]byte, udpHeader)
+	header{codec: codecGob, count: maxFragments}.put(d)
+	_, err = parseHeader(d)
+	require.NoError(t, err)
+	header{codec: codecGob, count: maxFragments + 1}.put(d)
+	_, err = parseHeader(d)
+	require.Error(t, err, "a message of more than maxMessage bytes")
+
+	streams := make(streamTable)
+	start := time.Now()
+	for i := 0; i <= maxStreams; i++ {
+		streams.get(streamKey{"127.0.0.1:1", uint32(i)}, start.Add(time.Duration(i)*time.Millisecond))
+	}
+	require.Len(t, streams, maxStreams)
+	require.NotContains(t, streams, streamKey{"127.0.0.1:1", 0}, "the least recently active stream is dropped")
+
+	// a stream that keeps sending is kept, the idle ones are dropped
+	now := start.Add(streamTimeout + 2*time.Second)
+	streams.get(streamKey{"127.0.0.1:1", 5}, now)
+	streams.expire(now)
+	require.Len(t, streams, 1)
+	require.Contains(t, streams, streamKey{"127.0.0.1:1", 5})
+}
+
+func TestUDPRetransmit(t *testing.T) {
+	*Retransmit = true
+	defer func() { *Retransmit = false }()
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer peer.Close()
+	client := newTransport(t, "udp://"+peer.LocalAddr().String())
+	require.NoError(t, client.Dial())
+	client.Send(countedMessage{Count: 1})
+
+	// the peer does not acknowledge the first datagram
+	first := make([]byte, maxDatagram)
+	n, _, err := peer.ReadFromUDP(first)
+	require.NoError(t, err)
+	first = first[:n]
+	h, err := parseHeader(first)
+	require.NoError(t, err)
+	require.NotZero(t, h.flags&flagAckRequest)
+	again := make([]byte, maxDatagram)
+	n, from, err := peer.ReadFromUDP(again)
+	require.NoError(t, err)
+	require.Equal(t, first, again[:n])
+	require.Equal(t, 1, client.State().Retransmits)
+
+	ack := make([]byte, udpHeader)
+	header{flags: flagAck, stream: h.stream, seq: h.seq}.put(ack)
+	_, err = peer.WriteToUDP(ack, from)
+	require.NoError(t, err)
+	require.NoError(t, peer.SetReadDeadline(time.Now().Add(4*retransmitTimeout)))
+	_, _, err = peer.ReadFromUDP(again)
+	require.Error(t, err, "an acknowledged message is not sent again")
+}
+
+// BenchmarkLatency sends a message of the size of a vote and waits for it to arrive, over each transport
+func BenchmarkLatency(b *testing.B) {
+	vote := countedMessage{Count: 1, Text: strings.Repeat("s", 64)}
+	for _, scheme := range []string{"tcp", "udp", "udp+retransmit"} {
+		b.Run(scheme, func(b *testing.B) {
+			*Retransmit = scheme == "udp+retransmit"
+			defer func() { *Retransmit = false }()
+			addr := freeAddress(b, strings.TrimSuffix(scheme, "+retransmit"))
+			server := newTransport(b, addr)
+			server.Listen()
+			client := newTransport(b, addr)
+			require.NoError(b, client.SetCodec("binary"))
+			require.NoError(b, client.Dial())
+			client.Send(vote)
+			server.Recv()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				client.Send(vote)
+				server.Recv()
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"banyan/types/encoding"
+	wirebinary "banyan/types/encoding/binary"
+	"banyan/types/encoding/gob"
+	"banyan/types/encoding/json"
+	"banyan/types/encoding/rlp"
+)
+
+/*
+*****************************
+/*         Wire format        *
+/*****************************
+*/
+
+// Over tcp and tls the dialer opens a connection with a hello, the versions of the wire format it speaks and the
+// codecs it encodes with in order of preference, and the listener replies with the version and the codec it picked.
+// The messages follow in frames: the tag of their type, flags, the length of the body and the body. A type the
+// codec cannot encode, like a message json or rlp do not handle or that has no binary encoding, is encoded with
+// gob, and the flags of the frame tell so. Over udp the frame of a message is cut in datagrams, each with the version
+// and the codec.
+
+const (
+	wireMagic      = "BNYN"
+	minWireVersion = 1
+	maxWireVersion = 1
+
+	frameHeader = 7        // tag, flags and length
+	maxFrame    = 16 << 20 // bytes of the body of the largest message, as over udp
+	readChunk   = 64 << 10 // bytes of the body allocated before they arrive
+
+	flagFallback = 1 // the body is encoded with gob rather than the codec of the connection
+)
+
+// the codecs by the id they are negotiated with
+const (
+	codecGob    byte = 1
+	codecJSON   byte = 2
+	codecRLP    byte = 3
+	codecBinary byte = 4
+)
+
+var codecs = map[string]byte{
+	"gob":    codecGob,
+	"json":   codecJSON,
+	"rlp":    codecRLP,
+	"binary": codecBinary,
+}
+
+// CodecID returns the id of the codec with the name
+func CodecID(name string) (byte, error) {
+	id, exists := codecs[name]
+	if !exists {
+		return 0, fmt.Errorf("unknown codec %q, expected gob, json, rlp or binary", name)
+	}
+	return id, nil
+}
+
+// newCodec returns the codec with the id, a gob codec keeps the state of a stream and is used for one connection
+func newCodec(id byte) encoding.Encoder {
+	switch id {
+	case codecGob:
+		return gob.NewEncoder()
+	case codecJSON:
+		return json.NewEncoder()
+	case codecRLP:
+		return rlp.NewEncoder()
+	case codecBinary:
+		return wirebinary.NewEncoder()
+	}
+	return nil
+}
+
+var (
+	registry sync.RWMutex
+	tags     = make(map[reflect.Type]uint16)
+	messages = make(map[uint16]reflect.Type)
+)
+
+// Register gives the messages of the type of m, or of the type m points to, a tag on the wire. Every replica has to
+// give a type the same tag, and a tag is never given to another type, or the replicas of different versions no
+// longer understand each other. Only the registered messages are sent over tcp, tls and udp, they are received as
+// values.
+func Register(tag uint16, m interface{}) {
+	t := reflect.TypeOf(m)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if registered, exists := messages[tag]; exists && registered != t {
+		panic(fmt.Sprintf("transport: tag %d registered for %v and %v", tag, registered, t))
+	}
+	if registered, exists := tags[t]; exists && registered != tag {
+		panic(fmt.Sprintf("transport: %v registered with tags %d and %d", t, registered, tag))
+	}
+	tags[t] = tag
+	messages[tag] = t
+}
+
+// errResync tells that the connection has to start over for the peer to decode the messages that follow
+var errResync = errors.New("the gob stream of the connection is out of sync")
+
+// encoder frames the messages sent over a connection
+type encoder struct {
+	codec    encoding.Encoder
+	fallback encoding.Encoder // nil if the codec is gob
+}
+
+func newEncoder(codec byte) *encoder {
+	e := &encoder{codec: newCodec(codec)}
+	if codec != codecGob {
+		e.fallback = gob.NewEncoder()
+	}
+	return e
+}
+
+// frame returns the frame of the message, errResync if a failure broke the gob stream
+func (e *encoder) frame(m interface{}) ([]byte, error) {
+	v := reflect.ValueOf(m)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	registry.RLock()
+	tag, exists := tags[v.Type()]
+	registry.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%T is not registered", m)
+	}
+	// the codecs get a pointer, the binary encodings have pointer receivers
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+
+	var flags byte
+	body, err := e.codec.Encode(p.Interface())
+	if err != nil && e.fallback != nil {
+		flags |= flagFallback
+		body, err = e.fallback.Encode(p.Interface())
+	}
+	if errors.Is(err, gob.ErrOutOfSync) {
+		return nil, fmt.Errorf("%w: %v", errResync, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxFrame {
+		return nil, fmt.Errorf("a %T of %d bytes does not fit in a frame", m, len(body))
+	}
+	frame := make([]byte, frameHeader, frameHeader+len(body))
+	binary.BigEndian.PutUint16(frame, tag)
+	frame[2] = flags
+	binary.BigEndian.PutUint32(frame[3:], uint32(len(body)))
+	return append(frame, body...), nil
+}
+
+// decoder reads the frames of a connection
+type decoder struct {
+	codec    encoding.Encoder
+	fallback encoding.Encoder
+}
+
+func newDecoder(codec byte) *decoder {
+	d := &decoder{codec: newCodec(codec)}
+	if codec != codecGob {
+		d.fallback = gob.NewEncoder()
+	}
+	return d
+}
+
+// errUnknownTag is returned for a frame of a type this replica does not know, the frames that follow are readable
+var errUnknownTag = errors.New("unknown message tag")
+
+// read returns the message of the next frame
+func (d *decoder) read(r io.Reader) (interface{}, error) {
+	var header [frameHeader]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	tag := binary.BigEndian.Uint16(header[:])
+	length := binary.BigEndian.Uint32(header[3:])
+	if length > maxFrame {
+		return nil, fmt.Errorf("a frame of %d bytes", length)
+	}
+	// the decoded messages may share the memory of the body, it is never reused
+	body, err := readBody(r, int(length))
+	if err != nil {
+		return nil, err
+	}
+	return d.decode(tag, header[2], body)
+}
+
+// readBody reads a body of the length in chunks, so that a header does not make the replica allocate more than
+// the bytes the peer actually sends
+func readBody(r io.Reader, length int) ([]byte, error) {
+	size := length
+	if size > readChunk {
+		size = readChunk
+	}
+	body := make([]byte, 0, size)
+	for len(body) < length {
+		n := length - len(body)
+		if n > readChunk {
+			n = readChunk
+		}
+		body = append(body, make([]byte, n)...)
+		if _, err := io.ReadFull(r, body[len(body)-n:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+func (d *decoder) decode(tag uint16, flags byte, body []byte) (interface{}, error) {
+	registry.RLock()
+	t, exists := messages[tag]
+	registry.RUnlock()
+	codec := d.codec
+	if flags&flagFallback != 0 {
+		if d.fallback == nil {
+			return nil, errors.New("a gob frame flagged as a fallback")
+		}
+		codec = d.fallback
+	}
+	if !exists {
+		// gob describes a type in the first frame of the type, the stream has to go through it
+		if stream, ok := codec.(*gob.Encoder); ok {
+			if err := stream.Decode(body, nil); err != nil {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("%w %d", errUnknownTag, tag)
+	}
+	p := reflect.New(t)
+	if err := codec.Decode(body, p.Interface()); err != nil {
+		return nil, fmt.Errorf("decoding a %v: %w", t, err)
+	}
+	return p.Elem().Interface(), nil
+}
+
+// hello opens a connection: it offers the versions and the codec, and gob in case the peer does not know the
+// codec, and returns the encoder of the codec the peer picked
+func hello(rw io.ReadWriter, codec byte) (*encoder, error) {
+	offer := []byte(wireMagic)
+	offer = append(offer, minWireVersion, maxWireVersion)
+	if codec == codecGob {
+		offer = append(offer, 1, codecGob)
+	} else {
+		offer = append(offer, 2, codec, codecGob)
+	}
+	if _, err := rw.Write(offer); err != nil {
+		return nil, err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(rw, reply[:]); err != nil {
+		return nil, err
+	}
+	if reply[0] == 0 {
+		return nil, fmt.Errorf("the peer speaks none of the versions %d to %d of the wire format or none of the codecs offered", minWireVersion, maxWireVersion)
+	}
+	if newCodec(reply[1]) == nil {
+		return nil, fmt.Errorf("the peer picked the unknown codec %d", reply[1])
+	}
+	return newEncoder(reply[1]), nil
+}
+
+// welcome answers the hello of a peer and returns the decoder of the codec it picked
+func welcome(rw io.ReadWriter) (*decoder, error) {
+	var offer [len(wireMagic) + 3]byte
+	if _, err := io.ReadFull(rw, offer[:]); err != nil {
+		return nil, err
+	}
+	if string(offer[:len(wireMagic)]) != wireMagic {
+		return nil, errors.New("the peer does not speak the wire format")
+	}
+	low, high := offer[len(wireMagic)], offer[len(wireMagic)+1]
+	offered := make([]byte, offer[len(wireMagic)+2])
+	if _, err := io.ReadFull(rw, offered); err != nil {
+		return nil, err
+	}
+	version := byte(maxWireVersion)
+	if high < version {
+		version = high
+	}
+	if version < low || version < minWireVersion {
+		_, _ = rw.Write([]byte{0, 0})
+		return nil, fmt.Errorf("the peer speaks the versions %d to %d of the wire format", low, high)
+	}
+	for _, codec := range offered {
+		if newCodec(codec) != nil {
+			if _, err := rw.Write([]byte{version, codec}); err != nil {
+				return nil, err
+			}
+			return newDecoder(codec), nil
+		}
+	}
+	_, _ = rw.Write([]byte{0, 0})
+	return nil, fmt.Errorf("the peer offers none of the known codecs: %v", offered)
+}
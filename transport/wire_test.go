@@ -0,0 +1,156 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+
+	wirebinary "banyan/types/encoding/binary"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countedMessage has a binary encoding
+type countedMessage struct {
+	Count int
+	Text  string
+}
+
+func (m *countedMessage) MarshalWire(w *wirebinary.Writer) {
+	w.Int(m.Count)
+	w.String(m.Text)
+}
+
+func (m *countedMessage) UnmarshalWire(r *wirebinary.Reader) {
+	m.Count = r.Int()
+	m.Text = r.String()
+}
+
+func init() {
+	Register(1002, countedMessage{})
+}
+
+func TestCodecs(t *testing.T) {
+	messages := []interface{}{
+		countedMessage{Count: -1, Text: "binary"},
+		&countedMessage{Count: 2, Text: "sent as a pointer"},
+		relayedMessage{Text: "no binary encoding"}, // encoded with gob by the binary codec
+		testMessage{From: "2", Text: "hello"},
+	}
+	for name := range codecs {
+		t.Run(name, func(t *testing.T) {
+			addr := freeAddress(t, "tcp")
+			server := newTransport(t, addr)
+			server.Listen()
+			client := newTransport(t, addr)
+			require.NoError(t, client.SetCodec(name))
+			require.NoError(t, client.Dial())
+			for _, m := range messages {
+				client.Send(m)
+			}
+			require.Equal(t, countedMessage{Count: -1, Text: "binary"}, receive(server))
+			require.Equal(t, countedMessage{Count: 2, Text: "sent as a pointer"}, receive(server))
+			// rlp encodes no signed integer and falls back to gob for these
+			require.Equal(t, relayedMessage{Text: "no binary encoding"}, receive(server))
+			require.Equal(t, testMessage{From: "2", Text: "hello"}, receive(server))
+		})
+	}
+	require.Error(t, newTransport(t, freeAddress(t, "tcp")).SetCodec("xml"))
+}
+
+func TestFallback(t *testing.T) {
+	e, d := newEncoder(codecRLP), newDecoder(codecRLP)
+	for _, m := range []interface{}{relayedMessage{Text: "rlp"}, countedMessage{Count: -1}, countedMessage{Count: -2}} {
+		frame, err := e.frame(m)
+		require.NoError(t, err)
+		decoded, err := d.decode(binary.BigEndian.Uint16(frame), frame[2], frame[frameHeader:])
+		require.NoError(t, err)
+		require.Equal(t, m, decoded)
+	}
+	frame, err := e.frame(relayedMessage{})
+	require.NoError(t, err)
+	require.Zero(t, frame[2]&flagFallback, "rlp encodes strings")
+	frame, err = e.frame(countedMessage{Count: -3})
+	require.NoError(t, err)
+	require.NotZero(t, frame[2]&flagFallback)
+
+	_, err = e.frame(struct{}{})
+	require.Error(t, err, "only the registered types are sent")
+}
+
+func TestUnknownTag(t *testing.T) {
+	e, d := newEncoder(codecGob), newDecoder(codecGob)
+	// the peer does not know the first message, gob describes the type in it
+	frame, err := e.frame(testMessage{From: "1", Text: "unknown"})
+	require.NoError(t, err)
+	binary.BigEndian.PutUint16(frame, 9999)
+	_, err = d.decode(9999, frame[2], frame[frameHeader:])
+	require.True(t, errors.Is(err, errUnknownTag))
+
+	frame, err = e.frame(testMessage{From: "1", Text: "known"})
+	require.NoError(t, err)
+	m, err := d.decode(binary.BigEndian.Uint16(frame), frame[2], frame[frameHeader:])
+	require.NoError(t, err)
+	require.Equal(t, testMessage{From: "1", Text: "known"}, m)
+}
+
+// a header cannot make the reader allocate the body it announces before the body arrives
+func TestFrameSize(t *testing.T) {
+	_, err := newEncoder(codecGob).frame(testMessage{Text: string(make([]byte, maxFrame+1))})
+	require.Error(t, err, "a message of more than maxFrame bytes")
+
+	e, d := newEncoder(codecGob), newDecoder(codecGob)
+	frame, err := e.frame(testMessage{From: "1", Text: string(make([]byte, 3*readChunk))})
+	require.NoError(t, err)
+	m, err := d.read(bytes.NewReader(frame))
+	require.NoError(t, err)
+	require.Equal(t, testMessage{From: "1", Text: string(make([]byte, 3*readChunk))}, m)
+
+	header := make([]byte, frameHeader)
+	binary.BigEndian.PutUint32(header[3:], maxFrame+1)
+	_, err = newDecoder(codecGob).read(bytes.NewReader(header))
+	require.Error(t, err)
+
+	binary.BigEndian.PutUint32(header[3:], maxFrame)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = newDecoder(codecGob).read(bytes.NewReader(append(header, 1, 2, 3)))
+	runtime.ReadMemStats(&after)
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+	require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(maxFrame/16))
+}
+
+func TestNegotiation(t *testing.T) {
+	dialer, listener := net.Pipe()
+	go func() {
+		_, _ = welcome(listener)
+	}()
+	e, err := hello(dialer, codecJSON)
+	require.NoError(t, err)
+	require.IsType(t, newCodec(codecJSON), e.codec)
+
+	// a peer that only speaks later versions
+	dialer, listener = net.Pipe()
+	go func() {
+		_, _ = dialer.Write([]byte(wireMagic + "\x02\x03\x01\x01"))
+		_, _ = dialer.Read(make([]byte, 2))
+	}()
+	_, err = welcome(listener)
+	require.Error(t, err)
+
+	// a peer that offers unknown codecs only
+	dialer, listener = net.Pipe()
+	go func() {
+		_, _ = welcome(listener)
+	}()
+	_, err = dialer.Write([]byte(wireMagic + "\x01\x01\x01\x63"))
+	require.NoError(t, err)
+	reply := make([]byte, 2)
+	_, err = dialer.Read(reply)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 0}, reply)
+}
@@ -0,0 +1,198 @@
+// Package twins tests the protocols with Twins: some replicas run twice, with the same identity and keys, and a
+// scenario decides the leader and the partition of the instances round by round. The twins behave like Byzantine
+// replicas that equivocate without any code to do it. Each scenario runs in the simulator and the replicas without
+// a twin are checked for safety violations; the scenarios that fail are minimized and saved to be replayed.
+package twins
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"banyan/election"
+	"banyan/identity"
+	"banyan/types"
+)
+
+// Round is a round of a scenario, the round of index i lasts from i times Scenario.Round to the next. The leader
+// is the leader of the height and rank whose sum is i+1 in Banyan and ICC, and of view i+1 in HotStuff and Streamlet.
+type Round struct {
+	Leader identity.NodeID `json:"leader"`
+	// Partition lists the groups of instances that cannot reach each other, the second instance of a twin is named
+	// with a quote, as 2'. The instances not listed form a group of their own.
+	Partition [][]string `json:"partition,omitempty"`
+}
+
+// Scenario is a Twins execution, after its rounds the leaders take turns and the network is whole
+type Scenario struct {
+	Algorithm string            `json:"algorithm"`
+	N         int               `json:"n"`
+	F         int               `json:"f"`
+	P         int               `json:"p"`
+	Seed      int64             `json:"seed"` // seed of the message delays
+	Latency   string            `json:"latency"`
+	Timeout   time.Duration     `json:"timeout_ns"`
+	Round     time.Duration     `json:"round_ns"`
+	Duration  time.Duration     `json:"duration_ns"`
+	Twins     []identity.NodeID `json:"twins"`
+	Rounds    []Round           `json:"rounds"`
+
+	// Violations are what went wrong when the scenario was saved, a replay reproduces them
+	Violations []string `json:"violations,omitempty"`
+}
+
+// Options describes the scenarios to generate
+type Options struct {
+	Algorithm string
+	N, F, P   int
+	Twins     int // replicas with a twin, F at most for the protocols to be safe
+	Rounds    int
+	Round     time.Duration
+	Timeout   time.Duration
+	Duration  time.Duration
+	Latency   string
+}
+
+// Generate draws a scenario from the seed, the same seed gives the same scenario
+func Generate(o Options, seed int64) Scenario {
+	r := rand.New(rand.NewSource(seed))
+	s := Scenario{
+		Algorithm: o.Algorithm,
+		N:         o.N,
+		F:         o.F,
+		P:         o.P,
+		Seed:      seed,
+		Latency:   o.Latency,
+		Timeout:   o.Timeout,
+		Round:     o.Round,
+		Duration:  o.Duration,
+	}
+	for _, i := range r.Perm(o.N)[:o.Twins] {
+		s.Twins = append(s.Twins, identity.NewNodeID(i+1))
+	}
+	sort.Slice(s.Twins, func(i, j int) bool { return s.Twins[i].Node() < s.Twins[j].Node() })
+	instances := s.instances()
+	for i := 0; i < o.Rounds; i++ {
+		round := Round{Leader: identity.NewNodeID(r.Intn(o.N) + 1)}
+		// a third of the rounds run without a partition
+		if groups := r.Intn(3); groups > 0 {
+			round.Partition = make([][]string, groups+1)
+			for _, instance := range instances {
+				g := r.Intn(groups + 1)
+				round.Partition[g] = append(round.Partition[g], instance)
+			}
+			round.Partition = trim(round.Partition)
+		}
+		s.Rounds = append(s.Rounds, round)
+	}
+	return s
+}
+
+// trim removes the empty groups, a single group is no partition
+func trim(groups [][]string) [][]string {
+	var trimmed [][]string
+	for _, g := range groups {
+		if len(g) > 0 {
+			trimmed = append(trimmed, g)
+		}
+	}
+	if len(trimmed) < 2 {
+		return nil
+	}
+	return trimmed
+}
+
+// instances names the instances, the replicas followed by the second instances of the twins
+func (s Scenario) instances() []string {
+	var names []string
+	for i := 1; i <= s.N; i++ {
+		names = append(names, string(identity.NewNodeID(i)))
+	}
+	for _, id := range s.Twins {
+		names = append(names, string(id)+"'")
+	}
+	return names
+}
+
+// instance returns the index of the named instance in the simulator
+func (s Scenario) instance(name string) (int, error) {
+	if strings.HasSuffix(name, "'") {
+		id := identity.NodeID(strings.TrimSuffix(name, "'"))
+		for i, twin := range s.Twins {
+			if twin == id {
+				return s.N + i, nil
+			}
+		}
+		return 0, fmt.Errorf("%v has no twin", id)
+	}
+	for i, instance := range s.instances()[:s.N] {
+		if instance == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown instance %v", name)
+}
+
+func (s Scenario) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v, n: %v, seed: %v, twins: %v", s.Algorithm, s.N, s.Seed, s.Twins)
+	for i, r := range s.Rounds {
+		fmt.Fprintf(&b, "\n  round %v: leader %v", i, r.Leader)
+		if len(r.Partition) > 0 {
+			fmt.Fprintf(&b, ", partition %v", r.Partition)
+		}
+	}
+	return b.String()
+}
+
+// Load reads a scenario saved with Save
+func Load(name string) (Scenario, error) {
+	var s Scenario
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(data, &s)
+	return s, err
+}
+
+// Save writes the scenario to a file to be replayed
+func (s Scenario) Save(name string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append(data, '\n'), 0644)
+}
+
+// schedule elects the leaders of the rounds of a scenario, and lets the replicas take turns after them
+type schedule struct {
+	leaders  []identity.NodeID
+	rotation *election.Rotation
+}
+
+func (s *schedule) FindLeaderFor(height int, rank int) identity.NodeID {
+	if round := height - 1 + rank; round >= 0 && round < len(s.leaders) {
+		return s.leaders[round]
+	}
+	return s.rotation.FindLeaderFor(height, rank)
+}
+
+func (s *schedule) IsLeader(id identity.NodeID, height int, rank int) bool {
+	return s.FindLeaderFor(height, rank) == id
+}
+
+func (s *schedule) FindLeaderForView(view types.View) identity.NodeID {
+	if round := int(view) - 1; round >= 0 && round < len(s.leaders) {
+		return s.leaders[round]
+	}
+	return s.rotation.FindLeaderForView(view)
+}
+
+func (s *schedule) IsLeaderView(id identity.NodeID, view types.View) bool {
+	return s.FindLeaderForView(view) == id
+}
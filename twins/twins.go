@@ -0,0 +1,193 @@
+package twins
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"banyan/checker"
+	"banyan/election"
+	"banyan/identity"
+	"banyan/simulator"
+)
+
+// Result is the outcome of a scenario
+type Result struct {
+	Violations []checker.Violation // the safety violations among the replicas without a twin
+	Panic      string              // what a replica panicked with, if one did
+}
+
+// Failed tells whether the scenario broke safety or crashed a replica
+func (r Result) Failed() bool {
+	return len(r.Violations) > 0 || r.Panic != ""
+}
+
+// Describe lists what went wrong
+func (r Result) Describe() []string {
+	var failures []string
+	if r.Panic != "" {
+		failures = append(failures, "panic: "+r.Panic)
+	}
+	for _, v := range r.Violations {
+		failures = append(failures, v.String())
+	}
+	return failures
+}
+
+// Run runs the scenario in the simulator and checks the replicas without a twin
+func Run(s Scenario) (result Result, err error) {
+	if s.Round <= 0 {
+		return result, errors.New("the rounds have to last")
+	}
+	latency, err := simulator.ParseLatency(s.Latency)
+	if err != nil {
+		return result, err
+	}
+	leaders := &schedule{rotation: election.NewRotation(s.N)}
+	partitions := make([][][]int, len(s.Rounds))
+	for i, round := range s.Rounds {
+		leaders.leaders = append(leaders.leaders, round.Leader)
+		for _, group := range round.Partition {
+			var instances []int
+			for _, name := range group {
+				instance, err := s.instance(name)
+				if err != nil {
+					return result, err
+				}
+				instances = append(instances, instance)
+			}
+			partitions[i] = append(partitions[i], instances)
+		}
+	}
+
+	// the checker follows the commits to stop at the first violation, the replicas of a broken protocol can flood
+	// each other with requests for the blocks they disagree on
+	ch := checker.New(checker.Config{Byzantine: s.Twins})
+	var sim *simulator.Simulator
+	sim, err = simulator.New(simulator.Config{
+		Algorithm: s.Algorithm,
+		N:         s.N,
+		F:         s.F,
+		P:         s.P,
+		Seed:      s.Seed,
+		Duration:  s.Duration,
+		Timeout:   s.Timeout,
+		Latency:   latency,
+		Twins:     s.Twins,
+		Election:  leaders,
+		OnCommit: func(id identity.NodeID, c simulator.Commit) {
+			ch.Commit(id, checker.FromSimulation(c))
+			if len(ch.Violations()) > 0 {
+				sim.Stop()
+			}
+		},
+	})
+	if err != nil {
+		return result, err
+	}
+	for i := range partitions {
+		groups := partitions[i]
+		sim.At(time.Duration(i)*s.Round, func() { sim.Isolate(groups) })
+	}
+	sim.At(time.Duration(len(partitions))*s.Round, func() { sim.Isolate(nil) })
+	defer func() {
+		if p := recover(); p != nil {
+			result.Panic = fmt.Sprint(p)
+		}
+	}()
+	// the twins keep going once their rounds are over, only safety is checked
+	sim.Run()
+	result.Violations = ch.Violations()
+	return result, nil
+}
+
+// Minimize simplifies a scenario that fails as long as it still does: it drops the last rounds, the partitions,
+// the twins and the end of the execution
+func Minimize(s Scenario, fails func(Scenario) bool) Scenario {
+	for changed := true; changed; {
+		changed = false
+		try := func(candidate Scenario) {
+			if !changed && fails(candidate) {
+				s, changed = candidate, true
+			}
+		}
+		for k := 0; k < len(s.Rounds); k++ {
+			candidate := s
+			candidate.Rounds = s.Rounds[:k]
+			try(candidate)
+		}
+		for i := range s.Rounds {
+			if len(s.Rounds[i].Partition) > 0 {
+				candidate := s
+				candidate.Rounds = append([]Round(nil), s.Rounds...)
+				candidate.Rounds[i].Partition = nil
+				try(candidate)
+			}
+		}
+		for i := range s.Twins {
+			try(s.withoutTwin(i))
+		}
+		if end := time.Duration(len(s.Rounds)) * s.Round; s.Duration/2 > end {
+			candidate := s
+			candidate.Duration /= 2
+			try(candidate)
+		}
+	}
+	return s
+}
+
+// withoutTwin removes the second instance of a twin from the scenario
+func (s Scenario) withoutTwin(i int) Scenario {
+	removed := string(s.Twins[i]) + "'"
+	s.Twins = append(s.Twins[:i:i], s.Twins[i+1:]...)
+	rounds := make([]Round, len(s.Rounds))
+	for r, round := range s.Rounds {
+		rounds[r].Leader = round.Leader
+		for _, group := range round.Partition {
+			var kept []string
+			for _, name := range group {
+				if name != removed {
+					kept = append(kept, name)
+				}
+			}
+			rounds[r].Partition = append(rounds[r].Partition, kept)
+		}
+		rounds[r].Partition = trim(rounds[r].Partition)
+	}
+	s.Rounds = rounds
+	return s
+}
+
+// Explore runs the scenarios drawn from the seeds from seed on, and minimizes the ones that fail. If dir is not
+// empty they are saved there with their violations.
+func Explore(o Options, seed int64, count int, dir string) ([]Scenario, error) {
+	var failed []Scenario
+	for i := 0; i < count; i++ {
+		s := Generate(o, seed+int64(i))
+		result, err := Run(s)
+		if err != nil {
+			return failed, err
+		}
+		if !result.Failed() {
+			continue
+		}
+		s = Minimize(s, func(candidate Scenario) bool {
+			result, err := Run(candidate)
+			return err == nil && result.Failed()
+		})
+		result, err = Run(s)
+		if err != nil {
+			return failed, err
+		}
+		s.Violations = result.Describe()
+		failed = append(failed, s)
+		if dir != "" {
+			err = s.Save(filepath.Join(dir, fmt.Sprintf("%v-%v.json", s.Algorithm, s.Seed)))
+			if err != nil {
+				return failed, err
+			}
+		}
+	}
+	return failed, nil
+}
@@ -0,0 +1,101 @@
+package twins
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func options(alg string, twins int) Options {
+	_ = flag.Set("log_level", "error")
+	return Options{Algorithm: alg, N: 4, F: 1, P: 1, Twins: twins, Rounds: 8, Round: 300 * time.Millisecond,
+		Timeout: 300 * time.Millisecond, Duration: 2 * time.Second, Latency: "uniform:5ms,30ms"}
+}
+
+func TestGenerate(t *testing.T) {
+	o := options("hotstuff", 1)
+	s := Generate(o, 7)
+	require.Equal(t, s, Generate(o, 7))
+	require.NotEqual(t, s, Generate(o, 8))
+	require.Len(t, s.Twins, 1)
+	require.Len(t, s.Rounds, 8)
+	partitioned := 0
+	for _, r := range s.Rounds {
+		require.True(t, r.Leader.Node() >= 1 && r.Leader.Node() <= 4)
+		if len(r.Partition) > 0 {
+			partitioned++
+			require.GreaterOrEqual(t, len(r.Partition), 2)
+		}
+		names := 0
+		for _, g := range r.Partition {
+			for _, name := range g {
+				_, err := s.instance(name)
+				require.NoError(t, err)
+				names++
+			}
+		}
+		if len(r.Partition) > 0 {
+			require.Equal(t, 5, names, "every instance is in a group")
+		}
+	}
+	require.Greater(t, partitioned, 0)
+}
+
+func TestSafeWithinF(t *testing.T) {
+	for _, alg := range []string{"banyan", "icc", "hotstuff", "streamlet"} {
+		failed, err := Explore(options(alg, 1), 1, 10, "")
+		require.NoError(t, err)
+		require.Empty(t, failed, alg)
+	}
+}
+
+func TestBeyondF(t *testing.T) {
+	dir := t.TempDir()
+	failed, err := Explore(options("banyan", 2), 15, 1, dir)
+	require.NoError(t, err)
+	require.Len(t, failed, 1, "two twins out of four replicas break safety")
+	require.NotEmpty(t, failed[0].Violations)
+
+	saved, err := Load(filepath.Join(dir, "banyan-15.json"))
+	require.NoError(t, err)
+	require.Equal(t, failed[0], saved)
+	result, err := Run(saved)
+	require.NoError(t, err)
+	require.Equal(t, saved.Violations, result.Describe())
+}
+
+func TestMinimize(t *testing.T) {
+	s := Generate(options("icc", 1), 3)
+	s.Duration = 10 * time.Second
+	s.Rounds[2].Partition = [][]string{{"1", "2"}, {"3", "4", s.instances()[4]}}
+	// fails as long as the third round isolates replicas 1 and 2
+	minimized := Minimize(s, func(s Scenario) bool {
+		return len(s.Rounds) > 2 && len(s.Rounds[2].Partition) == 2 && len(s.Rounds[2].Partition[0]) == 2
+	})
+	require.Len(t, minimized.Rounds, 3)
+	require.Nil(t, minimized.Rounds[0].Partition)
+	require.Nil(t, minimized.Rounds[1].Partition)
+	require.Equal(t, [][]string{{"1", "2"}, {"3", "4"}}, minimized.Rounds[2].Partition)
+	require.Empty(t, minimized.Twins)
+	require.Equal(t, 1250*time.Millisecond, minimized.Duration)
+	require.Equal(t, s.Rounds[2].Leader, minimized.Rounds[2].Leader)
+}
+
+// TestReplay replays the saved scenarios: the ones beyond the fault model keep failing the same way, and the ones
+// that crashed Streamlet before it was fixed no longer fail
+func TestReplay(t *testing.T) {
+	_ = flag.Set("log_level", "error")
+	names, err := filepath.Glob("testdata/*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, names)
+	for _, name := range names {
+		s, err := Load(name)
+		require.NoError(t, err)
+		result, err := Run(s)
+		require.NoError(t, err)
+		require.Equal(t, s.Violations, result.Describe(), name)
+	}
+}
@@ -0,0 +1,220 @@
+// Package binary encodes the values of the types that write themselves to a Writer and read themselves from a
+// Reader, a compact encoding for the messages the replicas send most, without reflection.
+package binary
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnsupported is returned for the values of the types that have no binary encoding
+var ErrUnsupported = errors.New("binary: the type has no binary encoding")
+
+var errShort = errors.New("binary: unexpected end of data")
+
+// Marshaler is implemented by the types with a binary encoding, on a pointer receiver
+type Marshaler interface {
+	MarshalWire(w *Writer)
+}
+
+// Unmarshaler reads what MarshalWire writes
+type Unmarshaler interface {
+	UnmarshalWire(r *Reader)
+}
+
+// Writer appends the fields of a value, the integers as varints and the byte slices and strings after their length
+type Writer struct {
+	buf []byte
+	err error
+}
+
+func (w *Writer) Int(v int) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, b[:binary.PutVarint(b[:], int64(v))]...)
+}
+
+func (w *Writer) Uint64(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func (w *Writer) Bool(v bool) {
+	if v {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+// Fixed writes bytes whose length the reader knows, like an identifier
+func (w *Writer) Fixed(b []byte) {
+	w.buf = append(w.buf, b...)
+}
+
+func (w *Writer) Bytes(b []byte) {
+	w.Uint64(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *Writer) String(s string) {
+	w.Uint64(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// Time writes the instant and the offset of the zone, as time.Time.MarshalBinary
+func (w *Writer) Time(t time.Time) {
+	b, err := t.MarshalBinary()
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	w.Bytes(b)
+}
+
+// Reader reads the fields in the order they were written, after an error it returns zero values and Err tells the
+// first error. The byte slices it returns share the memory of the data.
+type Reader struct {
+	buf []byte
+	err error
+}
+
+func NewReader(b []byte) *Reader {
+	return &Reader{buf: b}
+}
+
+// Err returns the first error of the reader
+func (r *Reader) Err() error {
+	return r.err
+}
+
+func (r *Reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.buf = nil
+}
+
+func (r *Reader) Int() int {
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.fail(errShort)
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return int(v)
+}
+
+func (r *Reader) Uint64() uint64 {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.fail(errShort)
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *Reader) Bool() bool {
+	b := r.next(1)
+	return len(b) == 1 && b[0] != 0
+}
+
+// Fixed fills b
+func (r *Reader) Fixed(b []byte) {
+	copy(b, r.next(len(b)))
+}
+
+// Bytes returns nil for an empty slice, as gob decodes it
+func (r *Reader) Bytes() []byte {
+	b := r.next(r.length(1))
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+func (r *Reader) String() string {
+	return string(r.next(r.length(1)))
+}
+
+func (r *Reader) Time() time.Time {
+	var t time.Time
+	b := r.Bytes()
+	if r.err == nil {
+		if err := t.UnmarshalBinary(b); err != nil {
+			r.fail(err)
+		}
+	}
+	return t
+}
+
+// Len reads the number of elements of a slice whose elements take at least size bytes each, it fails rather than
+// let the caller allocate more elements than the data can hold
+func (r *Reader) Len(size int) int {
+	return r.length(size)
+}
+
+func (r *Reader) length(size int) int {
+	n := r.Uint64()
+	if n > uint64(len(r.buf)/size) {
+		r.fail(errShort)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *Reader) next(n int) []byte {
+	if n > len(r.buf) {
+		r.fail(errShort)
+		return nil
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+type Encoder struct{}
+
+func NewEncoder() *Encoder {
+	return &Encoder{}
+}
+
+func (e *Encoder) Encode(val interface{}) ([]byte, error) {
+	m, ok := val.(Marshaler)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	var w Writer
+	m.MarshalWire(&w)
+	return w.buf, w.err
+}
+
+func (e *Encoder) Decode(b []byte, val interface{}) error {
+	u, ok := val.(Unmarshaler)
+	if !ok {
+		return ErrUnsupported
+	}
+	r := NewReader(b)
+	u.UnmarshalWire(r)
+	if r.err == nil && len(r.buf) > 0 {
+		return fmt.Errorf("binary: %d bytes left after the value", len(r.buf))
+	}
+	return r.err
+}
+
+func (e *Encoder) MustEncode(val interface{}) []byte {
+	b, err := e.Encode(val)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+func (e *Encoder) MustDecode(b []byte, val interface{}) {
+	err := e.Decode(b, val)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,67 @@
+package gob
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+// ErrOutOfSync is returned when a value failed to encode after the encoder had written the description of its type,
+// the decoder at the other end cannot follow the stream any more
+var ErrOutOfSync = errors.New("gob: the stream is out of sync")
+
+// Encoder encodes the values of a stream, each value on its own, as gob describes a type only the first time it is
+// sent: a value has to be decoded by the decoder of the same stream, after the values encoded before it. An Encoder
+// encodes one stream and decodes another, the encoder of the other end.
+type Encoder struct {
+	out     bytes.Buffer
+	encoder *gob.Encoder
+	in      bytes.Buffer
+	decoder *gob.Decoder
+}
+
+func NewEncoder() *Encoder {
+	e := new(Encoder)
+	e.encoder = gob.NewEncoder(&e.out)
+	e.decoder = gob.NewDecoder(&e.in)
+	return e
+}
+
+func (e *Encoder) Encode(val interface{}) ([]byte, error) {
+	e.out.Reset()
+	err := e.encoder.Encode(val)
+	if err != nil {
+		if e.out.Len() > 0 {
+			return nil, fmt.Errorf("%w: %v", ErrOutOfSync, err)
+		}
+		return nil, err
+	}
+	return append([]byte(nil), e.out.Bytes()...), nil
+}
+
+func (e *Encoder) Decode(b []byte, val interface{}) error {
+	e.in.Reset()
+	e.in.Write(b)
+	err := e.decoder.Decode(val)
+	if err == nil && e.in.Len() > 0 {
+		err = fmt.Errorf("gob: %d bytes left after the value", e.in.Len())
+	}
+	return err
+}
+
+func (e *Encoder) MustEncode(val interface{}) []byte {
+	b, err := e.Encode(val)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+func (e *Encoder) MustDecode(b []byte, val interface{}) {
+	err := e.Decode(b, val)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,130 @@
+// Package verifier checks the signatures of the shares and votes a replica receives on a pool of workers, so that
+// the event loop of the replica only gets messages whose signatures hold and no longer checks them itself.
+package verifier
+
+import (
+	"time"
+
+	"go.uber.org/atomic"
+
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/log"
+	"banyan/metrics"
+)
+
+// queueSize is the number of messages waiting for each worker before Submit blocks
+const queueSize = 1024
+
+// Message is a message whose signatures the verifier checks, a pointer to a share or a vote
+type Message interface {
+	// Claims returns the signatures of the message, all of them of the sender
+	Claims() []crypto.Claim
+	// MarkVerified tells the protocol not to check the signatures again
+	MarkVerified()
+}
+
+type job struct {
+	msg     Message
+	claims  []crypto.Claim
+	arrived time.Time
+}
+
+// Verifier hands the messages to the workers by sender: the messages of a sender are all checked by the same worker,
+// in the order they arrived, and reach the replica in that order. A worker checks the messages waiting for it together,
+// up to the batch size, which the BLS keys verify faster than one by one.
+type Verifier struct {
+	id      identity.NodeID
+	queues  []chan job
+	batch   int
+	out     chan<- interface{}
+	pending atomic.Int64
+
+	depth     *metrics.Histogram
+	wait      *metrics.Histogram
+	batchSize *metrics.Histogram
+	rejected  *metrics.Counter
+}
+
+// NewVerifier starts the workers, which send the verified messages to out. It returns nil if there are no workers,
+// the replica checks the signatures on its own then.
+func NewVerifier(id identity.NodeID, workers int, batch int, registry *metrics.Registry, out chan<- interface{}) *Verifier {
+	if workers <= 0 {
+		return nil
+	}
+	if batch <= 0 {
+		batch = 1
+	}
+	v := &Verifier{
+		id:        id,
+		queues:    make([]chan job, workers),
+		batch:     batch,
+		out:       out,
+		depth:     registry.Histogram("banyan_verify_queue_depth", "Messages waiting for their signatures to be checked when a message arrives.", []float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}),
+		wait:      registry.Histogram("banyan_verify_wait_seconds", "Time from the arrival of a message to its delivery to the protocol, waiting and verification included.", crypto.SignatureBuckets),
+		batchSize: registry.Histogram("banyan_verify_batch_size", "Messages checked together by a worker.", []float64{1, 2, 4, 8, 16, 32, 64, 128}),
+		rejected:  registry.Counter("banyan_verify_rejected_total", "Messages dropped because a signature did not hold."),
+	}
+	for i := range v.queues {
+		v.queues[i] = make(chan job, queueSize)
+		go v.work(v.queues[i])
+	}
+	return v
+}
+
+// Submit queues the message for verification, it blocks while the queue of the sender is full
+func (v *Verifier) Submit(msg Message) {
+	claims := msg.Claims()
+	queue := v.queues[0]
+	if len(claims) > 0 {
+		if n := claims[0].Signer.Node(); n > 0 {
+			queue = v.queues[n%len(v.queues)]
+		}
+	}
+	v.depth.Observe(float64(v.pending.Inc() - 1))
+	queue <- job{msg: msg, claims: claims, arrived: time.Now()}
+}
+
+func (v *Verifier) work(queue chan job) {
+	jobs := make([]job, 0, v.batch)
+	for {
+		jobs = append(jobs[:0], <-queue)
+	L:
+		for len(jobs) < v.batch {
+			select {
+			case j := <-queue:
+				jobs = append(jobs, j)
+			default:
+				break L
+			}
+		}
+		v.verify(jobs)
+	}
+}
+
+// verify checks the claims of the jobs at once and delivers the messages whose claims all hold
+func (v *Verifier) verify(jobs []job) {
+	var claims []crypto.Claim
+	for _, j := range jobs {
+		claims = append(claims, j.claims...)
+	}
+	valid := crypto.VerifyBatch(claims)
+	v.batchSize.Observe(float64(len(jobs)))
+	next := 0
+	for _, j := range jobs {
+		ok := true
+		for range j.claims {
+			ok = ok && valid[next]
+			next++
+		}
+		v.pending.Dec()
+		if !ok {
+			v.rejected.Inc()
+			log.Warningf("[%v] dropped a %T with an invalid signature from %v", v.id, j.msg, j.claims[0].Signer)
+			continue
+		}
+		j.msg.MarkVerified()
+		v.wait.ObserveDuration(time.Since(j.arrived))
+		v.out <- j.msg
+	}
+}
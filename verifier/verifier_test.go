@@ -0,0 +1,58 @@
+package verifier
+
+import (
+	"testing"
+	"time"
+
+	view "banyan/blockchain_view"
+	"banyan/crypto"
+	"banyan/identity"
+	"banyan/metrics"
+	"banyan/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier(t *testing.T) {
+	ids := []identity.NodeID{"1", "2", "3", "4"}
+	require.NoError(t, crypto.GenerateKeys(crypto.ED25519, ids))
+	defer crypto.UseKeys(nil)
+	registry := metrics.NewRegistry()
+	out := make(chan interface{}, 1000)
+	v := NewVerifier("1", 2, 4, registry, out)
+
+	const views = 50
+	for i := 1; i <= views; i++ {
+		for _, id := range ids[1:] {
+			vote := view.MakeVote(types.View(i), id, crypto.MakeID(i))
+			if id == "3" && i == 10 {
+				vote.Signature = append(crypto.Signature(nil), vote.Signature...)
+				vote.Signature[0] ^= 1
+			}
+			v.Submit(vote)
+		}
+	}
+	// votes of an unknown node are dropped too
+	v.Submit(&view.Vote{View: 1, Voter: "5", BlockID: crypto.MakeID(1), Signature: crypto.Signature{1}})
+
+	last := make(map[identity.NodeID]types.View)
+	for received := 0; received < 3*views-1; received++ {
+		select {
+		case msg := <-out:
+			vote := msg.(*view.Vote)
+			require.True(t, vote.IsVerified())
+			require.Greater(t, int(vote.View), int(last[vote.Voter]), "the votes of a sender keep their order")
+			require.False(t, vote.Voter == "3" && vote.View == 10, "the vote with an invalid signature is dropped")
+			last[vote.Voter] = vote.View
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %v votes verified", received)
+		}
+	}
+	require.Eventually(t, func() bool {
+		return registry.Snapshot().Counters["banyan_verify_rejected_total"][""] == 2
+	}, time.Second, 10*time.Millisecond)
+	require.Empty(t, out)
+	require.Equal(t, uint64(3*views+1), registry.Snapshot().Histograms["banyan_verify_queue_depth"][""].Count)
+
+	require.Nil(t, NewVerifier("1", 0, 4, registry, out), "no workers, no verifier")
+}